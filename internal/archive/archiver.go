@@ -1,16 +1,21 @@
 package archive
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
+	"math/rand/v2"
 	"path"
+	"slices"
+	"strings"
 	"time"
 
+	"go.mongodb.org/mongo-driver/mongo"
+
 	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
 )
 
@@ -21,6 +26,80 @@ type Archiver struct {
 	skipDelete            bool
 	ignoreFileExistsError bool
 	delay                 time.Duration
+	sharedDelay           *SharedDelay
+	fileHeader            *fileHeaderConfig
+	provenance            *source.Provenance
+	resume                *resumeConfig
+	adaptiveCompression   *adaptiveCompressionConfig
+	pause                 *pauseConfig
+	partition             *partitionConfig
+	maxOpenPartitions     int
+	exactDelete           bool
+	deleteChunkSize       int
+	offsetIndex           bool
+	schema                bool
+	compression           Compression
+	validateCompression   bool
+	newCompressor         compressor
+	oversize              *oversizeConfig
+	requiredFields        *requiredFieldsConfig
+	dayArchivedHook       func(ctx context.Context, day ArchivedDay) error
+	onCollision           CollisionPolicy
+	overwriteIncomplete   bool
+	stream                *streamConfig
+	strictDeleteCount     bool
+	maxDocuments          int
+	startDate             time.Time
+	successMarker         bool
+	checksums             bool
+	commitInterval        time.Duration
+	skipEmptyDays         bool
+	encoder               Encoder
+	fileExtension         string
+	ttl                   time.Duration
+	completeDaysOnly      bool
+	keepDays              int
+	keepDaysFrom          time.Time
+	explode               *explodeConfig
+	rollup                bool
+	deleteUnarchived      bool
+	contentCRC            bool
+	specialTypes          *SpecialTypesPolicy
+	now                   func() time.Time
+	layout                *layout
+	deleteGuard           *deleteGuardConfig
+	progress              *progressConfig
+	compressionThreads    int
+	emptyRunManifest      bool
+	bounds                bool
+	deleteCancelGrace     time.Duration
+	verifySampleRate      float64
+	sample                func() float64 // returns the next number in [0, 1) deciding whether a line is sampled
+}
+
+var (
+	// ErrIntegrity indicates that archived data could not be verified, or that existing archives would be overwritten
+	ErrIntegrity = errors.New("data integrity")
+	// ErrStorage indicates a failure interacting with the underlying store
+	ErrStorage = errors.New("storage")
+)
+
+// Option configures optional behaviour of an Archiver
+type Option func(*Archiver)
+
+const (
+	defaultFileExtension = "json"
+
+	// codecExtension is appended to the file extension, reflecting the compression applied to files
+	codecExtension = "gz"
+)
+
+// WithFileExtension overrides the data format portion of archived file names, e.g. "ndjson" results in files named
+// 01.ndjson.gz
+func WithFileExtension(extension string) Option {
+	return func(a *Archiver) {
+		a.fileExtension = strings.TrimPrefix(extension, ".")
+	}
 }
 
 type documentSource interface {
@@ -31,24 +110,66 @@ type documentSource interface {
 
 type store interface {
 	Create(ctx context.Context, path string) (io.WriteCloser, error)
+}
+
+// exister is optionally implemented by stores that are able to report whether a file already exists. Write-only
+// stores (e.g. streaming sinks) may not implement it, in which case overwrite protection is unavailable.
+type exister interface {
 	Exists(ctx context.Context, path string) (bool, error)
 }
 
+// dayOfer is optionally implemented by sources which assign documents to days other than by truncating createdAt,
+// e.g. where documents created exactly at midnight belong to the preceding day
+type dayOfer interface {
+	DayOf(t time.Time) time.Time
+}
+
 // NewArchiver initializes and returns an Archiver
-func NewArchiver(source documentSource, storage store, skipDelete, ignoreFileExistsError bool, delay time.Duration) *Archiver {
-	return &Archiver{
+func NewArchiver(
+	source documentSource,
+	storage store,
+	skipDelete, ignoreFileExistsError bool,
+	delay time.Duration,
+	opts ...Option,
+) *Archiver {
+	a := &Archiver{
 		source:                source,
 		store:                 storage,
 		skipDelete:            skipDelete,
 		ignoreFileExistsError: ignoreFileExistsError,
 		delay:                 delay,
+		fileExtension:         defaultFileExtension,
+		newCompressor:         newGzipWriter,
+		now:                   time.Now,
+		verifySampleRate:      1,
+		sample:                rand.Float64,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// WithMaxDocuments stops the run once at least n documents have been archived, bounding how much a single run of a
+// dense collection archives and deletes. The cap is only checked between days, so the day crossing it is archived and
+// deleted in full, leaving the remaining days untouched for a later run.
+func WithMaxDocuments(n int) Option {
+	return func(a *Archiver) {
+		a.maxDocuments = n
 	}
 }
 
 // Run executes the archiving process
-func (a *Archiver) Run(ctx context.Context, target time.Time) error {
+func (a *Archiver) Run(ctx context.Context, target time.Time) (err error) {
 	// Resolve the earliest document in the collection
 	earliest, err := a.source.EarliestCreatedAt(ctx)
+	if a.emptyRunManifest && errors.Is(err, mongo.ErrNoDocuments) {
+		slog.Info("collection is empty, nothing to archive")
+		if err = a.writeEmptyRunManifest(ctx, target, time.Time{}); err != nil {
+			return fmt.Errorf("failed to write empty run manifest: %w", err)
+		}
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get earliest created at: %w", err)
 	}
@@ -59,17 +180,205 @@ func (a *Archiver) Run(ctx context.Context, target time.Time) error {
 		slog.String("earliest", earliest.String()),
 	)
 
+	if _, ok := a.store.(exister); !ok {
+		slog.Warn("store does not support existence checks, overwrite protection is disabled")
+	}
+	if a.resume != nil {
+		if err = a.checkResumeSupported(); err != nil {
+			return err
+		}
+	}
+	if a.compression == CompressionStore {
+		if err = a.checkCompressionSupported(); err != nil {
+			return err
+		}
+	}
+	if a.validateCompression {
+		if err = a.checkCompressionValidationSupported(); err != nil {
+			return err
+		}
+	}
+	if a.checksums {
+		if err = a.checkChecksumsSupported(); err != nil {
+			return err
+		}
+	}
+	if a.commitInterval > 0 {
+		a.checkCommitIntervalSupported()
+	}
+	if a.skipEmptyDays {
+		if err = a.checkSkipEmptyDaysSupported(); err != nil {
+			return err
+		}
+	}
+	if a.customEncoder() {
+		if err = a.checkEncoderSupported(); err != nil {
+			return err
+		}
+	}
+	if a.adaptiveCompression != nil {
+		if err = a.checkAdaptiveCompressionSupported(); err != nil {
+			return err
+		}
+	}
+	if a.pause != nil {
+		if err = a.checkPauseSupported(); err != nil {
+			return err
+		}
+	}
+	if a.exactDelete {
+		if err = a.checkExactDeleteSupported(); err != nil {
+			return err
+		}
+	}
+	if a.partition != nil {
+		if err = a.checkPartitionSupported(); err != nil {
+			return err
+		}
+	}
+	if a.offsetIndex {
+		if err = a.checkOffsetIndexSupported(); err != nil {
+			return err
+		}
+	}
+	if a.onCollision != CollisionFail {
+		if err = a.checkCollisionPolicySupported(); err != nil {
+			return err
+		}
+	}
+	if a.overwriteIncomplete {
+		if err = a.checkOverwriteIncompleteSupported(); err != nil {
+			return err
+		}
+	}
+	if a.schema {
+		if err = a.checkSchemaSupported(); err != nil {
+			return err
+		}
+	}
+	if a.bounds {
+		if err = a.checkBoundsSupported(); err != nil {
+			return err
+		}
+	}
+	if a.oversize != nil {
+		if err = a.checkMaxDocumentSizeSupported(); err != nil {
+			return err
+		}
+	}
+	if a.requiredFields != nil {
+		if err = a.checkRequiredFieldsSupported(); err != nil {
+			return err
+		}
+	}
+	if a.explode != nil {
+		if err = a.checkExplodeSupported(); err != nil {
+			return err
+		}
+	}
+	if a.rollup {
+		if err = a.checkRollupSupported(); err != nil {
+			return err
+		}
+	}
+	if a.contentCRC {
+		if err = a.checkContentCRCSupported(); err != nil {
+			return err
+		}
+	}
+	if a.specialTypes != nil {
+		if err = a.checkSpecialTypesSupported(); err != nil {
+			return err
+		}
+	}
+	if !a.startDate.IsZero() {
+		if err = a.checkStartDateSupported(target); err != nil {
+			return err
+		}
+	}
+
+	if a.compressionThreads > 1 {
+		if err = a.checkCompressionThreadsSupported(); err != nil {
+			return err
+		}
+	}
+
+	if a.layout != nil {
+		if err = a.writeLayout(ctx); err != nil {
+			return fmt.Errorf("failed to write layout: %w", err)
+		}
+	}
+	if a.progress != nil {
+		a.startProgress(ctx, target)
+		defer func() {
+			a.finishProgress(ctx, err)
+		}()
+	}
+
 	// Iterate one day at a time, until we hit the target
-	var total int
-	for date := earliest.Truncate(time.Hour * 24); date.Before(target); date = date.AddDate(0, 0, 1) {
-		slog.Info("archiving", slog.String("date", date.String()))
+	var total, documents int
+	end := a.endOf(target)
+	for date := a.startDay(earliest); date.Before(end); date = date.AddDate(0, 0, 1) {
+		if a.skipEmptyDays {
+			next, err := a.nextDay(ctx, date, end)
+			if err != nil {
+				return fmt.Errorf("failed to find the next day with documents: %w", err)
+			}
+			if next.After(date) {
+				slog.Info(
+					"skipping days without documents",
+					slog.String("from", date.Format(time.DateOnly)),
+					slog.String("until", next.Format(time.DateOnly)),
+				)
+				if date = next; !date.Before(end) {
+					break
+				}
+			}
+		}
+		if err = a.waitWhilePaused(ctx); err != nil {
+			return err
+		}
+		if a.sharedDelay != nil {
+			if err = a.sharedDelay.wait(ctx); err != nil {
+				return err
+			}
+		}
 
-		if err = a.archiveDocumentsAndDelete(ctx, date); err != nil {
+		if a.ttl > 0 {
+			a.logArchivingExpired(date)
+		} else {
+			slog.Info("archiving", slog.String("date", date.String()))
+		}
+		a.progressDay(date)
+
+		res, err := a.archiveDocumentsAndDelete(ctx, date)
+		if err != nil {
 			return fmt.Errorf("archival failed: %w", err)
 		}
+		a.completeProgressDay(res)
+		if a.successMarker {
+			if err = a.writeSuccessMarker(ctx, date); err != nil {
+				return fmt.Errorf("failed to write success marker: %w", err)
+			}
+		}
+		if err = a.notifyDayArchived(ctx, date, res); err != nil {
+			return fmt.Errorf("post archive hook failed: %w", err)
+		}
 
 		total++
+		documents += res.written
+		if a.maxDocuments > 0 && documents >= a.maxDocuments {
+			slog.Info("document cap reached", slog.Int("datesArchived", total), slog.Int("documents", documents))
+			return nil
+		}
+		// Checked before waiting, as the delay may have already elapsed, e.g. once a cancelled delete was finished
+		if err = ctx.Err(); err != nil {
+			return err
+		}
 
+		if a.sharedDelay != nil {
+			continue
+		}
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
@@ -78,92 +387,404 @@ func (a *Archiver) Run(ctx context.Context, target time.Time) error {
 	}
 
 	slog.Info("target reached", slog.Int("datesArchived", total))
+	if a.emptyRunManifest && total == 0 {
+		if err = a.writeEmptyRunManifest(ctx, target, earliest); err != nil {
+			return fmt.Errorf("failed to write empty run manifest: %w", err)
+		}
+	}
 
 	return nil
 }
 
-func (a *Archiver) archiveDocumentsAndDelete(ctx context.Context, date time.Time) error {
-	if err := a.archiveDocuments(ctx, date); err != nil {
-		return fmt.Errorf("failed to archive documents: %w", err)
+// dayResult describes the outcome of writing a single day's documents
+type dayResult struct {
+	written int
+	skipped bool
+	files   []fileResult
+	ids     []json.RawMessage // only populated when deleting exactly the archived documents, without chunking
+	deleted int
+
+	invalid     int    // documents missing a required field, which are left in the collection
+	invalidFile string // the file holding invalid documents, when dead lettering them
+
+	special int // documents holding a value of a special type, when detecting them
+}
+
+// fileResult describes a single file written for a day
+type fileResult struct {
+	name              string
+	written           int
+	uncompressedBytes int64  // bytes fed to gzip
+	compressedBytes   int64  // bytes written to the store
+	contentCRC        string // CRC32 of the uncompressed contents, when recorded
+}
+
+// bytes returns the total uncompressed and compressed sizes of all files written for the day
+func (r *dayResult) bytes() (uncompressed, compressed int64) {
+	for _, f := range r.files {
+		uncompressed += f.uncompressedBytes
+		compressed += f.compressedBytes
 	}
-	if !a.skipDelete {
-		deleted, err := a.source.DeleteAllFromDate(ctx, date)
-		if err != nil {
-			return fmt.Errorf("failed to delete documents: %w", err)
+	return uncompressed, compressed
+}
+
+// sessionSource is implemented by sources able to scope all operations for a day to a single session
+type sessionSource interface {
+	WithDaySession(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+func (a *Archiver) archiveDocumentsAndDelete(ctx context.Context, date time.Time) (res *dayResult, err error) {
+	if s, ok := a.source.(sessionSource); ok {
+		err = s.WithDaySession(ctx, func(ctx context.Context) error {
+			res, err = a.archiveAndDelete(ctx, date)
+			return err
+		})
+		return res, err
+	}
+	return a.archiveAndDelete(ctx, date)
+}
+
+func (a *Archiver) archiveAndDelete(ctx context.Context, date time.Time) (*dayResult, error) {
+	fileName := a.fileName(date)
+
+	if !a.skipDelete && a.deleteGuard != nil && !a.deleteGuard.archive {
+		if err := a.checkDeleteGuard(ctx); err != nil {
+			return nil, err
 		}
-		slog.Info("documents deleted", slog.Int("total", deleted))
 	}
-	return nil
+
+	var rolled source.RollupResult
+	if a.rollup {
+		var err error
+		if rolled, err = a.rollupDay(ctx, date); err != nil {
+			return nil, fmt.Errorf("failed to roll up documents: %w", err)
+		}
+	}
+
+	res, err := a.archiveDocuments(ctx, date, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to archive documents: %w", err)
+	}
+	if a.rollup {
+		if err = checkRolledUpArchived(rolled, res); err != nil {
+			return nil, err
+		}
+	}
+	if a.skipDelete {
+		return res, nil
+	}
+	if blocked, err := a.deletesBlocked(ctx); err != nil || blocked {
+		return res, err
+	}
+	if err = a.checkUnarchivedDelete(ctx, date, res); err != nil {
+		return nil, err
+	}
+	deleteCtx, cancel := a.deletionContext(ctx, date)
+	defer cancel()
+	if a.exactDelete {
+		err = a.deleteExact(deleteCtx, date, res)
+		return res, a.interruptedDelete(deleteCtx, err, date, res, res.deleted)
+	}
+	if res.deleted, err = a.source.DeleteAllFromDate(deleteCtx, date); err != nil {
+		// Whether the server carried on deleting once the client gave up is unknown
+		err = a.interruptedDelete(deleteCtx, err, date, res, -1)
+		return nil, fmt.Errorf("failed to delete documents: %w", err)
+	}
+	slog.Info("documents deleted", slog.Int("total", res.deleted))
+	if res.skipped {
+		// The file was written by a previous run, so how many documents it holds is unknown
+		return res, nil
+	}
+	return res, a.checkDeletedCount(res.written, res.deleted)
 }
 
-func (a *Archiver) archiveDocuments(ctx context.Context, date time.Time) (err error) {
-	fileName := path.Join(
-		date.Format("2006"),
-		date.Format("01"),
-		date.Format("02")+".json.gz",
+// WithStrictDeleteCount fails the run should the number of documents deleted for a day differ from the number
+// archived, rather than only logging the discrepancy. The day's documents have been deleted by the time the
+// discrepancy is detected, so this stops the run from moving on to further days rather than preventing the delete.
+func WithStrictDeleteCount() Option {
+	return func(a *Archiver) {
+		a.strictDeleteCount = true
+	}
+}
+
+// checkDeletedCount compares the number of documents deleted for a day with the number archived. A difference means
+// documents were inserted or removed by something else whilst the day was being archived, in which case documents
+// may have been deleted without being archived.
+func (a *Archiver) checkDeletedCount(archived, deleted int) error {
+	if archived == deleted {
+		return nil
+	}
+	slog.Error(
+		"deleted count differs from archived count",
+		slog.Int("archived", archived),
+		slog.Int("deleted", deleted),
 	)
+	if a.strictDeleteCount {
+		return fmt.Errorf("%w: deleted %d documents, but archived %d", ErrIntegrity, deleted, archived)
+	}
+	return nil
+}
+
+func (a *Archiver) archiveDocuments(ctx context.Context, date time.Time, fileName string) (*dayResult, error) {
+	// A checkpoint means a previous run was interrupted part way through writing the file, which we can continue
+	var cp *checkpoint
+	if a.resume != nil {
+		var err error
+		if cp, err = a.readCheckpoint(ctx, date); err != nil {
+			return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+		}
+	}
 
 	// Check if target file already exists - the default behaviour of the storage implementations is to overwrite
-	exists, err := a.store.Exists(ctx, fileName)
+	exists, err := a.exists(ctx, fileName)
 	if err != nil {
-		return fmt.Errorf("failed to check if file exists: %w", err)
+		return nil, fmt.Errorf("%w: failed to check if file exists: %w", ErrStorage, err)
 	}
-	if exists {
+	if exists && cp == nil && a.overwriteIncomplete {
+		incomplete, err := a.incompleteFile(ctx, fileName)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to check existing file: %w", ErrStorage, err)
+		}
+		if incomplete {
+			slog.Warn("overwriting incomplete file left by a previous run", slog.String("file", fileName))
+			exists = false
+		}
+	}
+	if exists && cp == nil {
 		slog.Error("target file already exists", slog.String("file", fileName))
 		if a.ignoreFileExistsError {
 			// Archiver can be configured to skip past cases of the target file already existing. This should only be
 			// done if it is safe to assume the file already contains the full set of documents that is expected to be
 			// deleted.
 			slog.Warn("skipping documents write")
-			return nil
+			return &dayResult{skipped: true}, nil
+		}
+		if fileName, err = a.resolveCollision(ctx, date, fileName); err != nil {
+			return nil, err
 		}
-		return errors.New("target file exists")
 	}
 
-	slog.Info("writing to file", slog.String("fileName", fileName))
-
-	// Create target file in the underlying store
-	w, err := a.store.Create(ctx, fileName)
+	var res *dayResult
+	started := a.now()
+	if a.resume != nil {
+		res, err = a.writeDocumentsResumable(ctx, date, fileName, cp)
+	} else {
+		res, err = a.writeDocuments(ctx, date, fileName)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer func() {
-		// Close the file writer
-		if cErr := w.Close(); cErr != nil {
-			err = errors.Join(err, fmt.Errorf("failed to close file: %w", cErr))
+	a.tuneCompressionLevel(a.now().Sub(started), res)
+
+	if a.fileHeader != nil {
+		if err = a.writeFileHeader(ctx, date, fileName, res); err != nil {
+			return nil, fmt.Errorf("failed to write file header: %w", err)
 		}
-	}()
+	}
+
+	if a.resume != nil {
+		if err = a.removeCheckpoint(ctx, date); err != nil {
+			return nil, fmt.Errorf("failed to remove checkpoint: %w", err)
+		}
+	}
+
+	return res, nil
+}
 
-	// Contents will be gzipped
-	gw, err := gzip.NewWriterLevel(w, gzip.DefaultCompression)
+func (a *Archiver) writeDocuments(ctx context.Context, date time.Time, fileName string) (res *dayResult, err error) {
+	level, err := a.compressionLevel(ctx, date)
 	if err != nil {
-		return err
+		return nil, err
 	}
+
+	// Documents are written to a single file, unless partitioning, in which case a file is opened for each distinct
+	// partition value as it is encountered
+	files := make(map[string]*gzipFile)
+	recency := newPartitionRecency()
 	defer func() {
-		// Close the gzip writer - note that does not close the underlying file writer
-		if cErr := gw.Close(); cErr != nil {
-			err = errors.Join(err, fmt.Errorf("failed to close gzip writer: %w", cErr))
+		for _, f := range files {
+			if cErr := f.close(); cErr != nil {
+				err = errors.Join(err, cErr)
+			}
 		}
 	}()
+	if a.partition == nil {
+		if files[fileName], err = a.createIndexedFile(ctx, fileName, level); err != nil {
+			return nil, err
+		}
+	}
 
 	// Iterate each document to be archived
-	var i int
-	res := a.source.FindAllFromDate(ctx, date)
-	for doc := range res.Iter(ctx) {
-		i++
-		buf := bytes.NewBuffer(doc)
-		if err = buf.WriteByte('\n'); err != nil {
-			return err
+	res = &dayResult{}
+	docs := a.source.FindAllFromDate(ctx, date)
+	for doc := range docs.Iter(ctx) {
+		// Invalid documents are left in the collection, so are kept apart from oversized documents, which are deleted
+		invalid, err := a.checkRequiredFields(doc)
+		if err != nil {
+			return nil, err
 		}
-		if _, err = io.Copy(gw, buf); err != nil {
-			return err
+		if invalid {
+			res.invalid++
+			if a.requiredFields.policy == MissingFieldSkip {
+				continue
+			}
+		}
+		var oversized, special bool
+		if !invalid {
+			if oversized, err = a.checkDocumentSize(doc); err != nil {
+				return nil, err
+			}
+		}
+		if !invalid && !oversized {
+			if special, err = a.checkSpecialTypes(doc); err != nil {
+				return nil, err
+			}
+		}
+		setAside := special && a.setsAsideSpecialTypes()
+
+		name := fileName
+		switch {
+		case invalid:
+			name = a.invalidName(fileName)
+		case oversized:
+			name = a.deadLetterName(fileName)
+		case setAside:
+			name = a.specialTypesName(fileName)
+		case a.partition != nil:
+			if name, err = a.partitionFileName(doc, fileName); err != nil {
+				return nil, err
+			}
+		}
+		f, ok := files[name]
+		switch {
+		case a.partition != nil && !invalid && !oversized && !setAside:
+			f, err = a.partitionFile(ctx, files, recency, name, level)
+		case ok:
+		case invalid:
+			f, err = a.createInvalidFile(ctx, name, level)
+		case oversized:
+			f, err = a.createDeadLetterFile(ctx, name, level)
+		case setAside:
+			f, err = a.createSpecialTypesFile(ctx, name, level)
+		}
+		if err != nil {
+			return nil, err
+		}
+		files[name] = f
+		if invalid {
+			// Neither counted as written, nor deleted
+			if err = f.write(doc); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		res.written++
+		if special {
+			res.special++
+		}
+		if a.exactDelete {
+			id, err := documentID(doc)
+			if err != nil {
+				return nil, err
+			}
+			if a.deleteChunkSize == 0 {
+				res.ids = append(res.ids, id)
+			}
+		}
+		uncompressed := f.uncompressed
+		if oversized || setAside {
+			// Written whole, as set aside
+			err = f.write(doc)
+		} else {
+			err = a.writeDocument(f, doc)
+		}
+		if err != nil {
+			return nil, err
 		}
+		a.observeProgress(ctx, f.uncompressed-uncompressed)
 	}
-	if err = res.Err(); err != nil {
-		return err
+	if err = docs.Err(); err != nil {
+		return nil, err
 	}
 
-	slog.Info("documents written", slog.Int("total", i))
+	// Files are closed before reporting on them, as the compressed size is only final once gzip has been flushed
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		if err = files[name].close(); err != nil {
+			return nil, err
+		}
+		if name == a.invalidName(fileName) {
+			res.invalidFile = name
+			continue
+		}
+		res.files = append(res.files, files[name].result(name))
+		if s := files[name].schema; s != nil {
+			if err = a.writeSchema(ctx, name, s); err != nil {
+				return nil, fmt.Errorf("failed to write schema: %w", err)
+			}
+		}
+		if b := files[name].bounds; b != nil {
+			if err = a.writeBounds(ctx, name, b); err != nil {
+				return nil, fmt.Errorf("failed to write bounds: %w", err)
+			}
+		}
+	}
 
-	return nil
+	uncompressed, compressed := res.bytes()
+	slog.Info(
+		"documents written",
+		slog.Int("total", res.written),
+		slog.Int("invalid", res.invalid),
+		slog.Int("files", len(files)),
+		slog.Int64("uncompressedBytes", uncompressed),
+		slog.Int64("compressedBytes", compressed),
+	)
+
+	return res, nil
+}
+
+// dayPath returns the path, without extension, beneath which files relating to the supplied date are stored
+func dayPath(date time.Time) string {
+	return path.Join(
+		date.Format("2006"),
+		date.Format("01"),
+		date.Format("02"),
+	)
+}
+
+// fileName returns the name of the archive file for the supplied date
+func (a *Archiver) fileName(date time.Time) string {
+	return dayPath(date) + "." + a.fileExtension + a.codecSuffix()
+}
+
+// documentID extracts the _id of a document, as extended JSON
+func documentID(doc []byte) (json.RawMessage, error) {
+	var decoded struct {
+		ID json.RawMessage `json:"_id"`
+	}
+	if err := json.Unmarshal(doc, &decoded); err != nil {
+		return nil, fmt.Errorf("failed to resolve document _id: %w", err)
+	}
+	if decoded.ID == nil {
+		return nil, errors.New("document has no _id")
+	}
+	return decoded.ID, nil
+}
+
+// dayOf returns the start of the day to which a document created at t is assigned by the source
+func (a *Archiver) dayOf(t time.Time) time.Time {
+	if d, ok := a.source.(dayOfer); ok {
+		return d.DayOf(t)
+	}
+	return t.Truncate(time.Hour * 24)
+}
+
+// exists reports whether the file exists in the underlying store. Stores which cannot answer this are assumed to not
+// hold the file.
+func (a *Archiver) exists(ctx context.Context, fileName string) (bool, error) {
+	e, ok := a.store.(exister)
+	if !ok {
+		return false, nil
+	}
+	return e.Exists(ctx, fileName)
 }
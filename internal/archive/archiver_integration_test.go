@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -16,11 +17,13 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/e-flux-platform/mongo-collection-archiver/internal/archive"
 	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
 	"github.com/e-flux-platform/mongo-collection-archiver/internal/storage"
 	"github.com/e-flux-platform/mongo-collection-archiver/internal/testutil"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/watch"
 )
 
 func TestArchiver_Integration(t *testing.T) {
@@ -96,6 +99,374 @@ func TestArchiver_Integration(t *testing.T) {
 	assert.Equal(t, date.Add(time.Second*-1), earliest)
 }
 
+func TestArchiver_View_Integration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testutil.StartMongoDB(ctx, t)
+
+	date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	database := client.Database(uuid.NewString())
+	base := database.Collection("base")
+	_, err := base.InsertMany(ctx, []any{
+		bson.M{
+			"_id":       objectIDFromHex(t, "5d6fd699ee45770009e17140"),
+			"createdAt": primitive.NewDateTimeFromTime(date),
+			"secret":    "redacted by the view",
+		},
+		bson.M{
+			"_id":       objectIDFromHex(t, "5d6fd8ec10ca90000998cf31"),
+			"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour)),
+			"secret":    "redacted by the view",
+		},
+		bson.M{
+			"_id":       objectIDFromHex(t, "5d6fdf85451f58001939950a"),
+			"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 24)),
+			"secret":    "redacted by the view",
+		},
+	})
+	require.NoError(t, err)
+
+	// The view curates what is archived, whilst documents are deleted from the collection it is defined over
+	require.NoError(t, database.CreateView(ctx, "curated", "base", mongo.Pipeline{
+		{{Key: "$project", Value: bson.M{"secret": 0}}},
+	}))
+	src := source.NewMongoDB(database.Collection("curated"), source.WithDeleteCollection(base))
+	require.NoError(t, src.CheckDeletable(ctx))
+
+	baseDir := t.TempDir()
+	target, err := storage.FromURL(ctx, fmt.Sprintf("file://%s", baseDir))
+	require.NoError(t, err)
+	defer target.Close()
+
+	archiver := archive.NewArchiver(src, target, false, false, time.Duration(0))
+	require.NoError(t, archiver.Run(ctx, date.Add(time.Hour*24)))
+
+	// The day was deleted from the base collection
+	ids := readMongoIDs(ctx, t, base)
+	require.Len(t, ids, 1)
+	assert.Equal(t, "5d6fdf85451f58001939950a", ids[0].Hex())
+
+	// And archived as seen through the view
+	archived := readFile(t, filepath.Join(baseDir, "2024/11/01.json.gz"))
+	require.Len(t, archived, 2)
+	for _, doc := range archived {
+		assert.NotContains(t, doc, "secret")
+		assert.Contains(t, doc, "createdAt")
+	}
+}
+
+func TestArchiver_Reconcile_Integration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testutil.StartMongoDB(ctx, t)
+
+	date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	collection := client.Database(uuid.NewString()).Collection("test")
+	_, err := collection.InsertMany(ctx, []any{
+		bson.M{
+			"_id":       objectIDFromHex(t, "5d6fd699ee45770009e17140"),
+			"createdAt": primitive.NewDateTimeFromTime(date),
+		},
+		bson.M{
+			"_id":       objectIDFromHex(t, "5d6fd8ec10ca90000998cf31"),
+			"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour)),
+		},
+		bson.M{
+			"_id":       objectIDFromHex(t, "5d6fdf85451f58001939950a"),
+			"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 24)),
+		},
+	})
+	require.NoError(t, err)
+
+	src := source.NewMongoDB(collection)
+	target, err := storage.FromURL(ctx, fmt.Sprintf("file://%s", t.TempDir()))
+	require.NoError(t, err)
+	defer target.Close()
+
+	// Archive without deleting, leaving the day both archived and in the collection, as a failed delete would
+	archiver := archive.NewArchiver(src, target, true, false, time.Duration(0))
+	require.NoError(t, archiver.Run(ctx, date.Add(time.Hour*24)))
+	require.Len(t, readMongoIDs(ctx, t, collection), 3)
+
+	// Reconciling deletes the archived day only
+	archiver = archive.NewArchiver(src, target, false, false, time.Duration(0))
+	require.NoError(t, archiver.Reconcile(ctx, date.Add(time.Hour*48), true))
+	ids := readMongoIDs(ctx, t, collection)
+	require.Len(t, ids, 1)
+	assert.Equal(t, "5d6fdf85451f58001939950a", ids[0].Hex())
+
+	// And is idempotent
+	require.NoError(t, archiver.Reconcile(ctx, date.Add(time.Hour*48), true))
+	assert.Len(t, readMongoIDs(ctx, t, collection), 1)
+}
+
+func TestArchiver_Stream_Integration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testutil.StartMongoDBReplicaSet(ctx, t)
+
+	collection := client.Database(uuid.NewString()).Collection("test")
+	require.NoError(t, collection.Database().CreateCollection(ctx, "test"))
+
+	baseDir := t.TempDir()
+	target, err := storage.FromURL(ctx, fmt.Sprintf("file://%s", baseDir))
+	require.NoError(t, err)
+	defer target.Close()
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	const retention = time.Second * 2
+	archiver := archive.NewArchiver(
+		source.NewMongoDB(collection),
+		target,
+		false,
+		false,
+		time.Duration(0),
+		archive.WithStreamRoll(2, time.Second),
+	)
+	done := make(chan error, 1)
+	go func() {
+		done <- archiver.Stream(streamCtx, watch.SystemClock{}, retention)
+	}()
+
+	// Give the change stream a moment to open, as inserts before then aren't observed
+	time.Sleep(time.Second)
+
+	now := time.Now().UTC()
+	docs := []any{
+		bson.M{"_id": int32(1), "createdAt": primitive.NewDateTimeFromTime(now)},
+		bson.M{"_id": int32(2), "createdAt": primitive.NewDateTimeFromTime(now)},
+		bson.M{"_id": int32(3), "createdAt": primitive.NewDateTimeFromTime(now)},
+	}
+	_, err = collection.InsertMany(ctx, docs)
+	require.NoError(t, err)
+
+	// Nothing is archived until the documents are older than the retention
+	time.Sleep(retention / 2)
+	count, err := collection.CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, count)
+
+	require.Eventually(t, func() bool {
+		count, err := collection.CountDocuments(ctx, bson.M{})
+		return err == nil && count == 0
+	}, time.Second*10, time.Millisecond*100)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	files, err := filepath.Glob(filepath.Join(baseDir, now.Format("2006/01/02")+"-stream-*.json.gz"))
+	require.NoError(t, err)
+	var archived []bson.M
+	for _, file := range files {
+		archived = append(archived, readFile(t, file)...)
+	}
+	assert.ElementsMatch(t, docs, archived)
+
+	_, err = os.Stat(filepath.Join(baseDir, archive.StreamCheckpointPath))
+	assert.NoError(t, err)
+}
+
+func TestArchiver_Provenance_Integration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testutil.StartMongoDBReplicaSet(ctx, t)
+
+	date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	collection := client.Database(uuid.NewString()).Collection("test")
+	_, err := collection.InsertOne(ctx, bson.M{"_id": int32(1), "createdAt": primitive.NewDateTimeFromTime(date)})
+	require.NoError(t, err)
+
+	provenance, err := source.ServerProvenance(ctx, client)
+	require.NoError(t, err)
+	assert.NotEmpty(t, provenance.Host)
+	assert.Equal(t, "rs0", provenance.ReplicaSet)
+	assert.Regexp(t, `^6\.`, provenance.ServerVersion)
+
+	baseDir := t.TempDir()
+	target, err := storage.FromURL(ctx, fmt.Sprintf("file://%s", baseDir))
+	require.NoError(t, err)
+	defer target.Close()
+
+	archiver := archive.NewArchiver(
+		source.NewMongoDB(collection),
+		target,
+		false,
+		false,
+		time.Duration(0),
+		archive.WithFileHeader("test"),
+		archive.WithProvenance(provenance),
+	)
+	require.NoError(t, archiver.Run(ctx, date.Add(time.Hour*24)))
+
+	raw, err := os.ReadFile(filepath.Join(baseDir, "2024/11/01.header.json"))
+	require.NoError(t, err)
+	var header struct {
+		Source source.Provenance `json:"source"`
+	}
+	require.NoError(t, json.Unmarshal(raw, &header))
+	assert.Equal(t, provenance, header.Source)
+}
+
+func TestArchiver_Rollup_Integration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testutil.StartMongoDB(ctx, t)
+
+	day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	db := client.Database(uuid.NewString())
+	collection := db.Collection("test")
+	rollups := db.Collection("test_daily")
+	for i, doc := range []struct {
+		day    time.Time
+		device string
+	}{{day1, "a"}, {day1, "a"}, {day1, "b"}, {day2, "a"}} {
+		_, err := collection.InsertOne(ctx, bson.M{
+			"_id":       int32(i + 1),
+			"createdAt": primitive.NewDateTimeFromTime(doc.day.Add(time.Hour * time.Duration(i))),
+			"device":    doc.device,
+		})
+		require.NoError(t, err)
+	}
+
+	pipeline, err := source.ParseRollupPipeline(`[
+		{"$group":{"_id":{"device":"$device","day":{"$dateTrunc":{"date":"$createdAt","unit":"day"}}},"n":{"$sum":1}}}
+	]`)
+	require.NoError(t, err)
+
+	baseDir := t.TempDir()
+	target, err := storage.FromURL(ctx, fmt.Sprintf("file://%s", baseDir))
+	require.NoError(t, err)
+	defer target.Close()
+
+	archiver := archive.NewArchiver(
+		source.NewMongoDB(collection, source.WithRollup(rollups, pipeline, "day")),
+		target,
+		false,
+		false,
+		time.Duration(0),
+		archive.WithRollup(),
+	)
+	require.NoError(t, archiver.Run(ctx, day2.AddDate(0, 0, 1)))
+
+	// Each day was rolled up
+	sort := bson.D{{Key: "day", Value: 1}, {Key: "n", Value: 1}}
+	cursor, err := rollups.Find(ctx, bson.M{}, options.Find().SetSort(sort))
+	require.NoError(t, err)
+	var docs []struct {
+		Day time.Time `bson:"day"`
+		N   int       `bson:"n"`
+	}
+	require.NoError(t, cursor.All(ctx, &docs))
+	require.Len(t, docs, 3)
+	assert.Equal(t, []int{1, 2, 1}, []int{docs[0].N, docs[1].N, docs[2].N})
+	assert.True(t, docs[0].Day.Equal(day1))
+	assert.True(t, docs[2].Day.Equal(day2))
+
+	// Then archived, and deleted
+	assert.Len(t, readFile(t, filepath.Join(baseDir, "2024/11/01.json.gz")), 3)
+	assert.Len(t, readFile(t, filepath.Join(baseDir, "2024/11/02.json.gz")), 1)
+	count, err := collection.CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func TestArchiver_RollupFailure_Integration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testutil.StartMongoDB(ctx, t)
+
+	day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	db := client.Database(uuid.NewString())
+	collection := db.Collection("test")
+	_, err := collection.InsertOne(ctx, bson.M{"_id": int32(1), "createdAt": primitive.NewDateTimeFromTime(day)})
+	require.NoError(t, err)
+
+	// A pipeline producing nothing fails verification, so the day is neither archived nor deleted
+	pipeline, err := source.ParseRollupPipeline(`[{"$match":{"missing":true}}]`)
+	require.NoError(t, err)
+
+	baseDir := t.TempDir()
+	target, err := storage.FromURL(ctx, fmt.Sprintf("file://%s", baseDir))
+	require.NoError(t, err)
+	defer target.Close()
+
+	archiver := archive.NewArchiver(
+		source.NewMongoDB(collection, source.WithRollup(db.Collection("test_daily"), pipeline, "day")),
+		target,
+		false,
+		false,
+		time.Duration(0),
+		archive.WithRollup(),
+	)
+	assert.ErrorIs(t, archiver.Run(ctx, day.AddDate(0, 0, 1)), archive.ErrIntegrity)
+
+	assert.NoFileExists(t, filepath.Join(baseDir, "2024/11/01.json.gz"))
+	count, err := collection.CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestArchiver_Merged_Integration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testutil.StartMongoDB(ctx, t)
+
+	date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	db := client.Database(uuid.NewString())
+	events := db.Collection("events")
+	metadata := db.Collection("event_metadata")
+	_, err := events.InsertMany(ctx, []any{
+		bson.M{"_id": int32(1), "createdAt": primitive.NewDateTimeFromTime(date)},
+		bson.M{"_id": int32(2), "createdAt": primitive.NewDateTimeFromTime(date.AddDate(0, 0, 1))},
+	})
+	require.NoError(t, err)
+	_, err = metadata.InsertOne(ctx, bson.M{"_id": int32(1), "createdAt": primitive.NewDateTimeFromTime(date)})
+	require.NoError(t, err)
+
+	baseDir := t.TempDir()
+	target, err := storage.FromURL(ctx, fmt.Sprintf("file://%s", baseDir))
+	require.NoError(t, err)
+	defer target.Close()
+
+	archiver := archive.NewArchiver(
+		source.NewMerged(
+			"_collection",
+			source.NamedSource{Name: "events", DaySource: source.NewMongoDB(events)},
+			source.NamedSource{Name: "event_metadata", DaySource: source.NewMongoDB(metadata)},
+		),
+		target,
+		false,
+		false,
+		time.Duration(0),
+	)
+	require.NoError(t, archiver.Run(ctx, date.AddDate(0, 0, 1)))
+
+	docs := readFile(t, filepath.Join(baseDir, "2024/11/01.json.gz"))
+	require.Len(t, docs, 2)
+	assert.Equal(t, "events", docs[0]["_collection"])
+	assert.Equal(t, "event_metadata", docs[1]["_collection"])
+
+	// The day was deleted from both collections, leaving the next day in place
+	count, err := events.CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	count, err = metadata.CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
 func readMongoIDs(ctx context.Context, t *testing.T, collection *mongo.Collection) (ids []primitive.ObjectID) {
 	t.Helper()
 
@@ -5,18 +5,30 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"iter"
+	"maps"
+	"path"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/e-flux-platform/mongo-collection-archiver/internal/archive"
 	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/storage"
 )
 
 func TestArchiver(t *testing.T) {
@@ -110,46 +122,3933 @@ func TestArchiver(t *testing.T) {
 		assert.Equal(t, day, earliest) // document should not have been deleted
 	})
 
+	t.Run("with checksum mismatch on close", func(t *testing.T) {
+		t.Parallel()
+
+		doc := `{"id":1}`
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, doc)
+
+		dest := newMockStorage()
+		dest.forceCloseError = fmt.Errorf("%w: wrote 10 bytes, object holds 5", storage.ErrChecksumMismatch)
+
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0))
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		assert.ErrorIs(t, err, storage.ErrChecksumMismatch)
+
+		earliest, err := src.EarliestCreatedAt(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, day, earliest) // document should not have been deleted
+	})
+
 	t.Run("with file already exists and no ignore", func(t *testing.T) {
 		t.Parallel()
 
-		doc := `{"id":1}`
-		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		doc := `{"id":1}`
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, doc)
+
+		dest := newMockStorage()
+		dest.files["2024/11/01.json.gz"] = bytes.NewBuffer(nil)
+
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0))
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		assert.Error(t, err)
+		assert.ErrorContains(t, err, "file exists")
+	})
+
+	t.Run("with file already exists and ignore", func(t *testing.T) {
+		t.Parallel()
+
+		doc := `{"id":1}`
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, doc)
+
+		dest := newMockStorage()
+		dest.files["2024/11/01.json.gz"] = bytes.NewBuffer(nil)
+
+		archiver := archive.NewArchiver(src, dest, false, true, time.Duration(0))
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		assert.NoError(t, err)
+
+		assert.Len(t, src.docs, 0)
+	})
+
+	t.Run("with file already exists and overwrite incomplete", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		// A complete file holding a document from a previous run
+		var complete bytes.Buffer
+		gw := gzip.NewWriter(&complete)
+		_, err := gw.Write([]byte(`{"id":0}` + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+
+		tests := []struct {
+			name        string
+			existing    []byte
+			compression archive.Compression
+			overwritten bool
+		}{
+			{name: "empty", existing: nil, overwritten: true},
+			{name: "not gzipped", existing: []byte("partial"), overwritten: true},
+			{name: "truncated", existing: complete.Bytes()[:complete.Len()-4], overwritten: true},
+			{name: "complete", existing: complete.Bytes(), overwritten: false},
+			{name: "empty store compressed", existing: nil, compression: archive.CompressionStore, overwritten: true},
+			{
+				name:        "store compressed",
+				existing:    []byte(`{"id":0}` + "\n"),
+				compression: archive.CompressionStore,
+				overwritten: false,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				t.Parallel()
+
+				src := newMockDocumentSource()
+				src.add(day, `{"id":1}`)
+
+				fileName := "2024/11/01.json.gz"
+				if tt.compression == archive.CompressionStore {
+					fileName = "2024/11/01.json"
+				}
+				dest := newMockStorage()
+				dest.files[fileName] = bytes.NewBuffer(slices.Clone(tt.existing))
+
+				archiver := archive.NewArchiver(
+					src,
+					dest,
+					false,
+					false,
+					time.Duration(0),
+					archive.WithOverwriteIncomplete(),
+					archive.WithCompression(tt.compression),
+				)
+				err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+				if !tt.overwritten {
+					// A complete file is still a collision
+					require.ErrorIs(t, err, archive.ErrIntegrity)
+					assert.ErrorContains(t, err, "file exists")
+					assert.Equal(t, tt.existing, dest.files[fileName].Bytes())
+					assert.Len(t, src.docs[day], 1)
+					return
+				}
+				require.NoError(t, err)
+				docs, err := dest.read(fileName)
+				require.NoError(t, err)
+				assert.Equal(t, []string{`{"id":1}`}, docs)
+				assert.Empty(t, src.docs)
+			})
+		}
+
+		t.Run("requires a readable store", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day, `{"id":1}`)
+
+			archiver := archive.NewArchiver(
+				src,
+				&writeOnlyStorage{newMockStorage()},
+				false,
+				false,
+				time.Duration(0),
+				archive.WithOverwriteIncomplete(),
+			)
+			err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+			assert.ErrorContains(t, err, "existing files cannot be checked for being incomplete")
+		})
+	})
+
+	t.Run("with file already exists and collision policy", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		tests := []struct {
+			name     string
+			policy   archive.CollisionPolicy
+			existing []string
+			file     string // the file the day is expected to be archived to
+			err      string
+		}{
+			{
+				name:     "fail",
+				policy:   archive.CollisionFail,
+				existing: []string{"2024/11/01.json.gz"},
+				err:      "file exists",
+			},
+			{
+				name:     "suffix",
+				policy:   archive.CollisionSuffix,
+				existing: []string{"2024/11/01.json.gz"},
+				file:     "2024/11/01-1.json.gz",
+			},
+			{
+				name:     "suffix beyond existing suffixes",
+				policy:   archive.CollisionSuffix,
+				existing: []string{"2024/11/01.json.gz", "2024/11/01-1.json.gz", "2024/11/01-2.json.gz"},
+				file:     "2024/11/01-3.json.gz",
+			},
+			{
+				name:     "overwrite",
+				policy:   archive.CollisionOverwrite,
+				existing: []string{"2024/11/01.json.gz"},
+				file:     "2024/11/01.json.gz",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				t.Parallel()
+
+				src := newMockDocumentSource()
+				src.add(day, `{"id":1}`)
+
+				dest := newMockStorage()
+				for _, name := range tt.existing {
+					dest.files[name] = bytes.NewBufferString("existing")
+				}
+
+				archiver := archive.NewArchiver(
+					src,
+					dest,
+					false,
+					false,
+					time.Duration(0),
+					archive.WithCollisionPolicy(tt.policy),
+					archive.WithFileHeader("test"),
+				)
+				err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+				if tt.err != "" {
+					require.ErrorIs(t, err, archive.ErrIntegrity)
+					assert.ErrorContains(t, err, tt.err)
+					assert.Len(t, src.docs, 1)
+					return
+				}
+				require.NoError(t, err)
+				assert.Len(t, src.docs, 0)
+
+				docs, err := dest.read(tt.file)
+				require.NoError(t, err)
+				assert.Equal(t, []string{`{"id":1}`}, docs)
+				for _, name := range tt.existing {
+					if name != tt.file {
+						assert.Equal(t, "existing", dest.files[name].String())
+					}
+				}
+
+				// The header sits alongside the file, recording the name the day collided with, if it was moved
+				var header struct {
+					File         string `json:"file"`
+					OriginalFile string `json:"originalFile"`
+				}
+				headerName := strings.TrimSuffix(tt.file, ".json.gz") + ".header.json"
+				require.NoError(t, json.Unmarshal(dest.files[headerName].Bytes(), &header))
+				assert.Equal(t, tt.file, header.File)
+				if tt.file != "2024/11/01.json.gz" {
+					assert.Equal(t, "2024/11/01.json.gz", header.OriginalFile)
+				} else {
+					assert.Empty(t, header.OriginalFile)
+				}
+			})
+		}
+	})
+
+	t.Run("with suffix collision policy and resume", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":1}`)
+
+		archiver := archive.NewArchiver(
+			src,
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithCollisionPolicy(archive.CollisionSuffix),
+			archive.WithResume(10),
+		)
+		assert.ErrorContains(t, archiver.Run(ctx, day.AddDate(0, 0, 1)), "cannot be combined with resuming")
+	})
+
+	t.Run("with store lacking exists", func(t *testing.T) {
+		t.Parallel()
+
+		doc := `{"id":1}`
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, doc)
+
+		dest := newMockStorage()
+		dest.files["2024/11/01.json.gz"] = bytes.NewBuffer(nil) // would fail if existence was checked
+
+		archiver := archive.NewArchiver(src, &writeOnlyStorage{dest}, false, false, time.Duration(0))
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+
+		day1Docs, err := dest.read("2024/11/01.json.gz")
+		require.NoError(t, err)
+		assert.Equal(t, []string{doc}, day1Docs)
+		assert.Len(t, src.docs, 0)
+	})
+
+	t.Run("with counting store", func(t *testing.T) {
+		t.Parallel()
+
+		day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		day2 := day1.AddDate(0, 0, 1)
+
+		src := newMockDocumentSource()
+		for i := range 3 {
+			src.add(day1, fmt.Sprintf(`{"id":%d}`, i))
+		}
+		for i := range 1000 {
+			src.add(day2, fmt.Sprintf(`{"id":%d,"payload":"%s"}`, i, strings.Repeat("x", 100)))
+		}
+
+		store, err := storage.FromURL(ctx, "counting://")
+		require.NoError(t, err)
+		dest := store.(*storage.Counting)
+
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0), archive.WithFileHeader("test"))
+		err = archiver.Run(ctx, day2.AddDate(0, 0, 1))
+		require.NoError(t, err)
+
+		counts := dest.Counts()
+		assert.Len(t, counts, 4)
+		assert.Equal(t, 3, counts["2024/11/01.json.gz"].Documents)
+		assert.Equal(t, 1000, counts["2024/11/02.json.gz"].Documents)
+		assert.Positive(t, counts["2024/11/02.json.gz"].Bytes)
+		assert.Equal(t, 1, counts["2024/11/02.header.json"].Documents)
+		assert.Less(t, counts["2024/11/02.json.gz"].Bytes, int64(1000*100)) // compressed
+	})
+
+	t.Run("with offset index", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		var docs []string
+		for i := 1; i <= 20; i++ {
+			doc := fmt.Sprintf(`{"_id":%d,"payload":"%s"}`, i, strings.Repeat("x", i))
+			src.add(day, doc)
+			docs = append(docs, doc)
+		}
+		src.add(day, `{"payload":"no id"}`)
+		docs = append(docs, `{"payload":"no id"}`)
+
+		dest := newMockStorage()
+
+		archiver := archive.NewArchiver(src, dest, true, false, time.Duration(0), archive.WithOffsetIndex())
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+
+		gz, err := gzip.NewReader(bytes.NewReader(dest.files["2024/11/01.json.gz"].Bytes()))
+		require.NoError(t, err)
+		contents, err := io.ReadAll(gz)
+		require.NoError(t, err)
+
+		entries, err := dest.read("2024/11/01.index.json.gz")
+		require.NoError(t, err)
+		require.Len(t, entries, len(docs))
+
+		for i, raw := range entries {
+			var entry struct {
+				ID     json.RawMessage `json:"_id"`
+				Line   int             `json:"line"`
+				Offset int64           `json:"offset"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(raw), &entry))
+			assert.Equal(t, i+1, entry.Line)
+
+			// The offset resolves to the start of the document
+			line, _, _ := bytes.Cut(contents[entry.Offset:], []byte{'\n'})
+			assert.Equal(t, docs[i], string(line))
+
+			if i < 20 {
+				assert.Equal(t, fmt.Sprint(i+1), string(entry.ID))
+			} else {
+				assert.Nil(t, entry.ID)
+			}
+		}
+	})
+
+	t.Run("with schema", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":{"$oid":"5d6fd699ee45770009e17140"},"createdAt":{"$date":{"$numberLong":"1730419200000"}},`+
+			`"amount":{"$numberInt":"1"},"meta":{"source":"api","tags":["a","b"]}}`)
+		src.add(day, `{"_id":{"$oid":"5d6fd8ec10ca90000998cf31"},"createdAt":{"$date":{"$numberLong":"1730419200000"}},`+
+			`"amount":{"$numberDouble":"1.5"},"meta":{"source":null,"retries":{"$numberLong":"3"}},"note":"x"}`)
+		src.add(day, `{"_id":{"$numberInt":"3"},"amount":"free","items":[{"sku":"a","qty":{"$numberInt":"1"}},`+
+			`{"sku":{"$numberInt":"2"}}],"plain":7,"flag":true,`+
+			`"blob":{"$binary":{"base64":"AQ==","subType":"00"}},"re":{"$regularExpression":{"pattern":"^a","options":""}}}`)
+
+		dest := newMockStorage()
+
+		archiver := archive.NewArchiver(src, dest, true, false, time.Duration(0), archive.WithSchema())
+		require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+		var schema struct {
+			File          string `json:"file"`
+			DocumentCount int    `json:"documentCount"`
+			Fields        map[string]struct {
+				Types []string `json:"types"`
+				Count int      `json:"count"`
+			} `json:"fields"`
+		}
+		require.Contains(t, dest.files, "2024/11/01.schema.json")
+		require.NoError(t, json.Unmarshal(dest.files["2024/11/01.schema.json"].Bytes(), &schema))
+		assert.Equal(t, "2024/11/01.json.gz", schema.File)
+		assert.Equal(t, 3, schema.DocumentCount)
+
+		types := make(map[string][]string)
+		counts := make(map[string]int)
+		for path, field := range schema.Fields {
+			types[path] = field.Types
+			counts[path] = field.Count
+		}
+		assert.Equal(t, map[string][]string{
+			"_id":          {"int", "objectId"},
+			"createdAt":    {"date"},
+			"amount":       {"double", "int", "string"},
+			"meta":         {"object"},
+			"meta.source":  {"null", "string"},
+			"meta.tags":    {"array"},
+			"meta.tags[]":  {"string"},
+			"meta.retries": {"long"},
+			"note":         {"string"},
+			"items":        {"array"},
+			"items[]":      {"object"},
+			"items[].sku":  {"int", "string"},
+			"items[].qty":  {"int"},
+			"plain":        {"number"},
+			"flag":         {"bool"},
+			"blob":         {"binData"},
+			"re":           {"regex"},
+		}, types)
+		assert.Equal(t, 3, counts["_id"])
+		assert.Equal(t, 2, counts["createdAt"])
+		assert.Equal(t, 2, counts["items[].sku"])
+		assert.Equal(t, 1, counts["items[].qty"])
+	})
+
+	t.Run("with schema and resume", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":1}`)
+
+		opts := []archive.Option{archive.WithSchema(), archive.WithResume(10)}
+		archiver := archive.NewArchiver(src, newMockStorage(), true, false, time.Duration(0), opts...)
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		assert.ErrorContains(t, err, "schema cannot be combined with resuming")
+	})
+
+	t.Run("with bounds", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		readBounds := func(t *testing.T, dest *mockStorage) map[string]any {
+			t.Helper()
+			require.Contains(t, dest.files, "2024/11/01.bounds.json")
+			var bounds map[string]any
+			require.NoError(t, json.Unmarshal(dest.files["2024/11/01.bounds.json"].Bytes(), &bounds))
+			return bounds
+		}
+
+		t.Run("records the lowest and highest", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":{"$oid":"5d6fd8ec10ca90000998cf31"},"createdAt":{"$date":{"$numberLong":"1730430000000"}}}`)
+			src.add(day, `{"_id":{"$oid":"5d6fd699ee45770009e17140"},"createdAt":{"$date":{"$numberLong":"1730500000000"}}}`)
+			src.add(day, `{"_id":{"$oid":"6723ef9b2f1a4c0001a1b2c3"},"createdAt":{"$date":{"$numberLong":"1730419200000"}}}`)
+			src.add(day, `{"_id":{"$oid":"5e0000000000000000000000"},"createdAt":"not a date"}`)
+
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(src, dest, true, false, time.Duration(0), archive.WithBounds())
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+			assert.Equal(t, map[string]any{
+				"file":          "2024/11/01.json.gz",
+				"documentCount": float64(4),
+				"min": map[string]any{
+					"_id":       map[string]any{"$oid": "5d6fd699ee45770009e17140"},
+					"createdAt": map[string]any{"$date": map[string]any{"$numberLong": "1730419200000"}},
+				},
+				"max": map[string]any{
+					"_id":       map[string]any{"$oid": "6723ef9b2f1a4c0001a1b2c3"},
+					"createdAt": map[string]any{"$date": map[string]any{"$numberLong": "1730500000000"}},
+				},
+			}, readBounds(t, dest))
+		})
+
+		t.Run("orders numbers by value", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":{"$numberLong":"10"}}`)
+			src.add(day, `{"_id":{"$numberDouble":"2.5"}}`)
+			src.add(day, `{"_id":{"$numberInt":"7"}}`)
+
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(src, dest, true, false, time.Duration(0), archive.WithBounds())
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+			bounds := readBounds(t, dest)
+			assert.Equal(t, map[string]any{"_id": map[string]any{"$numberDouble": "2.5"}}, bounds["min"])
+			assert.Equal(t, map[string]any{"_id": map[string]any{"$numberLong": "10"}}, bounds["max"])
+		})
+
+		t.Run("omits unorderable ids", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":{"$oid":"5d6fd699ee45770009e17140"},"createdAt":{"$date":{"$numberLong":"1730419200000"}}}`)
+			src.add(day, `{"_id":"order-1","createdAt":{"$date":{"$numberLong":"1730419200000"}}}`)
+
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(src, dest, true, false, time.Duration(0), archive.WithBounds())
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+			bounds := readBounds(t, dest)
+			createdAt := map[string]any{"$date": map[string]any{"$numberLong": "1730419200000"}}
+			assert.Equal(t, map[string]any{"createdAt": createdAt}, bounds["min"])
+			assert.Equal(t, map[string]any{"createdAt": createdAt}, bounds["max"])
+		})
+
+		t.Run("rejects resume", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":1}`)
+
+			opts := []archive.Option{archive.WithBounds(), archive.WithResume(10)}
+			archiver := archive.NewArchiver(src, newMockStorage(), true, false, time.Duration(0), opts...)
+			err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+			assert.ErrorContains(t, err, "bounds cannot be combined with resuming")
+		})
+	})
+
+	t.Run("with max document size", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		oversized := fmt.Sprintf(`{"_id":2,"payload":"%s"}`, strings.Repeat("x", 64))
+
+		newSource := func() *mockDocumentSource {
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":1}`)
+			src.add(day, oversized)
+			src.add(day, `{"_id":3}`)
+			return src
+		}
+
+		t.Run("fail", func(t *testing.T) {
+			t.Parallel()
+
+			src := newSource()
+			archiver := archive.NewArchiver(
+				src,
+				newMockStorage(),
+				false,
+				false,
+				time.Duration(0),
+				archive.WithMaxDocumentSize(32, archive.OversizeFail),
+			)
+			err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+			require.ErrorIs(t, err, archive.ErrDocumentTooLarge)
+			assert.ErrorContains(t, err, "document 2 is 86 bytes, exceeding the maximum of 32")
+			assert.Len(t, src.docs[day], 3) // nothing deleted
+		})
+
+		t.Run("fail when resuming", func(t *testing.T) {
+			t.Parallel()
+
+			src := newSource()
+			archiver := archive.NewArchiver(
+				src,
+				newMockStorage(),
+				false,
+				false,
+				time.Duration(0),
+				archive.WithMaxDocumentSize(32, archive.OversizeFail),
+				archive.WithResume(10),
+			)
+			err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+			require.ErrorIs(t, err, archive.ErrDocumentTooLarge)
+			assert.Len(t, src.docs[day], 3)
+		})
+
+		t.Run("dead letter", func(t *testing.T) {
+			t.Parallel()
+
+			src := newSource()
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithMaxDocumentSize(32, archive.OversizeDeadLetter),
+				archive.WithExactDelete(),
+				archive.WithFileHeader("events"),
+				archive.WithStrictDeleteCount(),
+			)
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+			docs, err := dest.read("2024/11/01.json.gz")
+			require.NoError(t, err)
+			assert.Equal(t, []string{`{"_id":1}`, `{"_id":3}`}, docs)
+
+			deadLettered, err := dest.read("2024/11/01.deadletter.json.gz")
+			require.NoError(t, err)
+			assert.Equal(t, []string{oversized}, deadLettered)
+
+			// Set aside documents are held by a file, so are deleted along with the rest of the day
+			assert.Empty(t, src.docs)
+
+			var header struct {
+				DocumentCount  int    `json:"documentCount"`
+				DeadLetterFile string `json:"deadLetterFile"`
+			}
+			require.NoError(t, json.Unmarshal(dest.files["2024/11/01.header.json"].Bytes(), &header))
+			assert.Equal(t, 2, header.DocumentCount)
+			assert.Equal(t, "2024/11/01.deadletter.json.gz", header.DeadLetterFile)
+		})
+
+		t.Run("dead letter when resuming", func(t *testing.T) {
+			t.Parallel()
+
+			archiver := archive.NewArchiver(
+				newSource(),
+				newMockStorage(),
+				false,
+				false,
+				time.Duration(0),
+				archive.WithMaxDocumentSize(32, archive.OversizeDeadLetter),
+				archive.WithResume(10),
+			)
+			err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+			assert.ErrorContains(t, err, "dead lettering cannot be combined with resuming")
+		})
+	})
+
+	t.Run("with max BSON document size", func(t *testing.T) {
+		t.Parallel()
+
+		// Sources yield documents once transformed, here with n renamed to a longer name, pushing the second document
+		// from 23 bytes of BSON to 46
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		renamed := `{"_id":2,"a_much_longer_field_name":"x"}`
+		newSource := func() *mockDocumentSource {
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":1}`)
+			src.add(day, renamed)
+			src.add(day, `{"_id":3}`)
+			return src
+		}
+
+		t.Run("fail", func(t *testing.T) {
+			t.Parallel()
+
+			src := newSource()
+			archiver := archive.NewArchiver(
+				src,
+				newMockStorage(),
+				false,
+				false,
+				time.Duration(0),
+				archive.WithMaxBSONDocumentSize(32, archive.OversizeFail),
+			)
+			err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+			require.ErrorIs(t, err, archive.ErrDocumentTooLarge)
+			assert.ErrorContains(t, err, "document 2 is 46 bytes as BSON, exceeding the maximum of 32")
+			assert.Len(t, src.docs[day], 3) // nothing deleted
+		})
+
+		t.Run("dead letter", func(t *testing.T) {
+			t.Parallel()
+
+			src := newSource()
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				// Within the limit on extended JSON, which is checked alongside
+				archive.WithMaxDocumentSize(64, archive.OversizeDeadLetter),
+				archive.WithMaxBSONDocumentSize(32, archive.OversizeDeadLetter),
+			)
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+			docs, err := dest.read("2024/11/01.json.gz")
+			require.NoError(t, err)
+			assert.Equal(t, []string{`{"_id":1}`, `{"_id":3}`}, docs)
+
+			deadLettered, err := dest.read("2024/11/01.deadletter.json.gz")
+			require.NoError(t, err)
+			assert.Equal(t, []string{renamed}, deadLettered)
+		})
+	})
+
+	t.Run("with required fields", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		newSource := func() *mockDocumentSource {
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":1,"type":"a","meta":{"source":"api"}}`)
+			src.add(day, `{"_id":2,"type":"b","meta":{}}`) // lacks meta.source
+			src.add(day, `{"_id":3,"type":"a","meta":{"source":null}}`)
+			return src
+		}
+		required := []string{"type", "meta.source"}
+
+		t.Run("fail", func(t *testing.T) {
+			t.Parallel()
+
+			src := newSource()
+			archiver := archive.NewArchiver(
+				src,
+				newMockStorage(),
+				false,
+				false,
+				time.Duration(0),
+				archive.WithRequiredFields(required, archive.MissingFieldFail),
+			)
+			err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+			require.ErrorIs(t, err, archive.ErrMissingRequiredField)
+			assert.ErrorContains(t, err, "document 2 lacks meta.source")
+			assert.Len(t, src.docs[day], 3) // nothing deleted
+		})
+
+		t.Run("skip", func(t *testing.T) {
+			t.Parallel()
+
+			src := newSource()
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithRequiredFields(required, archive.MissingFieldSkip),
+				archive.WithExactDelete(),
+				archive.WithStrictDeleteCount(),
+			)
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+			docs, err := dest.read("2024/11/01.json.gz")
+			require.NoError(t, err)
+			assert.Equal(t, []string{
+				`{"_id":1,"type":"a","meta":{"source":"api"}}`,
+				`{"_id":3,"type":"a","meta":{"source":null}}`,
+			}, docs)
+			assert.Len(t, dest.files, 1)
+
+			// The invalid document is left in the collection
+			assert.Equal(t, [][]byte{[]byte(`{"_id":2,"type":"b","meta":{}}`)}, src.docs[day])
+		})
+
+		t.Run("dead letter", func(t *testing.T) {
+			t.Parallel()
+
+			src := newSource()
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithRequiredFields(required, archive.MissingFieldDeadLetter),
+				archive.WithExactDelete(),
+				archive.WithDeleteChunkSize(1),
+				archive.WithFileHeader("events"),
+				archive.WithStrictDeleteCount(),
+			)
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+			// Dead lettered documents are left in the collection, unlike oversized documents
+			assert.Equal(t, [][]byte{[]byte(`{"_id":2,"type":"b","meta":{}}`)}, src.docs[day])
+
+			// Reconciling deletes only the archived documents, so the invalid document is still left alone
+			src.add(day, `{"_id":1,"type":"a","meta":{"source":"api"}}`) // as though a previous delete failed
+			require.NoError(t, archiver.Reconcile(ctx, day.AddDate(0, 0, 1), true))
+			assert.Equal(t, [][]byte{[]byte(`{"_id":2,"type":"b","meta":{}}`)}, src.docs[day])
+
+			docs, err := dest.read("2024/11/01.json.gz")
+			require.NoError(t, err)
+			assert.Len(t, docs, 2)
+
+			invalid, err := dest.read("2024/11/01.invalid.json.gz")
+			require.NoError(t, err)
+			assert.Equal(t, []string{`{"_id":2,"type":"b","meta":{}}`}, invalid)
+
+			var header struct {
+				DocumentCount int    `json:"documentCount"`
+				InvalidCount  int    `json:"invalidCount"`
+				InvalidFile   string `json:"invalidFile"`
+			}
+			require.NoError(t, json.Unmarshal(dest.files["2024/11/01.header.json"].Bytes(), &header))
+			assert.Equal(t, 2, header.DocumentCount)
+			assert.Equal(t, 1, header.InvalidCount)
+			assert.Equal(t, "2024/11/01.invalid.json.gz", header.InvalidFile)
+		})
+
+		t.Run("dead letter without exact delete", func(t *testing.T) {
+			t.Parallel()
+
+			src := newSource()
+			archiver := archive.NewArchiver(
+				src,
+				newMockStorage(),
+				false,
+				false,
+				time.Duration(0),
+				archive.WithRequiredFields(required, archive.MissingFieldDeadLetter),
+			)
+			err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+			assert.ErrorContains(t, err, "requires exact delete")
+			assert.ErrorContains(t, archiver.Reconcile(ctx, day.AddDate(0, 0, 1), false), "requires exact delete")
+			assert.Len(t, src.docs[day], 3)
+		})
+
+		t.Run("skip when resuming", func(t *testing.T) {
+			t.Parallel()
+
+			archiver := archive.NewArchiver(
+				newSource(),
+				newMockStorage(),
+				true,
+				false,
+				time.Duration(0),
+				archive.WithRequiredFields(required, archive.MissingFieldSkip),
+				archive.WithResume(10),
+			)
+			err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+			assert.ErrorContains(t, err, "cannot be combined with resuming")
+		})
+	})
+
+	t.Run("with store compression", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":1}`)
+		src.add(day, `{"_id":2}`)
+
+		dest := newMockStorage()
+		archiver := archive.NewArchiver(
+			src,
+			dest,
+			false,
+			false,
+			time.Duration(0),
+			archive.WithCompression(archive.CompressionStore),
+			archive.WithExactDelete(),
+			archive.WithOffsetIndex(),
+			archive.WithFileHeader("events"),
+		)
+		require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+		assert.Empty(t, src.docs) // verified by reading back the plain JSON
+
+		// Written as plain JSON, named without the .gz extension
+		assert.Equal(t, `{"_id":1}`+"\n"+`{"_id":2}`+"\n", dest.files["2024/11/01.json"].String())
+		assert.Contains(t, dest.files, "2024/11/01.index.json")
+		assert.NotContains(t, dest.files, "2024/11/01.json.gz")
+
+		var header struct {
+			Codec             string `json:"codec"`
+			UncompressedBytes int64  `json:"uncompressedBytes"`
+			CompressedBytes   int64  `json:"compressedBytes"`
+		}
+		require.NoError(t, json.Unmarshal(dest.files["2024/11/01.header.json"].Bytes(), &header))
+		assert.Equal(t, "none", header.Codec)
+		assert.Equal(t, int64(20), header.UncompressedBytes)
+		assert.Equal(t, int64(20), header.CompressedBytes)
+	})
+
+	t.Run("with store compression and resume", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":1}`)
+
+		archiver := archive.NewArchiver(
+			src,
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithCompression(archive.CompressionStore),
+			archive.WithResume(10),
+		)
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		assert.ErrorContains(t, err, "store compression cannot be combined with resuming")
+	})
+
+	t.Run("with max documents", func(t *testing.T) {
+		t.Parallel()
+
+		day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		day2 := day1.AddDate(0, 0, 1)
+		day3 := day2.AddDate(0, 0, 1)
+
+		src := newMockDocumentSource()
+		src.add(day1, `{"_id":1}`)
+		src.add(day1, `{"_id":2}`)
+		src.add(day2, `{"_id":3}`)
+		src.add(day2, `{"_id":4}`)
+		src.add(day3, `{"_id":5}`)
+
+		dest := newMockStorage()
+		archiver := archive.NewArchiver(
+			src,
+			dest,
+			false,
+			false,
+			time.Duration(0),
+			archive.WithMaxDocuments(3),
+			archive.WithExactDelete(),
+		)
+		require.NoError(t, archiver.Run(ctx, day3.AddDate(0, 0, 1)))
+
+		// The day crossing the cap is archived and deleted in full, with the following days left untouched
+		docs, err := dest.read("2024/11/02.json.gz")
+		require.NoError(t, err)
+		assert.Equal(t, []string{`{"_id":3}`, `{"_id":4}`}, docs)
+		assert.Len(t, dest.files, 2)
+		assert.NotContains(t, src.docs, day1)
+		assert.NotContains(t, src.docs, day2)
+		assert.Len(t, src.docs[day3], 1)
+
+		// A later run picks up where the previous one stopped
+		require.NoError(t, archiver.Run(ctx, day3.AddDate(0, 0, 1)))
+		assert.Contains(t, dest.files, "2024/11/03.json.gz")
+		assert.Empty(t, src.docs)
+	})
+
+	t.Run("with start date", func(t *testing.T) {
+		t.Parallel()
+
+		day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		day2 := day1.AddDate(0, 0, 1)
+		day3 := day2.AddDate(0, 0, 1)
+
+		src := newMockDocumentSource()
+		src.add(day1, `{"_id":1}`)
+		src.add(day2, `{"_id":2}`)
+		src.add(day3, `{"_id":3}`)
+
+		dest := newMockStorage()
+		archiver := archive.NewArchiver(
+			src,
+			dest,
+			false,
+			false,
+			time.Duration(0),
+			archive.WithStartDate(day2.Add(time.Hour)),
+		)
+		require.NoError(t, archiver.Run(ctx, day3.AddDate(0, 0, 1)))
+
+		// The run begins at the start date, even though earlier documents exist
+		assert.ElementsMatch(
+			t,
+			[]string{"2024/11/02.json.gz", "2024/11/03.json.gz"},
+			slices.Collect(maps.Keys(dest.files)),
+		)
+		assert.ElementsMatch(t, []time.Time{day1}, slices.Collect(maps.Keys(src.docs)))
+
+		// Whilst a start date before the earliest document is clamped to its day
+		dest = newMockStorage()
+		archiver = archive.NewArchiver(
+			src,
+			dest,
+			false,
+			false,
+			time.Duration(0),
+			archive.WithStartDate(day1.AddDate(0, -1, 0)),
+		)
+		require.NoError(t, archiver.Run(ctx, day2))
+		assert.ElementsMatch(t, []string{"2024/11/01.json.gz"}, slices.Collect(maps.Keys(dest.files)))
+		assert.Empty(t, src.docs)
+
+		// And a start date at or past the target is rejected
+		archiver = archive.NewArchiver(
+			src,
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithStartDate(day3),
+		)
+		src.add(day1, `{"_id":4}`)
+		assert.ErrorContains(t, archiver.Run(ctx, day2), "must be before the target")
+
+		// As is one the target reaches, but which kept days hold back
+		archiver = archive.NewArchiver(
+			src,
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithStartDate(day2),
+			archive.WithKeepDays(1, day3.Add(time.Hour)),
+		)
+		assert.ErrorContains(t, archiver.Run(ctx, day3.AddDate(0, 0, 1)), "must be before the target")
+		assert.Len(t, src.docs[day1], 1)
+	})
+
+	t.Run("with compression validation", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		t.Run("well-formed", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":1}`)
+			src.add(day, `{"_id":2}`)
+
+			dest := newAbortingStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithCompressionValidation(),
+			)
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+			assert.Empty(t, dest.aborted)
+			docs, err := dest.read("2024/11/01.json.gz")
+			require.NoError(t, err)
+			assert.Equal(t, []string{`{"_id":1}`, `{"_id":2}`}, docs)
+			assert.Empty(t, src.docs)
+		})
+
+		t.Run("corrupt compressor", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":1}`)
+			src.add(day, `{"_id":2}`)
+
+			// Writes the documents uncompressed, so the stream lacks a gzip header
+			corrupt := func(w io.Writer, _ int) (io.WriteCloser, error) {
+				return nopCloser{w}, nil
+			}
+
+			dest := newAbortingStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithCompressionValidation(),
+				archive.WithCompressor(corrupt),
+				archive.WithOffsetIndex(),
+			)
+			err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+			require.ErrorIs(t, err, archive.ErrIntegrity)
+
+			// The file and its offset index are discarded, and nothing is deleted
+			assert.ElementsMatch(t, []string{"2024/11/01.json.gz", "2024/11/01.index.json.gz"}, dest.aborted)
+			assert.Empty(t, dest.files)
+			assert.Len(t, src.docs[day], 2)
+		})
+
+		t.Run("without validation corrupt files are committed", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":1}`)
+
+			dest := newAbortingStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				true,
+				false,
+				time.Duration(0),
+				archive.WithCompressor(func(w io.Writer, _ int) (io.WriteCloser, error) {
+					return nopCloser{w}, nil
+				}),
+			)
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+			assert.Empty(t, dest.aborted)
+			assert.Contains(t, dest.files, "2024/11/01.json.gz")
+		})
+
+		t.Run("rejects store compression", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":1}`)
+
+			archiver := archive.NewArchiver(
+				src,
+				newMockStorage(),
+				false,
+				false,
+				time.Duration(0),
+				archive.WithCompressionValidation(),
+				archive.WithCompression(archive.CompressionStore),
+			)
+			assert.ErrorContains(t, archiver.Run(ctx, day.AddDate(0, 0, 1)), "cannot be combined with store compression")
+		})
+	})
+
+	t.Run("with compression threads", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		t.Run("compresses in parallel", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			var want []string
+			// Enough documents to span several blocks
+			for i := range 3000 {
+				doc := fmt.Sprintf(`{"_id":%d,"padding":"%s"}`, i, strings.Repeat("x", 1000))
+				src.add(day, doc)
+				want = append(want, doc)
+			}
+
+			dest := newAbortingStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithCompressionThreads(4),
+				archive.WithCompressionValidation(),
+			)
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+			assert.Empty(t, dest.aborted)
+			docs, err := dest.read("2024/11/01.json.gz")
+			require.NoError(t, err)
+			assert.Equal(t, want, docs)
+		})
+
+		t.Run("rejects resume", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":1}`)
+
+			archiver := archive.NewArchiver(
+				src,
+				newMockStorage(),
+				false,
+				false,
+				time.Duration(0),
+				archive.WithCompressionThreads(4),
+				archive.WithResume(2),
+			)
+			assert.ErrorContains(t, archiver.Run(ctx, day.AddDate(0, 0, 1)), "cannot be combined with resuming")
+		})
+	})
+
+	t.Run("with commit interval", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		// partial decompresses what had been committed of the file at the time, which is missing the end of the stream
+		partial := func(t *testing.T, committed []byte) string {
+			t.Helper()
+
+			gr, err := gzip.NewReader(bytes.NewReader(committed))
+			require.NoError(t, err)
+			out, err := io.ReadAll(gr)
+			require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+			return string(out)
+		}
+
+		t.Run("commits intermediate progress", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":1}`)
+			src.add(day, `{"_id":2}`)
+
+			dest := newCommittingStorage()
+			// Any interval elapses by the time the next document is written
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithCommitInterval(time.Nanosecond),
+			)
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+			commits := dest.commits["2024/11/01.json.gz"]
+			require.Len(t, commits, 2)
+			assert.Equal(t, `{"_id":1}`+"\n", partial(t, commits[0]))
+			assert.Equal(t, `{"_id":1}`+"\n"+`{"_id":2}`+"\n", partial(t, commits[1]))
+
+			docs, err := dest.read("2024/11/01.json.gz")
+			require.NoError(t, err)
+			assert.Equal(t, []string{`{"_id":1}`, `{"_id":2}`}, docs)
+		})
+
+		t.Run("commits and checkpoints when resuming", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":1}`)
+			src.add(day, `{"_id":2}`)
+
+			dest := newCommittingStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithResume(1000),
+				archive.WithCommitInterval(time.Nanosecond),
+			)
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+			// Well within the checkpoint interval, so only committing results in checkpoints
+			commits := dest.commits["2024/11/01.json.gz"]
+			require.Len(t, commits, 2)
+			gr, err := gzip.NewReader(bytes.NewReader(commits[0]))
+			require.NoError(t, err)
+			out, err := io.ReadAll(gr) // each commit completes a gzip member
+			require.NoError(t, err)
+			assert.Equal(t, `{"_id":1}`+"\n", string(out))
+		})
+
+		t.Run("not committed by stores unable to", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":1}`)
+
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(
+				src,
+				&writeOnlyStorage{dest},
+				false,
+				false,
+				time.Duration(0),
+				archive.WithCommitInterval(time.Nanosecond),
+			)
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+			docs, err := dest.read("2024/11/01.json.gz")
+			require.NoError(t, err)
+			assert.Equal(t, []string{`{"_id":1}`}, docs)
+		})
+	})
+
+	t.Run("with skip empty days", func(t *testing.T) {
+		t.Parallel()
+
+		day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		day10 := day1.AddDate(0, 0, 9)
+		target := day1.AddDate(0, 0, 14)
+
+		// drained returns a collection which has been largely archived, with a few documents left around a gap
+		drained := func() *mockDocumentSource {
+			src := newMockDocumentSource()
+			src.add(day1, `{"_id":1}`)
+			src.add(day10, `{"_id":2}`)
+			src.add(day10, `{"_id":3}`)
+			return src
+		}
+
+		t.Run("lands on days with documents", func(t *testing.T) {
+			t.Parallel()
+
+			src := drained()
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithSkipEmptyDays(),
+				archive.WithSuccessMarker(),
+			)
+			require.NoError(t, archiver.Run(ctx, target))
+
+			// Neither the days in between, nor those following the last document, are written
+			assert.ElementsMatch(
+				t,
+				[]string{"2024/11/01.json.gz", "2024/11/01/_SUCCESS", "2024/11/10.json.gz", "2024/11/10/_SUCCESS"},
+				slices.Collect(maps.Keys(dest.files)),
+			)
+			docs, err := dest.read("2024/11/10.json.gz")
+			require.NoError(t, err)
+			assert.Equal(t, []string{`{"_id":2}`, `{"_id":3}`}, docs)
+			assert.Empty(t, src.docs)
+		})
+
+		t.Run("rejects sources unable to find the next document", func(t *testing.T) {
+			t.Parallel()
+
+			archiver := archive.NewArchiver(
+				&findOnlySource{drained()},
+				newMockStorage(),
+				false,
+				false,
+				time.Duration(0),
+				archive.WithSkipEmptyDays(),
+			)
+			assert.ErrorContains(t, archiver.Run(ctx, target), "empty days cannot be skipped")
+		})
+
+		t.Run("visits every day otherwise", func(t *testing.T) {
+			t.Parallel()
+
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(drained(), dest, false, false, time.Duration(0))
+			require.NoError(t, archiver.Run(ctx, target))
+
+			assert.Len(t, dest.files, 14) // including empty archives of the days in between
+		})
+	})
+
+	t.Run("with complete days only", func(t *testing.T) {
+		t.Parallel()
+
+		day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		day2 := day1.AddDate(0, 0, 1)
+
+		src := newMockDocumentSource()
+		src.add(day1, `{"_id":1}`)
+		src.add(day2, `{"_id":2}`)
+		dest := newMockStorage()
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0), archive.WithCompleteDaysOnly())
+
+		// The day holding the target is left in place until the target reaches its end
+		require.NoError(t, archiver.Run(ctx, day2.Add(time.Hour*24-time.Nanosecond)))
+		assert.Equal(t, []string{"2024/11/01.json.gz"}, slices.Collect(maps.Keys(dest.files)))
+		assert.Contains(t, src.docs, day2)
+
+		require.NoError(t, archiver.Run(ctx, day2.AddDate(0, 0, 1)))
+		assert.Contains(t, dest.files, "2024/11/02.json.gz")
+		assert.Empty(t, src.docs)
+	})
+
+	t.Run("with keep days", func(t *testing.T) {
+		t.Parallel()
+
+		today := time.Date(2024, time.November, 10, 0, 0, 0, 0, time.UTC)
+		now := today.Add(time.Hour * 12)
+
+		src := newMockDocumentSource()
+		for i := range 6 {
+			src.add(today.AddDate(0, 0, -i), fmt.Sprintf(`{"_id":%d}`, i))
+		}
+		dest := newMockStorage()
+		archiver := archive.NewArchiver(
+			src,
+			dest,
+			false,
+			false,
+			time.Duration(0),
+			archive.WithKeepDays(2, now),
+		)
+
+		// However far the target reaches, the two most recent full days and today are left in place
+		for _, target := range []time.Time{now, today.AddDate(0, 0, -2).Add(time.Hour)} {
+			require.NoError(t, archiver.Run(ctx, target))
+			assert.ElementsMatch(
+				t,
+				[]string{"2024/11/05.json.gz", "2024/11/06.json.gz", "2024/11/07.json.gz"},
+				slices.Collect(maps.Keys(dest.files)),
+			)
+			assert.ElementsMatch(
+				t,
+				[]time.Time{today.AddDate(0, 0, -2), today.AddDate(0, 0, -1), today},
+				slices.Collect(maps.Keys(src.docs)),
+			)
+		}
+
+		// Whilst targets short of the kept days are unaffected
+		archiver = archive.NewArchiver(
+			src,
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithKeepDays(2, now.AddDate(0, 0, 10)),
+		)
+		require.NoError(t, archiver.Run(ctx, today.AddDate(0, 0, -1)))
+		assert.ElementsMatch(t, []time.Time{today.AddDate(0, 0, -1), today}, slices.Collect(maps.Keys(src.docs)))
+
+		// And days are kept by the supplied time, e.g. the server's, however skewed the local clock is
+		src = newMockDocumentSource()
+		for i := range 6 {
+			src.add(today.AddDate(0, 0, -i), fmt.Sprintf(`{"_id":%d}`, i))
+		}
+		archiver = archive.NewArchiver(
+			src,
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithKeepDays(2, now),
+			archive.WithClock(func() time.Time { return now.AddDate(0, 0, 3) }),
+		)
+		require.NoError(t, archiver.Run(ctx, now))
+		assert.ElementsMatch(
+			t,
+			[]time.Time{today.AddDate(0, 0, -2), today.AddDate(0, 0, -1), today},
+			slices.Collect(maps.Keys(src.docs)),
+		)
+	})
+
+	t.Run("with explode field", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":1,"account":"a","items":[{"sku":"x"},{"sku":"y"},{"sku":"z"}],"total":3}`)
+		src.add(day, `{"_id":2,"items":[{"sku":"x"}]}`)
+		src.add(day, `{"_id":3,"account":"b","items":[]}`)
+		src.add(day, `{"_id":4,"account":"c"}`)
+		src.add(day, `{"_id":5,"account":"d","items":null}`)
+		src.add(day, `{"_id":6,"account":"e","items":{"sku":"w"}}`)
+		dest := newMockStorage()
+		archiver := archive.NewArchiver(
+			src,
+			dest,
+			false,
+			false,
+			time.Duration(0),
+			archive.WithExplodeField("items", []string{"account", "_id"}),
+		)
+		require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+		// A record per element, with documents without any elements producing none
+		docs, err := dest.read("2024/11/01.json.gz")
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			`{"account":"a","_id":1,"items":{"sku":"x"}}`,
+			`{"account":"a","_id":1,"items":{"sku":"y"}}`,
+			`{"account":"a","_id":1,"items":{"sku":"z"}}`,
+			`{"_id":2,"items":{"sku":"x"}}`,
+			`{"account":"e","_id":6,"items":{"sku":"w"}}`,
+		}, docs)
+
+		// Whilst every document is deleted, however many records it produced
+		assert.Empty(t, src.docs)
+	})
+
+	t.Run("with explode field and resume", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":1,"items":[1]}`)
+		archiver := archive.NewArchiver(
+			src,
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithExplodeField("items", nil),
+			archive.WithResume(10),
+		)
+		assert.ErrorContains(t, archiver.Run(ctx, day.AddDate(0, 0, 1)), "cannot be combined with resuming")
+	})
+
+	t.Run("with rollup", func(t *testing.T) {
+		t.Parallel()
+
+		day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		day2 := day1.AddDate(0, 0, 1)
+		target := day2.AddDate(0, 0, 1)
+
+		tests := map[string]struct {
+			rollups     func(date time.Time) int
+			afterRollup func(src *mockDocumentSource, date time.Time)
+			expectedErr string
+			expectedOps []string
+			archived    []string
+		}{
+			"rolls up, archives, then deletes each day": {
+				rollups: func(time.Time) int { return 1 },
+				expectedOps: []string{
+					"rollup:2024-11-01", "find:2024-11-01", "delete:2024-11-01",
+					"rollup:2024-11-02", "find:2024-11-02", "delete:2024-11-02",
+				},
+				archived: []string{"2024/11/01.json.gz", "2024/11/02.json.gz"},
+			},
+			"an empty rollup stops the day before archiving": {
+				rollups:     func(date time.Time) int { return int(date.Sub(day1).Hours()) / 24 },
+				expectedErr: "the rollup holds none for the day",
+				expectedOps: []string{"rollup:2024-11-01"},
+			},
+			"documents arriving after the rollup stop the day before deleting": {
+				rollups: func(time.Time) int { return 1 },
+				afterRollup: func(src *mockDocumentSource, date time.Time) {
+					src.add(date, `{"_id":99}`)
+				},
+				expectedErr: "archived 2 documents, but rolled up 1",
+				expectedOps: []string{"rollup:2024-11-01", "find:2024-11-01"},
+				archived:    []string{"2024/11/01.json.gz"},
+			},
+		}
+
+		for name, tt := range tests {
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+
+				mock := newMockDocumentSource()
+				mock.add(day1, `{"_id":1}`)
+				mock.add(day2, `{"_id":2}`)
+				src := &rollupDocumentSource{mockDocumentSource: mock, rollups: tt.rollups}
+				if tt.afterRollup != nil {
+					src.afterRollup = func(date time.Time) { tt.afterRollup(mock, date) }
+				}
+				dest := newMockStorage()
+				archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0), archive.WithRollup())
+
+				err := archiver.Run(ctx, target)
+				if tt.expectedErr != "" {
+					require.ErrorIs(t, err, archive.ErrIntegrity)
+					assert.ErrorContains(t, err, tt.expectedErr)
+					assert.Contains(t, mock.docs, day1)
+				} else {
+					require.NoError(t, err)
+					assert.Empty(t, mock.docs)
+				}
+				assert.Equal(t, tt.expectedOps, src.ops)
+				assert.ElementsMatch(t, tt.archived, slices.Collect(maps.Keys(dest.files)))
+			})
+		}
+	})
+
+	t.Run("with rollup and a source without rollups", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":1}`)
+		archiver := archive.NewArchiver(
+			src,
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithRollup(),
+		)
+		assert.ErrorContains(t, archiver.Run(ctx, day.AddDate(0, 0, 1)), "source does not support rollups")
+	})
+
+	t.Run("refuses deleting unarchived documents", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		newSource := func() *mockDocumentSource {
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":1}`)
+			src.add(day, `{"_id":2}`)
+			return src
+		}
+
+		// Nothing is read or counted for the day, yet deleting it would delete documents, so the delete is blocked
+		src := newSource()
+		dest := newMockStorage()
+		archiver := archive.NewArchiver(
+			&divergentDocumentSource{src},
+			dest,
+			false,
+			false,
+			time.Duration(0),
+		)
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		require.ErrorIs(t, err, archive.ErrIntegrity)
+		assert.ErrorContains(t, err, "refusing to delete 2 documents, as none were archived for the day")
+		assert.Len(t, src.docs[day], 2)
+		docs, err := dest.read("2024/11/01.json.gz")
+		require.NoError(t, err)
+		assert.Empty(t, docs)
+
+		// Unless deleting unarchived documents is allowed
+		src = newSource()
+		archiver = archive.NewArchiver(
+			&divergentDocumentSource{src},
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithDeleteUnarchived(),
+		)
+		require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+		assert.Empty(t, src.docs)
+	})
+
+	t.Run("with merged collections", func(t *testing.T) {
+		t.Parallel()
+
+		day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		day2 := day1.AddDate(0, 0, 1)
+		day3 := day2.AddDate(0, 0, 1)
+
+		events := newMockDocumentSource()
+		events.add(day1, `{"_id":1,"kind":"start"}`)
+		events.add(day1, `{"_id":2,"kind":"stop"}`)
+		events.add(day3, `{"_id":3,"kind":"start"}`)
+		metadata := newMockDocumentSource()
+		metadata.add(day1, `{"_id":1,"eventId":1}`)
+		metadata.add(day2, `{"_id":2,"eventId":2}`)
+		metadata.add(day2, `{}`)
+		merged := source.NewMerged(
+			"_collection",
+			source.NamedSource{Name: "events", DaySource: dayMockDocumentSource{events}},
+			source.NamedSource{Name: "event_metadata", DaySource: dayMockDocumentSource{metadata}},
+		)
+
+		dest := newMockStorage()
+		archiver := archive.NewArchiver(merged, dest, false, false, time.Duration(0), archive.WithStrictDeleteCount())
+		require.NoError(t, archiver.Run(ctx, day3))
+
+		// A single file per day, with each document tagged with its collection
+		docs, err := dest.read("2024/11/01.json.gz")
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			`{"_collection":"events","_id":1,"kind":"start"}`,
+			`{"_collection":"events","_id":2,"kind":"stop"}`,
+			`{"_collection":"event_metadata","_id":1,"eventId":1}`,
+		}, docs)
+		docs, err = dest.read("2024/11/02.json.gz")
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			`{"_collection":"event_metadata","_id":2,"eventId":2}`,
+			`{"_collection":"event_metadata"}`,
+		}, docs)
+
+		// Whilst each day was deleted from both collections, leaving days beyond the target alone
+		assert.Equal(t, []time.Time{day3}, slices.Collect(maps.Keys(events.docs)))
+		assert.Empty(t, metadata.docs)
+	})
+
+	t.Run("with delete guard", func(t *testing.T) {
+		t.Parallel()
+
+		day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		day2 := day1.AddDate(0, 0, 1)
+		target := day2.AddDate(0, 0, 1)
+
+		docs := func() *mockDocumentSource {
+			src := newMockDocumentSource()
+			src.add(day1, `{"_id":1}`)
+			src.add(day2, `{"_id":2}`)
+			return src
+		}
+		unhealthy := errors.New("replica set unhealthy: no primary")
+		check := func(err error) func(context.Context) error {
+			return func(context.Context) error {
+				return err
+			}
+		}
+
+		t.Run("archives but doesn't delete whilst blocked", func(t *testing.T) {
+			t.Parallel()
+
+			src := docs()
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithDeleteGuard(check(unhealthy), true),
+			)
+			require.NoError(t, archiver.Run(ctx, target))
+
+			assert.ElementsMatch(
+				t,
+				[]string{"2024/11/01.json.gz", "2024/11/02.json.gz"},
+				slices.Collect(maps.Keys(dest.files)),
+			)
+			assert.Len(t, src.docs, 2)
+
+			// Once healthy, the archived days are reconciled
+			reconciler := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithDeleteGuard(check(nil), true),
+			)
+			require.NoError(t, reconciler.Reconcile(ctx, target, false))
+			assert.Empty(t, src.docs)
+		})
+
+		t.Run("stops before archiving whilst blocked", func(t *testing.T) {
+			t.Parallel()
+
+			src := docs()
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithDeleteGuard(check(unhealthy), false),
+			)
+			err := archiver.Run(ctx, target)
+			assert.ErrorIs(t, err, unhealthy)
+			assert.ErrorContains(t, err, "deletes blocked")
+
+			assert.Empty(t, dest.files)
+			assert.Len(t, src.docs, 2)
+		})
+
+		t.Run("blocks reconciling", func(t *testing.T) {
+			t.Parallel()
+
+			src := docs()
+			dest := newMockStorage()
+			require.NoError(t, archive.NewArchiver(src, dest, true, false, time.Duration(0)).Run(ctx, target))
+
+			reconciler := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithDeleteGuard(check(unhealthy), true),
+			)
+			assert.ErrorIs(t, reconciler.Reconcile(ctx, target, false), unhealthy)
+			assert.Len(t, src.docs, 2)
+		})
+
+		t.Run("deletes whilst healthy", func(t *testing.T) {
+			t.Parallel()
+
+			src := docs()
+			archiver := archive.NewArchiver(
+				src,
+				newMockStorage(),
+				false,
+				false,
+				time.Duration(0),
+				archive.WithDeleteGuard(check(nil), false),
+			)
+			require.NoError(t, archiver.Run(ctx, target))
+			assert.Empty(t, src.docs)
+		})
+	})
+
+	t.Run("with success marker", func(t *testing.T) {
+		t.Parallel()
+
+		day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		day2 := day1.AddDate(0, 0, 1)
+
+		t.Run("written once each day completes", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day1, `{"_id":1}`)
+			src.add(day2, `{"_id":2}`)
+
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithSuccessMarker(),
+				archive.WithExactDelete(),
+			)
+			require.NoError(t, archiver.Run(ctx, day2.AddDate(0, 0, 1)))
+
+			for _, marker := range []string{"2024/11/01/_SUCCESS", "2024/11/02/_SUCCESS"} {
+				require.Contains(t, dest.files, marker)
+				assert.Zero(t, dest.files[marker].Len())
+			}
+			assert.Empty(t, src.docs)
+		})
+
+		t.Run("not written when writing fails", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day1, `{"_id":1}`)
+
+			dest := newMockStorage()
+			dest.forceCloseError = errors.New("forced close failure")
+			archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0), archive.WithSuccessMarker())
+			require.ErrorIs(t, archiver.Run(ctx, day2), archive.ErrStorage)
+
+			assert.NotContains(t, dest.files, "2024/11/01/_SUCCESS")
+			assert.Len(t, src.docs[day1], 1)
+		})
+
+		t.Run("not written when deleting fails", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day1, `{"_id":1}`)
+			src.afterFind = func(date time.Time) {
+				src.add(date, `{"_id":2}`) // inserted out of band, so the deleted count differs
+			}
+
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithSuccessMarker(),
+				archive.WithStrictDeleteCount(),
+			)
+			require.ErrorIs(t, archiver.Run(ctx, day2), archive.ErrIntegrity)
+
+			// The file was written, but the day didn't complete
+			assert.Contains(t, dest.files, "2024/11/01.json.gz")
+			assert.NotContains(t, dest.files, "2024/11/01/_SUCCESS")
+		})
+	})
+
+	t.Run("with empty run manifest", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		ranAt := time.Date(2024, time.November, 10, 3, 0, 0, 0, time.UTC)
+		clock := archive.WithClock(func() time.Time { return ranAt })
+		manifestName := "_archiver/empty-runs/20241110T030000Z.json"
+
+		t.Run("written for an empty collection", func(t *testing.T) {
+			t.Parallel()
+
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(
+				newMockDocumentSource(),
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithEmptyRunManifest(),
+				clock,
+			)
+			require.NoError(t, archiver.Run(ctx, day))
+
+			require.Contains(t, dest.files, manifestName)
+			assert.JSONEq(
+				t,
+				`{"ranAt":"2024-11-10T03:00:00Z","target":"2024-11-01","reason":"collection is empty","days":0,"documents":0}`,
+				dest.files[manifestName].String(),
+			)
+			assert.Len(t, dest.files, 1)
+		})
+
+		t.Run("written when the target precedes the earliest document", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":1}`)
+
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithEmptyRunManifest(),
+				clock,
+			)
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, -1)))
+
+			require.Contains(t, dest.files, manifestName)
+			assert.JSONEq(
+				t,
+				`{"ranAt":"2024-11-10T03:00:00Z","target":"2024-10-31","earliest":"2024-11-01",`+
+					`"reason":"no documents precede the target","days":0,"documents":0}`,
+				dest.files[manifestName].String(),
+			)
+			assert.Len(t, src.docs[day], 1)
+		})
+
+		t.Run("not written once days are archived", func(t *testing.T) {
+			t.Parallel()
+
+			src := newMockDocumentSource()
+			src.add(day, `{"_id":1}`)
+
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithEmptyRunManifest(),
+				clock,
+			)
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+			assert.Contains(t, dest.files, "2024/11/01.json.gz")
+			assert.NotContains(t, dest.files, manifestName)
+		})
+
+		t.Run("empty collection fails without it", func(t *testing.T) {
+			t.Parallel()
+
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(newMockDocumentSource(), dest, false, false, time.Duration(0), clock)
+			require.ErrorIs(t, archiver.Run(ctx, day), mongo.ErrNoDocuments)
+			assert.Empty(t, dest.files)
+		})
+	})
+
+	t.Run("with day archived hook", func(t *testing.T) {
+		t.Parallel()
+
+		day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		day2 := day1.AddDate(0, 0, 1)
+
+		src := newMockDocumentSource()
+		src.add(day1, `{"_id":1}`)
+		src.add(day1, `{"_id":2}`)
+		src.add(day2, `{"_id":3}`)
+
+		var days []archive.ArchivedDay
+		archiver := archive.NewArchiver(
+			src,
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithDayArchivedHook(func(_ context.Context, day archive.ArchivedDay) error {
+				assert.NotContains(t, src.docs, day.Date) // only invoked once the day has been deleted
+				days = append(days, day)
+				return nil
+			}),
+		)
+		require.NoError(t, archiver.Run(ctx, day2.AddDate(0, 0, 1)))
+
+		require.Len(t, days, 2)
+		assert.Equal(t, day1, days[0].Date)
+		assert.Equal(t, 2, days[0].Documents)
+		assert.Equal(t, 2, days[0].Deleted)
+		require.Len(t, days[0].Files, 1)
+		assert.Equal(t, "2024/11/01.json.gz", days[0].Files[0].Name)
+		assert.Equal(t, 2, days[0].Files[0].Documents)
+		assert.Positive(t, days[0].Files[0].CompressedBytes)
+		assert.Equal(t, int64(len(`{"_id":1}`+"\n"+`{"_id":2}`+"\n")), days[0].Files[0].UncompressedBytes)
+
+		assert.Equal(t, day2, days[1].Date)
+		assert.Equal(t, 1, days[1].Documents)
+		assert.Equal(t, 1, days[1].Deleted)
+		require.Len(t, days[1].Files, 1)
+		assert.Equal(t, "2024/11/02.json.gz", days[1].Files[0].Name)
+	})
+
+	t.Run("with failing day archived hook", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":1}`)
+		src.add(day.AddDate(0, 0, 1), `{"_id":2}`)
+
+		archiver := archive.NewArchiver(
+			src,
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithDayArchivedHook(func(context.Context, archive.ArchivedDay) error {
+				return errors.New("downstream unavailable")
+			}),
+		)
+		err := archiver.Run(ctx, day.AddDate(0, 0, 2))
+		assert.ErrorContains(t, err, "post archive hook failed: downstream unavailable")
+		assert.Len(t, src.docs, 1) // the following day was not archived
+	})
+
+	t.Run("with offset index and resume", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":1}`)
+
+		archiver := archive.NewArchiver(
+			src,
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithOffsetIndex(),
+			archive.WithResume(3),
+		)
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		assert.ErrorContains(t, err, "offset index cannot be combined with resuming")
+	})
+
+	t.Run("with resume after failure", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		var expected []string
+		for i := 1; i <= 10; i++ {
+			doc := fmt.Sprintf(`{"_id":%d}`, i)
+			src.add(day, doc)
+			expected = append(expected, doc)
+		}
+		src.failAfter = 7
+
+		dest := newMockStorage()
+
+		// First run fails part way through the day, after the second checkpoint
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0), archive.WithResume(3))
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		require.Error(t, err)
+		assert.Len(t, src.docs[day], 10) // nothing deleted
+
+		var cp map[string]any
+		err = json.Unmarshal(dest.files["2024/11/01.checkpoint.json"].Bytes(), &cp)
+		require.NoError(t, err)
+		assert.Equal(t, float64(6), cp["lastId"])
+		assert.Equal(t, float64(6), cp["documents"])
+
+		// Second run resumes from the checkpoint
+		src.failAfter = 0
+		err = archiver.Run(ctx, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+
+		docs, err := dest.read("2024/11/01.json.gz")
+		require.NoError(t, err)
+		assert.Equal(t, expected, docs)
+		assert.NotContains(t, dest.files, "2024/11/01.checkpoint.json")
+		assert.Len(t, src.docs, 0)
+	})
+
+	t.Run("with resume and unsupported store", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":1}`)
+
+		archiver := archive.NewArchiver(
+			src,
+			&writeOnlyStorage{newMockStorage()},
+			false,
+			false,
+			time.Duration(0),
+			archive.WithResume(3),
+		)
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		assert.ErrorContains(t, err, "store does not support resuming")
+	})
+
+	t.Run("with adaptive compression", func(t *testing.T) {
+		t.Parallel()
+
+		day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		day2 := day1.AddDate(0, 0, 1)
+		day3 := day2.AddDate(0, 0, 1)
+
+		src := newMockDocumentSource()
+		src.add(day1, `{"id":1}`)
+		src.add(day2, `{"id":2}`)
+		src.add(day3, `{"id":3}`)
+		src.count = func(date time.Time) int {
+			return map[time.Time]int{day1: 10, day2: 500, day3: 5000}[date]
+		}
+
+		dest := newMockStorage()
+
+		archiver := archive.NewArchiver(
+			src,
+			dest,
+			false,
+			false,
+			time.Duration(0),
+			archive.WithAdaptiveCompression(100, 1000),
+		)
+		err := archiver.Run(ctx, day3.AddDate(0, 0, 1))
+		require.NoError(t, err)
+
+		// The gzip header's XFL byte records whether the fastest or best compression level was used
+		const xflBestCompression, xflBestSpeed = 2, 4
+		assert.Equal(t, byte(xflBestSpeed), dest.files["2024/11/01.json.gz"].Bytes()[8])
+		assert.Equal(t, byte(0), dest.files["2024/11/02.json.gz"].Bytes()[8])
+		assert.Equal(t, byte(xflBestCompression), dest.files["2024/11/03.json.gz"].Bytes()[8])
+	})
+
+	t.Run("with adaptive compression target", func(t *testing.T) {
+		t.Parallel()
+
+		day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		// run archives five days, each taking ten minutes per gzip level to write, returning the level used for each
+		run := func(target time.Duration) []int {
+			src := newMockDocumentSource()
+			for i := range 5 {
+				src.add(day1.AddDate(0, 0, i), fmt.Sprintf(`{"id":%d}`, i))
+			}
+
+			now := day1
+			var levels []int
+			archiver := archive.NewArchiver(
+				src,
+				newMockStorage(),
+				false,
+				false,
+				time.Duration(0),
+				archive.WithAdaptiveCompressionTarget(target),
+				archive.WithClock(func() time.Time {
+					return now
+				}),
+				archive.WithCompressor(func(w io.Writer, level int) (io.WriteCloser, error) {
+					levels = append(levels, level)
+					now = now.Add(time.Minute * 10 * time.Duration(level))
+					return gzip.NewWriterLevel(w, level)
+				}),
+			)
+			require.NoError(t, archiver.Run(ctx, day1.AddDate(0, 0, 5)))
+			return levels
+		}
+
+		t.Run("lowers the level of days over the target", func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, []int{6, 5, 4, 3, 3}, run(time.Minute*35))
+		})
+
+		t.Run("raises the level of days well within the target", func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, []int{6, 7, 8, 9, 9}, run(time.Hour*3))
+		})
+
+		t.Run("holds the level of days within the target", func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, []int{6, 6, 6, 6, 6}, run(time.Hour))
+		})
+	})
+
+	t.Run("with shared delay", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		const delay = time.Millisecond * 50
+
+		var (
+			mu     sync.Mutex
+			starts []time.Time
+		)
+		shared := archive.NewSharedDelay(delay)
+
+		var wg sync.WaitGroup
+		for range 2 {
+			src := newMockDocumentSource()
+			for i := range 3 {
+				src.add(day.AddDate(0, 0, i), `{"id":1}`)
+			}
+			src.afterFind = func(time.Time) {
+				mu.Lock()
+				defer mu.Unlock()
+				starts = append(starts, time.Now())
+			}
+
+			// The archiver's own delay would otherwise dominate
+			archiver := archive.NewArchiver(
+				src,
+				newMockStorage(),
+				false,
+				false,
+				time.Hour,
+				archive.WithSharedDelay(shared),
+			)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 3)))
+			}()
+		}
+		wg.Wait()
+
+		// Days are spaced out across both archivers, as though archived by one
+		require.Len(t, starts, 6)
+		slices.SortFunc(starts, time.Time.Compare)
+		for i := 1; i < len(starts); i++ {
+			assert.GreaterOrEqual(t, starts[i].Sub(starts[i-1]), delay*4/5)
+		}
+	})
+
+	t.Run("with pause flag", func(t *testing.T) {
+		t.Parallel()
+
+		day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		day2 := day1.AddDate(0, 0, 1)
+
+		src := newMockDocumentSource()
+		src.add(day1, `{"id":1}`)
+		src.add(day2, `{"id":2}`)
+
+		dest := &pausingStorage{mockStorage: newMockStorage(), pausedChecks: 3}
+		dest.files[archive.PauseFlagPath] = bytes.NewBuffer(nil)
+
+		archiver := archive.NewArchiver(
+			src,
+			dest,
+			false,
+			false,
+			time.Duration(0),
+			archive.WithPauseFlag(time.Millisecond, time.Millisecond*2),
+		)
+		err := archiver.Run(ctx, day2.AddDate(0, 0, 1))
+		require.NoError(t, err)
+
+		// Paused for 3 checks before the first day, then a single check before each day once removed
+		assert.Equal(t, 5, dest.pauseChecks)
+		assert.Contains(t, dest.files, "2024/11/01.json.gz")
+		assert.Contains(t, dest.files, "2024/11/02.json.gz")
+		assert.Len(t, src.docs, 0)
+	})
+
+	t.Run("with pause flag and cancellation", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, `{"id":1}`)
+
+		dest := newMockStorage()
+		dest.files[archive.PauseFlagPath] = bytes.NewBuffer(nil)
+
+		ctx, cancel := context.WithTimeout(ctx, time.Millisecond*20)
+		defer cancel()
+
+		archiver := archive.NewArchiver(
+			src,
+			dest,
+			false,
+			false,
+			time.Duration(0),
+			archive.WithPauseFlag(time.Millisecond, time.Millisecond*5),
+		)
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Len(t, src.docs, 1) // nothing archived while paused
+	})
+
+	t.Run("with exact delete and concurrent inserts", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":1}`)
+		src.add(day, `{"_id":2}`)
+		src.afterFind = func(date time.Time) {
+			src.add(date, `{"_id":3}`) // arrives after the day has been read
+		}
+
+		dest := newMockStorage()
+
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0), archive.WithExactDelete())
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+
+		docs, err := dest.read("2024/11/01.json.gz")
+		require.NoError(t, err)
+		assert.Equal(t, []string{`{"_id":1}`, `{"_id":2}`}, docs)
+
+		// Only the archived documents were deleted
+		assert.Equal(t, [][]byte{[]byte(`{"_id":3}`)}, src.docs[day])
+	})
+
+	t.Run("with exact delete to a store which can't be read", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":1}`)
+
+		// Files can't be verified, so nothing is archived or deleted
+		dest := newMockStorage()
+		archiver := archive.NewArchiver(
+			src,
+			&writeOnlyStorage{dest},
+			false,
+			false,
+			time.Duration(0),
+			archive.WithExactDelete(),
+		)
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		assert.ErrorContains(t, err, "files cannot be verified before deleting exactly")
+		assert.Empty(t, dest.files)
+		assert.Len(t, src.docs[day], 1)
+	})
+
+	t.Run("with deleted count differing from archived count", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		for _, strict := range []bool{false, true} {
+			t.Run(fmt.Sprintf("strict %t", strict), func(t *testing.T) {
+				t.Parallel()
+
+				src := newMockDocumentSource()
+				src.add(day, `{"_id":1}`)
+				src.add(day.AddDate(0, 0, 1), `{"_id":2}`)
+				src.afterFind = func(date time.Time) {
+					src.add(date, `{"_id":3}`) // inserted out of band, so deleted without being archived
+				}
+
+				var opts []archive.Option
+				if strict {
+					opts = append(opts, archive.WithStrictDeleteCount())
+				}
+				archiver := archive.NewArchiver(src, newMockStorage(), false, false, time.Duration(0), opts...)
+				err := archiver.Run(ctx, day.AddDate(0, 0, 2))
+				if !strict {
+					require.NoError(t, err)
+					assert.Empty(t, src.docs)
+					return
+				}
+				require.ErrorIs(t, err, archive.ErrIntegrity)
+				assert.ErrorContains(t, err, "deleted 2 documents, but archived 1")
+				assert.Len(t, src.docs, 1) // the following day was not archived
+			})
+		}
+	})
+
+	t.Run("with strict delete count and matching counts", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":1}`)
+		src.add(day, `{"_id":2}`)
+
+		archiver := archive.NewArchiver(
+			src,
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithStrictDeleteCount(),
+		)
+		require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+		assert.Empty(t, src.docs)
+	})
+
+	t.Run("with exact delete in chunks", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		const total, chunkSize = 5000, 100
+		newSource := func(failOnDelete int) *chunkingDocumentSource {
+			src := &chunkingDocumentSource{mockDocumentSource: newMockDocumentSource(), failOnDelete: failOnDelete}
+			for i := range total {
+				src.add(day, fmt.Sprintf(`{"_id":%d}`, i))
+			}
+			src.add(day.AddDate(0, 0, 1), `{"_id":"next"}`)
+			return src
+		}
+
+		t.Run("deletes every document, never holding more than a chunk of ids", func(t *testing.T) {
+			t.Parallel()
+
+			src := newSource(0)
+			dest := newMockStorage()
+
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithExactDelete(),
+				archive.WithDeleteChunkSize(chunkSize),
+				archive.WithStrictDeleteCount(),
+			)
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+			assert.NotContains(t, src.docs, day)
+			assert.Len(t, src.batches, total/chunkSize)
+			for _, batch := range src.batches {
+				assert.LessOrEqual(t, batch, chunkSize)
+			}
+			docs, err := dest.read("2024/11/01.json.gz")
+			require.NoError(t, err)
+			assert.Len(t, docs, total)
+		})
+
+		t.Run("leaves a failed delete for reconciling", func(t *testing.T) {
+			t.Parallel()
+
+			src := newSource(3)
+			dest := newMockStorage()
+
+			opts := []archive.Option{archive.WithExactDelete(), archive.WithDeleteChunkSize(chunkSize)}
+			archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0), opts...)
+			err := archiver.Run(ctx, day.AddDate(0, 0, 2))
+			require.ErrorContains(t, err, "after deleting 200")
+			assert.Len(t, src.docs[day], total-2*chunkSize)
+			assert.Len(t, src.docs[day.AddDate(0, 0, 1)], 1) // the following day was not archived
+
+			// The file is complete, so reconciling deletes the remainder of the day
+			require.NoError(t, archiver.Reconcile(ctx, day.AddDate(0, 0, 2), true))
+			assert.NotContains(t, src.docs, day)
+			assert.Len(t, src.docs[day.AddDate(0, 0, 1)], 1) // no archive file, so left alone
+		})
+
+		t.Run("leaves a cancelled delete for reconciling", func(t *testing.T) {
+			t.Parallel()
+
+			runCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			src := newSource(0)
+			src.cancelOnDelete, src.cancel = 2, cancel
+			dest := newMockStorage()
+
+			opts := []archive.Option{archive.WithExactDelete(), archive.WithDeleteChunkSize(chunkSize)}
+			archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0), opts...)
+			err := archiver.Run(runCtx, day.AddDate(0, 0, 2))
+			require.ErrorIs(t, err, archive.ErrDeleteInterrupted)
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.ErrorContains(t, err, fmt.Sprintf("deleted 200 of %d documents archived for 2024-11-01", total))
+			assert.Len(t, src.docs[day], total-2*chunkSize)
+
+			require.NoError(t, archiver.Reconcile(ctx, day.AddDate(0, 0, 2), true))
+			assert.NotContains(t, src.docs, day)
+			assert.Len(t, src.docs[day.AddDate(0, 0, 1)], 1)
+
+			// Nothing was lost, as the file holds every document of the day
+			docs, err := dest.read("2024/11/01.json.gz")
+			require.NoError(t, err)
+			assert.Len(t, docs, total)
+		})
+
+		t.Run("finishes a cancelled delete within the grace period", func(t *testing.T) {
+			t.Parallel()
+
+			runCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			src := newSource(0)
+			src.cancelOnDelete, src.cancel = 2, cancel
+
+			opts := []archive.Option{
+				archive.WithExactDelete(),
+				archive.WithDeleteChunkSize(chunkSize),
+				archive.WithDeleteCancelGrace(time.Minute),
+			}
+			archiver := archive.NewArchiver(src, newMockStorage(), false, false, time.Duration(0), opts...)
+			err := archiver.Run(runCtx, day.AddDate(0, 0, 2))
+			require.ErrorIs(t, err, context.Canceled)
+			assert.NotErrorIs(t, err, archive.ErrDeleteInterrupted)
+			assert.NotContains(t, src.docs, day)
+			assert.Len(t, src.docs[day.AddDate(0, 0, 1)], 1) // the run stopped once the day was deleted
+		})
+
+		t.Run("requires a readable store", func(t *testing.T) {
+			t.Parallel()
+
+			archiver := archive.NewArchiver(
+				newSource(0),
+				&writeOnlyStorage{storage: newMockStorage()},
+				false,
+				false,
+				time.Duration(0),
+				archive.WithExactDelete(),
+				archive.WithDeleteChunkSize(chunkSize),
+			)
+			err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+			assert.ErrorContains(t, err, "files cannot be verified before deleting exactly")
+		})
+	})
+
+	t.Run("with exact delete and documents lacking _id", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, `{"id":1}`)
+
+		archiver := archive.NewArchiver(src, newMockStorage(), false, false, time.Duration(0), archive.WithExactDelete())
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		assert.ErrorContains(t, err, "document has no _id")
+		assert.Len(t, src.docs[day], 1)
+	})
+
+	t.Run("with file extension", func(t *testing.T) {
+		t.Parallel()
+
+		doc := `{"id":1}`
+		day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		day2 := day1.AddDate(0, 0, 1)
+
+		src := newMockDocumentSource()
+		src.add(day1, doc)
+		src.add(day2, doc)
+
+		dest := newMockStorage()
+		dest.files["2024/11/02.json.gz"] = bytes.NewBuffer(nil) // different extension, so should not clash
+
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0), archive.WithFileExtension("ndjson"))
+		err := archiver.Run(ctx, day2.AddDate(0, 0, 1))
+		require.NoError(t, err)
+
+		for _, fileName := range []string{"2024/11/01.ndjson.gz", "2024/11/02.ndjson.gz"} {
+			docs, err := dest.read(fileName)
+			require.NoError(t, err)
+			assert.Equal(t, []string{doc}, docs)
+		}
+	})
+
+	t.Run("with file extension and file already exists", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, `{"id":1}`)
+
+		dest := newMockStorage()
+		dest.files["2024/11/01.jsonl.gz"] = bytes.NewBuffer(nil)
+
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0), archive.WithFileExtension(".jsonl"))
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		assert.ErrorContains(t, err, "file exists")
+	})
+
+	t.Run("with partition field", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":1,"region":"eu"}`)
+		src.add(day, `{"_id":2,"region":"us"}`)
+		src.add(day, `{"_id":3,"region":"eu"}`)
+		src.add(day, `{"_id":4,"region":{"$numberInt":"7"}}`)
+		src.add(day, `{"_id":5}`)
+
+		dest := newMockStorage()
+
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0), archive.WithPartitionField("region"))
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		assert.Len(t, dest.files, 4)
+
+		for fileName, expected := range map[string][]string{
+			"region=eu/2024/11/01.json.gz":       {`{"_id":1,"region":"eu"}`, `{"_id":3,"region":"eu"}`},
+			"region=us/2024/11/01.json.gz":       {`{"_id":2,"region":"us"}`},
+			"region=7/2024/11/01.json.gz":        {`{"_id":4,"region":{"$numberInt":"7"}}`},
+			"region=_missing/2024/11/01.json.gz": {`{"_id":5}`},
+		} {
+			docs, err := dest.read(fileName)
+			require.NoError(t, err)
+			assert.Equal(t, expected, docs, fileName)
+		}
+		assert.Len(t, src.docs, 0)
+	})
+
+	t.Run("with partition field and too many values", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		for i := range 100 {
+			src.add(day, fmt.Sprintf(`{"_id":%d,"region":"r%d"}`, i, i))
+		}
+
+		archiver := archive.NewArchiver(
+			src,
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithPartitionField("region"),
+		)
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		assert.ErrorContains(t, err, "distinct values")
+		assert.Len(t, src.docs[day], 100) // nothing deleted
+	})
+
+	t.Run("with partition field and max open partitions", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		// Values are interleaved, so each file is suspended and reopened for every document after its first
+		src := newMockDocumentSource()
+		for i := range 100 {
+			src.add(day, fmt.Sprintf(`{"_id":%d,"region":"r%d"}`, i, i%10))
+		}
+
+		dest := newMockStorage()
+		archiver := archive.NewArchiver(
+			src,
+			dest,
+			false,
+			false,
+			time.Duration(0),
+			archive.WithPartitionField("region"),
+			archive.WithMaxOpenPartitions(3),
+			archive.WithChecksums(),
+		)
+		require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+		assert.Len(t, dest.files, 20) // a file and checksum for each value
+		assert.Len(t, src.docs, 0)
+
+		for r := range 10 {
+			fileName := fmt.Sprintf("region=r%d/2024/11/01.json.gz", r)
+
+			sum := sha256.Sum256(dest.files[fileName].Bytes())
+			assert.Equal(t, hex.EncodeToString(sum[:])+"  01.json.gz\n", dest.files[fileName+".sha256"].String())
+
+			// Each reopening appends a gzip member of its own
+			gr, err := gzip.NewReader(bytes.NewReader(dest.files[fileName].Bytes()))
+			require.NoError(t, err)
+			gr.Multistream(false)
+			first, err := io.ReadAll(gr)
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf(`{"_id":%d,"region":"r%d"}`+"\n", r, r), string(first))
+
+			expected := make([]string, 0, 10)
+			for i := r; i < 100; i += 10 {
+				expected = append(expected, fmt.Sprintf(`{"_id":%d,"region":"r%d"}`, i, r))
+			}
+			docs, err := dest.read(fileName)
+			require.NoError(t, err)
+			assert.Equal(t, expected, docs, fileName)
+		}
+	})
+
+	t.Run("with max open partitions and a store unable to append", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		for i := range 10 {
+			src.add(day, fmt.Sprintf(`{"_id":%d,"region":"r%d"}`, i, i))
+		}
+
+		archiver := archive.NewArchiver(
+			src,
+			&writeOnlyStorage{newMockStorage()},
+			false,
+			false,
+			time.Duration(0),
+			archive.WithPartitionField("region"),
+			archive.WithMaxOpenPartitions(3),
+		)
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		assert.ErrorContains(t, err, "more than 3 distinct values")
+		assert.ErrorContains(t, err, "can't append")
+		assert.Len(t, src.docs[day], 10) // nothing deleted
+	})
+
+	t.Run("with max open partitions and an offset index", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":1,"region":"eu"}`)
+
+		archiver := archive.NewArchiver(
+			src,
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithPartitionField("region"),
+			archive.WithMaxOpenPartitions(3),
+			archive.WithOffsetIndex(),
+		)
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		assert.ErrorContains(t, err, "cannot be combined with offset indexes")
+	})
+
+	t.Run("with day session", func(t *testing.T) {
+		t.Parallel()
+
+		day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		day2 := day1.AddDate(0, 0, 1)
+
+		src := &sessionDocumentSource{mockDocumentSource: newMockDocumentSource()}
+		src.add(day1, `{"id":1}`)
+		src.add(day2, `{"id":2}`)
+
+		archiver := archive.NewArchiver(src, newMockStorage(), false, false, time.Duration(0))
+		err := archiver.Run(ctx, day2.AddDate(0, 0, 1))
+		require.NoError(t, err)
+
+		// Each day's find and delete share a session, distinct from other days
+		assert.Equal(t, []string{"find:1", "delete:1", "find:2", "delete:2"}, src.ops)
+	})
+
+	t.Run("with file header", func(t *testing.T) {
+		t.Parallel()
+
+		doc1 := `{"id":1}`
+		doc2 := `{"id":2}`
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, doc1)
+		src.add(day, doc2)
+
+		dest := newMockStorage()
+
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0), archive.WithFileHeader("test"))
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		assert.Len(t, dest.files, 2)
+		compressed := dest.files["2024/11/01.json.gz"].Len()
+
+		docs, err := dest.read("2024/11/01.json.gz")
+		require.NoError(t, err)
+		assert.Equal(t, []string{doc1, doc2}, docs)
+
+		var header map[string]any
+		err = json.Unmarshal(dest.files["2024/11/01.header.json"].Bytes(), &header)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{
+			"schemaVersion":     float64(1),
+			"collection":        "test",
+			"date":              "2024-11-01",
+			"file":              "2024/11/01.json.gz",
+			"codec":             "gzip",
+			"contentType":       "application/x-ndjson",
+			"documentCount":     float64(len(docs)),
+			"uncompressedBytes": float64(len(doc1) + len(doc2) + 2),
+			"compressedBytes":   float64(compressed),
+		}, header)
+	})
+
+	t.Run("with file header recording provenance", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		src.add(day, `{"id":1}`)
+
+		dest := newMockStorage()
+		archiver := archive.NewArchiver(
+			src,
+			dest,
+			false,
+			false,
+			time.Duration(0),
+			archive.WithFileHeader("test"),
+			archive.WithProvenance(source.Provenance{Host: "mongo-0:27017", ReplicaSet: "rs0", ServerVersion: "6.0.19"}),
+		)
+		require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+		var header struct {
+			Source map[string]any `json:"source"`
+		}
+		require.NoError(t, json.Unmarshal(dest.files["2024/11/01.header.json"].Bytes(), &header))
+		assert.Equal(t, map[string]any{
+			"host":          "mongo-0:27017",
+			"replicaSet":    "rs0",
+			"serverVersion": "6.0.19",
+		}, header.Source)
+	})
+
+	t.Run("with file header reports sizes after resuming", func(t *testing.T) {
+		t.Parallel()
+
+		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		src := newMockDocumentSource()
+		var uncompressed int
+		for i := 1; i <= 5; i++ {
+			doc := fmt.Sprintf(`{"_id":%d,"payload":"%s"}`, i, strings.Repeat("x", i*10))
+			src.add(day, doc)
+			uncompressed += len(doc) + 1
+		}
+		src.failAfter = 3
+
+		dest := newMockStorage()
+
+		// First run fails part way through the day, so the second must account for the bytes written by the first
+		archiver := archive.NewArchiver(
+			src, dest, false, false, time.Duration(0),
+			archive.WithFileHeader("test"), archive.WithResume(2),
+		)
+		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
+		require.Error(t, err)
+
+		src.failAfter = 0
+		err = archiver.Run(ctx, day.AddDate(0, 0, 1))
+		require.NoError(t, err)
+
+		var header struct {
+			DocumentCount     int   `json:"documentCount"`
+			UncompressedBytes int64 `json:"uncompressedBytes"`
+			CompressedBytes   int64 `json:"compressedBytes"`
+		}
+		err = json.Unmarshal(dest.files["2024/11/01.header.json"].Bytes(), &header)
+		require.NoError(t, err)
+		assert.Equal(t, 5, header.DocumentCount)
+		assert.EqualValues(t, uncompressed, header.UncompressedBytes)
+		assert.EqualValues(t, dest.files["2024/11/01.json.gz"].Len(), header.CompressedBytes)
+	})
+}
+
+func TestArchiver_Estimate(t *testing.T) {
+	t.Parallel()
+
+	day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	day3 := day1.AddDate(0, 0, 2)
+
+	src := newMockDocumentSource()
+	src.add(day1.Add(time.Hour*5), `{"id":1}`)
+	src.add(day3, `{"id":2}`)
+	src.averageSize = 200
+
+	var countedBefore time.Time
+	src.countBefore = func(before time.Time) int {
+		countedBefore = before
+		return 1000
+	}
+
+	archiver := archive.NewArchiver(src, newMockStorage(), false, false, time.Minute)
+	est, err := archiver.Estimate(context.Background(), day3.Add(time.Hour), 0.1)
+	require.NoError(t, err)
+
+	assert.Equal(t, day3.AddDate(0, 0, 1), countedBefore) // target day is included, as with Run
+	assert.Equal(t, archive.Estimate{
+		Days:                3,
+		Documents:           1000,
+		AverageDocumentSize: 200,
+		UncompressedBytes:   200000,
+		CompressedBytes:     20000,
+		MinimumRuntime:      time.Minute * 3,
+	}, est)
+}
+
+func TestArchiver_CompareEstimates(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, time.December, 31, 12, 0, 0, 0, time.UTC)
+	earliest := now.AddDate(0, 0, -100).Truncate(time.Hour * 24)
+
+	// A document a day for the last 100 days, with five on the earliest
+	src := newMockDocumentSource()
+	for day := earliest; day.Before(now); day = day.AddDate(0, 0, 1) {
+		src.add(day, `{"id":1}`)
+	}
+	for range 4 {
+		src.add(earliest, `{"id":1}`)
+	}
+	src.averageSize = 100
+	src.countBefore = func(before time.Time) int {
+		var n int
+		for day, docs := range src.docs {
+			if day.Before(before) {
+				n += len(docs)
+			}
+		}
+		return n
+	}
+
+	archiver := archive.NewArchiver(src, newMockStorage(), false, false, time.Second)
+	ests, err := archiver.CompareEstimates(
+		context.Background(),
+		[]time.Time{now.AddDate(0, 0, -30), now.AddDate(0, 0, -90), now.AddDate(0, 0, -200)},
+		0.5,
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, []archive.Estimate{
+		{
+			Days:                71,
+			Documents:           75,
+			AverageDocumentSize: 100,
+			UncompressedBytes:   7500,
+			CompressedBytes:     3750,
+			MinimumRuntime:      time.Second * 71,
+		},
+		{
+			Days:                11,
+			Documents:           15,
+			AverageDocumentSize: 100,
+			UncompressedBytes:   1500,
+			CompressedBytes:     750,
+			MinimumRuntime:      time.Second * 11,
+		},
+		{}, // retaining more than there is archives nothing
+	}, ests)
+
+	// Each estimate matches the one made for its target alone
+	for i, target := range []time.Time{now.AddDate(0, 0, -30), now.AddDate(0, 0, -90)} {
+		est, err := archiver.Estimate(context.Background(), target, 0.5)
+		require.NoError(t, err)
+		assert.Equal(t, ests[i], est)
+	}
+}
+
+func TestArchiver_CatchUpTTL(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	day3 := day1.AddDate(0, 0, 2)
+
+	src := newMockDocumentSource()
+	src.add(day1, `{"_id":1}`)
+	src.add(day2, `{"_id":2}`) // past the ttl, but sharing its day with documents within it
+	src.add(day3, `{"_id":3}`)
+
+	// Documents created before the second day's 06:00 are past the ttl
+	now := day3.AddDate(0, 0, 1).Add(time.Hour * 6)
+	dest := newMockStorage()
+	archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0))
+	require.NoError(t, archiver.CatchUpTTL(ctx, now, time.Hour*48))
+
+	assert.Equal(t, []string{"2024/11/01.json.gz"}, slices.Collect(maps.Keys(dest.files)))
+	docs, err := dest.read("2024/11/01.json.gz")
+	require.NoError(t, err)
+	assert.Equal(t, []string{`{"_id":1}`}, docs)
+
+	// The days holding documents within the ttl are left in place
+	assert.Equal(t, map[time.Time][][]byte{
+		day2: {[]byte(`{"_id":2}`)},
+		day3: {[]byte(`{"_id":3}`)},
+	}, src.docs)
+}
+
+func TestArchiver_Reconcile(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	day3 := day2.AddDate(0, 0, 1)
+
+	// archived returns a store holding the archive of day1 only, as though a previous run failed to delete it
+	archived := func(t *testing.T) *mockStorage {
+		t.Helper()
+
+		dest := newMockStorage()
+		w, err := dest.Create(ctx, "2024/11/01.json.gz")
+		require.NoError(t, err)
+		gw := gzip.NewWriter(w)
+		_, err = gw.Write([]byte(`{"_id":1}` + "\n" + `{"_id":2}` + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+		return dest
+	}
+	leftovers := func() *mockDocumentSource {
+		src := newMockDocumentSource()
+		src.add(day1, `{"_id":1}`)
+		src.add(day1, `{"_id":2}`)
+		src.add(day1, `{"_id":3}`) // arrived after the day was archived
+		src.add(day2, `{"_id":4}`)
+		src.add(day3, `{"_id":5}`)
+		return src
+	}
+
+	t.Run("deletes days which have been archived", func(t *testing.T) {
+		t.Parallel()
+
+		src := leftovers()
+		dest := archived(t)
+
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0))
+		require.NoError(t, archiver.Reconcile(ctx, day3, false))
+		assert.NotContains(t, src.docs, day1)
+		assert.Len(t, src.docs[day2], 1) // no archive file, so left alone
+		assert.Len(t, src.docs[day3], 1) // beyond the target
+		assert.Len(t, dest.files, 1)     // nothing written
+
+		// Reconciling again has nothing left to do
+		require.NoError(t, archiver.Reconcile(ctx, day3, false))
+		assert.Len(t, src.docs[day2], 1)
+	})
+
+	t.Run("deletes exactly the archived documents", func(t *testing.T) {
+		t.Parallel()
+
+		src := leftovers()
+
+		archiver := archive.NewArchiver(src, archived(t), false, false, time.Duration(0), archive.WithExactDelete())
+		require.NoError(t, archiver.Reconcile(ctx, day3, false))
+		assert.Equal(t, [][]byte{[]byte(`{"_id":3}`)}, src.docs[day1])
+	})
+
+	t.Run("verifies files before deleting", func(t *testing.T) {
+		t.Parallel()
+
+		src := leftovers()
+		dest := archived(t)
+		buf := dest.files["2024/11/01.json.gz"]
+		buf.Truncate(buf.Len() - 4) // e.g. cut short by a crash
+
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0))
+		err := archiver.Reconcile(ctx, day3, true)
+		require.ErrorIs(t, err, archive.ErrIntegrity)
+		assert.Len(t, src.docs[day1], 3)
+	})
+
+	t.Run("verifying catches altered contents by their CRC", func(t *testing.T) {
+		t.Parallel()
+
+		src := newMockDocumentSource()
+		src.add(day1, `{"_id":1}`)
+		src.add(day1, `{"_id":2}`)
+		dest := newMockStorage()
+		opts := []archive.Option{archive.WithFileHeader("test"), archive.WithContentCRC()}
+
+		// Archived without deleting, recording the CRC in the file header
+		require.NoError(t, archive.NewArchiver(src, dest, true, false, time.Duration(0), opts...).Run(ctx, day2))
+		original := bytes.Clone(dest.files["2024/11/01.json.gz"].Bytes())
+		var header struct {
+			ContentCRC32 string `json:"contentCrc32"`
+		}
+		require.NoError(t, json.Unmarshal(dest.files["2024/11/01.header.json"].Bytes(), &header))
+		crc := crc32.ChecksumIEEE([]byte(`{"_id":1}` + "\n" + `{"_id":2}` + "\n"))
+		assert.Equal(t, fmt.Sprintf("%08x", crc), header.ContentCRC32)
+
+		// Altered whilst remaining well-formed, so still decompressing successfully with the same number of lines
+		altered := &bytes.Buffer{}
+		gw := gzip.NewWriter(altered)
+		_, err := gw.Write([]byte(`{"_id":1}` + "\n" + `{"_id":3}` + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+		dest.files["2024/11/01.json.gz"] = altered
+
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0), opts...)
+		err = archiver.Reconcile(ctx, day2, true)
+		require.ErrorIs(t, err, archive.ErrIntegrity)
+		assert.ErrorContains(t, err, "content CRC")
+		assert.Len(t, src.docs[day1], 2)
+
+		// Whilst the original contents are verified, and the day deleted
+		dest.files["2024/11/01.json.gz"] = bytes.NewBuffer(original)
+		require.NoError(t, archiver.Reconcile(ctx, day2, true))
+		assert.NotContains(t, src.docs, day1)
+	})
+
+	t.Run("verifies a sample of lines", func(t *testing.T) {
+		t.Parallel()
+
+		dest := newMockStorage()
+		w, err := dest.Create(ctx, "2024/11/01.json.gz")
+		require.NoError(t, err)
+		gw := gzip.NewWriter(w)
+		for i := range 10000 {
+			_, err = fmt.Fprintf(gw, `{"_id":%d}`+"\n", i)
+			require.NoError(t, err)
+		}
+		require.NoError(t, gw.Close())
+
+		archiver := archive.NewArchiver(leftovers(), dest, false, false, time.Duration(0), archive.WithVerifySampleRate(0.1))
+		lines, sampled, err := archiver.VerifySample(ctx, "2024/11/01.json.gz")
+		require.NoError(t, err)
+		assert.Equal(t, 10000, lines)
+		assert.InDelta(t, 1000, sampled, 200)
+	})
+
+	t.Run("verifying a sample catches corrupt lines once sampled", func(t *testing.T) {
+		t.Parallel()
+
+		corrupt := func(t *testing.T) *mockStorage {
+			t.Helper()
+
+			dest := newMockStorage()
+			w, err := dest.Create(ctx, "2024/11/01.json.gz")
+			require.NoError(t, err)
+			gw := gzip.NewWriter(w)
+			_, err = gw.Write([]byte(`{"_id":1}` + "\n" + `{"_id":2` + "\n"))
+			require.NoError(t, err)
+			require.NoError(t, gw.Close())
+			return dest
+		}
+		// sampler samples only the lines numbered in turn, from 1
+		sampler := func(sampled ...int) func() float64 {
+			line := 0
+			return func() float64 {
+				line++
+				if slices.Contains(sampled, line) {
+					return 0
+				}
+				return 1
+			}
+		}
+
+		src := leftovers()
+		archiver := archive.NewArchiver(
+			src,
+			corrupt(t),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithVerifySampleRate(0.5),
+			archive.WithSampler(sampler(2)),
+		)
+		err := archiver.Reconcile(ctx, day3, true)
+		require.ErrorIs(t, err, archive.ErrIntegrity)
+		assert.ErrorContains(t, err, "line 2")
+		assert.Len(t, src.docs[day1], 3)
+
+		// Missed when unsampled, though the file is still read in full
+		archiver = archive.NewArchiver(
+			leftovers(),
+			corrupt(t),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithVerifySampleRate(0.5),
+			archive.WithSampler(sampler(1)),
+		)
+		lines, sampled, err := archiver.VerifySample(ctx, "2024/11/01.json.gz")
+		require.NoError(t, err)
+		assert.Equal(t, 2, lines)
+		assert.Equal(t, 1, sampled)
+	})
+
+	t.Run("skips days with a checkpoint", func(t *testing.T) {
+		t.Parallel()
+
+		src := leftovers()
+		dest := archived(t)
+		dest.files["2024/11/01.checkpoint.json"] = bytes.NewBufferString(`{"lastId":1,"offset":10,"documents":1}`)
+
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0), archive.WithResume(1))
+		require.NoError(t, archiver.Reconcile(ctx, day3, false))
+		assert.Len(t, src.docs[day1], 3)
+	})
+
+	t.Run("store without existence checks", func(t *testing.T) {
+		t.Parallel()
+
+		archiver := archive.NewArchiver(leftovers(), &writeOnlyStorage{newMockStorage()}, false, false, time.Duration(0))
+		assert.Error(t, archiver.Reconcile(ctx, day3, false))
+	})
+}
+
+func TestArchiver_Stream(t *testing.T) {
+	t.Parallel()
+
+	day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	day3 := day2.AddDate(0, 0, 1)
+
+	// streamFiles returns the documents of each streamed file for the day, in the order the files were written
+	streamFiles := func(t *testing.T, dest *mockStorage, day string) [][]string {
+		t.Helper()
+
+		var names []string
+		for name := range dest.files {
+			if strings.HasPrefix(name, day+"-stream-") {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+
+		files := make([][]string, 0, len(names))
+		for _, name := range names {
+			docs, err := dest.read(name)
+			require.NoError(t, err)
+			files = append(files, docs)
+		}
+		return files
+	}
+
+	t.Run("archives documents once eligible", func(t *testing.T) {
+		t.Parallel()
+
+		src := newStreamingDocumentSource(
+			insertEvent(1, day1.Add(time.Hour*10)),
+			insertEvent(2, day1.Add(time.Hour*11)),
+			insertEvent(3, day2.Add(time.Hour)),
+			insertEvent(4, day3.Add(time.Minute*30)), // not eligible until 01:30
+		)
+		dest := newMockStorage()
+		clock := &fakeClock{now: day3}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		src.drained = cancel
+
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0))
+		require.NoError(t, archiver.Stream(ctx, clock, time.Hour))
+
+		assert.Equal(t, [][]string{{`{"_id":1}`, `{"_id":2}`}}, streamFiles(t, dest, "2024/11/01"))
+		assert.Equal(t, [][]string{{`{"_id":3}`}}, streamFiles(t, dest, "2024/11/02"))
+		assert.Equal(t, [][]string{{`{"_id":4}`}}, streamFiles(t, dest, "2024/11/03"))
+		assert.False(t, clock.now.Before(day3.Add(time.Minute*90)), "document 4 was archived before it was eligible")
+		assert.Empty(t, src.docs)
+
+		var cp struct {
+			ResumeToken string `json:"resumeToken"`
+		}
+		require.NoError(t, json.Unmarshal(dest.files[archive.StreamCheckpointPath].Bytes(), &cp))
+		assert.Equal(t, "token-4", cp.ResumeToken)
+	})
+
+	t.Run("rolls files", func(t *testing.T) {
+		t.Parallel()
+
+		src := newStreamingDocumentSource(
+			insertEvent(1, day1.Add(time.Hour)),
+			insertEvent(2, day1.Add(time.Hour*2)),
+			insertEvent(3, day1.Add(time.Hour*3)),
+		)
+		dest := newMockStorage()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		src.drained = cancel
+
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0), archive.WithStreamRoll(2, time.Hour))
+		require.NoError(t, archiver.Stream(ctx, &fakeClock{now: day3}, time.Hour))
+
+		assert.Equal(t, [][]string{{`{"_id":1}`, `{"_id":2}`}, {`{"_id":3}`}}, streamFiles(t, dest, "2024/11/01"))
+	})
+
+	t.Run("resumes after the checkpoint", func(t *testing.T) {
+		t.Parallel()
+
+		src := newStreamingDocumentSource()
+		dest := newMockStorage()
+		dest.files[archive.StreamCheckpointPath] = bytes.NewBufferString(`{"resumeToken":"token-7"}`)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		src.drained = cancel
+
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0))
+		require.NoError(t, archiver.Stream(ctx, &fakeClock{now: day3}, time.Hour))
+		assert.JSONEq(t, `"token-7"`, string(src.resumedAfter))
+	})
+
+	t.Run("without delete", func(t *testing.T) {
+		t.Parallel()
+
+		src := newStreamingDocumentSource(insertEvent(1, day1))
+		dest := newMockStorage()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		src.drained = cancel
+
+		archiver := archive.NewArchiver(src, dest, true, false, time.Duration(0))
+		require.NoError(t, archiver.Stream(ctx, &fakeClock{now: day3}, time.Hour))
+		assert.Equal(t, [][]string{{`{"_id":1}`}}, streamFiles(t, dest, "2024/11/01"))
+		assert.Len(t, src.docs, 1)
+	})
+
+	t.Run("source without change streams", func(t *testing.T) {
+		t.Parallel()
+
+		archiver := archive.NewArchiver(newMockDocumentSource(), newMockStorage(), false, false, time.Duration(0))
+		assert.Error(t, archiver.Stream(context.Background(), &fakeClock{now: day3}, time.Hour))
+	})
+}
+
+func TestArchiver_Encoder(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	docs := func() *mockDocumentSource {
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":{"$numberInt":"1"},"name":"first"}`)
+		src.add(day, `{"_id":{"$numberInt":"2"},"name":"second"}`)
+		return src
+	}
+
+	t.Run("custom encoder from the registry", func(t *testing.T) {
+		t.Parallel()
+
+		archive.RegisterEncoder("test-tsv", tsvEncoder{})
+		encoder, err := archive.LookupEncoder("test-tsv")
+		require.NoError(t, err)
+
+		dest := newMockStorage()
+		archiver := archive.NewArchiver(
+			docs(),
+			dest,
+			false,
+			false,
+			time.Duration(0),
+			archive.WithEncoder(encoder),
+			archive.WithFileHeader("test"),
+			archive.WithOffsetIndex(),
+		)
+		require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+		// Named by the encoder's extension
+		lines, err := dest.read("2024/11/01.tsv.gz")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1\tfirst", "2\tsecond"}, lines)
+
+		var header struct {
+			File        string `json:"file"`
+			ContentType string `json:"contentType"`
+		}
+		require.NoError(t, json.Unmarshal(dest.files["2024/11/01.header.json"].Bytes(), &header))
+		assert.Equal(t, "2024/11/01.tsv.gz", header.File)
+		assert.Equal(t, "text/tab-separated-values", header.ContentType)
+
+		// Offsets locate documents within the encoded archive, though the index remains extended JSON
+		index, err := dest.read("2024/11/01.index.json.gz")
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			`{"_id":{"$numberInt":"1"},"line":1,"offset":0}`,
+			`{"_id":{"$numberInt":"2"},"line":2,"offset":8}`,
+		}, index)
+	})
+
+	t.Run("bson", func(t *testing.T) {
+		t.Parallel()
+
+		encoder, err := archive.LookupEncoder("bson")
+		require.NoError(t, err)
+
+		dest := newMockStorage()
+		archiver := archive.NewArchiver(docs(), dest, false, false, time.Duration(0), archive.WithEncoder(encoder))
+		require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+		gr, err := gzip.NewReader(dest.files["2024/11/01.bson.gz"])
+		require.NoError(t, err)
+		var names []string
+		for {
+			doc, err := bson.ReadDocument(gr)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			names = append(names, doc.Lookup("name").StringValue())
+		}
+		assert.Equal(t, []string{"first", "second"}, names)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := archive.LookupEncoder("avro")
+		assert.ErrorContains(t, err, `unknown format "avro"`)
+	})
+
+	t.Run("rejects exact delete", func(t *testing.T) {
+		t.Parallel()
+
+		encoder, err := archive.LookupEncoder("bson")
+		require.NoError(t, err)
+
+		archiver := archive.NewArchiver(
+			docs(),
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithEncoder(encoder),
+			archive.WithExactDelete(),
+		)
+		assert.ErrorContains(t, archiver.Run(ctx, day.AddDate(0, 0, 1)), "cannot be combined with other formats")
+	})
+}
+
+// tsvEncoder renders the _id and name of each document as a line of tab separated values
+type tsvEncoder struct{}
+
+func (tsvEncoder) Encode(doc bson.Raw, w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%v\t%s\n", doc.Lookup("_id").Int32(), doc.Lookup("name").StringValue())
+	return err
+}
+
+func (tsvEncoder) Extension() string {
+	return "tsv"
+}
+
+func (tsvEncoder) ContentType() string {
+	return "text/tab-separated-values"
+}
+
+func TestArchiver_SpecialTypes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	decimal := `{"_id":{"$numberInt":"1"},"amount":{"$numberDecimal":"12.50"}}`
+	oldUUID := `{"_id":{"$numberInt":"2"},"ref":{"$binary":{"base64":"AAECAwQFBgcICQoLDA0ODw==","subType":"03"}}}`
+	generic := `{"_id":{"$numberInt":"3"},"blob":{"$binary":{"base64":"AAEC","subType":"00"}}}`
+	plain := `{"_id":{"$numberInt":"4"},"name":"plain"}`
+	newSource := func() *mockDocumentSource {
+		src := newMockDocumentSource()
+		for _, doc := range []string{decimal, oldUUID, generic, plain} {
+			src.add(day, doc)
+		}
+		return src
+	}
+
+	type header struct {
+		DocumentCount    int    `json:"documentCount"`
+		SpecialTypeCount int    `json:"specialTypeCount"`
+		SpecialTypesFile string `json:"specialTypesFile"`
+	}
+	readHeader := func(t *testing.T, dest *mockStorage) header {
+		var h header
+		require.NoError(t, json.Unmarshal(dest.files["2024/11/01.header.json"].Bytes(), &h))
+		return h
+	}
+
+	for _, policy := range []archive.SpecialTypesPolicy{archive.SpecialTypesCanonical, archive.SpecialTypesFlag} {
+		t.Run(policy.String(), func(t *testing.T) {
+			t.Parallel()
+
+			src := newSource()
+			dest := newMockStorage()
+			archiver := archive.NewArchiver(
+				src,
+				dest,
+				false,
+				false,
+				time.Duration(0),
+				archive.WithSpecialTypes(policy),
+				archive.WithFileHeader("events"),
+			)
+			require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+			// Archived as canonical extended JSON, so Decimal128 and binary subtypes are preserved exactly
+			docs, err := dest.read("2024/11/01.json.gz")
+			require.NoError(t, err)
+			assert.Equal(t, []string{decimal, oldUUID, generic, plain}, docs)
+			assert.NotContains(t, dest.files, "2024/11/01.special.bson.gz")
+			assert.Equal(t, header{DocumentCount: 4, SpecialTypeCount: 2}, readHeader(t, dest))
+			assert.Empty(t, src.docs)
+		})
+	}
+
+	t.Run("bson", func(t *testing.T) {
+		t.Parallel()
+
+		src := newSource()
+		dest := newMockStorage()
+		archiver := archive.NewArchiver(
+			src,
+			dest,
+			false,
+			false,
+			time.Duration(0),
+			archive.WithSpecialTypes(archive.SpecialTypesBSON),
+			archive.WithFileHeader("events"),
+		)
+		require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+
+		docs, err := dest.read("2024/11/01.json.gz")
+		require.NoError(t, err)
+		assert.Equal(t, []string{generic, plain}, docs)
+
+		gr, err := gzip.NewReader(dest.files["2024/11/01.special.bson.gz"])
+		require.NoError(t, err)
+		var special []bson.Raw
+		for {
+			doc, err := bson.ReadDocument(gr)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			special = append(special, doc)
+		}
+		require.Len(t, special, 2)
+
+		amount, ok := special[0].Lookup("amount").Decimal128OK()
+		require.True(t, ok)
+		assert.Equal(t, "12.50", amount.String())
+		subtype, data, ok := special[1].Lookup("ref").BinaryOK()
+		require.True(t, ok)
+		assert.Equal(t, bson.TypeBinaryUUIDOld, subtype)
+		assert.Equal(t, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, data)
+
+		// Set aside documents are held by a file, so are deleted along with the rest of the day
+		assert.Empty(t, src.docs)
+		assert.Equal(t, header{
+			DocumentCount:    2,
+			SpecialTypeCount: 2,
+			SpecialTypesFile: "2024/11/01.special.bson.gz",
+		}, readHeader(t, dest))
+	})
+
+	t.Run("bson rejects exact delete", func(t *testing.T) {
+		t.Parallel()
+
+		archiver := archive.NewArchiver(
+			newSource(),
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithSpecialTypes(archive.SpecialTypesBSON),
+			archive.WithExactDelete(),
+		)
+		assert.ErrorContains(t, archiver.Run(ctx, day.AddDate(0, 0, 1)), "cannot be combined with exact delete")
+	})
+}
+
+func TestArchiver_Layout(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	run := func(t *testing.T, dest *mockStorage, opts ...archive.Option) {
+		t.Helper()
+
+		src := newMockDocumentSource()
+		src.add(day, `{"_id":{"$numberInt":"1"},"name":"first","tenant":"a"}`)
+		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0), opts...)
+		require.NoError(t, archiver.Run(ctx, day.AddDate(0, 0, 1)))
+	}
+
+	t.Run("with default settings", func(t *testing.T) {
+		t.Parallel()
+
+		dest := newMockStorage()
+		run(t, dest, archive.WithLayout(nil))
+
+		assert.JSONEq(t, `{
+			"schemaVersion": 1,
+			"pathTemplate": "{yyyy}/{mm}/{dd}.json.gz",
+			"codec": "gzip",
+			"contentType": "application/x-ndjson",
+			"recordSeparator": "\n",
+			"fieldTransforms": []
+		}`, dest.files[archive.LayoutPath].String())
+	})
+
+	t.Run("with non-default settings", func(t *testing.T) {
+		t.Parallel()
+
+		encoder, err := archive.LookupEncoder("bson")
+		require.NoError(t, err)
+
+		dest := newMockStorage()
+		run(
+			t,
+			dest,
+			archive.WithLayout([]archive.FieldTransform{
+				{Kind: "rename", Field: "userId", To: "user_id"},
+				{Kind: "plain", Field: "createdAt"},
+			}),
+			archive.WithEncoder(encoder),
+			archive.WithPartitionField("tenant"),
+		)
+
+		assert.JSONEq(t, `{
+			"schemaVersion": 1,
+			"pathTemplate": "tenant={value}/{yyyy}/{mm}/{dd}.bson.gz",
+			"codec": "gzip",
+			"contentType": "application/bson",
+			"recordSeparator": "",
+			"fieldTransforms": [
+				{"kind": "rename", "field": "userId", "to": "user_id"},
+				{"kind": "plain", "field": "createdAt"}
+			]
+		}`, dest.files[archive.LayoutPath].String())
+		assert.Contains(t, dest.files, "tenant=a/2024/11/01.bson.gz")
+	})
+
+	t.Run("omits the record separator of custom encoders", func(t *testing.T) {
+		t.Parallel()
+
+		dest := newMockStorage()
+		run(
+			t,
+			dest,
+			archive.WithLayout(nil),
+			archive.WithEncoder(tsvEncoder{}),
+			archive.WithCompression(archive.CompressionStore),
+		)
+
+		assert.JSONEq(t, `{
+			"schemaVersion": 1,
+			"pathTemplate": "{yyyy}/{mm}/{dd}.tsv",
+			"codec": "none",
+			"contentType": "text/tab-separated-values",
+			"fieldTransforms": []
+		}`, dest.files[archive.LayoutPath].String())
+	})
+
+	t.Run("rewritten only when settings change", func(t *testing.T) {
+		t.Parallel()
+
+		dest := newMockStorage()
+		run(t, dest, archive.WithLayout(nil))
+		written := dest.files[archive.LayoutPath]
+
+		delete(dest.files, "2024/11/01.json.gz")
+		run(t, dest, archive.WithLayout(nil))
+		assert.Same(t, written, dest.files[archive.LayoutPath])
+
+		run(t, dest, archive.WithLayout(nil), archive.WithFileExtension("ndjson"))
+		assert.NotSame(t, written, dest.files[archive.LayoutPath])
+		assert.Contains(t, dest.files[archive.LayoutPath].String(), `"pathTemplate": "{yyyy}/{mm}/{dd}.ndjson.gz"`)
+	})
+}
+
+func TestArchiver_Progress(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	type progress struct {
+		UpdatedAt         time.Time `json:"updatedAt"`
+		Target            string    `json:"target"`
+		Date              string    `json:"date"`
+		DaysArchived      int       `json:"daysArchived"`
+		Documents         int       `json:"documents"`
+		UncompressedBytes int64     `json:"uncompressedBytes"`
+		CompressedBytes   int64     `json:"compressedBytes"`
+		Finished          bool      `json:"finished"`
+		Error             string    `json:"error"`
+	}
+
+	first := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	second := first.AddDate(0, 0, 1)
+	src := newMockDocumentSource()
+	for i := 1; i <= 10; i++ {
+		src.add(first, fmt.Sprintf(`{"_id":%d}`, i))
+		src.add(second, fmt.Sprintf(`{"_id":%d}`, i+10))
+	}
+
+	// Every reading of the clock advances it by a minute, so that progress falls due as documents are written
+	run := func(t *testing.T, src *mockDocumentSource, opts ...archive.Option) (*recordingStorage, []progress, error) {
+		t.Helper()
+
+		now := first.AddDate(0, 1, 0)
+		clock := func() time.Time {
+			now = now.Add(time.Minute)
+			return now
+		}
+		dest := &recordingStorage{mockStorage: newMockStorage(), path: "_archiver/progress.json"}
+		archiver := archive.NewArchiver(
+			src,
+			dest,
+			true,
+			false,
+			time.Duration(0),
+			append(opts, archive.WithProgress("_archiver/progress.json", 5*time.Minute), archive.WithClock(clock))...,
+		)
+		runErr := archiver.Run(ctx, second.AddDate(0, 0, 1))
+
+		updates := make([]progress, len(dest.writes))
+		for i, write := range dest.writes {
+			require.NoError(t, json.Unmarshal([]byte(write), &updates[i]))
+		}
+		return dest, updates, runErr
+	}
+
+	t.Run("updated at the interval", func(t *testing.T) {
+		t.Parallel()
+
+		dest, updates, err := run(t, src)
+		require.NoError(t, err)
+		require.Greater(t, len(updates), 3)
+
+		// Updates whilst running are at least an interval apart, and never go backwards
+		for i := 1; i < len(updates)-1; i++ {
+			assert.GreaterOrEqual(t, updates[i].UpdatedAt.Sub(updates[i-1].UpdatedAt), 5*time.Minute)
+			assert.GreaterOrEqual(t, updates[i].Documents, updates[i-1].Documents)
+			assert.False(t, updates[i].Finished)
+		}
+		assert.Equal(t, progress{UpdatedAt: updates[0].UpdatedAt, Target: "2024-11-03"}, updates[0])
+
+		var uncompressed int64
+		for _, date := range []time.Time{first, second} {
+			for _, doc := range src.docs[date] {
+				uncompressed += int64(len(doc)) + 1
+			}
+		}
+		last := updates[len(updates)-1]
+		assert.Equal(t, progress{
+			UpdatedAt:         last.UpdatedAt,
+			Target:            "2024-11-03",
+			Date:              "2024-11-02",
+			DaysArchived:      2,
+			Documents:         20,
+			UncompressedBytes: uncompressed,
+			CompressedBytes:   int64(dest.files["2024/11/01.json.gz"].Len() + dest.files["2024/11/02.json.gz"].Len()),
+			Finished:          true,
+		}, last)
+	})
+
+	t.Run("records the error the run failed with", func(t *testing.T) {
+		t.Parallel()
+
+		failing := newMockDocumentSource()
+		failing.docs = src.docs
+		failing.failAfter = 3
+
+		// Failing part way through a day is only simulated when resuming, which also covers progress when resuming
+		_, updates, err := run(t, failing, archive.WithResume(2))
+		require.Error(t, err)
+
+		last := updates[len(updates)-1]
+		assert.True(t, last.Finished)
+		assert.Equal(t, err.Error(), last.Error)
+		assert.Equal(t, "2024-11-01", last.Date)
+		assert.Equal(t, 3, last.Documents)
+		assert.Zero(t, last.DaysArchived)
+	})
+}
 
-		src := newMockDocumentSource()
-		src.add(day, doc)
+func TestArchiver_Peek(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	first := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	second := first.AddDate(0, 0, 1)
+	archived := func(t *testing.T, opts ...archive.Option) (*mockStorage, map[time.Time][]string) {
+		t.Helper()
 
+		src := newMockDocumentSource()
+		docs := make(map[time.Time][]string)
+		for i := 1; i <= 10; i++ {
+			for _, date := range []time.Time{first, second} {
+				doc := fmt.Sprintf(`{"_id":{"$numberInt":"%d"},"name":"%s"}`, i, date.Format(time.DateOnly))
+				src.add(date, doc)
+				docs[date] = append(docs[date], doc)
+			}
+		}
 		dest := newMockStorage()
-		dest.files["2024/11/01.json.gz"] = bytes.NewBuffer(nil)
+		archiver := archive.NewArchiver(src, dest, true, false, time.Duration(0), opts...)
+		require.NoError(t, archiver.Run(ctx, second.AddDate(0, 0, 1)))
+		return dest, docs
+	}
+	strs := func(docs [][]byte) []string {
+		out := make([]string, len(docs))
+		for i, doc := range docs {
+			out[i] = string(doc)
+		}
+		return out
+	}
 
-		archiver := archive.NewArchiver(src, dest, false, false, time.Duration(0))
-		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
-		assert.Error(t, err)
-		assert.ErrorContains(t, err, "file exists")
+	t.Run("first documents of the latest day", func(t *testing.T) {
+		t.Parallel()
+
+		dest, docs := archived(t, archive.WithFileHeader("test"), archive.WithChecksums())
+		path, peeked, err := archive.Peek(ctx, dest, "", 3, false)
+		require.NoError(t, err)
+		assert.Equal(t, "2024/11/02.json.gz", path)
+		assert.Equal(t, docs[second][:3], strs(peeked))
 	})
 
-	t.Run("with file already exists and ignore", func(t *testing.T) {
+	t.Run("supplied file", func(t *testing.T) {
 		t.Parallel()
 
-		doc := `{"id":1}`
-		day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		dest, docs := archived(t)
+		path, peeked, err := archive.Peek(ctx, dest, "2024/11/01.json.gz", 20, false)
+		require.NoError(t, err)
+		assert.Equal(t, "2024/11/01.json.gz", path)
+		assert.Equal(t, docs[first], strs(peeked))
+	})
+
+	t.Run("random sample", func(t *testing.T) {
+		t.Parallel()
+
+		dest, docs := archived(t)
+		_, peeked, err := archive.Peek(ctx, dest, "2024/11/01.json.gz", 4, true)
+		require.NoError(t, err)
+		assert.Len(t, peeked, 4)
+		assert.Subset(t, docs[first], strs(peeked))
+	})
+
+	t.Run("uncompressed BSON", func(t *testing.T) {
+		t.Parallel()
+
+		encoder, err := archive.LookupEncoder("bson")
+		require.NoError(t, err)
+
+		dest, docs := archived(t, archive.WithEncoder(encoder), archive.WithCompression(archive.CompressionStore))
+		path, peeked, err := archive.Peek(ctx, dest, "", 2, false)
+		require.NoError(t, err)
+		assert.Equal(t, "2024/11/02.bson", path)
+		require.Len(t, peeked, 2)
+		for i, doc := range peeked {
+			assert.JSONEq(t, docs[second][i], string(doc))
+		}
+	})
+
+	t.Run("empty store", func(t *testing.T) {
+		t.Parallel()
+
+		_, _, err := archive.Peek(ctx, newMockStorage(), "", 3, false)
+		assert.ErrorContains(t, err, "store holds no archive files")
+	})
+}
+
+func TestArchiver_Audit(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	day1 := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	day3 := day2.AddDate(0, 0, 1)
+
+	// archived returns a store holding three days archived with checksums, along with their offset indexes
+	archived := func(t *testing.T) *mockStorage {
+		t.Helper()
 
 		src := newMockDocumentSource()
-		src.add(day, doc)
+		src.add(day1, `{"_id":1}`)
+		src.add(day2, `{"_id":2}`)
+		src.add(day3, `{"_id":3}`)
 
 		dest := newMockStorage()
-		dest.files["2024/11/01.json.gz"] = bytes.NewBuffer(nil)
+		archiver := archive.NewArchiver(
+			src,
+			dest,
+			false,
+			false,
+			time.Duration(0),
+			archive.WithChecksums(),
+			archive.WithOffsetIndex(),
+		)
+		require.NoError(t, archiver.Run(ctx, day3.AddDate(0, 0, 1)))
+		return dest
+	}
 
-		archiver := archive.NewArchiver(src, dest, false, true, time.Duration(0))
-		err := archiver.Run(ctx, day.AddDate(0, 0, 1))
-		assert.NoError(t, err)
+	t.Run("checksums written in sha256sum format", func(t *testing.T) {
+		t.Parallel()
 
-		assert.Len(t, src.docs, 0)
+		dest := archived(t)
+		for _, name := range []string{"2024/11/01.json.gz", "2024/11/01.index.json.gz"} {
+			require.Contains(t, dest.files, name+".sha256")
+			sum := sha256.Sum256(dest.files[name].Bytes())
+			expected := hex.EncodeToString(sum[:]) + "  " + path.Base(name) + "\n"
+			assert.Equal(t, expected, dest.files[name+".sha256"].String())
+		}
+	})
+
+	t.Run("flags tampered files and missing checksums", func(t *testing.T) {
+		t.Parallel()
+
+		dest := archived(t)
+
+		// Flip a bit of the second day's archive, as bit rot would
+		tampered := dest.files["2024/11/02.json.gz"].Bytes()
+		tampered[len(tampered)/2] ^= 0x01
+		delete(dest.files, "2024/11/03.json.gz.sha256")
+
+		report, err := archive.Audit(ctx, dest, 2)
+		require.NoError(t, err)
+		assert.Equal(t, archive.AuditReport{
+			Matched: []string{
+				"2024/11/01.index.json.gz",
+				"2024/11/01.json.gz",
+				"2024/11/02.index.json.gz",
+				"2024/11/03.index.json.gz",
+			},
+			Mismatched:      []string{"2024/11/02.json.gz"},
+			MissingChecksum: []string{"2024/11/03.json.gz"},
+		}, report)
+	})
+
+	t.Run("rejects stores which cannot list", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := archive.Audit(ctx, &writeOnlyStorage{archived(t)}, 1)
+		assert.ErrorContains(t, err, "does not support listing")
+	})
+
+	t.Run("rejects store compression", func(t *testing.T) {
+		t.Parallel()
+
+		src := newMockDocumentSource()
+		src.add(day1, `{"_id":1}`)
+		archiver := archive.NewArchiver(
+			src,
+			newMockStorage(),
+			false,
+			false,
+			time.Duration(0),
+			archive.WithChecksums(),
+			archive.WithCompression(archive.CompressionStore),
+		)
+		assert.ErrorContains(t, archiver.Run(ctx, day2), "checksums cannot be combined with store compression")
 	})
 }
 
 type mockDocumentSource struct {
-	docs map[time.Time][][]byte
+	docs      map[time.Time][][]byte
+	failAfter int                 // when non-zero, results fail after yielding this many documents
+	count     func(time.Time) int // when set, overrides the count of documents for a date
+
+	countBefore func(time.Time) int
+	averageSize int
+
+	afterFind func(date time.Time) // invoked once a result has been resolved, e.g. to simulate concurrent inserts
 }
 
 func newMockDocumentSource() *mockDocumentSource {
@@ -168,9 +4067,61 @@ func (m *mockDocumentSource) add(date time.Time, doc string) {
 }
 
 func (m *mockDocumentSource) FindAllFromDate(_ context.Context, date time.Time) source.StreamingResult {
+	res := &mockStreamingResult{
+		docs: slices.Clone(m.docs[date]),
+	}
+	if m.afterFind != nil {
+		m.afterFind(date)
+	}
+	return res
+}
+
+func (m *mockDocumentSource) FindAllFromDateAfterID(
+	_ context.Context,
+	date time.Time,
+	afterID json.RawMessage,
+) source.StreamingResult {
+	var after int
+	if afterID != nil {
+		if err := json.Unmarshal(afterID, &after); err != nil {
+			return &mockStreamingResult{err: err}
+		}
+	}
+	var docs [][]byte
+	for _, doc := range m.docs[date] {
+		var decoded struct {
+			ID int `json:"_id"`
+		}
+		if err := json.Unmarshal(doc, &decoded); err != nil {
+			return &mockStreamingResult{err: err}
+		}
+		if decoded.ID > after {
+			docs = append(docs, doc)
+		}
+	}
 	return &mockStreamingResult{
-		docs: m.docs[date],
+		docs:      docs,
+		failAfter: m.failAfter,
+	}
+}
+
+func (m *mockDocumentSource) CountFromDate(_ context.Context, date time.Time) (int, error) {
+	if m.count != nil {
+		return m.count(date), nil
 	}
+	return len(m.docs[date]), nil
+}
+
+func (m *mockDocumentSource) CountDeletable(_ context.Context, date time.Time) (int, error) {
+	return len(m.docs[date]), nil
+}
+
+func (m *mockDocumentSource) CountBefore(_ context.Context, before time.Time) (int, error) {
+	return m.countBefore(before), nil
+}
+
+func (m *mockDocumentSource) AverageDocumentSize(_ context.Context) (int, error) {
+	return m.averageSize, nil
 }
 
 func (m *mockDocumentSource) DeleteAllFromDate(_ context.Context, date time.Time) (int, error) {
@@ -179,9 +4130,33 @@ func (m *mockDocumentSource) DeleteAllFromDate(_ context.Context, date time.Time
 	return total, nil
 }
 
+func (m *mockDocumentSource) DeleteByIDs(_ context.Context, ids []json.RawMessage) (int, error) {
+	var deleted int
+	for date, docs := range m.docs {
+		remaining := slices.DeleteFunc(docs, func(doc []byte) bool {
+			var decoded struct {
+				ID json.RawMessage `json:"_id"`
+			}
+			if err := json.Unmarshal(doc, &decoded); err != nil {
+				return false
+			}
+			return slices.ContainsFunc(ids, func(id json.RawMessage) bool {
+				return bytes.Equal(id, decoded.ID)
+			})
+		})
+		deleted += len(docs) - len(remaining)
+		if len(remaining) == 0 {
+			delete(m.docs, date)
+		} else {
+			m.docs[date] = remaining
+		}
+	}
+	return deleted, nil
+}
+
 func (m *mockDocumentSource) EarliestCreatedAt(_ context.Context) (time.Time, error) {
 	if len(m.docs) == 0 {
-		return time.Time{}, errors.New("no documents found")
+		return time.Time{}, mongo.ErrNoDocuments
 	}
 	var earliest time.Time
 	for t := range m.docs {
@@ -192,13 +4167,32 @@ func (m *mockDocumentSource) EarliestCreatedAt(_ context.Context) (time.Time, er
 	return earliest, nil
 }
 
+func (m *mockDocumentSource) EarliestCreatedAtFrom(_ context.Context, from time.Time) (time.Time, bool, error) {
+	var earliest time.Time
+	for t, docs := range m.docs {
+		if len(docs) > 0 && !t.Before(from) && (earliest.IsZero() || t.Before(earliest)) {
+			earliest = t
+		}
+	}
+	return earliest, !earliest.IsZero(), nil
+}
+
 type mockStreamingResult struct {
-	docs [][]byte
+	docs      [][]byte
+	failAfter int
+	err       error
 }
 
 func (m *mockStreamingResult) Iter(_ context.Context) iter.Seq[[]byte] {
 	return func(yield func([]byte) bool) {
-		for _, doc := range m.docs {
+		if m.err != nil {
+			return
+		}
+		for i, doc := range m.docs {
+			if m.failAfter > 0 && i == m.failAfter {
+				m.err = errors.New("forced failure")
+				return
+			}
 			if !yield(doc) {
 				return
 			}
@@ -207,7 +4201,7 @@ func (m *mockStreamingResult) Iter(_ context.Context) iter.Seq[[]byte] {
 }
 
 func (m *mockStreamingResult) Err() error {
-	return nil
+	return m.err
 }
 
 type mockStorage struct {
@@ -235,6 +4229,76 @@ func (m *mockStorage) Exists(_ context.Context, path string) (bool, error) {
 	return exists, nil
 }
 
+func (m *mockStorage) Open(_ context.Context, path string) (io.ReadCloser, error) {
+	buf, exists := m.files[path]
+	if !exists {
+		return nil, errors.New("file not found")
+	}
+	return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+}
+
+func (m *mockStorage) List(context.Context) ([]string, error) {
+	paths := slices.Collect(maps.Keys(m.files))
+	slices.Sort(paths)
+	return paths, nil
+}
+
+func (m *mockStorage) Append(_ context.Context, path string, offset int64) (io.WriteCloser, error) {
+	buf, exists := m.files[path]
+	if !exists {
+		return nil, errors.New("file not found")
+	}
+	buf.Truncate(int(offset))
+	return &errCloser{
+		Writer: buf,
+		err:    m.forceCloseError,
+	}, nil
+}
+
+func (m *mockStorage) Remove(_ context.Context, path string) error {
+	delete(m.files, path)
+	return nil
+}
+
+// abortingStorage is a mock store whose files can be aborted, which removes them
+type abortingStorage struct {
+	*mockStorage
+	aborted []string
+}
+
+func newAbortingStorage() *abortingStorage {
+	return &abortingStorage{mockStorage: newMockStorage()}
+}
+
+func (m *abortingStorage) Create(ctx context.Context, path string) (io.WriteCloser, error) {
+	w, err := m.mockStorage.Create(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &abortingFile{WriteCloser: w, abort: func() {
+		m.aborted = append(m.aborted, path)
+		delete(m.files, path)
+	}}, nil
+}
+
+type abortingFile struct {
+	io.WriteCloser
+	abort func()
+}
+
+func (f *abortingFile) Abort() error {
+	f.abort()
+	return nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
 func (m *mockStorage) read(path string) ([]string, error) {
 	buf := m.files[path]
 
@@ -262,6 +4326,191 @@ func (m *mockStorage) read(path string) ([]string, error) {
 	return lines, nil
 }
 
+type sessionKey struct{}
+
+// sessionDocumentSource records the session each find and delete operation was performed within
+type sessionDocumentSource struct {
+	*mockDocumentSource
+	sessions int
+	ops      []string
+}
+
+func (s *sessionDocumentSource) WithDaySession(ctx context.Context, fn func(ctx context.Context) error) error {
+	s.sessions++
+	return fn(context.WithValue(ctx, sessionKey{}, s.sessions))
+}
+
+func (s *sessionDocumentSource) FindAllFromDate(ctx context.Context, date time.Time) source.StreamingResult {
+	s.ops = append(s.ops, fmt.Sprintf("find:%v", ctx.Value(sessionKey{})))
+	return s.mockDocumentSource.FindAllFromDate(ctx, date)
+}
+
+func (s *sessionDocumentSource) DeleteAllFromDate(ctx context.Context, date time.Time) (int, error) {
+	s.ops = append(s.ops, fmt.Sprintf("delete:%v", ctx.Value(sessionKey{})))
+	return s.mockDocumentSource.DeleteAllFromDate(ctx, date)
+}
+
+// findOnlySource exposes only the methods every source implements, hiding the optional capabilities of the mock
+type findOnlySource struct {
+	source *mockDocumentSource
+}
+
+func (f *findOnlySource) FindAllFromDate(ctx context.Context, date time.Time) source.StreamingResult {
+	return f.source.FindAllFromDate(ctx, date)
+}
+
+func (f *findOnlySource) DeleteAllFromDate(ctx context.Context, date time.Time) (int, error) {
+	return f.source.DeleteAllFromDate(ctx, date)
+}
+
+func (f *findOnlySource) EarliestCreatedAt(ctx context.Context) (time.Time, error) {
+	return f.source.EarliestCreatedAt(ctx)
+}
+
+// chunkingDocumentSource records the number of ids in each delete by id, failing the failOnDelete-th when non-zero
+type chunkingDocumentSource struct {
+	*mockDocumentSource
+	failOnDelete int
+	batches      []int
+
+	// cancel is invoked once cancelOnDelete batches have been deleted, e.g. to simulate the run being stopped
+	cancelOnDelete int
+	cancel         context.CancelFunc
+}
+
+func (c *chunkingDocumentSource) DeleteByIDs(ctx context.Context, ids []json.RawMessage) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	c.batches = append(c.batches, len(ids))
+	if len(c.batches) == c.failOnDelete {
+		return 0, errors.New("delete failed")
+	}
+	n, err := c.mockDocumentSource.DeleteByIDs(ctx, ids)
+	if len(c.batches) == c.cancelOnDelete {
+		c.cancel()
+	}
+	return n, err
+}
+
+// rollupDocumentSource records the order in which days are rolled up, found and deleted, rolling up into as many
+// rollup documents as rollups returns for the day
+type rollupDocumentSource struct {
+	*mockDocumentSource
+	rollups     func(date time.Time) int
+	afterRollup func(date time.Time) // invoked once a day has been rolled up, e.g. to simulate late inserts
+	ops         []string
+}
+
+func (r *rollupDocumentSource) RollupDay(_ context.Context, date time.Time) (source.RollupResult, error) {
+	r.ops = append(r.ops, "rollup:"+date.Format(time.DateOnly))
+	res := source.RollupResult{Documents: len(r.docs[date]), Rollups: r.rollups(date)}
+	if r.afterRollup != nil {
+		r.afterRollup(date)
+	}
+	return res, nil
+}
+
+func (r *rollupDocumentSource) FindAllFromDate(ctx context.Context, date time.Time) source.StreamingResult {
+	r.ops = append(r.ops, "find:"+date.Format(time.DateOnly))
+	return r.mockDocumentSource.FindAllFromDate(ctx, date)
+}
+
+func (r *rollupDocumentSource) DeleteAllFromDate(ctx context.Context, date time.Time) (int, error) {
+	r.ops = append(r.ops, "delete:"+date.Format(time.DateOnly))
+	return r.mockDocumentSource.DeleteAllFromDate(ctx, date)
+}
+
+// divergentDocumentSource finds and counts nothing, whilst still deleting the documents of the mock, as though
+// documents are deleted from a different collection to the one they're read from
+type divergentDocumentSource struct {
+	*mockDocumentSource
+}
+
+func (d *divergentDocumentSource) FindAllFromDate(context.Context, time.Time) source.StreamingResult {
+	return &mockStreamingResult{}
+}
+
+func (d *divergentDocumentSource) CountFromDate(context.Context, time.Time) (int, error) {
+	return 0, nil
+}
+
+// dayMockDocumentSource assigns documents to days by truncating their time, as merged sources must
+type dayMockDocumentSource struct {
+	*mockDocumentSource
+}
+
+func (d dayMockDocumentSource) DayOf(t time.Time) time.Time {
+	return t.Truncate(time.Hour * 24)
+}
+
+// pausingStorage removes the pause flag once it has been checked pausedChecks times
+type pausingStorage struct {
+	*mockStorage
+	pausedChecks int
+	pauseChecks  int
+}
+
+func (p *pausingStorage) Exists(ctx context.Context, path string) (bool, error) {
+	if path == archive.PauseFlagPath {
+		p.pauseChecks++
+		if p.pauseChecks > p.pausedChecks {
+			delete(p.files, path)
+		}
+	}
+	return p.mockStorage.Exists(ctx, path)
+}
+
+// writeOnlyStorage exposes only the Create method of the wrapped storage
+type writeOnlyStorage struct {
+	storage *mockStorage
+}
+
+func (w *writeOnlyStorage) Create(ctx context.Context, path string) (io.WriteCloser, error) {
+	return w.storage.Create(ctx, path)
+}
+
+// committingStorage is a mock store whose files record a copy of their contents each time they're committed
+type committingStorage struct {
+	*mockStorage
+	commits map[string][][]byte
+}
+
+func newCommittingStorage() *committingStorage {
+	return &committingStorage{
+		mockStorage: newMockStorage(),
+		commits:     make(map[string][][]byte),
+	}
+}
+
+func (c *committingStorage) Create(ctx context.Context, path string) (io.WriteCloser, error) {
+	w, err := c.mockStorage.Create(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &committingFile{WriteCloser: w, storage: c, path: path}, nil
+}
+
+func (c *committingStorage) Append(ctx context.Context, path string, offset int64) (io.WriteCloser, error) {
+	w, err := c.mockStorage.Append(ctx, path, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &committingFile{WriteCloser: w, storage: c, path: path}, nil
+}
+
+type committingFile struct {
+	io.WriteCloser
+	storage *committingStorage
+	path    string
+}
+
+func (f *committingFile) Commit() error {
+	committed := bytes.Clone(f.storage.files[f.path].Bytes())
+	f.storage.commits[f.path] = append(f.storage.commits[f.path], committed)
+	return nil
+}
+
 type errCloser struct {
 	io.Writer
 	err error
@@ -270,3 +4519,97 @@ type errCloser struct {
 func (e *errCloser) Close() error {
 	return e.err
 }
+
+// recordingStorage is a mock store which records the contents of every write to a particular path
+type recordingStorage struct {
+	*mockStorage
+	path   string
+	writes []string
+}
+
+func (r *recordingStorage) Create(ctx context.Context, path string) (io.WriteCloser, error) {
+	w, err := r.mockStorage.Create(ctx, path)
+	if err != nil || path != r.path {
+		return w, err
+	}
+	return &recordingWriter{WriteCloser: w, storage: r}, nil
+}
+
+type recordingWriter struct {
+	io.WriteCloser
+	storage *recordingStorage
+}
+
+func (w *recordingWriter) Close() error {
+	w.storage.writes = append(w.storage.writes, w.storage.files[w.storage.path].String())
+	return w.WriteCloser.Close()
+}
+
+// streamingDocumentSource streams a fixed set of insert events, whose documents are also held by the source
+type streamingDocumentSource struct {
+	*mockDocumentSource
+	events       []source.InsertEvent
+	resumedAfter json.RawMessage
+	drained      func() // invoked once every event has been streamed
+}
+
+func newStreamingDocumentSource(events ...source.InsertEvent) *streamingDocumentSource {
+	src := &streamingDocumentSource{
+		mockDocumentSource: newMockDocumentSource(),
+		events:             events,
+	}
+	for _, ev := range events {
+		src.add(ev.CreatedAt.Truncate(time.Hour*24), string(ev.Document))
+	}
+	return src
+}
+
+func (s *streamingDocumentSource) WatchInserts(
+	_ context.Context,
+	resumeAfter json.RawMessage,
+) (source.InsertStream, error) {
+	s.resumedAfter = resumeAfter
+	return s, nil
+}
+
+func (s *streamingDocumentSource) TryNext(_ context.Context) (source.InsertEvent, bool) {
+	if len(s.events) == 0 {
+		s.drained()
+		return source.InsertEvent{}, false
+	}
+	ev := s.events[0]
+	s.events = s.events[1:]
+	return ev, true
+}
+
+func (s *streamingDocumentSource) Err() error {
+	return nil
+}
+
+func (s *streamingDocumentSource) Close(_ context.Context) error {
+	return nil
+}
+
+func insertEvent(id int, createdAt time.Time) source.InsertEvent {
+	return source.InsertEvent{
+		Document:    []byte(fmt.Sprintf(`{"_id":%d}`, id)),
+		CreatedAt:   createdAt,
+		ResumeToken: json.RawMessage(fmt.Sprintf(`"token-%d"`, id)),
+	}
+}
+
+// fakeClock advances immediately whenever it is waited on
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
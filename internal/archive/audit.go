@@ -0,0 +1,144 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// lister is implemented by stores able to enumerate the files they hold
+type lister interface {
+	List(ctx context.Context) ([]string, error)
+}
+
+// AuditReport describes the outcome of auditing the checksums of every gzipped file in a store
+type AuditReport struct {
+	// Matched are the files whose contents hash to their checksum
+	Matched []string
+	// Mismatched are the files whose contents no longer hash to their checksum, e.g. due to bit rot or tampering
+	Mismatched []string
+	// MissingChecksum are the files without a checksum sidecar, e.g. having been archived before checksums were enabled
+	MissingChecksum []string
+}
+
+// Audit recomputes the SHA-256 of every gzipped file in the store, comparing it against the file's checksum sidecar,
+// with up to workers files hashed at once. Nothing is modified, so files which fail to match are only reported. Any
+// failure to read a file fails the audit.
+func Audit(ctx context.Context, s store, workers int) (AuditReport, error) {
+	l, ok := s.(lister)
+	if !ok {
+		return AuditReport{}, errors.New("store does not support listing, so files cannot be audited")
+	}
+	o, ok := s.(opener)
+	if !ok {
+		return AuditReport{}, errors.New("store does not support reading, so files cannot be audited")
+	}
+
+	paths, err := l.List(ctx)
+	if err != nil {
+		return AuditReport{}, fmt.Errorf("%w: failed to list files: %w", ErrStorage, err)
+	}
+	listed := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		listed[p] = true
+	}
+
+	var report AuditReport
+	var audited []string
+	for _, p := range paths {
+		if !strings.HasSuffix(p, "."+codecExtension) {
+			continue
+		}
+		if !listed[p+checksumSuffix] {
+			report.MissingChecksum = append(report.MissingChecksum, p)
+			continue
+		}
+		audited = append(audited, p)
+	}
+
+	// Files are hashed concurrently, with the outcome of each recorded by its position so the report stays ordered
+	matches := make([]bool, len(audited))
+	errs := make([]error, len(audited))
+	slots := make(chan struct{}, max(workers, 1))
+	var wg sync.WaitGroup
+	for i, p := range audited {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return AuditReport{}, ctx.Err()
+		case slots <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if matches[i], errs[i] = auditFile(ctx, o, p); errs[i] != nil {
+				errs[i] = fmt.Errorf("%w: failed to audit file %s: %w", ErrStorage, p, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	if err = errors.Join(errs...); err != nil {
+		return AuditReport{}, err
+	}
+
+	for i, p := range audited {
+		if matches[i] {
+			report.Matched = append(report.Matched, p)
+		} else {
+			slog.Error("checksum mismatch", slog.String("fileName", p))
+			report.Mismatched = append(report.Mismatched, p)
+		}
+	}
+	for _, p := range report.MissingChecksum {
+		slog.Warn("checksum missing", slog.String("fileName", p))
+	}
+	return report, nil
+}
+
+// auditFile reports whether the named file hashes to the checksum held in its sidecar
+func auditFile(ctx context.Context, o opener, fileName string) (bool, error) {
+	sum, err := hashFile(ctx, o, fileName)
+	if err != nil {
+		return false, err
+	}
+
+	r, err := o.Open(ctx, fileName+checksumSuffix)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	recorded, err := io.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(recorded, checksumLine(fileName, sum)), nil
+}
+
+// hashFile computes the SHA-256 of the named file, as stored
+func hashFile(ctx context.Context, o opener, fileName string) (sum []byte, err error) {
+	r, err := o.Open(ctx, fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cErr := r.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close file: %w", cErr))
+		}
+	}()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,190 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// boundsSuffix is appended to the day path of an archive to name its bounds
+const boundsSuffix = ".bounds.json"
+
+// WithBounds enables writing a bounds sidecar (e.g. 2024/11/01.bounds.json) alongside each archived file, holding the
+// lowest and highest _id and createdAt of the documents in it, so that the file holding a given _id or timestamp can
+// be found without opening any archives. The bounds of _id are only recorded when every _id can be ordered against
+// the others, being all ObjectIDs, strings, numbers or dates, with createdAt only bounded by those documents holding a
+// date.
+func WithBounds() Option {
+	return func(a *Archiver) {
+		a.bounds = true
+	}
+}
+
+func (a *Archiver) checkBoundsSupported() error {
+	if a.resume != nil {
+		return errors.New("bounds cannot be combined with resuming")
+	}
+	return nil
+}
+
+// bounds tracks the lowest and highest _id and createdAt of the documents written to a file
+type bounds struct {
+	documentCount          int
+	minID, maxID           bson.RawValue
+	minCreated, maxCreated bson.RawValue
+	hasID, hasCreated      bool
+	// unorderedIDs is set once an _id is encountered which can't be ordered against those before it
+	unorderedIDs bool
+}
+
+// observe widens the bounds to include the document, given as canonical extended JSON
+func (b *bounds) observe(doc []byte) error {
+	var decoded struct {
+		ID        bson.RawValue `bson:"_id"`
+		CreatedAt bson.RawValue `bson:"createdAt"`
+	}
+	if err := bson.UnmarshalExtJSON(doc, true, &decoded); err != nil {
+		return fmt.Errorf("failed to decode document for bounds: %w", err)
+	}
+	b.documentCount++
+
+	if decoded.CreatedAt.Type == bsontype.DateTime {
+		created := decoded.CreatedAt.DateTime()
+		if !b.hasCreated || created < b.minCreated.DateTime() {
+			b.minCreated = decoded.CreatedAt
+		}
+		if !b.hasCreated || created > b.maxCreated.DateTime() {
+			b.maxCreated = decoded.CreatedAt
+		}
+		b.hasCreated = true
+	}
+
+	if b.unorderedIDs || decoded.ID.Type == 0 {
+		b.unorderedIDs = true
+		return nil
+	}
+	if !b.hasID {
+		b.minID, b.maxID, b.hasID = decoded.ID, decoded.ID, true
+		return nil
+	}
+	lower, ok := compareIDs(decoded.ID, b.minID)
+	if !ok {
+		b.unorderedIDs = true
+		return nil
+	}
+	if lower < 0 {
+		b.minID = decoded.ID
+	}
+	if higher, _ := compareIDs(decoded.ID, b.maxID); higher > 0 {
+		b.maxID = decoded.ID
+	}
+	return nil
+}
+
+// compareIDs orders two _id values, reporting false should they be of types that can't be ordered against each other
+func compareIDs(x, y bson.RawValue) (int, bool) {
+	switch {
+	case x.Type == bsontype.ObjectID && y.Type == bsontype.ObjectID:
+		xID, yID := x.ObjectID(), y.ObjectID()
+		return bytes.Compare(xID[:], yID[:]), true
+	case x.Type == bsontype.String && y.Type == bsontype.String:
+		return strings.Compare(x.StringValue(), y.StringValue()), true
+	case x.Type == bsontype.DateTime && y.Type == bsontype.DateTime:
+		return compareOrdered(x.DateTime(), y.DateTime()), true
+	}
+	xNum, xOK := numericID(x)
+	yNum, yOK := numericID(y)
+	if !xOK || !yOK {
+		return 0, false
+	}
+	return compareOrdered(xNum, yNum), true
+}
+
+// numericID returns the value of a numeric _id, as MongoDB orders numbers of differing types by value
+func numericID(v bson.RawValue) (float64, bool) {
+	switch v.Type {
+	case bsontype.Int32:
+		return float64(v.Int32()), true
+	case bsontype.Int64:
+		return float64(v.Int64()), true
+	case bsontype.Double:
+		return v.Double(), true
+	default:
+		return 0, false
+	}
+}
+
+func compareOrdered[T int64 | float64](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// boundsFile is the bounds sidecar of an archive file, with each bound as canonical extended JSON. Either field of a
+// bound is omitted when it couldn't be determined.
+type boundsFile struct {
+	File          string          `json:"file"`
+	DocumentCount int             `json:"documentCount"`
+	Min           json.RawMessage `json:"min"`
+	Max           json.RawMessage `json:"max"`
+}
+
+// bound renders the _id and createdAt of a bound as an extended JSON document
+func bound(id, createdAt bson.RawValue) (json.RawMessage, error) {
+	var doc bson.D
+	if id.Type != 0 {
+		doc = append(doc, bson.E{Key: "_id", Value: id})
+	}
+	if createdAt.Type != 0 {
+		doc = append(doc, bson.E{Key: "createdAt", Value: createdAt})
+	}
+	if doc == nil {
+		return json.RawMessage("{}"), nil
+	}
+	return bson.MarshalExtJSON(doc, true, false)
+}
+
+// writeBounds writes the bounds sidecar of the named archive file
+func (a *Archiver) writeBounds(ctx context.Context, fileName string, b *bounds) (err error) {
+	out := boundsFile{
+		File:          fileName,
+		DocumentCount: b.documentCount,
+	}
+	var minID, maxID bson.RawValue
+	if !b.unorderedIDs {
+		minID, maxID = b.minID, b.maxID
+	}
+	if out.Min, err = bound(minID, b.minCreated); err != nil {
+		return fmt.Errorf("failed to encode lower bound: %w", err)
+	}
+	if out.Max, err = bound(maxID, b.maxCreated); err != nil {
+		return fmt.Errorf("failed to encode upper bound: %w", err)
+	}
+
+	boundsName := a.sidecarPath(fileName) + boundsSuffix
+	slog.Info("writing bounds", slog.String("fileName", boundsName))
+
+	w, err := a.store.Create(ctx, boundsName)
+	if err != nil {
+		return fmt.Errorf("%w: failed to create file: %w", ErrStorage, err)
+	}
+	defer func() {
+		if cErr := w.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("%w: failed to close file: %w", ErrStorage, cErr))
+		}
+	}()
+
+	return json.NewEncoder(w).Encode(out)
+}
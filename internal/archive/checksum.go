@@ -0,0 +1,76 @@
+package archive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"path"
+)
+
+// checksumSuffix is appended to the full name of a file to name its checksum sidecar
+const checksumSuffix = ".sha256"
+
+// WithChecksums enables writing a checksum sidecar (e.g. 2024/11/01.json.gz.sha256) alongside each gzipped file
+// written, archives and their indexes, dead letter and invalid files alike. Each holds the SHA-256 of the file exactly
+// as stored, in the format of sha256sum, so that files can be checked for corruption with `sha256sum -c`, or by Audit.
+func WithChecksums() Option {
+	return func(a *Archiver) {
+		a.checksums = true
+	}
+}
+
+func (a *Archiver) checkChecksumsSupported() error {
+	switch {
+	case a.resume != nil:
+		// Resumed files are appended to, so the hash of what was written before is unknown
+		return errors.New("checksums cannot be combined with resuming")
+	case a.compression == CompressionStore:
+		// What's stored is compressed by the store, so differs from what's hashed
+		return errors.New("checksums cannot be combined with store compression")
+	}
+	return nil
+}
+
+// fileChecksum hashes a file as it's written, writing its sidecar once the file has been closed
+type fileChecksum struct {
+	hash  hash.Hash
+	write func(sum []byte) error
+}
+
+// newFileChecksum returns the checksum of the named file, which is written to the store once complete
+func (a *Archiver) newFileChecksum(ctx context.Context, name string) *fileChecksum {
+	return &fileChecksum{
+		hash: sha256.New(),
+		write: func(sum []byte) error {
+			return a.writeChecksum(ctx, name, sum)
+		},
+	}
+}
+
+// writeChecksum writes the checksum sidecar of the named file
+func (a *Archiver) writeChecksum(ctx context.Context, fileName string, sum []byte) (err error) {
+	name := fileName + checksumSuffix
+	slog.Info("writing checksum", slog.String("fileName", name))
+
+	w, err := a.store.Create(ctx, name)
+	if err != nil {
+		return fmt.Errorf("%w: failed to create file: %w", ErrStorage, err)
+	}
+	defer func() {
+		if cErr := w.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("%w: failed to close file: %w", ErrStorage, cErr))
+		}
+	}()
+
+	_, err = w.Write(checksumLine(fileName, sum))
+	return err
+}
+
+// checksumLine formats the checksum of the file as sha256sum does, relative to the directory of the file
+func checksumLine(fileName string, sum []byte) []byte {
+	return []byte(hex.EncodeToString(sum) + "  " + path.Base(fileName) + "\n")
+}
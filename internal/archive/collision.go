@@ -0,0 +1,178 @@
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CollisionPolicy controls what happens when the file a day is archived to already exists
+type CollisionPolicy int
+
+const (
+	// CollisionFail refuses to archive the day
+	CollisionFail CollisionPolicy = iota
+	// CollisionSuffix archives the day to the first free name with a numeric suffix, e.g. 2024/11/01-1.json.gz
+	CollisionSuffix
+	// CollisionOverwrite replaces the existing file
+	CollisionOverwrite
+)
+
+// maxCollisionSuffix bounds the search for a free suffixed name, guarding against endlessly re-archiving a day
+const maxCollisionSuffix = 1000
+
+// ParseCollisionPolicy parses a policy from its flag representation, one of "fail", "suffix" or "overwrite"
+func ParseCollisionPolicy(s string) (CollisionPolicy, error) {
+	switch s {
+	case "fail":
+		return CollisionFail, nil
+	case "suffix":
+		return CollisionSuffix, nil
+	case "overwrite":
+		return CollisionOverwrite, nil
+	default:
+		return 0, fmt.Errorf("invalid collision policy %q, expected fail, suffix or overwrite", s)
+	}
+}
+
+// String returns the flag representation of the policy
+func (p CollisionPolicy) String() string {
+	switch p {
+	case CollisionSuffix:
+		return "suffix"
+	case CollisionOverwrite:
+		return "overwrite"
+	default:
+		return "fail"
+	}
+}
+
+// Set parses the policy from its flag representation, allowing it to be used as a flag value
+func (p *CollisionPolicy) Set(s string) error {
+	parsed, err := ParseCollisionPolicy(s)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// WithCollisionPolicy overrides the default of refusing to archive a day whose file already exists. Suffixed names
+// are recorded in the file header, when enabled, alongside the name the day would otherwise have been archived to.
+func WithCollisionPolicy(policy CollisionPolicy) Option {
+	return func(a *Archiver) {
+		a.onCollision = policy
+	}
+}
+
+func (a *Archiver) checkCollisionPolicySupported() error {
+	switch {
+	case a.ignoreFileExistsError:
+		return errors.New("collision policy cannot be combined with ignoring existing files")
+	case a.onCollision == CollisionSuffix && a.resume != nil:
+		// A checkpoint is found by the day's unsuffixed name, so couldn't be continued in a suffixed file
+		return errors.New("suffixing colliding files cannot be combined with resuming")
+	case a.onCollision == CollisionSuffix && a.partition != nil:
+		return errors.New("suffixing colliding files cannot be combined with partitioning")
+	}
+	return nil
+}
+
+// WithOverwriteIncomplete overwrites an existing file which is empty, or fails to decompress through to its end, as is
+// the case for a file left behind by a run that failed part way through writing it, rather than treating it as a
+// collision. Only a complete file is then a collision. Checking reads the existing file back in full, so requires a
+// store which can be read from. Files compressed by the store are only checked for being empty.
+func WithOverwriteIncomplete() Option {
+	return func(a *Archiver) {
+		a.overwriteIncomplete = true
+	}
+}
+
+func (a *Archiver) checkOverwriteIncompleteSupported() error {
+	if _, ok := a.store.(opener); !ok {
+		return errors.New("store does not support reading, so existing files cannot be checked for being incomplete")
+	}
+	return nil
+}
+
+// incompleteFile reports whether the existing file is empty, or is truncated or corrupt such that it fails to
+// decompress through to its end
+func (a *Archiver) incompleteFile(ctx context.Context, fileName string) (incomplete bool, err error) {
+	r, err := a.store.(opener).Open(ctx, fileName)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if cErr := r.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close file: %w", cErr))
+		}
+	}()
+
+	if a.compression == CompressionStore {
+		n, err := r.Read(make([]byte, 1))
+		if n > 0 {
+			return false, nil
+		}
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		// Covers empty files, which lack a gzip header altogether
+		slog.Warn("existing file is not gzipped", slog.String("file", fileName), slog.Any("error", err))
+		return true, nil
+	}
+	defer gr.Close()
+	if _, err = io.Copy(io.Discard, gr); err != nil {
+		slog.Warn("existing file is incomplete", slog.String("file", fileName), slog.Any("error", err))
+		return true, nil
+	}
+	return false, nil
+}
+
+// resolveCollision returns the name the day should be archived to, given that its file already exists
+func (a *Archiver) resolveCollision(ctx context.Context, date time.Time, fileName string) (string, error) {
+	switch a.onCollision {
+	case CollisionOverwrite:
+		slog.Warn("overwriting existing file", slog.String("file", fileName))
+		return fileName, nil
+	case CollisionSuffix:
+		for i := 1; i <= maxCollisionSuffix; i++ {
+			name := a.suffixedFileName(date, i)
+			exists, err := a.exists(ctx, name)
+			if err != nil {
+				return "", fmt.Errorf("%w: failed to check if file exists: %w", ErrStorage, err)
+			}
+			if !exists {
+				slog.Warn(
+					"target file exists, archiving to suffixed file",
+					slog.String("file", fileName),
+					slog.String("suffixedFile", name),
+				)
+				return name, nil
+			}
+		}
+		return "", fmt.Errorf("%w: no free suffix for %s", ErrIntegrity, fileName)
+	default:
+		return "", fmt.Errorf("%w: target file exists", ErrIntegrity)
+	}
+}
+
+// suffixedFileName returns the name of the archive file for the supplied date, distinguished by the numeric suffix
+func (a *Archiver) suffixedFileName(date time.Time, suffix int) string {
+	return dayPath(date) + "-" + strconv.Itoa(suffix) + "." + a.fileExtension + a.codecSuffix()
+}
+
+// sidecarPath returns the archive file name without its extension, to which sidecar suffixes are appended
+func (a *Archiver) sidecarPath(fileName string) string {
+	return strings.TrimSuffix(fileName, "."+a.fileExtension+a.codecSuffix())
+}
@@ -0,0 +1,57 @@
+package archive
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// committer is implemented by the files of stores able to make everything written so far durable and visible, without
+// finishing the file
+type committer interface {
+	Commit() error
+}
+
+// flusher is implemented by compressors able to write out everything compressed so far, without ending the stream
+type flusher interface {
+	Flush() error
+}
+
+// WithCommitInterval commits each file being written at least every interval, flushing the compressor and committing
+// what has been written so far, e.g. syncing it to disk, so that progress through a large day is durable and visible
+// before the day is finalized. When resuming, each commit is also checkpointed, in addition to every checkpoint
+// interval documents. Files of stores unable to commit in increments, those which can't append, are committed once
+// complete as usual.
+func WithCommitInterval(interval time.Duration) Option {
+	return func(a *Archiver) {
+		a.commitInterval = interval
+	}
+}
+
+func (a *Archiver) checkCommitIntervalSupported() {
+	if _, ok := a.store.(resumableStore); !ok {
+		slog.Warn("store does not support appending, so files are committed once complete rather than every interval")
+	}
+}
+
+// commitDue reports whether the commit interval has elapsed since the last commit
+func (a *Archiver) commitDue(committedAt time.Time) bool {
+	return a.commitInterval > 0 && time.Since(committedAt) >= a.commitInterval
+}
+
+// commit flushes the file's compressor, and then commits the file, should the commit interval have elapsed
+func (f *gzipFile) commit() error {
+	if f.committer == nil || time.Since(f.committedAt) < f.commitInterval {
+		return nil
+	}
+	if fl, ok := f.gw.(flusher); ok {
+		if err := fl.Flush(); err != nil {
+			return fmt.Errorf("failed to flush gzip writer: %w", err)
+		}
+	}
+	if err := f.committer.Commit(); err != nil {
+		return fmt.Errorf("%w: failed to commit file: %w", ErrStorage, err)
+	}
+	f.committedAt = time.Now()
+	return nil
+}
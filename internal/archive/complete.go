@@ -0,0 +1,37 @@
+package archive
+
+import "time"
+
+// WithCompleteDaysOnly only archives days lying wholly before the target, leaving the day holding the target for a
+// later run. Otherwise every day starting before the target is archived in full, including any of its documents
+// which are yet to pass the retention, or are yet to be inserted at all when the retention is shorter than a day.
+func WithCompleteDaysOnly() Option {
+	return func(a *Archiver) {
+		a.completeDaysOnly = true
+	}
+}
+
+// WithKeepDays leaves the n full days before now unarchived, along with now's day, however far back the target is, as
+// a safety buffer of readily queryable days expressed as a count rather than a duration. It only ever holds back days
+// the target would otherwise archive. Now should be the time the target was derived from, so that both follow the
+// same clock, e.g. the server's rather than a skewed local one.
+func WithKeepDays(n int, now time.Time) Option {
+	return func(a *Archiver) {
+		a.keepDays = n
+		a.keepDaysFrom = now
+	}
+}
+
+// endOf returns the start of the first day which is not eligible for archiving up to the target
+func (a *Archiver) endOf(target time.Time) time.Time {
+	end := target
+	if a.completeDaysOnly {
+		end = a.dayOf(target)
+	}
+	if a.keepDays > 0 {
+		if kept := a.dayOf(a.keepDaysFrom.UTC()).AddDate(0, 0, -a.keepDays); kept.Before(end) {
+			end = kept
+		}
+	}
+	return end
+}
@@ -0,0 +1,203 @@
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Compression selects what compresses archives
+type Compression int
+
+const (
+	// CompressionGzip gzips archives as they're written, naming them with a .gz extension
+	CompressionGzip Compression = iota
+	// CompressionStore writes archives as plain JSON, leaving their compression to the store
+	CompressionStore
+)
+
+// ParseCompression parses a compression from its flag representation, one of "gzip" or "store"
+func ParseCompression(s string) (Compression, error) {
+	switch s {
+	case "gzip":
+		return CompressionGzip, nil
+	case "store":
+		return CompressionStore, nil
+	default:
+		return 0, fmt.Errorf("invalid compression %q, expected gzip or store", s)
+	}
+}
+
+// String returns the flag representation of the compression
+func (c Compression) String() string {
+	if c == CompressionStore {
+		return "store"
+	}
+	return "gzip"
+}
+
+// Set parses the compression from its flag representation, allowing it to be used as a flag value
+func (c *Compression) Set(s string) error {
+	parsed, err := ParseCompression(s)
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
+
+// WithCompression selects what compresses archives. Under CompressionStore the archiver's own gzip is bypassed, with
+// files written as plain JSON and named without the .gz extension, for stores which compress transparently, e.g. GCS
+// objects uploaded with Content-Encoding: gzip. The compressed size then isn't known, so is reported as the size of
+// the plain JSON.
+func WithCompression(compression Compression) Option {
+	return func(a *Archiver) {
+		a.compression = compression
+	}
+}
+
+func (a *Archiver) checkCompressionSupported() error {
+	switch {
+	case a.resume != nil:
+		return errors.New("store compression cannot be combined with resuming")
+	case a.adaptiveCompression != nil:
+		return errors.New("store compression cannot be combined with adaptive compression")
+	}
+	return nil
+}
+
+// codecSuffix returns the extension appended to the data format of file names, reflecting how they're compressed
+func (a *Archiver) codecSuffix() string {
+	if a.compression == CompressionStore {
+		return ""
+	}
+	return "." + codecExtension
+}
+
+// codec returns the encoding of archive files as written by the archiver, as recorded by file headers
+func (a *Archiver) codec() string {
+	if a.compression == CompressionStore {
+		return "none"
+	}
+	return "gzip"
+}
+
+// newFileReader wraps the reader of a file read back from the store, decompressing it should the archiver have
+// compressed it. Stores compressing files themselves are expected to decompress them as they're read.
+func (a *Archiver) newFileReader(r io.Reader) (io.ReadCloser, error) {
+	if a.compression == CompressionStore {
+		return io.NopCloser(r), nil
+	}
+	return gzip.NewReader(r)
+}
+
+type adaptiveCompressionConfig struct {
+	smallThreshold int
+	largeThreshold int
+
+	// When targeting a duration, the level is instead tuned from one day to the next
+	target time.Duration
+	level  int
+}
+
+// counter is implemented by sources able to cheaply count a day's documents
+type counter interface {
+	CountFromDate(ctx context.Context, date time.Time) (int, error)
+}
+
+// WithAdaptiveCompression selects the gzip level for each day based on its document count. Days with fewer than
+// smallThreshold documents are compressed with the fastest level, as they gain little from extra effort, and days with
+// at least largeThreshold documents use the best compression level. Anything in between uses the default level.
+func WithAdaptiveCompression(smallThreshold, largeThreshold int) Option {
+	return func(a *Archiver) {
+		a.adaptiveCompression = &adaptiveCompressionConfig{
+			smallThreshold: smallThreshold,
+			largeThreshold: largeThreshold,
+		}
+	}
+}
+
+// WithAdaptiveCompressionTarget tunes the gzip level toward writing each day within the target, for runs with a fixed
+// window to archive and delete in. Days start at the default level, and each day taking longer than the target to
+// write lowers the level used for the next, whereas each taking less than half of it raises the level, trading the
+// headroom for smaller files. Days whose file already exists aren't written, so leave the level as it is.
+func WithAdaptiveCompressionTarget(target time.Duration) Option {
+	return func(a *Archiver) {
+		a.adaptiveCompression = &adaptiveCompressionConfig{
+			target: target,
+			level:  defaultCompressionLevel,
+		}
+	}
+}
+
+// defaultCompressionLevel is the level gzip.DefaultCompression stands for, from which tuning starts
+const defaultCompressionLevel = 6
+
+func (a *Archiver) checkAdaptiveCompressionSupported() error {
+	if a.adaptiveCompression.target > 0 {
+		return nil
+	}
+	if _, ok := a.source.(counter); !ok {
+		return errors.New("source does not support counting, which adaptive compression requires")
+	}
+	return nil
+}
+
+// compressionLevel resolves the gzip level to use for the supplied date
+func (a *Archiver) compressionLevel(ctx context.Context, date time.Time) (int, error) {
+	if a.adaptiveCompression == nil {
+		return gzip.DefaultCompression, nil
+	}
+	if a.adaptiveCompression.target > 0 {
+		return a.adaptiveCompression.level, nil
+	}
+
+	count, err := a.source.(counter).CountFromDate(ctx, date)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count documents: %w", err)
+	}
+
+	level := gzip.DefaultCompression
+	switch {
+	case count < a.adaptiveCompression.smallThreshold:
+		level = gzip.BestSpeed
+	case count >= a.adaptiveCompression.largeThreshold:
+		level = gzip.BestCompression
+	}
+
+	slog.Info("selected compression level", slog.Int("count", count), slog.Int("level", level))
+
+	return level, nil
+}
+
+// tuneCompressionLevel nudges the gzip level used for the next day toward writing it within the target, the day just
+// written having taken elapsed
+func (a *Archiver) tuneCompressionLevel(elapsed time.Duration, res *dayResult) {
+	c := a.adaptiveCompression
+	if c == nil || c.target == 0 || elapsed <= 0 {
+		return
+	}
+
+	level := c.level
+	switch {
+	case elapsed > c.target && level > gzip.BestSpeed:
+		level--
+	case elapsed < c.target/2 && level < gzip.BestCompression:
+		level++
+	}
+
+	uncompressed, _ := res.bytes()
+	slog.Info(
+		"tuned compression level",
+		slog.Duration("elapsed", elapsed),
+		slog.Duration("target", c.target),
+		slog.Int64("bytesPerSecond", int64(float64(uncompressed)/elapsed.Seconds())),
+		slog.Int("previousLevel", c.level),
+		slog.Int("level", level),
+	)
+	c.level = level
+}
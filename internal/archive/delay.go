@@ -0,0 +1,48 @@
+package archive
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SharedDelay spaces out the days archived by several archivers running at once, so that together they start a day no
+// more often than a single archiver would with the same delay. This keeps the load on the cluster the same however
+// many collections are archived in parallel.
+type SharedDelay struct {
+	delay time.Duration
+	mu    sync.Mutex
+	next  time.Time // the earliest time at which the next day may start
+}
+
+// NewSharedDelay returns a SharedDelay starting days at most once per delay
+func NewSharedDelay(delay time.Duration) *SharedDelay {
+	return &SharedDelay{delay: delay}
+}
+
+// wait blocks until the caller may start a day, reserving the following slot for the next caller
+func (d *SharedDelay) wait(ctx context.Context) error {
+	d.mu.Lock()
+	now := time.Now()
+	start := d.next
+	if start.Before(now) {
+		start = now
+	}
+	d.next = start.Add(d.delay)
+	d.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(start.Sub(now)):
+		return nil
+	}
+}
+
+// WithSharedDelay paces days using the supplied SharedDelay, which is waited upon before each day, in place of the
+// archiver's own delay after each day
+func WithSharedDelay(d *SharedDelay) Option {
+	return func(a *Archiver) {
+		a.sharedDelay = d
+	}
+}
@@ -0,0 +1,70 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"time"
+)
+
+// emptyRunDir is the directory beneath which a manifest is written for each run archiving nothing
+const emptyRunDir = "_archiver/empty-runs"
+
+// emptyRunManifest records a run which archived nothing
+type emptyRunManifest struct {
+	RanAt  time.Time `json:"ranAt"`
+	Target string    `json:"target"`
+	// Earliest is the day of the earliest document, omitted should the collection be empty
+	Earliest  string `json:"earliest,omitempty"`
+	Reason    string `json:"reason"`
+	Days      int    `json:"days"`
+	Documents int    `json:"documents"`
+}
+
+// WithEmptyRunManifest writes a manifest beneath _archiver/empty-runs for each run archiving nothing, being one whose
+// collection is empty, or whose target precedes its earliest document, so that a run which found nothing can be told
+// apart from one which never ran. Each is named by the time of the run, e.g. 20241101T030000Z.json, and records the
+// target and why nothing was archived. An empty collection otherwise fails the run, as finding the earliest document
+// does.
+func WithEmptyRunManifest() Option {
+	return func(a *Archiver) {
+		a.emptyRunManifest = true
+	}
+}
+
+// writeEmptyRunManifest records that the run towards the target archived nothing. A zero earliest denotes an empty
+// collection.
+func (a *Archiver) writeEmptyRunManifest(ctx context.Context, target, earliest time.Time) error {
+	now := a.now().UTC()
+	manifest := emptyRunManifest{
+		RanAt:  now,
+		Target: target.Format(time.DateOnly),
+		Reason: "collection is empty",
+	}
+	if !earliest.IsZero() {
+		manifest.Earliest = a.dayOf(earliest).Format(time.DateOnly)
+		manifest.Reason = "no documents precede the target"
+	}
+	out, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	name := path.Join(emptyRunDir, now.Format("20060102T150405Z")+".json")
+	slog.Info("writing empty run manifest", slog.String("fileName", name), slog.String("reason", manifest.Reason))
+
+	w, err := a.store.Create(ctx, name)
+	if err != nil {
+		return fmt.Errorf("%w: failed to create file: %w", ErrStorage, err)
+	}
+	if _, err = w.Write(append(out, '\n')); err != nil {
+		return errors.Join(fmt.Errorf("%w: failed to write file: %w", ErrStorage, err), w.Close())
+	}
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("%w: failed to close file: %w", ErrStorage, err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Encoder renders documents into the format written to archive files. Each call to Encode writes a single document,
+// including whatever framing the format requires to separate it from the next.
+type Encoder interface {
+	Encode(doc bson.Raw, w io.Writer) error
+	// Extension is the data format portion of archived file names, e.g. "json" results in files named 01.json.gz
+	Extension() string
+	// ContentType is the media type of the encoded documents, as recorded by file headers
+	ContentType() string
+}
+
+// DefaultEncoder is the name of the encoder used unless another is configured
+const DefaultEncoder = "extjson"
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[string]Encoder{
+		DefaultEncoder: extJSONEncoder{},
+		"bson":         bsonEncoder{},
+	}
+)
+
+// RegisterEncoder makes the encoder available by name, allowing embedders to archive to formats of their own, e.g.
+// Protobuf or Avro. Registering a name that is already taken replaces the existing encoder.
+func RegisterEncoder(name string, e Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[name] = e
+}
+
+// LookupEncoder returns the encoder registered under the name
+func LookupEncoder(name string) (Encoder, error) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	e, ok := encoders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q, expected one of %v", name, slices.Sorted(maps.Keys(encoders)))
+	}
+	return e, nil
+}
+
+// WithEncoder renders each document with the encoder, naming files with its extension, rather than writing the
+// extended JSON documents yielded by the source as lines. Documents are only encoded once they have been validated and
+// transformed, so required fields, partitioning and the like apply as usual. Archives can only be read back when
+// written as extended JSON, so any other encoder rules out exact deletes, and verifying or deleting exactly when
+// reconciling.
+func WithEncoder(e Encoder) Option {
+	return func(a *Archiver) {
+		a.encoder = e
+		a.fileExtension = e.Extension()
+	}
+}
+
+func (a *Archiver) checkEncoderSupported() error {
+	if a.exactDelete {
+		return errors.New("exact delete reads back archives as extended JSON, so cannot be combined with other formats")
+	}
+	return nil
+}
+
+// customEncoder reports whether documents are encoded as something other than the extended JSON yielded by the source
+func (a *Archiver) customEncoder() bool {
+	if a.encoder == nil {
+		return false
+	}
+	_, ok := a.encoder.(extJSONEncoder)
+	return !ok
+}
+
+// recordEncoder returns the encoder documents are archived with
+func (a *Archiver) recordEncoder() Encoder {
+	if a.encoder == nil {
+		return extJSONEncoder{}
+	}
+	return a.encoder
+}
+
+// contentType returns the media type of archived documents
+func (a *Archiver) contentType() string {
+	return a.recordEncoder().ContentType()
+}
+
+// encodeDocument writes the extended JSON document to w, in the format of the encoder, returning the number of bytes
+// written. Documents are written as lines when there's no encoder, or it's the extended JSON encoder, as they're
+// already rendered as such.
+func encodeDocument(w io.Writer, e Encoder, doc []byte) (int64, error) {
+	if _, ok := e.(extJSONEncoder); e == nil || ok {
+		return writeLine(w, doc)
+	}
+	var raw bson.Raw
+	if err := bson.UnmarshalExtJSON(doc, true, &raw); err != nil {
+		return 0, fmt.Errorf("failed to decode document: %w", err)
+	}
+	cw := &countingWriter{Writer: w}
+	if err := e.Encode(raw, cw); err != nil {
+		return cw.n, fmt.Errorf("failed to encode document: %w", err)
+	}
+	return cw.n, nil
+}
+
+// extJSONEncoder renders documents as canonical extended JSON, one per line
+type extJSONEncoder struct{}
+
+func (extJSONEncoder) Encode(doc bson.Raw, w io.Writer) error {
+	out, err := bson.MarshalExtJSON(doc, true, false)
+	if err != nil {
+		return err
+	}
+	_, err = writeLine(w, out)
+	return err
+}
+
+func (extJSONEncoder) Extension() string {
+	return "json"
+}
+
+func (extJSONEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (extJSONEncoder) RecordSeparator() string {
+	return "\n"
+}
+
+// bsonEncoder writes documents as raw BSON, one after another, as mongodump does, so that archives can be restored
+// with mongorestore once decompressed
+type bsonEncoder struct{}
+
+func (bsonEncoder) Encode(doc bson.Raw, w io.Writer) error {
+	_, err := w.Write(doc)
+	return err
+}
+
+func (bsonEncoder) Extension() string {
+	return "bson"
+}
+
+func (bsonEncoder) ContentType() string {
+	return "application/bson"
+}
+
+// RecordSeparator is empty, as BSON documents are prefixed by their length
+func (bsonEncoder) RecordSeparator() string {
+	return ""
+}
@@ -0,0 +1,83 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// estimator is implemented by sources able to provide the statistics an Estimate is built from
+type estimator interface {
+	CountBefore(ctx context.Context, before time.Time) (int, error)
+	AverageDocumentSize(ctx context.Context) (int, error)
+}
+
+// Estimate is an approximation of the work a run would perform
+type Estimate struct {
+	Days                int
+	Documents           int
+	AverageDocumentSize int
+	UncompressedBytes   int64
+	CompressedBytes     int64
+	MinimumRuntime      time.Duration
+}
+
+// Estimate approximates the work involved in archiving up to the target, without archiving anything. The output size
+// assumes the supplied compression ratio (compressed / uncompressed), and the runtime only accounts for the delay
+// between days, so should be treated as a lower bound.
+func (a *Archiver) Estimate(ctx context.Context, target time.Time, compressionRatio float64) (Estimate, error) {
+	ests, err := a.CompareEstimates(ctx, []time.Time{target}, compressionRatio)
+	if err != nil {
+		return Estimate{}, err
+	}
+	return ests[0], nil
+}
+
+// CompareEstimates approximates the work involved in archiving up to each of the targets over the same documents, as
+// Estimate does, so the impact of candidate retentions can be compared without archiving anything. The earliest
+// document and average document size are only looked up once, so estimates differ by their days alone.
+func (a *Archiver) CompareEstimates(
+	ctx context.Context,
+	targets []time.Time,
+	compressionRatio float64,
+) ([]Estimate, error) {
+	src, ok := a.source.(estimator)
+	if !ok {
+		return nil, errors.New("source does not support estimation")
+	}
+
+	earliest, err := a.source.EarliestCreatedAt(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get earliest created at: %w", err)
+	}
+
+	ests := make([]Estimate, len(targets))
+	averageSize := -1 // looked up once there are days to estimate
+	for i, target := range targets {
+		// Mirror the iteration performed by Run, so that the same set of days is covered
+		est := &ests[i]
+		end := a.dayOf(earliest)
+		for ; end.Before(a.endOf(target)); end = end.AddDate(0, 0, 1) {
+			est.Days++
+		}
+		if est.Days == 0 {
+			continue
+		}
+
+		if est.Documents, err = src.CountBefore(ctx, end); err != nil {
+			return nil, fmt.Errorf("failed to count documents: %w", err)
+		}
+		if averageSize < 0 {
+			if averageSize, err = src.AverageDocumentSize(ctx); err != nil {
+				return nil, fmt.Errorf("failed to get average document size: %w", err)
+			}
+		}
+
+		est.AverageDocumentSize = averageSize
+		est.UncompressedBytes = int64(est.Documents) * int64(est.AverageDocumentSize)
+		est.CompressedBytes = int64(float64(est.UncompressedBytes) * compressionRatio)
+		est.MinimumRuntime = a.delay * time.Duration(est.Days)
+	}
+	return ests, nil
+}
@@ -0,0 +1,176 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// exactDeleter is implemented by sources able to delete documents by _id
+type exactDeleter interface {
+	DeleteByIDs(ctx context.Context, ids []json.RawMessage) (int, error)
+}
+
+// opener is implemented by stores able to read back files
+type opener interface {
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+}
+
+// WithExactDelete structures each day as read, write, verify, then delete, with the delete scoped to exactly the _ids
+// written to the file, rather than everything in the day. Documents arriving for the day after it was read are
+// therefore left in place for a later run, rather than being deleted without having been archived. Archive and
+// delete can't be a single transaction, but this keeps the window of inconsistency as small as possible.
+func WithExactDelete() Option {
+	return func(a *Archiver) {
+		a.exactDelete = true
+	}
+}
+
+// WithDeleteChunkSize bounds the memory used when deleting exactly, which otherwise holds the _id of every document
+// written for the day. The ids are instead read back from each file once it has been written and verified, and
+// deleted n at a time. Should deleting a chunk fail, the file is nonetheless complete, so reconciling deletes the rest.
+func WithDeleteChunkSize(n int) Option {
+	return func(a *Archiver) {
+		a.deleteChunkSize = n
+	}
+}
+
+func (a *Archiver) checkExactDeleteSupported() error {
+	if _, ok := a.source.(exactDeleter); !ok {
+		return errors.New("source does not support deleting by id")
+	}
+	if a.resume != nil {
+		return errors.New("exact delete cannot be combined with resuming")
+	}
+	// Every file is read back and verified before its documents are deleted
+	if _, ok := a.store.(opener); !ok {
+		return errors.New("store does not support reading, so files cannot be verified before deleting exactly")
+	}
+	return nil
+}
+
+// deleteExact verifies the written file, and then deletes exactly the documents it holds
+func (a *Archiver) deleteExact(ctx context.Context, date time.Time, res *dayResult) error {
+	if res.skipped {
+		slog.Warn("file write was skipped, so the archived ids are unknown and nothing will be deleted")
+		return nil
+	}
+
+	for _, f := range res.files {
+		if err := a.verifyFile(ctx, f); err != nil {
+			return fmt.Errorf("failed to verify file %s: %w", f.name, err)
+		}
+	}
+
+	// What was deleted is recorded even should deleting fail part way through, to report how far it got
+	var deleted int
+	if a.deleteChunkSize > 0 {
+		for _, f := range res.files {
+			n, err := a.deleteFileIDs(ctx, f.name)
+			deleted += n
+			if err != nil {
+				res.deleted = deleted
+				return fmt.Errorf("failed to delete documents of file %s after deleting %d: %w", f.name, deleted, err)
+			}
+		}
+	} else {
+		var err error
+		if deleted, err = a.source.(exactDeleter).DeleteByIDs(ctx, res.ids); err != nil {
+			res.deleted = deleted
+			return fmt.Errorf("failed to delete documents: %w", err)
+		}
+	}
+
+	uncompressed, compressed := res.bytes()
+	slog.Info(
+		"day archived",
+		slog.String("date", date.Format(time.DateOnly)),
+		slog.Int("files", len(res.files)),
+		slog.Int("written", res.written),
+		slog.Int64("uncompressedBytes", uncompressed),
+		slog.Int64("compressedBytes", compressed),
+		slog.Int("deleted", deleted),
+	)
+	res.deleted = deleted
+
+	// Fewer may be deleted should something else have removed documents in the meantime
+	return a.checkDeletedCount(res.written, deleted)
+}
+
+// deleteFileIDs reads back the _ids of the documents held in the file, deleting them a chunk at a time
+func (a *Archiver) deleteFileIDs(ctx context.Context, fileName string) (deleted int, err error) {
+	deleter := a.source.(exactDeleter)
+	chunk := make([]json.RawMessage, 0, a.deleteChunkSize)
+	flush := func() error {
+		n, err := deleter.DeleteByIDs(ctx, chunk)
+		deleted += n
+		chunk = chunk[:0]
+		return err
+	}
+
+	err = a.scanFileIDs(ctx, fileName, func(id json.RawMessage) error {
+		if chunk = append(chunk, id); len(chunk) < a.deleteChunkSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil && len(chunk) > 0 {
+		err = flush()
+	}
+	return deleted, err
+}
+
+// verifyFile reads back the written file, checking that it holds the expected number of documents, and that its
+// contents match their CRC when recorded
+func (a *Archiver) verifyFile(ctx context.Context, f fileResult) (err error) {
+	r, err := a.store.(opener).Open(ctx, f.name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cErr := r.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close file: %w", cErr))
+		}
+	}()
+
+	gr, err := a.newFileReader(r)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	// The contents are hashed as they're scanned, when their CRC was recorded
+	var content io.Reader = gr
+	crc := crc32.NewIEEE()
+	if f.contentCRC != "" {
+		content = io.TeeReader(gr, crc)
+	}
+
+	var lines int
+	scanner := bufio.NewScanner(content)
+	scanner.Buffer(nil, maxLineSize)
+	for scanner.Scan() {
+		lines++
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	if lines != f.written {
+		return fmt.Errorf("%w: file holds %d documents, expected %d", ErrIntegrity, lines, f.written)
+	}
+	if f.contentCRC != "" && formatCRC(crc.Sum32()) != f.contentCRC {
+		return contentCRCMismatch(f.contentCRC, crc.Sum32())
+	}
+	return nil
+}
+
+// maxLineSize bounds the length of a single line when reading back files. MongoDB documents are limited to 16MB, and
+// extended JSON may be several times larger than the BSON it represents.
+const maxLineSize = 64 * 1024 * 1024
@@ -0,0 +1,101 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// explodeConfig describes how documents are split into a record for each element of an array field
+type explodeConfig struct {
+	field        string
+	parentFields []string
+}
+
+// WithExplodeField writes a record for each element of the named top level array field of every document, rather
+// than the document itself, holding the element under the field's name alongside the named parent fields. Documents
+// whose field is missing, null or an empty array produce no records, and a value other than an array is written as a
+// single record. Each document is still counted and deleted as one, however many records it produces, and dead
+// lettered documents are written whole.
+func WithExplodeField(field string, parentFields []string) Option {
+	return func(a *Archiver) {
+		a.explode = &explodeConfig{field: field, parentFields: parentFields}
+	}
+}
+
+func (a *Archiver) checkExplodeSupported() error {
+	switch {
+	case a.resume != nil:
+		return errors.New("exploding documents cannot be combined with resuming")
+	case a.exactDelete:
+		// Records don't carry the _id of their document, and documents without elements produce none to read back
+		return errors.New("exploding documents cannot be combined with exact delete")
+	}
+	return nil
+}
+
+// writeDocument writes the document to the file, or its records should documents be exploded
+func (a *Archiver) writeDocument(f *gzipFile, doc []byte) error {
+	if a.explode == nil {
+		return f.write(doc)
+	}
+	records, err := a.explode.records(doc)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err = f.write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// records splits the document into a record for each element of the exploded field. Values are copied as is, so
+// extended JSON is preserved exactly.
+func (e *explodeConfig) records(doc []byte) ([][]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+	value, ok := fields[e.field]
+	if !ok || bytes.Equal(value, []byte("null")) {
+		return nil, nil
+	}
+	elements := []json.RawMessage{value}
+	if bytes.HasPrefix(value, []byte("[")) {
+		if err := json.Unmarshal(value, &elements); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", e.field, err)
+		}
+	}
+
+	// Parent fields are written first, in the order configured, followed by the element
+	var parent bytes.Buffer
+	parent.WriteByte('{')
+	for _, name := range e.parentFields {
+		v, ok := fields[name]
+		if !ok || name == e.field {
+			continue
+		}
+		writeMember(&parent, name, v)
+		parent.WriteByte(',')
+	}
+
+	records := make([][]byte, 0, len(elements))
+	for _, element := range elements {
+		record := bytes.NewBuffer(bytes.Clone(parent.Bytes()))
+		writeMember(record, e.field, element)
+		record.WriteByte('}')
+		records = append(records, record.Bytes())
+	}
+	return records, nil
+}
+
+// writeMember writes a single name and value pair of a JSON object
+func writeMember(buf *bytes.Buffer, name string, value json.RawMessage) {
+	key, _ := json.Marshal(name) // strings always marshal
+	buf.Write(key)
+	buf.WriteByte(':')
+	buf.Write(value)
+}
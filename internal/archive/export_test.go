@@ -0,0 +1,39 @@
+package archive
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// WithCompressor replaces the gzip writer of files, allowing a faulty compressor to be injected
+func WithCompressor(fn func(w io.Writer, level int) (io.WriteCloser, error)) Option {
+	return func(a *Archiver) {
+		a.newCompressor = fn
+	}
+}
+
+// WithClock replaces the clock used to time each day, allowing the time taken to write days to be controlled
+func WithClock(now func() time.Time) Option {
+	return func(a *Archiver) {
+		a.now = now
+	}
+}
+
+// NewParallelGzipWriter exposes the parallel compressor, so its output can be checked against its input directly
+func NewParallelGzipWriter(w io.Writer, level, threads int) (io.WriteCloser, error) {
+	return newParallelGzipWriter(threads)(w, level)
+}
+
+// WithSampler replaces the source of random numbers deciding which lines are sampled when verifying
+func WithSampler(sample func() float64) Option {
+	return func(a *Archiver) {
+		a.sample = sample
+	}
+}
+
+// VerifySample exposes verifying a sample of the lines of a file, returning the number of lines read and sampled
+func (a *Archiver) VerifySample(ctx context.Context, fileName string) (lines, sampled int, err error) {
+	res, err := a.verifySample(ctx, fileName)
+	return res.lines, res.sampled, err
+}
@@ -0,0 +1,262 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// gzipFile is an archive file being written to the store
+type gzipFile struct {
+	w            io.WriteCloser
+	gw           io.WriteCloser // gzip writer, or the compressed writer itself when compression is left to the store
+	compressed   *countingWriter
+	uncompressed int64
+	written      int
+	closed       bool
+	suspended    bool                  // whether the file has been closed for now, to be reopened by appending to it
+	index        *gzipFile             // offset index of the file, if enabled
+	validator    *compressionValidator // validates the compressed stream, if enabled
+	schema       *schema               // schema of the documents in the file, if enabled
+	bounds       *bounds               // bounds of the documents in the file, if enabled
+	checksum     *fileChecksum         // checksum of the file as stored, if enabled
+	contentCRC   hash.Hash32           // CRC32 of the uncompressed contents, if enabled
+	encoder      Encoder               // renders documents, or nil to write them as extended JSON lines
+	// committer commits the file every commitInterval, when enabled and supported by the store
+	committer      committer
+	commitInterval time.Duration
+	committedAt    time.Time
+}
+
+// createFile creates the named file in the underlying store, ready for documents to be written to it
+func (a *Archiver) createFile(ctx context.Context, name string, level int) (*gzipFile, error) {
+	slog.Info("writing to file", slog.String("fileName", name))
+
+	w, err := a.store.Create(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create file: %w", ErrStorage, err)
+	}
+
+	// Contents will be gzipped, with the compressed output counted, and hashed if enabled, on its way to the store
+	var stored io.Writer = w
+	var checksum *fileChecksum
+	if a.checksums {
+		checksum = a.newFileChecksum(ctx, name)
+		stored = io.MultiWriter(w, checksum.hash)
+	}
+	cw := &countingWriter{Writer: stored}
+	f := &gzipFile{
+		w:          w,
+		gw:         nopWriteCloser{cw},
+		compressed: cw,
+		checksum:   checksum,
+		encoder:    a.encoder,
+	}
+	if a.contentCRC {
+		f.contentCRC = crc32.NewIEEE()
+	}
+	if c, ok := w.(committer); ok && a.commitInterval > 0 {
+		f.committer = c
+		f.commitInterval = a.commitInterval
+		f.committedAt = time.Now()
+	}
+	if a.compression == CompressionStore {
+		return f, nil
+	}
+
+	// When validating, the compressed output is also decompressed on its way to the store
+	var out io.Writer = cw
+	if a.validateCompression {
+		f.validator = newCompressionValidator()
+		out = io.MultiWriter(cw, f.validator)
+	}
+	if f.gw, err = a.newCompressor(out, level); err != nil {
+		if f.validator != nil {
+			_ = f.validator.wait()
+		}
+		return nil, errors.Join(err, w.Close())
+	}
+	return f, nil
+}
+
+// write appends a document to the file, as a single line
+func (f *gzipFile) write(doc []byte) error {
+	if f.schema != nil {
+		if err := f.schema.observe(doc); err != nil {
+			return err
+		}
+	}
+	if f.bounds != nil {
+		if err := f.bounds.observe(doc); err != nil {
+			return err
+		}
+	}
+	if f.index != nil {
+		if err := f.writeOffset(doc); err != nil {
+			return fmt.Errorf("failed to write offset index: %w", err)
+		}
+	}
+	var w io.Writer = f.gw
+	if f.contentCRC != nil {
+		w = io.MultiWriter(f.gw, f.contentCRC)
+	}
+	n, err := encodeDocument(w, f.encoder, doc)
+	f.uncompressed += n
+	if err != nil {
+		return err
+	}
+	f.written++
+	return f.commit()
+}
+
+// nopWriteCloser writes to the underlying writer, without closing it, as it's closed separately
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// newline terminates each document written
+var newline = []byte{'\n'}
+
+// writeLine writes the document followed by a newline. The document is written as is, rather than first being copied
+// to append the newline, as documents are written at a rate where the extra allocation adds up.
+func writeLine(w io.Writer, doc []byte) (int64, error) {
+	n, err := w.Write(doc)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := w.Write(newline)
+	return int64(n + m), err
+}
+
+// close closes the gzip writer and then the underlying file writer, writing its checksum once it has been committed,
+// followed by the offset index if there is one. Should the compressed stream be malformed, the file is aborted rather
+// than closed. Only the first call has any effect.
+func (f *gzipFile) close() (err error) {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	if !f.suspended {
+		if cErr := f.gw.Close(); cErr != nil {
+			err = fmt.Errorf("failed to close gzip writer: %w", cErr)
+		}
+		if f.validator != nil {
+			if vErr := f.validator.wait(); vErr != nil {
+				return errors.Join(err, vErr, f.abort())
+			}
+		}
+		if cErr := f.w.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("%w: failed to close file: %w", ErrStorage, cErr))
+		}
+	}
+	if f.checksum != nil && err == nil {
+		if cErr := f.checksum.write(f.checksum.hash.Sum(nil)); cErr != nil {
+			err = fmt.Errorf("failed to write checksum: %w", cErr)
+		}
+	}
+	if f.index != nil {
+		if cErr := f.index.close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close offset index: %w", cErr))
+		}
+	}
+	return err
+}
+
+// suspend finishes the file's current gzip member and closes the underlying file, without finishing the file itself, so
+// that it can be reopened by appending to it should more documents belong in it
+func (f *gzipFile) suspend() error {
+	if err := f.gw.Close(); err != nil {
+		return fmt.Errorf("failed to close gzip writer: %w", err)
+	}
+	if err := f.w.Close(); err != nil {
+		return fmt.Errorf("%w: failed to close file: %w", ErrStorage, err)
+	}
+	f.suspended = true
+	return nil
+}
+
+// reopenFile continues the suspended file by appending a further gzip member to it, which gzip readers decompress as
+// though the file had been written in one go
+func (a *Archiver) reopenFile(ctx context.Context, name string, f *gzipFile, level int) error {
+	slog.Info("reopening file", slog.String("fileName", name))
+
+	w, err := a.store.(resumableStore).Append(ctx, name, f.compressed.n)
+	if err != nil {
+		return fmt.Errorf("%w: failed to reopen file: %w", ErrStorage, err)
+	}
+	var stored io.Writer = w
+	if f.checksum != nil {
+		stored = io.MultiWriter(w, f.checksum.hash)
+	}
+	f.w = w
+	f.compressed = &countingWriter{Writer: stored, n: f.compressed.n}
+	f.gw = nopWriteCloser{f.compressed}
+	f.committer = nil
+	if c, ok := w.(committer); ok && a.commitInterval > 0 {
+		f.committer = c
+		f.committedAt = time.Now()
+	}
+	f.suspended = false
+	if a.compression == CompressionStore {
+		return nil
+	}
+	if f.gw, err = a.newCompressor(f.compressed, level); err != nil {
+		return errors.Join(err, w.Close())
+	}
+	return nil
+}
+
+// abort discards the file, and its offset index, for stores able to do so. Files of other stores can only be closed,
+// which commits them.
+func (f *gzipFile) abort() (err error) {
+	if a, ok := f.w.(aborter); ok {
+		if aErr := a.Abort(); aErr != nil {
+			err = fmt.Errorf("%w: failed to abort file: %w", ErrStorage, aErr)
+		}
+	} else {
+		slog.Warn("store does not support aborting files, so the malformed file has been committed")
+		if cErr := f.w.Close(); cErr != nil {
+			err = fmt.Errorf("%w: failed to close file: %w", ErrStorage, cErr)
+		}
+	}
+	if f.index != nil {
+		err = errors.Join(err, f.index.discard())
+	}
+	return err
+}
+
+// discard finishes writing the file, aborting it regardless of whether it's well-formed
+func (f *gzipFile) discard() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	_ = f.gw.Close()
+	if f.validator != nil {
+		_ = f.validator.wait()
+	}
+	return f.abort()
+}
+
+// result describes the file, which is only complete once it has been closed
+func (f *gzipFile) result(name string) fileResult {
+	res := fileResult{
+		name:              name,
+		written:           f.written,
+		uncompressedBytes: f.uncompressed,
+		compressedBytes:   f.compressed.n,
+	}
+	if f.contentCRC != nil {
+		res.contentCRC = formatCRC(f.contentCRC.Sum32())
+	}
+	return res
+}
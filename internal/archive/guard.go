@@ -0,0 +1,51 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+type deleteGuardConfig struct {
+	check   func(ctx context.Context) error
+	archive bool
+}
+
+// WithDeleteGuard makes check before deleting each day's documents, e.g. that the cluster is healthy enough to not
+// compound an incident. Should the check fail, the run stops, having checked before archiving the day too so that it
+// isn't archived only to be left in the collection. When archiving whilst blocked, days are instead archived as usual
+// but their documents are left in the collection, to be deleted by Reconcile once deleting is safe again. Reconcile
+// makes the check before deleting each day, stopping should it fail.
+func WithDeleteGuard(check func(ctx context.Context) error, archiveWhenBlocked bool) Option {
+	return func(a *Archiver) {
+		a.deleteGuard = &deleteGuardConfig{
+			check:   check,
+			archive: archiveWhenBlocked,
+		}
+	}
+}
+
+// checkDeleteGuard fails should the delete guard's check fail
+func (a *Archiver) checkDeleteGuard(ctx context.Context) error {
+	if a.deleteGuard == nil {
+		return nil
+	}
+	if err := a.deleteGuard.check(ctx); err != nil {
+		return fmt.Errorf("deletes blocked: %w", err)
+	}
+	return nil
+}
+
+// deletesBlocked reports whether the day's documents should be left in the collection, having been archived whilst
+// the delete guard's check fails
+func (a *Archiver) deletesBlocked(ctx context.Context) (bool, error) {
+	err := a.checkDeleteGuard(ctx)
+	if err == nil {
+		return false, nil
+	}
+	if !a.deleteGuard.archive {
+		return false, err
+	}
+	slog.Warn("leaving archived documents in the collection for reconciling", slog.String("reason", err.Error()))
+	return true, nil
+}
@@ -0,0 +1,128 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+)
+
+const (
+	// fileHeaderSchemaVersion should be incremented whenever the layout of archived files changes
+	fileHeaderSchemaVersion = 1
+
+	fileHeaderSuffix = ".header.json"
+)
+
+type fileHeaderConfig struct {
+	collection string
+}
+
+// fileHeader describes the contents of an archived file. Since the document count is only known once all documents
+// have been streamed, it is written as a sidecar file next to the archive rather than as a leading line, which keeps
+// archives as plain newline delimited documents.
+type fileHeader struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Collection    string `json:"collection"`
+	Date          string `json:"date"`
+	File          string `json:"file"`
+	Codec         string `json:"codec"`
+	ContentType   string `json:"contentType"`
+	DocumentCount int    `json:"documentCount"`
+	// UncompressedBytes and CompressedBytes are the sizes of the archive before and after compression
+	UncompressedBytes int64 `json:"uncompressedBytes"`
+	CompressedBytes   int64 `json:"compressedBytes"`
+	// OriginalFile is the name the day would have been archived to, had it not collided with an existing file
+	OriginalFile string `json:"originalFile,omitempty"`
+	// DeadLetterFile is the name of the file holding the day's documents which exceeded the maximum document size
+	DeadLetterFile string `json:"deadLetterFile,omitempty"`
+	// InvalidCount is the number of the day's documents missing a required field, which were left in the collection
+	InvalidCount int `json:"invalidCount,omitempty"`
+	// InvalidFile is the name of the file holding the day's documents missing a required field, when dead lettered
+	InvalidFile string `json:"invalidFile,omitempty"`
+	// Source identifies the mongo deployment the documents were archived from, when recorded
+	Source *source.Provenance `json:"source,omitempty"`
+	// ContentCRC32 is the CRC32 (IEEE) of the archive's uncompressed contents as hex, when recorded
+	ContentCRC32 string `json:"contentCrc32,omitempty"`
+	// SpecialTypeCount is the number of the day's documents holding a value of a special type, when detected
+	SpecialTypeCount int `json:"specialTypeCount,omitempty"`
+	// SpecialTypesFile is the name of the file holding the day's documents holding special types, when set aside
+	SpecialTypesFile string `json:"specialTypesFile,omitempty"`
+}
+
+// WithFileHeader enables writing a header sidecar (e.g. 2024/11/01.header.json) alongside each archived file
+func WithFileHeader(collection string) Option {
+	return func(a *Archiver) {
+		a.fileHeader = &fileHeaderConfig{
+			collection: collection,
+		}
+	}
+}
+
+// WithProvenance records the mongo deployment documents are archived from in the header of each archived file, having
+// no effect without file headers
+func WithProvenance(p source.Provenance) Option {
+	return func(a *Archiver) {
+		a.provenance = &p
+	}
+}
+
+func (a *Archiver) writeFileHeader(ctx context.Context, date time.Time, fileName string, res *dayResult) (err error) {
+	headerName := a.sidecarPath(fileName) + fileHeaderSuffix
+
+	slog.Info("writing file header", slog.String("fileName", headerName))
+
+	w, err := a.store.Create(ctx, headerName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cErr := w.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close file: %w", cErr))
+		}
+	}()
+
+	var originalFile string
+	if name := a.fileName(date); name != fileName {
+		originalFile = name
+	}
+
+	// The header describes the archive alone, with the day's dead letter and special types files, if any, only
+	// referenced by name
+	var file fileResult
+	var deadLetterFile, specialTypesFile string
+	for _, f := range res.files {
+		switch f.name {
+		case fileName:
+			file = f
+		case a.deadLetterName(fileName):
+			deadLetterFile = f.name
+		case a.specialTypesName(fileName):
+			specialTypesFile = f.name
+		}
+	}
+
+	return json.NewEncoder(w).Encode(fileHeader{
+		SchemaVersion:     fileHeaderSchemaVersion,
+		Collection:        a.fileHeader.collection,
+		Date:              date.Format(time.DateOnly),
+		File:              fileName,
+		Codec:             a.codec(),
+		ContentType:       a.contentType(),
+		DocumentCount:     file.written,
+		UncompressedBytes: file.uncompressedBytes,
+		CompressedBytes:   file.compressedBytes,
+		OriginalFile:      originalFile,
+		DeadLetterFile:    deadLetterFile,
+		InvalidCount:      res.invalid,
+		InvalidFile:       res.invalidFile,
+		Source:            a.provenance,
+		ContentCRC32:      file.contentCRC,
+		SpecialTypeCount:  res.special,
+		SpecialTypesFile:  specialTypesFile,
+	})
+}
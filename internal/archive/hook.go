@@ -0,0 +1,53 @@
+package archive
+
+import (
+	"context"
+	"time"
+)
+
+// ArchivedDay describes a day once its documents have been archived, and deleted unless deletes are skipped
+type ArchivedDay struct {
+	Date      time.Time
+	Files     []ArchivedFile
+	Documents int
+	Deleted   int
+}
+
+// ArchivedFile describes a single file written for a day, named relative to the store
+type ArchivedFile struct {
+	Name              string
+	Documents         int
+	UncompressedBytes int64
+	CompressedBytes   int64
+}
+
+// WithDayArchivedHook invokes fn once each day has been archived and deleted, e.g. to trigger downstream processing of
+// its files. Days whose file already existed, and so was skipped, aren't reported, as what the file holds is unknown.
+// An error returned by fn fails the run, so fn should swallow errors which shouldn't.
+func WithDayArchivedHook(fn func(ctx context.Context, day ArchivedDay) error) Option {
+	return func(a *Archiver) {
+		a.dayArchivedHook = fn
+	}
+}
+
+// notifyDayArchived invokes the day archived hook, if there is one
+func (a *Archiver) notifyDayArchived(ctx context.Context, date time.Time, res *dayResult) error {
+	if a.dayArchivedHook == nil || res.skipped {
+		return nil
+	}
+
+	day := ArchivedDay{
+		Date:      date,
+		Documents: res.written,
+		Deleted:   res.deleted,
+	}
+	for _, f := range res.files {
+		day.Files = append(day.Files, ArchivedFile{
+			Name:              f.name,
+			Documents:         f.written,
+			UncompressedBytes: f.uncompressedBytes,
+			CompressedBytes:   f.compressedBytes,
+		})
+	}
+	return a.dayArchivedHook(ctx, day)
+}
@@ -0,0 +1,86 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// ErrDeleteInterrupted indicates that the run was cancelled whilst deleting a day's documents, once its files had been
+// written in full. Some of the day's documents may have been deleted, but none without having been archived, with
+// the rest deleted by reconciling.
+var ErrDeleteInterrupted = errors.New("delete interrupted")
+
+// WithDeleteCancelGrace lets a day's delete carry on for up to the grace period should the run be cancelled whilst
+// it's in progress, e.g. on SIGTERM, so that a run being stopped usually finishes the day it was deleting rather than
+// leaving it part deleted. The run stops once the delete has finished, or been interrupted at the end of the grace
+// period. Without one, deleting is interrupted straight away.
+func WithDeleteCancelGrace(grace time.Duration) Option {
+	return func(a *Archiver) {
+		a.deleteCancelGrace = grace
+	}
+}
+
+// deletionContext returns the context to delete the day's documents within, which is only cancelled once the grace
+// period has elapsed after ctx is
+func (a *Archiver) deletionContext(ctx context.Context, date time.Time) (context.Context, context.CancelFunc) {
+	if a.deleteCancelGrace <= 0 {
+		return ctx, func() {}
+	}
+	deleteCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		slog.Warn(
+			"run cancelled whilst deleting, finishing the day's delete",
+			slog.String("date", date.Format(time.DateOnly)),
+			slog.Duration("grace", a.deleteCancelGrace),
+		)
+		time.AfterFunc(a.deleteCancelGrace, cancel)
+	})
+	return deleteCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// interruptedDelete describes a delete of the day's documents which failed as the run was cancelled, leaving what was
+// deleted so far, if known, alongside the complete files for reconciling to finish off. A negative count of deleted
+// documents denotes that it's unknown. Other errors are returned as is.
+func (a *Archiver) interruptedDelete(
+	ctx context.Context,
+	err error,
+	date time.Time,
+	res *dayResult,
+	deleted int,
+) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+
+	files := make([]string, 0, len(res.files))
+	for _, f := range res.files {
+		files = append(files, f.name)
+	}
+	count := "an unknown number"
+	if deleted >= 0 {
+		count = strconv.Itoa(deleted)
+	}
+	slog.Error(
+		"delete interrupted, reconcile to delete the rest of the day's documents",
+		slog.String("date", date.Format(time.DateOnly)),
+		slog.Any("files", files),
+		slog.Int("archived", res.written),
+		slog.String("deleted", count),
+		slog.Any("error", err),
+	)
+	return fmt.Errorf(
+		"%w: deleted %s of %d documents archived for %s, whose files are complete, so reconciling deletes the rest: %w",
+		ErrDeleteInterrupted,
+		count,
+		res.written,
+		date.Format(time.DateOnly),
+		err,
+	)
+}
@@ -0,0 +1,126 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+const (
+	// LayoutPath is the location in the store of the document describing how archives are laid out
+	LayoutPath = "_archiver/layout.json"
+
+	// layoutSchemaVersion should be incremented whenever the layout document changes incompatibly
+	layoutSchemaVersion = 1
+)
+
+// FieldTransform describes a change made to documents before they're archived, so that consumers can map archived
+// fields back to those of the collection
+type FieldTransform struct {
+	// Kind is either "rename", where Field is archived as To, or "plain", where Field is archived as plain JSON rather
+	// than extended JSON
+	Kind  string `json:"kind"`
+	Field string `json:"field"`
+	To    string `json:"to,omitempty"`
+}
+
+// layout describes how archives are laid out in the store, for consumers to interpret archives written with
+// non-default settings
+type layout struct {
+	SchemaVersion int `json:"schemaVersion"`
+	// PathTemplate is the name of each day's archive, in which {yyyy}, {mm} and {dd} stand for the day, and {value}
+	// for the value of the partition field
+	PathTemplate string `json:"pathTemplate"`
+	Codec        string `json:"codec"`
+	ContentType  string `json:"contentType"`
+	// RecordSeparator follows each document, and is omitted when unknown, as for encoders registered by embedders
+	RecordSeparator *string          `json:"recordSeparator,omitempty"`
+	FieldTransforms []FieldTransform `json:"fieldTransforms"`
+}
+
+// separator is optionally implemented by encoders whose documents are each followed by a fixed separator
+type separator interface {
+	RecordSeparator() string
+}
+
+// WithLayout enables writing a layout document to LayoutPath at the start of each run, describing the path of each
+// day's archive, its codec and format, and the transforms applied to documents, which are supplied as the archiver
+// has no knowledge of them. The document is only rewritten when it differs from the one already in the store, so
+// changing settings between runs is reflected without rewriting it on every run.
+func WithLayout(transforms []FieldTransform) Option {
+	return func(a *Archiver) {
+		a.layout = &layout{
+			SchemaVersion:   layoutSchemaVersion,
+			FieldTransforms: transforms,
+		}
+	}
+}
+
+// writeLayout writes the layout document, unless the store already holds an identical one
+func (a *Archiver) writeLayout(ctx context.Context) (err error) {
+	l := *a.layout
+	l.PathTemplate = "{yyyy}/{mm}/{dd}." + a.fileExtension + a.codecSuffix()
+	if a.partition != nil {
+		l.PathTemplate = a.partition.field + "={value}/" + l.PathTemplate
+	}
+	l.Codec = a.codec()
+	l.ContentType = a.contentType()
+	if s, ok := a.recordEncoder().(separator); ok {
+		sep := s.RecordSeparator()
+		l.RecordSeparator = &sep
+	}
+	if l.FieldTransforms == nil {
+		l.FieldTransforms = []FieldTransform{}
+	}
+
+	out, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode layout: %w", err)
+	}
+	out = append(out, '\n')
+
+	current, err := a.readLayout(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: failed to read layout: %w", ErrStorage, err)
+	}
+	if bytes.Equal(current, out) {
+		return nil
+	}
+
+	slog.Info("writing layout", slog.String("fileName", LayoutPath))
+
+	w, err := a.store.Create(ctx, LayoutPath)
+	if err != nil {
+		return fmt.Errorf("%w: failed to create file: %w", ErrStorage, err)
+	}
+	defer func() {
+		if cErr := w.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("%w: failed to close file: %w", ErrStorage, cErr))
+		}
+	}()
+	_, err = w.Write(out)
+	return err
+}
+
+// readLayout returns the layout document currently in the store, or nil should there be none, or should the store be
+// unable to tell
+func (a *Archiver) readLayout(ctx context.Context) ([]byte, error) {
+	o, ok := a.store.(opener)
+	if !ok {
+		return nil, nil
+	}
+	exists, err := a.exists(ctx, LayoutPath)
+	if err != nil || !exists {
+		return nil, err
+	}
+	r, err := o.Open(ctx, LayoutPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,77 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// offsetIndexSuffix is appended to the day path of an archive to name its offset index
+const offsetIndexSuffix = ".index.json"
+
+// offsetIndexEntry locates a single document within the uncompressed contents of an archive
+type offsetIndexEntry struct {
+	ID     json.RawMessage `json:"_id,omitempty"`
+	Line   int             `json:"line"`   // 1-based
+	Offset int64           `json:"offset"` // of the first byte of the document
+}
+
+// WithOffsetIndex enables writing an index sidecar (e.g. 2024/11/01.index.json.gz) alongside each archived file,
+// holding the _id, line number and uncompressed byte offset of every document in it. Note that gzip streams can't be
+// seeked, so offsets are only directly usable once the archive has been decompressed, or when paired with a block
+// compressed or uncompressed format.
+func WithOffsetIndex() Option {
+	return func(a *Archiver) {
+		a.offsetIndex = true
+	}
+}
+
+func (a *Archiver) checkOffsetIndexSupported() error {
+	if a.resume != nil {
+		return errors.New("offset index cannot be combined with resuming")
+	}
+	return nil
+}
+
+// offsetIndexName returns the name of the offset index for the archive file
+func (a *Archiver) offsetIndexName(fileName string) string {
+	return a.sidecarPath(fileName) + offsetIndexSuffix + a.codecSuffix()
+}
+
+// createIndexedFile creates the named file, along with its offset index, schema and bounds, each if enabled
+func (a *Archiver) createIndexedFile(ctx context.Context, name string, level int) (*gzipFile, error) {
+	f, err := a.createFile(ctx, name, level)
+	if err != nil {
+		return nil, err
+	}
+	if a.schema {
+		f.schema = newSchema()
+	}
+	if a.bounds {
+		f.bounds = &bounds{}
+	}
+	if !a.offsetIndex {
+		return f, nil
+	}
+	if f.index, err = a.createFile(ctx, a.offsetIndexName(name), level); err != nil {
+		return nil, errors.Join(err, f.close())
+	}
+	f.index.encoder = nil // the index is always extended JSON, whatever the format of the archive
+	return f, nil
+}
+
+// writeOffset records the position of the next document to be written to the file in its index
+func (f *gzipFile) writeOffset(doc []byte) error {
+	entry := offsetIndexEntry{
+		Line:   f.written + 1,
+		Offset: f.uncompressed,
+	}
+	// Documents lacking an _id can still be located by line
+	entry.ID, _ = documentID(doc)
+
+	out, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return f.index.write(out)
+}
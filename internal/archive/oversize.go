@@ -0,0 +1,166 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// OversizePolicy controls what happens to documents exceeding the maximum document size
+type OversizePolicy int
+
+const (
+	// OversizeFail fails the day
+	OversizeFail OversizePolicy = iota
+	// OversizeDeadLetter writes the document to the day's dead letter file, rather than its archive
+	OversizeDeadLetter
+)
+
+// ParseOversizePolicy parses a policy from its flag representation, one of "fail" or "dead-letter"
+func ParseOversizePolicy(s string) (OversizePolicy, error) {
+	switch s {
+	case "fail":
+		return OversizeFail, nil
+	case "dead-letter":
+		return OversizeDeadLetter, nil
+	default:
+		return 0, fmt.Errorf("invalid oversize policy %q, expected fail or dead-letter", s)
+	}
+}
+
+// String returns the flag representation of the policy
+func (p OversizePolicy) String() string {
+	if p == OversizeDeadLetter {
+		return "dead-letter"
+	}
+	return "fail"
+}
+
+// Set parses the policy from its flag representation, allowing it to be used as a flag value
+func (p *OversizePolicy) Set(s string) error {
+	parsed, err := ParseOversizePolicy(s)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// ErrDocumentTooLarge is returned when a document exceeds the maximum document size, under OversizeFail
+var ErrDocumentTooLarge = errors.New("document too large")
+
+// deadLetterSuffix is appended to the day path of an archive to name its dead letter file
+const deadLetterSuffix = ".deadletter"
+
+type oversizeConfig struct {
+	maxBytes     int // of extended JSON, or 0 for no limit
+	maxBSONBytes int // of BSON, or 0 for no limit
+	policy       OversizePolicy
+}
+
+// MaxBSONDocumentSize is the largest document MongoDB stores, beyond which archived documents couldn't be reinserted
+const MaxBSONDocumentSize = 16 * 1024 * 1024
+
+// WithMaxDocumentSize guards against documents whose extended JSON exceeds maxBytes, protecting downstream consumers
+// from outliers. Depending on the policy, the day either fails, or oversized documents are set aside in a dead letter
+// file (e.g. 2024/11/01.deadletter.json.gz) next to the archive. Dead lettered documents are still archived, in that
+// they're held by a file, so are deleted along with the rest of the day.
+func WithMaxDocumentSize(maxBytes int, policy OversizePolicy) Option {
+	return func(a *Archiver) {
+		a.oversizeConfig(policy).maxBytes = maxBytes
+	}
+}
+
+// WithMaxBSONDocumentSize guards against documents whose BSON exceeds maxBytes, handling them as for
+// WithMaxDocumentSize. Documents are measured once transformed, so that e.g. renaming fields of a document already near
+// MaxBSONDocumentSize can't produce an archive which couldn't be reinserted. Measuring requires converting each
+// document to BSON, unless it's already being archived as such.
+func WithMaxBSONDocumentSize(maxBytes int, policy OversizePolicy) Option {
+	return func(a *Archiver) {
+		a.oversizeConfig(policy).maxBSONBytes = maxBytes
+	}
+}
+
+// oversizeConfig returns the oversize config, with the policy applying to both limits, creating it if need be
+func (a *Archiver) oversizeConfig(policy OversizePolicy) *oversizeConfig {
+	if a.oversize == nil {
+		a.oversize = &oversizeConfig{}
+	}
+	a.oversize.policy = policy
+	return a.oversize
+}
+
+func (a *Archiver) checkMaxDocumentSizeSupported() error {
+	if a.oversize.policy == OversizeDeadLetter && a.resume != nil {
+		return errors.New("dead lettering cannot be combined with resuming")
+	}
+	return nil
+}
+
+// checkDocumentSize reports whether the document exceeds either maximum size, logging its _id should it do so. Under
+// OversizeFail an oversized document results in an error.
+func (a *Archiver) checkDocumentSize(doc []byte) (oversized bool, err error) {
+	if a.oversize == nil {
+		return false, nil
+	}
+	if a.oversize.maxBytes > 0 && len(doc) > a.oversize.maxBytes {
+		return true, a.handleOversized(doc, "extended JSON", len(doc), a.oversize.maxBytes)
+	}
+	if a.oversize.maxBSONBytes > 0 {
+		var raw bson.Raw
+		if err = bson.UnmarshalExtJSON(doc, true, &raw); err != nil {
+			return false, fmt.Errorf("failed to decode document: %w", err)
+		}
+		if len(raw) > a.oversize.maxBSONBytes {
+			return true, a.handleOversized(doc, "BSON", len(raw), a.oversize.maxBSONBytes)
+		}
+	}
+	return false, nil
+}
+
+// handleOversized logs the oversized document, returning an error should the policy be to fail
+func (a *Archiver) handleOversized(doc []byte, format string, size, maxBytes int) error {
+	// Documents lacking an _id are reported without one
+	id, _ := documentID(doc)
+	slog.Error(
+		"document exceeds maximum size",
+		slog.String("_id", string(id)),
+		slog.String("format", format),
+		slog.Int("bytes", size),
+		slog.Int("maxBytes", maxBytes),
+		slog.String("policy", a.oversize.policy.String()),
+	)
+	if a.oversize.policy == OversizeFail {
+		if format == "BSON" {
+			return fmt.Errorf(
+				"%w: document %s is %d bytes as BSON, exceeding the maximum of %d",
+				ErrDocumentTooLarge,
+				id,
+				size,
+				maxBytes,
+			)
+		}
+		return fmt.Errorf(
+			"%w: document %s is %d bytes, exceeding the maximum of %d",
+			ErrDocumentTooLarge,
+			id,
+			size,
+			maxBytes,
+		)
+	}
+	return nil
+}
+
+// deadLetterName returns the name of the dead letter file for the archive file
+func (a *Archiver) deadLetterName(fileName string) string {
+	return a.sidecarPath(fileName) + deadLetterSuffix + "." + a.fileExtension + a.codecSuffix()
+}
+
+// createDeadLetterFile creates the dead letter file, the first time a document is set aside for the day
+func (a *Archiver) createDeadLetterFile(ctx context.Context, name string, level int) (*gzipFile, error) {
+	slog.Warn("setting aside oversized documents", slog.String("fileName", name))
+	return a.createFile(ctx, name, level)
+}
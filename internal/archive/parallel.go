@@ -0,0 +1,157 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+)
+
+// parallelBlockSize is the amount of uncompressed input compressed as each gzip member by parallel compressors. Each
+// member starts without the history of the last, so larger blocks compress better, at the cost of memory.
+const parallelBlockSize = 1 << 20
+
+// WithCompressionThreads compresses each file on up to n goroutines, for days whose compression is CPU-bound. Input is
+// split into blocks of parallelBlockSize, each compressed on its own goroutine as a gzip member, with members written
+// in order. A series of members is a standard gzip stream, read as a whole by gunzip and the like, so archives remain
+// readable by anything reading them now. One thread, or fewer, compresses each file on the goroutine writing it.
+func WithCompressionThreads(n int) Option {
+	return func(a *Archiver) {
+		a.compressionThreads = n
+		if n > 1 {
+			a.newCompressor = newParallelGzipWriter(n)
+		}
+	}
+}
+
+func (a *Archiver) checkCompressionThreadsSupported() error {
+	switch {
+	case a.resume != nil:
+		return errors.New("compression threads cannot be combined with resuming")
+	case a.compression == CompressionStore:
+		return errors.New("compression threads cannot be combined with store compression")
+	}
+	return nil
+}
+
+// newParallelGzipWriter returns a compressor compressing on up to n goroutines at once
+func newParallelGzipWriter(n int) compressor {
+	return func(w io.Writer, level int) (io.WriteCloser, error) {
+		// Validates the level up front, as blocks are only compressed once written
+		if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+			return nil, err
+		}
+		return &parallelGzipWriter{
+			w:       w,
+			level:   level,
+			threads: n,
+			buf:     make([]byte, 0, parallelBlockSize),
+		}, nil
+	}
+}
+
+// parallelGzipWriter compresses blocks of its input as gzip members on their own goroutines, writing them in order
+type parallelGzipWriter struct {
+	w       io.Writer
+	level   int
+	threads int
+	buf     []byte             // the block being filled
+	blocks  []*compressedBlock // blocks being compressed, oldest first
+	members int                // written to w
+	err     error
+
+	// gzip writers are reused from one block to the next, as each allocates the sizeable state of the compressor
+	writers sync.Pool
+}
+
+// compressedBlock is a block of input being compressed, whose member is available once done is closed
+type compressedBlock struct {
+	done   chan struct{}
+	member bytes.Buffer
+	err    error
+}
+
+func (p *parallelGzipWriter) Write(b []byte) (int, error) {
+	var n int
+	for len(b) > 0 && p.err == nil {
+		m := min(len(b), cap(p.buf)-len(p.buf))
+		p.buf = append(p.buf, b[:m]...)
+		b = b[m:]
+		n += m
+		if len(p.buf) == cap(p.buf) {
+			p.compressBlock()
+		}
+	}
+	return n, p.err
+}
+
+// Flush completes the block being filled, and waits for every block to be written, so that everything written so far
+// can be decompressed
+func (p *parallelGzipWriter) Flush() error {
+	if len(p.buf) > 0 {
+		p.compressBlock()
+	}
+	for len(p.blocks) > 0 && p.err == nil {
+		p.writeOldest()
+	}
+	return p.err
+}
+
+// Close flushes the writer. Should nothing have been written, an empty member is written, as an empty file isn't a
+// valid gzip stream.
+func (p *parallelGzipWriter) Close() error {
+	if err := p.Flush(); err != nil {
+		return err
+	}
+	if p.members == 0 {
+		gw, err := gzip.NewWriterLevel(p.w, p.level)
+		if err != nil {
+			return err
+		}
+		p.members++
+		return gw.Close()
+	}
+	return nil
+}
+
+// compressBlock starts compressing the block being filled, first waiting for the oldest block to be written should
+// every thread be busy
+func (p *parallelGzipWriter) compressBlock() {
+	for len(p.blocks) >= p.threads && p.err == nil {
+		p.writeOldest()
+	}
+	if p.err != nil {
+		return
+	}
+
+	in := p.buf
+	p.buf = make([]byte, 0, parallelBlockSize)
+	b := &compressedBlock{done: make(chan struct{})}
+	p.blocks = append(p.blocks, b)
+	go func() {
+		defer close(b.done)
+		gw, ok := p.writers.Get().(*gzip.Writer)
+		if ok {
+			gw.Reset(&b.member)
+		} else if gw, b.err = gzip.NewWriterLevel(&b.member, p.level); b.err != nil {
+			return
+		}
+		defer p.writers.Put(gw)
+		if _, b.err = gw.Write(in); b.err == nil {
+			b.err = gw.Close()
+		}
+	}()
+}
+
+// writeOldest waits for the oldest block to be compressed, and writes it
+func (p *parallelGzipWriter) writeOldest() {
+	b := p.blocks[0]
+	p.blocks = p.blocks[1:]
+	<-b.done
+	if p.err = b.err; p.err != nil {
+		return
+	}
+	_, p.err = p.w.Write(b.member.Bytes())
+	p.members++
+}
@@ -0,0 +1,132 @@
+package archive_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/archive"
+)
+
+// documents returns n lines of extended JSON, varied enough to compress as archives do
+func documents(n int) []byte {
+	var buf bytes.Buffer
+	for i := range n {
+		fmt.Fprintf(
+			&buf,
+			`{"_id":{"$oid":"%024x"},"type":"event-%d","value":{"$numberInt":"%d"},"createdAt":{"$date":"%s"}}`+"\n",
+			i,
+			i%17,
+			i*7919%100000,
+			time.Date(2024, time.November, 1, 0, 0, i%86400, 0, time.UTC).Format(time.RFC3339),
+		)
+	}
+	return buf.Bytes()
+}
+
+func TestParallelGzipWriter(t *testing.T) {
+	t.Parallel()
+
+	decompress := func(t *testing.T, compressed []byte) []byte {
+		t.Helper()
+
+		gr, err := gzip.NewReader(bytes.NewReader(compressed))
+		require.NoError(t, err)
+		out, err := io.ReadAll(gr)
+		require.NoError(t, err)
+		return out
+	}
+
+	t.Run("decompresses identically", func(t *testing.T) {
+		t.Parallel()
+
+		in := documents(50000) // several blocks
+		var compressed bytes.Buffer
+		w, err := archive.NewParallelGzipWriter(&compressed, gzip.DefaultCompression, 4)
+		require.NoError(t, err)
+		// Written in uneven chunks, so that writes straddle blocks
+		for rest := in; len(rest) > 0; {
+			n := min(len(rest), 12345)
+			_, err = w.Write(rest[:n])
+			require.NoError(t, err)
+			rest = rest[n:]
+		}
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, in, decompress(t, compressed.Bytes()))
+
+		// Written as a series of members, rather than a single one
+		gr, err := gzip.NewReader(bytes.NewReader(compressed.Bytes()))
+		require.NoError(t, err)
+		gr.Multistream(false)
+		first, err := io.ReadAll(gr)
+		require.NoError(t, err)
+		assert.Less(t, len(first), len(in))
+	})
+
+	t.Run("flushes everything written so far", func(t *testing.T) {
+		t.Parallel()
+
+		in := documents(100)
+		var compressed bytes.Buffer
+		w, err := archive.NewParallelGzipWriter(&compressed, gzip.BestSpeed, 4)
+		require.NoError(t, err)
+		_, err = w.Write(in)
+		require.NoError(t, err)
+		require.NoError(t, w.(interface{ Flush() error }).Flush())
+
+		assert.Equal(t, in, decompress(t, compressed.Bytes()))
+		require.NoError(t, w.Close())
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		t.Parallel()
+
+		var compressed bytes.Buffer
+		w, err := archive.NewParallelGzipWriter(&compressed, gzip.DefaultCompression, 4)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		assert.Empty(t, decompress(t, compressed.Bytes()))
+	})
+
+	t.Run("invalid level", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := archive.NewParallelGzipWriter(io.Discard, 42, 4)
+		assert.Error(t, err)
+	})
+}
+
+func BenchmarkParallelGzipWriter(b *testing.B) {
+	in := documents(200000)
+	for _, threads := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("threads=%d", threads), func(b *testing.B) {
+			b.SetBytes(int64(len(in)))
+			for range b.N {
+				var w io.WriteCloser
+				var err error
+				if threads == 1 {
+					w, err = gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+				} else {
+					w, err = archive.NewParallelGzipWriter(io.Discard, gzip.DefaultCompression, threads)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err = w.Write(in); err != nil {
+					b.Fatal(err)
+				}
+				if err = w.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
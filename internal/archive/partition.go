@@ -0,0 +1,210 @@
+package archive
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// defaultMaxPartitionFiles bounds the number of files open at once when partitioning, unless a cap is configured
+const defaultMaxPartitionFiles = 64
+
+// missingPartitionValue is used for documents lacking the partition field
+const missingPartitionValue = "_missing"
+
+type partitionConfig struct {
+	field    string
+	maxFiles int
+}
+
+// WithPartitionField splits each day's documents into separate files by the value of the supplied top level field,
+// e.g. partitioning by region produces region=eu/2024/11/01.json.gz. A file is held open for each distinct value
+// within a day, so the number of distinct values is capped, with the day failing should it be exceeded.
+func WithPartitionField(field string) Option {
+	return func(a *Archiver) {
+		a.partition = &partitionConfig{
+			field:    field,
+			maxFiles: defaultMaxPartitionFiles,
+		}
+	}
+}
+
+// WithMaxOpenPartitions caps the number of partition files held open at once, rather than failing the day should it
+// have more than 64 distinct values. Once the cap is reached, the least recently written file is finished and closed
+// to make room for another, and appended to as a further gzip member should its value recur, so memory remains bounded
+// however many distinct values a day has, at the cost of reopening files whose values are interleaved. Reopening
+// requires a store able to append, without which the day fails once the cap would be exceeded, as by default.
+func WithMaxOpenPartitions(n int) Option {
+	return func(a *Archiver) {
+		a.maxOpenPartitions = n
+	}
+}
+
+func (a *Archiver) checkPartitionSupported() error {
+	switch {
+	case a.maxOpenPartitions > 0 && a.offsetIndex:
+		return errors.New("max open partitions cannot be combined with offset indexes")
+	case a.maxOpenPartitions > 0 && a.validateCompression:
+		// Each file is validated as a single stream whilst it's written, which closing and reopening it would split
+		return errors.New("max open partitions cannot be combined with compression validation")
+	case a.resume != nil:
+		return errors.New("partitioning cannot be combined with resuming")
+	case a.fileHeader != nil:
+		return errors.New("partitioning cannot be combined with file headers")
+	case a.ignoreFileExistsError:
+		return errors.New("partitioning cannot be combined with ignoring existing files")
+	}
+	return nil
+}
+
+// partitionFileName returns the name of the file the document belongs in
+func (a *Archiver) partitionFileName(doc []byte, fileName string) (string, error) {
+	value, err := partitionValue(doc, a.partition.field)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(a.partition.field+"="+value, fileName), nil
+}
+
+// partitionFile returns the named partition file ready to be written to, creating it, or reopening it should it have
+// been suspended. Should the cap on open files have been reached, the least recently written is suspended first.
+func (a *Archiver) partitionFile(
+	ctx context.Context,
+	files map[string]*gzipFile,
+	recency *partitionRecency,
+	name string,
+	level int,
+) (*gzipFile, error) {
+	f, ok := files[name]
+	if ok && !f.suspended {
+		recency.touch(name)
+		return f, nil
+	}
+
+	if maxFiles := a.maxOpenPartitionFiles(); recency.len() >= maxFiles {
+		_, appendable := a.store.(resumableStore)
+		if a.maxOpenPartitions == 0 || !appendable {
+			err := fmt.Errorf("partition field %s has more than %d distinct values within a day", a.partition.field, maxFiles)
+			if a.maxOpenPartitions > 0 {
+				err = fmt.Errorf("%w, and the store can't append to partition files to reopen them", err)
+			}
+			return nil, err
+		}
+		oldest := recency.evict()
+		if err := files[oldest].suspend(); err != nil {
+			return nil, fmt.Errorf("failed to suspend partition file %s: %w", oldest, err)
+		}
+	}
+
+	var err error
+	if ok {
+		err = a.reopenFile(ctx, name, f, level)
+	} else {
+		f, err = a.createPartitionFile(ctx, name, level)
+	}
+	if err != nil {
+		return nil, err
+	}
+	recency.touch(name)
+	return f, nil
+}
+
+// maxOpenPartitionFiles returns the number of partition files which may be open at once
+func (a *Archiver) maxOpenPartitionFiles() int {
+	if a.maxOpenPartitions > 0 {
+		return a.maxOpenPartitions
+	}
+	return a.partition.maxFiles
+}
+
+// createPartitionFile creates a new partition file, provided it doesn't already exist
+func (a *Archiver) createPartitionFile(ctx context.Context, name string, level int) (*gzipFile, error) {
+	exists, err := a.exists(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to check if file exists: %w", ErrStorage, err)
+	}
+	if exists {
+		if a.onCollision != CollisionOverwrite {
+			return nil, fmt.Errorf("%w: target file exists: %s", ErrIntegrity, name)
+		}
+		slog.Warn("overwriting existing file", slog.String("file", name))
+	}
+	return a.createIndexedFile(ctx, name, level)
+}
+
+// partitionRecency orders the open partition files of a day by when they were last written, so that the least
+// recently written can be suspended once the cap on open files is reached
+type partitionRecency struct {
+	order    *list.List // names of open files, least recently written first
+	elements map[string]*list.Element
+}
+
+func newPartitionRecency() *partitionRecency {
+	return &partitionRecency{
+		order:    list.New(),
+		elements: make(map[string]*list.Element),
+	}
+}
+
+// touch records that the named file has just been written to
+func (r *partitionRecency) touch(name string) {
+	if e, ok := r.elements[name]; ok {
+		r.order.MoveToBack(e)
+		return
+	}
+	r.elements[name] = r.order.PushBack(name)
+}
+
+// evict forgets the least recently written file, returning its name
+func (r *partitionRecency) evict() string {
+	name := r.order.Remove(r.order.Front()).(string)
+	delete(r.elements, name)
+	return name
+}
+
+func (r *partitionRecency) len() int {
+	return r.order.Len()
+}
+
+// partitionValue resolves the value of the field within the extended JSON document, in a form safe to use as a path
+// segment. Extended JSON wrapped scalars, e.g. {"$numberInt":"1"}, are unwrapped.
+func partitionValue(doc []byte, field string) (string, error) {
+	var decoded map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &decoded); err != nil {
+		return "", fmt.Errorf("failed to decode document: %w", err)
+	}
+	raw, ok := decoded[field]
+	if !ok || bytes.Equal(raw, []byte("null")) {
+		return missingPartitionValue, nil
+	}
+
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("failed to decode partition field: %w", err)
+	}
+	if wrapped, ok := value.(map[string]any); ok && len(wrapped) == 1 {
+		for k, v := range wrapped {
+			if strings.HasPrefix(k, "$") {
+				value = v
+			}
+		}
+	}
+
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	default:
+		s = string(raw)
+	}
+	if s == "" {
+		return missingPartitionValue, nil
+	}
+	return strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(s), nil
+}
@@ -0,0 +1,64 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// PauseFlagPath is the location in the store which, while present, pauses the archiver between days
+const PauseFlagPath = "_archiver/PAUSE"
+
+type pauseConfig struct {
+	minWait time.Duration
+	maxWait time.Duration
+}
+
+// WithPauseFlag causes the archiver to check for PauseFlagPath in the store before each day. While the flag exists,
+// the archiver waits, polling with an exponential backoff between minWait and maxWait, allowing operators to halt
+// archiving (and deletes) without stopping the process and losing its place.
+func WithPauseFlag(minWait, maxWait time.Duration) Option {
+	return func(a *Archiver) {
+		a.pause = &pauseConfig{
+			minWait: minWait,
+			maxWait: maxWait,
+		}
+	}
+}
+
+func (a *Archiver) checkPauseSupported() error {
+	if _, ok := a.store.(exister); !ok {
+		return errors.New("store does not support existence checks, which the pause flag requires")
+	}
+	return nil
+}
+
+// waitWhilePaused blocks until the pause flag is absent from the store
+func (a *Archiver) waitWhilePaused(ctx context.Context) error {
+	if a.pause == nil {
+		return nil
+	}
+
+	wait := a.pause.minWait
+	for {
+		paused, err := a.exists(ctx, PauseFlagPath)
+		if err != nil {
+			return fmt.Errorf("failed to check pause flag: %w", err)
+		}
+		if !paused {
+			return nil
+		}
+
+		slog.Warn("archiver paused", slog.String("flag", PauseFlagPath), slog.Duration("recheckIn", wait))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		wait = min(wait*2, a.pause.maxWait)
+	}
+}
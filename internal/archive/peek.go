@@ -0,0 +1,139 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// archivePattern matches the names of the files days are archived to, capturing the day, and excluding sidecars such
+// as headers, offset indexes and checksums
+var archivePattern = regexp.MustCompile(`(^|/)(\d{4}/\d{2}/\d{2})(-[a-z0-9-]+)?\.[a-z0-9]+(\.gz)?$`)
+
+// gzipMagic prefixes every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Peek returns the first n documents of the archive file at path, or a random sample of n of them should sample be
+// set, each as extended JSON, along with the path peeked at. An empty path peeks at the file of the latest day in the
+// store. Whether the file is gzipped is detected from its contents, so files compressed by the archiver and those left
+// to the store are both read, with BSON files told apart by their extension.
+func Peek(ctx context.Context, s store, path string, n int, sample bool) (string, [][]byte, error) {
+	o, ok := s.(opener)
+	if !ok {
+		return "", nil, errors.New("store does not support reading, so files cannot be peeked at")
+	}
+	if path == "" {
+		var err error
+		if path, err = latestArchive(ctx, s); err != nil {
+			return "", nil, err
+		}
+	}
+
+	r, err := o.Open(ctx, path)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: failed to open file: %w", ErrStorage, err)
+	}
+	defer r.Close()
+
+	br := bufio.NewReader(r)
+	var decompressed io.Reader = br
+	if magic, _ := br.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+		}
+		defer gr.Close()
+		decompressed = gr
+	}
+
+	next := nextLine(bufio.NewReader(decompressed))
+	if strings.HasSuffix(strings.TrimSuffix(path, "."+codecExtension), ".bson") {
+		next = nextBSON(decompressed)
+	}
+
+	var docs [][]byte
+	for seen := 0; sample || len(docs) < n; seen++ {
+		doc, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		// Reservoir sampling, so that every document is equally likely to be sampled without holding them all
+		switch {
+		case len(docs) < n:
+			docs = append(docs, doc)
+		case sample:
+			if i := rand.IntN(seen + 1); i < n {
+				docs[i] = doc
+			}
+		}
+	}
+	return path, docs, nil
+}
+
+// latestArchive returns the name of the archive file of the latest day in the store
+func latestArchive(ctx context.Context, s store) (string, error) {
+	l, ok := s.(lister)
+	if !ok {
+		return "", errors.New("store does not support listing, so a file to peek at must be supplied")
+	}
+	paths, err := l.List(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to list files: %w", ErrStorage, err)
+	}
+
+	var latest, latestDay string
+	for _, p := range slices.Sorted(slices.Values(paths)) {
+		m := archivePattern.FindStringSubmatch(p)
+		if m != nil && m[2] >= latestDay {
+			latest, latestDay = p, m[2]
+		}
+	}
+	if latest == "" {
+		return "", errors.New("store holds no archive files")
+	}
+	return latest, nil
+}
+
+// nextLine returns a function reading each line in turn, without its newline
+func nextLine(r *bufio.Reader) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && (err == nil || errors.Is(err, io.EOF)) {
+			return bytes.TrimSuffix(line, []byte{'\n'}), nil
+		}
+		return nil, err
+	}
+}
+
+// nextBSON returns a function reading each BSON document in turn, rendered as extended JSON
+func nextBSON(r io.Reader) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		var length [4]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return nil, err
+		}
+		doc := make([]byte, binary.LittleEndian.Uint32(length[:]))
+		if len(doc) < len(length) {
+			return nil, fmt.Errorf("invalid document length %d", len(doc))
+		}
+		copy(doc, length[:])
+		if _, err := io.ReadFull(r, doc[len(length):]); err != nil {
+			return nil, fmt.Errorf("truncated document: %w", err)
+		}
+		return bson.MarshalExtJSON(bson.Raw(doc), true, false)
+	}
+}
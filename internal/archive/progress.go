@@ -0,0 +1,129 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// progressConfig persists how far through the run the archiver is, for long running backfills to be followed
+type progressConfig struct {
+	path      string
+	interval  time.Duration
+	state     progress
+	writtenAt time.Time
+}
+
+// progress is the state written to the progress file. Unlike checkpoints, which record where to resume a single day,
+// it covers the whole run, so that e.g. a dashboard can tell where a backfill spanning weeks stands.
+type progress struct {
+	StartedAt time.Time `json:"startedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Target    string    `json:"target"`
+	// Date is the day being archived, or the last day archived once the run has finished
+	Date         string `json:"date,omitempty"`
+	DaysArchived int    `json:"daysArchived"`
+	// Documents and UncompressedBytes include those written for the day being archived so far, whereas
+	// CompressedBytes only covers days archived, as compressed sizes are only final once a day's files are closed
+	Documents         int    `json:"documents"`
+	UncompressedBytes int64  `json:"uncompressedBytes"`
+	CompressedBytes   int64  `json:"compressedBytes"`
+	Finished          bool   `json:"finished"`
+	Error             string `json:"error,omitempty"`
+}
+
+// WithProgress enables writing the progress of each run to the supplied path in the store, covering the day being
+// archived and the documents and bytes written so far. It's rewritten at most every interval as documents are written,
+// and once the run finishes, successfully or otherwise. Each update is encoded in full before being written with a
+// single write, so object stores, which replace objects whole, never expose a partial update.
+func WithProgress(path string, interval time.Duration) Option {
+	return func(a *Archiver) {
+		a.progress = &progressConfig{
+			path:     path,
+			interval: interval,
+		}
+	}
+}
+
+// startProgress resets the progress for a run towards the target, and writes it
+func (a *Archiver) startProgress(ctx context.Context, target time.Time) {
+	now := a.now()
+	a.progress.state = progress{
+		StartedAt: now,
+		Target:    target.Format(time.DateOnly),
+	}
+	a.writeProgress(ctx, now)
+}
+
+// progressDay records that the day is being archived
+func (a *Archiver) progressDay(date time.Time) {
+	if a.progress == nil {
+		return
+	}
+	a.progress.state.Date = date.Format(time.DateOnly)
+}
+
+// observeProgress records a document written, of n bytes before compression, writing the progress should the interval
+// have elapsed since it was last written
+func (a *Archiver) observeProgress(ctx context.Context, n int64) {
+	if a.progress == nil {
+		return
+	}
+	a.progress.state.Documents++
+	a.progress.state.UncompressedBytes += n
+	if now := a.now(); now.Sub(a.progress.writtenAt) >= a.progress.interval {
+		a.writeProgress(ctx, now)
+	}
+}
+
+// completeProgressDay records that the day being archived is complete
+func (a *Archiver) completeProgressDay(res *dayResult) {
+	if a.progress == nil {
+		return
+	}
+	_, compressed := res.bytes()
+	a.progress.state.DaysArchived++
+	a.progress.state.CompressedBytes += compressed
+}
+
+// finishProgress writes the final progress of the run, recording the error it failed with, if any
+func (a *Archiver) finishProgress(ctx context.Context, err error) {
+	a.progress.state.Finished = true
+	if err != nil {
+		a.progress.state.Error = err.Error()
+	}
+	// The run's context may have been cancelled, which mustn't prevent recording that it stopped
+	a.writeProgress(context.WithoutCancel(ctx), a.now())
+}
+
+// writeProgress writes the progress as of now. Failing to do so is logged rather than failing the run, as progress is
+// only informational.
+func (a *Archiver) writeProgress(ctx context.Context, now time.Time) {
+	a.progress.state.UpdatedAt = now
+	a.progress.writtenAt = now
+
+	out, err := json.Marshal(a.progress.state)
+	if err == nil {
+		err = a.writeProgressFile(ctx, append(out, '\n'))
+	}
+	if err != nil {
+		slog.Warn("failed to write progress", slog.String("fileName", a.progress.path), slog.Any("error", err))
+	}
+}
+
+func (a *Archiver) writeProgressFile(ctx context.Context, out []byte) (err error) {
+	w, err := a.store.Create(ctx, a.progress.path)
+	if err != nil {
+		return fmt.Errorf("%w: failed to create file: %w", ErrStorage, err)
+	}
+	defer func() {
+		if cErr := w.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("%w: failed to close file: %w", ErrStorage, cErr))
+		}
+	}()
+	_, err = w.Write(out)
+	return err
+}
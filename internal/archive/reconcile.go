@@ -0,0 +1,193 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+func (a *Archiver) checkReconcileSupported(verify bool) error {
+	if _, ok := a.store.(exister); !ok {
+		return errors.New("store does not support existence checks, so archived days cannot be identified")
+	}
+	if a.partition != nil {
+		return errors.New("reconciling cannot be combined with partitioning")
+	}
+	if _, ok := a.store.(opener); (verify || a.exactDelete) && !ok {
+		return errors.New("store does not support reading, so archived files cannot be read back")
+	}
+	if a.resume != nil {
+		if err := a.checkResumeSupported(); err != nil {
+			return err
+		}
+	}
+	if a.requiredFields != nil && a.requiredFields.policy != MissingFieldFail && !a.exactDelete {
+		// Deleting the whole day would delete the invalid documents left in the collection when the day was archived
+		return errors.New("reconciling after skipping or dead lettering invalid documents requires exact delete")
+	}
+	if _, ok := a.source.(exactDeleter); a.exactDelete && !ok {
+		return errors.New("source does not support deleting by id")
+	}
+	if verify && a.explode != nil {
+		return errors.New("exploded records don't carry the _id of their document, so cannot be verified")
+	}
+	if (verify || a.exactDelete) && a.customEncoder() {
+		return errors.New("archived files can only be read back as extended JSON, so cannot be verified in other formats")
+	}
+	return nil
+}
+
+// Reconcile deletes the documents of each day up to the target whose archive file already exists, cleaning up after
+// runs which archived a day but failed to delete it, e.g. having crashed in between. Days without a file, or with a
+// checkpoint showing that writing the file was interrupted, are left alone. When verifying, each file is read back in
+// full before anything is deleted, and when deleting exactly, only the documents held in the file are deleted. As
+// nothing is written, reconciling is idempotent.
+func (a *Archiver) Reconcile(ctx context.Context, target time.Time, verify bool) error {
+	if err := a.checkReconcileSupported(verify); err != nil {
+		return err
+	}
+
+	earliest, err := a.source.EarliestCreatedAt(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get earliest created at: %w", err)
+	}
+
+	slog.Info(
+		"reconciling",
+		slog.String("target", target.String()),
+		slog.String("earliest", earliest.String()),
+		slog.Bool("verify", verify),
+	)
+
+	var days, total int
+	for date := a.startDay(earliest); date.Before(a.endOf(target)); date = date.AddDate(0, 0, 1) {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		deleted, reconciled, err := a.reconcileDay(ctx, date, verify)
+		if err != nil {
+			return fmt.Errorf("reconcile failed for %s: %w", date.Format(time.DateOnly), err)
+		}
+		if reconciled {
+			days++
+			total += deleted
+		}
+	}
+
+	slog.Info("reconcile complete", slog.Int("datesReconciled", days), slog.Int("deleted", total))
+
+	return nil
+}
+
+// reconcileDay deletes the documents of the day, provided its archive file exists and is complete
+func (a *Archiver) reconcileDay(ctx context.Context, date time.Time, verify bool) (deleted int, reconciled bool, err error) {
+	fileName := a.fileName(date)
+
+	exists, err := a.exists(ctx, fileName)
+	if err != nil {
+		return 0, false, fmt.Errorf("%w: failed to check if file exists: %w", ErrStorage, err)
+	}
+	if !exists {
+		slog.Info("no archive file, skipping", slog.String("date", date.Format(time.DateOnly)))
+		return 0, false, nil
+	}
+	if a.resume != nil {
+		cp, err := a.readCheckpoint(ctx, date)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to read checkpoint: %w", err)
+		}
+		if cp != nil {
+			slog.Warn("archive file is incomplete, skipping", slog.String("date", date.Format(time.DateOnly)))
+			return 0, false, nil
+		}
+	}
+
+	// When deleting in chunks, ids are read back as they are deleted, rather than being held in full
+	chunked := a.exactDelete && a.deleteChunkSize > 0
+	var ids []json.RawMessage
+	switch {
+	case chunked && verify:
+		err = a.scanFileIDs(ctx, fileName, func(json.RawMessage) error { return nil })
+	case verify && !a.exactDelete && a.verifySampleRate < 1:
+		_, err = a.verifySample(ctx, fileName)
+	case verify || a.exactDelete:
+		ids, err = a.readFileIDs(ctx, fileName)
+	}
+	if err == nil && verify && a.contentCRC {
+		err = a.verifyContentCRC(ctx, fileName)
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("%w: failed to read back file %s: %w", ErrIntegrity, fileName, err)
+	}
+
+	if err = a.checkDeleteGuard(ctx); err != nil {
+		return 0, false, err
+	}
+
+	switch {
+	case chunked:
+		deleted, err = a.deleteFileIDs(ctx, fileName)
+	case a.exactDelete:
+		deleted, err = a.source.(exactDeleter).DeleteByIDs(ctx, ids)
+	default:
+		deleted, err = a.source.DeleteAllFromDate(ctx, date)
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to delete documents: %w", err)
+	}
+
+	slog.Info(
+		"day reconciled",
+		slog.String("date", date.Format(time.DateOnly)),
+		slog.Bool("verified", verify),
+		slog.Int("deleted", deleted),
+	)
+
+	return deleted, true, nil
+}
+
+// readFileIDs reads back the archive file in full, returning the _id of each document it holds
+func (a *Archiver) readFileIDs(ctx context.Context, fileName string) (ids []json.RawMessage, err error) {
+	err = a.scanFileIDs(ctx, fileName, func(id json.RawMessage) error {
+		ids = append(ids, id)
+		return nil
+	})
+	return ids, err
+}
+
+// scanFileIDs reads back the archive file in full, passing the _id of each document it holds to fn
+func (a *Archiver) scanFileIDs(ctx context.Context, fileName string, fn func(id json.RawMessage) error) (err error) {
+	r, err := a.store.(opener).Open(ctx, fileName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cErr := r.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close file: %w", cErr))
+		}
+	}()
+
+	gr, err := a.newFileReader(r)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	scanner := bufio.NewScanner(gr)
+	scanner.Buffer(nil, maxLineSize)
+	for scanner.Scan() {
+		id, err := documentID(scanner.Bytes())
+		if err != nil {
+			return err
+		}
+		if err = fn(id); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,162 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// MissingFieldPolicy controls what happens to documents lacking a required field
+type MissingFieldPolicy int
+
+const (
+	// MissingFieldFail fails the day
+	MissingFieldFail MissingFieldPolicy = iota
+	// MissingFieldSkip leaves the document in the collection, without writing it anywhere
+	MissingFieldSkip
+	// MissingFieldDeadLetter writes the document to the day's invalid file, leaving it in the collection
+	MissingFieldDeadLetter
+)
+
+// ParseMissingFieldPolicy parses a policy from its flag representation, one of "fail", "skip" or "dead-letter"
+func ParseMissingFieldPolicy(s string) (MissingFieldPolicy, error) {
+	switch s {
+	case "fail":
+		return MissingFieldFail, nil
+	case "skip":
+		return MissingFieldSkip, nil
+	case "dead-letter":
+		return MissingFieldDeadLetter, nil
+	default:
+		return 0, fmt.Errorf("invalid missing field policy %q, expected fail, skip or dead-letter", s)
+	}
+}
+
+// String returns the flag representation of the policy
+func (p MissingFieldPolicy) String() string {
+	switch p {
+	case MissingFieldSkip:
+		return "skip"
+	case MissingFieldDeadLetter:
+		return "dead-letter"
+	default:
+		return "fail"
+	}
+}
+
+// Set parses the policy from its flag representation, allowing it to be used as a flag value
+func (p *MissingFieldPolicy) Set(s string) error {
+	parsed, err := ParseMissingFieldPolicy(s)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// ErrMissingRequiredField is returned when a document lacks a required field, under MissingFieldFail
+var ErrMissingRequiredField = errors.New("missing required field")
+
+// invalidSuffix is appended to the day path of an archive to name the file of its documents missing required fields
+const invalidSuffix = ".invalid"
+
+type requiredFieldsConfig struct {
+	fields []string
+	policy MissingFieldPolicy
+}
+
+// WithRequiredFields validates that each document holds every one of the fields, given as dotted paths for nested
+// fields, e.g. meta.source. Documents missing a field are indicative of an upstream bug, so depending on the policy
+// either fail the day, or are left in the collection, having optionally been written to an invalid file (e.g.
+// 2024/11/01.invalid.json.gz) next to the archive. Fields are checked once documents have been transformed, so
+// renamed fields are required by their new name.
+func WithRequiredFields(fields []string, policy MissingFieldPolicy) Option {
+	return func(a *Archiver) {
+		a.requiredFields = &requiredFieldsConfig{
+			fields: fields,
+			policy: policy,
+		}
+	}
+}
+
+func (a *Archiver) checkRequiredFieldsSupported() error {
+	if a.requiredFields.policy == MissingFieldFail {
+		return nil
+	}
+	if a.resume != nil {
+		return errors.New("skipping or dead lettering invalid documents cannot be combined with resuming")
+	}
+	if !a.skipDelete && !a.exactDelete {
+		// Deleting the whole day would delete the invalid documents, which are meant to be left in the collection
+		return errors.New("skipping or dead lettering invalid documents requires exact delete")
+	}
+	return nil
+}
+
+// checkRequiredFields reports whether the document lacks a required field, logging its _id should it do so. Under
+// MissingFieldFail a missing field results in an error.
+func (a *Archiver) checkRequiredFields(doc []byte) (invalid bool, err error) {
+	if a.requiredFields == nil {
+		return false, nil
+	}
+	field, err := missingField(doc, a.requiredFields.fields)
+	if err != nil || field == "" {
+		return false, err
+	}
+
+	// Documents lacking an _id are reported without one
+	id, _ := documentID(doc)
+	slog.Error(
+		"document missing required field",
+		slog.String("_id", string(id)),
+		slog.String("field", field),
+		slog.String("policy", a.requiredFields.policy.String()),
+	)
+	if a.requiredFields.policy == MissingFieldFail {
+		return true, fmt.Errorf("%w: document %s lacks %s", ErrMissingRequiredField, id, field)
+	}
+	return true, nil
+}
+
+// missingField returns the first of the fields absent from the extended JSON document, or an empty string should it
+// hold all of them. A field holding null is present.
+func missingField(doc []byte, fields []string) (string, error) {
+	var decoded map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &decoded); err != nil {
+		return "", fmt.Errorf("failed to decode document: %w", err)
+	}
+
+	for _, field := range fields {
+		obj := decoded
+		segments := strings.Split(field, ".")
+		for i, segment := range segments {
+			raw, ok := obj[segment]
+			if !ok {
+				return field, nil
+			}
+			if i == len(segments)-1 {
+				break
+			}
+			// Anything other than an object, e.g. an array, can't hold the remaining segments
+			obj = nil
+			if err := json.Unmarshal(raw, &obj); err != nil {
+				return field, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// invalidName returns the name of the file of documents missing required fields for the archive file
+func (a *Archiver) invalidName(fileName string) string {
+	return a.sidecarPath(fileName) + invalidSuffix + "." + a.fileExtension + a.codecSuffix()
+}
+
+// createInvalidFile creates the invalid file, the first time a document missing a required field is found for the day
+func (a *Archiver) createInvalidFile(ctx context.Context, name string, level int) (*gzipFile, error) {
+	slog.Warn("setting aside documents missing required fields", slog.String("fileName", name))
+	return a.createFile(ctx, name, level)
+}
@@ -0,0 +1,259 @@
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+)
+
+const checkpointSuffix = ".checkpoint.json"
+
+type resumeConfig struct {
+	interval int
+}
+
+// resumableSource is implemented by sources able to stream a day's documents in _id order, starting after a given _id
+type resumableSource interface {
+	FindAllFromDateAfterID(ctx context.Context, date time.Time, afterID json.RawMessage) source.StreamingResult
+}
+
+// resumableStore is implemented by stores which are able to read back checkpoints, and to continue writing a file from
+// a known good offset
+type resumableStore interface {
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+	Append(ctx context.Context, path string, offset int64) (io.WriteCloser, error)
+	Remove(ctx context.Context, path string) error
+}
+
+// checkpoint records how far through a day the archiver has durably written
+type checkpoint struct {
+	LastID            json.RawMessage `json:"lastId"`
+	Offset            int64           `json:"offset"`
+	Documents         int             `json:"documents"`
+	UncompressedBytes int64           `json:"uncompressedBytes"`
+}
+
+// WithResume enables resumable archiving of days. Documents are read in _id order, and after every interval documents
+// the gzip stream is flushed and a checkpoint holding the last written _id is persisted. Should the archiver stop part
+// way through a day, the next run continues from the checkpoint, appending to the partially written file rather than
+// starting the day again.
+func WithResume(interval int) Option {
+	return func(a *Archiver) {
+		a.resume = &resumeConfig{
+			interval: interval,
+		}
+	}
+}
+
+func (a *Archiver) checkResumeSupported() error {
+	if _, ok := a.source.(resumableSource); !ok {
+		return errors.New("source does not support resuming")
+	}
+	if _, ok := a.store.(resumableStore); !ok {
+		return errors.New("store does not support resuming")
+	}
+	if _, ok := a.store.(exister); !ok {
+		return errors.New("store does not support existence checks, which resuming requires")
+	}
+	return nil
+}
+
+func checkpointName(date time.Time) string {
+	return dayPath(date) + checkpointSuffix
+}
+
+// readCheckpoint returns the checkpoint for the supplied date, or nil if there isn't one
+func (a *Archiver) readCheckpoint(ctx context.Context, date time.Time) (cp *checkpoint, err error) {
+	name := checkpointName(date)
+
+	exists, err := a.exists(ctx, name)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	r, err := a.store.(resumableStore).Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cErr := r.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close checkpoint: %w", cErr))
+		}
+	}()
+
+	if err = json.NewDecoder(r).Decode(&cp); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint: %w", err)
+	}
+	return cp, nil
+}
+
+func (a *Archiver) writeCheckpoint(ctx context.Context, date time.Time, cp checkpoint) (err error) {
+	w, err := a.store.Create(ctx, checkpointName(date))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cErr := w.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close checkpoint: %w", cErr))
+		}
+	}()
+	return json.NewEncoder(w).Encode(cp)
+}
+
+// writeDocumentsResumable writes the day's documents as a series of gzip members, checkpointing after each. If cp is
+// non-nil, writing continues from it.
+func (a *Archiver) writeDocumentsResumable(
+	ctx context.Context,
+	date time.Time,
+	fileName string,
+	cp *checkpoint,
+) (res *dayResult, err error) {
+	rStore := a.store.(resumableStore)
+	rSource := a.source.(resumableSource)
+
+	level, err := a.compressionLevel(ctx, date)
+	if err != nil {
+		return nil, err
+	}
+
+	var w io.WriteCloser
+	if cp != nil {
+		slog.Info(
+			"resuming file from checkpoint",
+			slog.String("fileName", fileName),
+			slog.String("lastId", string(cp.LastID)),
+			slog.Int("documents", cp.Documents),
+		)
+		w, err = rStore.Append(ctx, fileName, cp.Offset)
+	} else {
+		slog.Info("writing to file", slog.String("fileName", fileName))
+		cp = &checkpoint{}
+		w, err = a.store.Create(ctx, fileName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cErr := w.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close file: %w", cErr))
+		}
+	}()
+
+	cw := &countingWriter{Writer: w, n: cp.Offset}
+	gw, err := gzip.NewWriterLevel(cw, level)
+	if err != nil {
+		return nil, err
+	}
+
+	total := cp.Documents
+	uncompressed := cp.UncompressedBytes
+	committedAt := time.Now()
+	var pending int
+	var last []byte
+
+	// flush completes the current gzip member, commits it if enabled, and records a checkpoint up to the last document
+	// written
+	flush := func() error {
+		if err := gw.Close(); err != nil {
+			return fmt.Errorf("failed to close gzip writer: %w", err)
+		}
+		if c, ok := w.(committer); ok && a.commitInterval > 0 {
+			if err := c.Commit(); err != nil {
+				return fmt.Errorf("%w: failed to commit file: %w", ErrStorage, err)
+			}
+			committedAt = time.Now()
+		}
+		id, err := documentID(last)
+		if err != nil {
+			return err
+		}
+		cp.LastID = id
+		cp.Offset = cw.n
+		cp.Documents = total
+		cp.UncompressedBytes = uncompressed
+		if err := a.writeCheckpoint(ctx, date, *cp); err != nil {
+			return fmt.Errorf("failed to write checkpoint: %w", err)
+		}
+		pending = 0
+		gw.Reset(cw)
+		return nil
+	}
+
+	docs := rSource.FindAllFromDateAfterID(ctx, date, cp.LastID)
+	for doc := range docs.Iter(ctx) {
+		// Invalid or oversized documents can only fail the day, as neither can be set aside when resuming
+		if _, err = a.checkRequiredFields(doc); err != nil {
+			return nil, err
+		}
+		if _, err = a.checkDocumentSize(doc); err != nil {
+			return nil, err
+		}
+		total++
+		pending++
+		last = append(last[:0], doc...) // retained beyond the iteration, so copied into a buffer of its own
+		n, err := encodeDocument(gw, a.encoder, doc)
+		uncompressed += n
+		if err != nil {
+			return nil, err
+		}
+		a.observeProgress(ctx, n)
+		if pending >= a.resume.interval || a.commitDue(committedAt) {
+			if err = flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err = docs.Err(); err != nil {
+		// Leave the unflushed member unterminated, so the next run can resume from the last checkpoint
+		return nil, err
+	}
+
+	if err = gw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close gzip writer: %w", err)
+	}
+
+	slog.Info(
+		"documents written",
+		slog.Int("total", total),
+		slog.Int64("uncompressedBytes", uncompressed),
+		slog.Int64("compressedBytes", cw.n),
+	)
+
+	return &dayResult{
+		written: total,
+		files: []fileResult{{
+			name:              fileName,
+			written:           total,
+			uncompressedBytes: uncompressed,
+			compressedBytes:   cw.n,
+		}},
+	}, nil
+}
+
+// removeCheckpoint removes a checkpoint once its file has been completely written
+func (a *Archiver) removeCheckpoint(ctx context.Context, date time.Time) error {
+	name := checkpointName(date)
+	exists, err := a.exists(ctx, name)
+	if err != nil || !exists {
+		return err
+	}
+	return a.store.(resumableStore).Remove(ctx, name)
+}
+
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.Writer.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
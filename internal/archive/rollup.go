@@ -0,0 +1,68 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+)
+
+// rollupSource is implemented by sources able to pre-aggregate a day's documents into a rollup collection
+type rollupSource interface {
+	RollupDay(ctx context.Context, date time.Time) (source.RollupResult, error)
+}
+
+// WithRollup rolls up each day's documents before archiving them, structuring each day as aggregate, verify the
+// rollup, archive, then delete, with each step only taken once the previous one has succeeded. The rollup is verified
+// by requiring it to hold documents for any day with documents, and the day's archive to hold every document that
+// was rolled up, so documents are never deleted without having been both rolled up and archived.
+func WithRollup() Option {
+	return func(a *Archiver) {
+		a.rollup = true
+	}
+}
+
+func (a *Archiver) checkRollupSupported() error {
+	if _, ok := a.source.(rollupSource); !ok {
+		return errors.New("source does not support rollups")
+	}
+	if a.resume != nil {
+		// A resumed day's documents span runs, so can't be compared against those rolled up by this one
+		return errors.New("rollups cannot be combined with resuming")
+	}
+	return nil
+}
+
+// rollupDay rolls up the day's documents, failing should the rollup hold nothing for a day with documents
+func (a *Archiver) rollupDay(ctx context.Context, date time.Time) (source.RollupResult, error) {
+	res, err := a.source.(rollupSource).RollupDay(ctx, date)
+	if err != nil {
+		return res, err
+	}
+	if res.Documents > 0 && res.Rollups == 0 {
+		return res, fmt.Errorf(
+			"%w: rolled up %d documents, but the rollup holds none for the day", ErrIntegrity, res.Documents,
+		)
+	}
+	slog.Info("documents rolled up", slog.Int("documents", res.Documents), slog.Int("rollups", res.Rollups))
+	return res, nil
+}
+
+// checkRolledUpArchived confirms that the documents archived for the day are those that were rolled up, before any
+// are deleted. Documents left in the collection for missing a required field were rolled up, but not archived.
+func checkRolledUpArchived(rolled source.RollupResult, res *dayResult) error {
+	if res.skipped {
+		// The file was written by a previous run, so how many documents it holds is unknown
+		return nil
+	}
+	if archived := res.written + res.invalid; archived != rolled.Documents {
+		return fmt.Errorf(
+			"%w: archived %d documents, but rolled up %d, so the day changed after being rolled up",
+			ErrIntegrity, archived, rolled.Documents,
+		)
+	}
+	return nil
+}
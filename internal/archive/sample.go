@@ -0,0 +1,69 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// WithVerifySampleRate causes reconciling with verification to parse only a random fraction of the lines of each
+// file, between 0 and 1, rather than every one. Every file is still read and decompressed in full, so truncation and
+// corrupt compressed streams are always caught, but a malformed document is only caught should its line be sampled.
+// It doesn't apply when deleting exactly, which parses every line for its _id regardless.
+func WithVerifySampleRate(rate float64) Option {
+	return func(a *Archiver) {
+		a.verifySampleRate = rate
+	}
+}
+
+// sampledVerification describes the outcome of verifying a sample of a file's lines
+type sampledVerification struct {
+	lines   int
+	sampled int
+}
+
+// verifySample reads back the file in full, parsing the _id of a random sample of its lines
+func (a *Archiver) verifySample(ctx context.Context, fileName string) (res sampledVerification, err error) {
+	r, err := a.store.(opener).Open(ctx, fileName)
+	if err != nil {
+		return res, err
+	}
+	defer func() {
+		if cErr := r.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close file: %w", cErr))
+		}
+	}()
+
+	gr, err := a.newFileReader(r)
+	if err != nil {
+		return res, err
+	}
+	defer gr.Close()
+
+	scanner := bufio.NewScanner(gr)
+	scanner.Buffer(nil, maxLineSize)
+	for scanner.Scan() {
+		res.lines++
+		if a.sample() >= a.verifySampleRate {
+			continue
+		}
+		res.sampled++
+		if _, err = documentID(scanner.Bytes()); err != nil {
+			return res, fmt.Errorf("line %d: %w", res.lines, err)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return res, err
+	}
+
+	slog.Info(
+		"file verified by sample",
+		slog.String("fileName", fileName),
+		slog.Int("lines", res.lines),
+		slog.Int("sampled", res.sampled),
+		slog.Float64("rate", a.verifySampleRate),
+	)
+	return res, nil
+}
@@ -0,0 +1,163 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// schemaSuffix is appended to the day path of an archive to name its schema
+const schemaSuffix = ".schema.json"
+
+// schema describes the fields observed across the documents of an archive file, and the BSON types each was seen as
+type schema struct {
+	File          string                  `json:"file"`
+	DocumentCount int                     `json:"documentCount"`
+	Fields        map[string]*schemaField `json:"fields"`
+}
+
+// schemaField describes a single field, keyed by its dotted path. Elements of arrays are described beneath the path of
+// the array suffixed with [], e.g. items[].sku.
+type schemaField struct {
+	Types []string `json:"types"` // BSON type aliases, as accepted by $type, sorted
+	Count int      `json:"count"` // number of documents (or array elements) holding the field
+}
+
+// WithSchema enables writing a schema sidecar (e.g. 2024/11/01.schema.json) alongside each archived file, describing
+// every field observed in its documents along with each of the BSON types it was seen as, for schema-on-read systems.
+// Fields rendered as plain JSON, rather than extended JSON, are described by their JSON type, with numbers as number.
+func WithSchema() Option {
+	return func(a *Archiver) {
+		a.schema = true
+	}
+}
+
+func (a *Archiver) checkSchemaSupported() error {
+	if a.resume != nil {
+		return errors.New("schema cannot be combined with resuming")
+	}
+	return nil
+}
+
+func newSchema() *schema {
+	return &schema{Fields: make(map[string]*schemaField)}
+}
+
+// observe merges the fields of the document, given as canonical extended JSON, into the schema
+func (s *schema) observe(doc []byte) error {
+	var decoded map[string]any
+	if err := json.Unmarshal(doc, &decoded); err != nil {
+		return fmt.Errorf("failed to decode document for schema: %w", err)
+	}
+	s.DocumentCount++
+	s.observeObject("", decoded)
+	return nil
+}
+
+func (s *schema) observeObject(prefix string, obj map[string]any) {
+	for key, value := range obj {
+		s.observeValue(prefix+key, value)
+	}
+}
+
+func (s *schema) observeValue(path string, value any) {
+	typ := jsonValueType(value)
+
+	f, ok := s.Fields[path]
+	if !ok {
+		f = &schemaField{}
+		s.Fields[path] = f
+	}
+	f.Count++
+	if i, found := slices.BinarySearch(f.Types, typ); !found {
+		f.Types = slices.Insert(f.Types, i, typ)
+	}
+
+	switch typ {
+	case "object":
+		s.observeObject(path+".", value.(map[string]any))
+	case "array":
+		for _, element := range value.([]any) {
+			s.observeValue(path+"[]", element)
+		}
+	}
+}
+
+// extJSONTypes maps the keys identifying canonical extended JSON values to the aliases of the BSON types they represent
+var extJSONTypes = map[string]string{
+	"$oid":               "objectId",
+	"$symbol":            "symbol",
+	"$numberInt":         "int",
+	"$numberLong":        "long",
+	"$numberDouble":      "double",
+	"$numberDecimal":     "decimal",
+	"$binary":            "binData",
+	"$code":              "javascript",
+	"$timestamp":         "timestamp",
+	"$regularExpression": "regex",
+	"$dbPointer":         "dbPointer",
+	"$date":              "date",
+	"$minKey":            "minKey",
+	"$maxKey":            "maxKey",
+	"$undefined":         "undefined",
+}
+
+// jsonValueType returns the BSON type alias of a decoded extended JSON value
+func jsonValueType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case float64:
+		return "number" // only plain fields are rendered as bare numbers
+	case []any:
+		return "array"
+	case map[string]any:
+		if len(v) > 2 {
+			return "object"
+		}
+		for key := range v {
+			if !strings.HasPrefix(key, "$") {
+				return "object"
+			}
+		}
+		if _, ok := v["$scope"]; ok {
+			return "javascriptWithScope"
+		}
+		for key := range v {
+			if typ, ok := extJSONTypes[key]; ok {
+				return typ
+			}
+		}
+		return "object"
+	default:
+		return "unknown"
+	}
+}
+
+// writeSchema writes the schema sidecar of the named archive file
+func (a *Archiver) writeSchema(ctx context.Context, fileName string, s *schema) (err error) {
+	schemaName := a.sidecarPath(fileName) + schemaSuffix
+
+	slog.Info("writing schema", slog.String("fileName", schemaName))
+
+	w, err := a.store.Create(ctx, schemaName)
+	if err != nil {
+		return fmt.Errorf("%w: failed to create file: %w", ErrStorage, err)
+	}
+	defer func() {
+		if cErr := w.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("%w: failed to close file: %w", ErrStorage, cErr))
+		}
+	}()
+
+	s.File = fileName
+	return json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,48 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// nextFinder is implemented by sources able to find the earliest document at or after a given time
+type nextFinder interface {
+	EarliestCreatedAtFrom(ctx context.Context, from time.Time) (time.Time, bool, error)
+}
+
+// WithSkipEmptyDays fast-forwards over days holding no documents, such as days which were deleted by hand, or already
+// archived by an earlier run, landing on the next day with documents to archive in a single query rather than visiting
+// each day in turn. Skipped days are neither written, so have no archive file, nor waited on between, which makes
+// re-running over a largely drained collection cheap.
+func WithSkipEmptyDays() Option {
+	return func(a *Archiver) {
+		a.skipEmptyDays = true
+	}
+}
+
+func (a *Archiver) checkSkipEmptyDaysSupported() error {
+	if _, ok := a.source.(nextFinder); !ok {
+		return errors.New("source does not support finding the next document, so empty days cannot be skipped")
+	}
+	return nil
+}
+
+// nextDay returns the first day from date onwards which holds documents, or target should none before it do
+func (a *Archiver) nextDay(ctx context.Context, date, target time.Time) (time.Time, error) {
+	next, ok, err := a.source.(nextFinder).EarliestCreatedAtFrom(ctx, date)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !ok {
+		return target, nil
+	}
+	// Documents at the very start of the day may belong to the day before, which has already been visited
+	if day := a.dayOf(next); day.After(date) {
+		date = day
+	}
+	if date.After(target) {
+		return target, nil
+	}
+	return date, nil
+}
@@ -0,0 +1,153 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+)
+
+// SpecialTypesPolicy controls what happens to documents holding a value whose BSON type doesn't round-trip cleanly
+// through extended JSON, as found by source.FindSpecialType
+type SpecialTypesPolicy int
+
+const (
+	// SpecialTypesCanonical archives the document as canonical extended JSON, which preserves every type
+	SpecialTypesCanonical SpecialTypesPolicy = iota
+	// SpecialTypesBSON writes the document to the day's special types file as raw BSON, rather than its archive
+	SpecialTypesBSON
+	// SpecialTypesFlag archives the document as usual, logging the value found
+	SpecialTypesFlag
+)
+
+// ParseSpecialTypesPolicy parses a policy from its flag representation, one of "canonical", "bson" or "flag"
+func ParseSpecialTypesPolicy(s string) (SpecialTypesPolicy, error) {
+	switch s {
+	case "canonical":
+		return SpecialTypesCanonical, nil
+	case "bson":
+		return SpecialTypesBSON, nil
+	case "flag":
+		return SpecialTypesFlag, nil
+	default:
+		return 0, fmt.Errorf("invalid special types policy %q, expected canonical, bson or flag", s)
+	}
+}
+
+// String returns the flag representation of the policy
+func (p SpecialTypesPolicy) String() string {
+	switch p {
+	case SpecialTypesBSON:
+		return "bson"
+	case SpecialTypesFlag:
+		return "flag"
+	default:
+		return "canonical"
+	}
+}
+
+// Set parses the policy from its flag representation, allowing it to be used as a flag value
+func (p *SpecialTypesPolicy) Set(s string) error {
+	parsed, err := ParseSpecialTypesPolicy(s)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// specialTypesSuffix is appended to the day path of an archive to name the file of its documents holding special types
+const specialTypesSuffix = ".special"
+
+// specialTypeMarkers are the keys of the canonical extended JSON values which may be of a special type. Documents
+// without any are never special, so needn't be decoded to be checked.
+var specialTypeMarkers = [][]byte{
+	[]byte(`"$numberDecimal"`),
+	[]byte(`"$undefined"`),
+	[]byte(`"$dbPointer"`),
+	[]byte(`"$symbol"`),
+	[]byte(`"$scope"`),
+	[]byte(`"$binary"`),
+}
+
+// WithSpecialTypes detects documents holding a value whose BSON type doesn't round-trip cleanly through extended JSON,
+// e.g. a Decimal128 or a deprecated type, handling them according to the policy. Each day's count of such documents
+// is recorded by its file header. Documents must be rendered as canonical extended JSON for their values to be
+// detected, and preserved, so the source should ignore plain fields for them, as source.WithCanonicalSpecialTypes
+// does. Under SpecialTypesBSON, documents are set aside in a special types file (e.g. 2024/11/01.special.bson.gz) next
+// to the archive, being archived nonetheless, so are deleted along with the rest of the day.
+func WithSpecialTypes(policy SpecialTypesPolicy) Option {
+	return func(a *Archiver) {
+		a.specialTypes = &policy
+	}
+}
+
+func (a *Archiver) checkSpecialTypesSupported() error {
+	if *a.specialTypes != SpecialTypesBSON {
+		return nil
+	}
+	switch {
+	case a.resume != nil:
+		return errors.New("setting aside special types as BSON cannot be combined with resuming")
+	case a.exactDelete:
+		// Exact deletes read back every file of the day as extended JSON
+		return errors.New("setting aside special types as BSON cannot be combined with exact delete")
+	}
+	return nil
+}
+
+// checkSpecialTypes reports whether the document holds a value of a special type, logging it should the policy be to
+// flag such documents
+func (a *Archiver) checkSpecialTypes(doc []byte) (special bool, err error) {
+	if a.specialTypes == nil || !slices.ContainsFunc(specialTypeMarkers, func(m []byte) bool {
+		return bytes.Contains(doc, m)
+	}) {
+		return false, nil
+	}
+	var raw bson.Raw
+	if err = bson.UnmarshalExtJSON(doc, true, &raw); err != nil {
+		return false, fmt.Errorf("failed to decode document: %w", err)
+	}
+	path, typ, special, err := source.FindSpecialType(raw)
+	if err != nil || !special {
+		return false, err
+	}
+	if *a.specialTypes == SpecialTypesFlag {
+		// Documents lacking an _id are reported without one
+		id, _ := documentID(doc)
+		slog.Warn(
+			"document holds a value of a special type",
+			slog.String("_id", string(id)),
+			slog.String("field", path),
+			slog.String("type", typ),
+		)
+	}
+	return true, nil
+}
+
+// setsAsideSpecialTypes reports whether documents holding special types are written to the special types file
+func (a *Archiver) setsAsideSpecialTypes() bool {
+	return a.specialTypes != nil && *a.specialTypes == SpecialTypesBSON
+}
+
+// specialTypesName returns the name of the special types file for the archive file, which is always BSON
+func (a *Archiver) specialTypesName(fileName string) string {
+	return a.sidecarPath(fileName) + specialTypesSuffix + "." + bsonEncoder{}.Extension() + a.codecSuffix()
+}
+
+// createSpecialTypesFile creates the special types file, the first time a document is set aside for the day
+func (a *Archiver) createSpecialTypesFile(ctx context.Context, name string, level int) (*gzipFile, error) {
+	slog.Warn("setting aside documents holding special types as BSON", slog.String("fileName", name))
+	f, err := a.createFile(ctx, name, level)
+	if err != nil {
+		return nil, err
+	}
+	f.encoder = bsonEncoder{}
+	return f, nil
+}
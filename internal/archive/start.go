@@ -0,0 +1,44 @@
+package archive
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// WithStartDate begins the run at the day of the start date rather than that of the earliest document, without relying
+// on checkpoints, e.g. when earlier days have already been handled by hand. Documents of earlier days are left in the
+// collection. Should the earliest document be later than the start date, the run begins at its day as usual.
+func WithStartDate(start time.Time) Option {
+	return func(a *Archiver) {
+		a.startDate = start.UTC().Truncate(time.Hour * 24)
+	}
+}
+
+// checkStartDateSupported refuses start dates at or beyond the end of the run, which accounts for complete days only
+// and kept days as well as the target, as nothing would be archived
+func (a *Archiver) checkStartDateSupported(target time.Time) error {
+	if end := a.endOf(target); !a.startDate.Before(end) {
+		return fmt.Errorf(
+			"start date %s must be before the target %s",
+			a.startDate.Format(time.DateOnly),
+			end.Format(time.RFC3339),
+		)
+	}
+	return nil
+}
+
+// startDay returns the first day of the run, being the day of the earliest document, or the start date should it be
+// later
+func (a *Archiver) startDay(earliest time.Time) time.Time {
+	day := a.dayOf(earliest)
+	if a.startDate.After(day) {
+		slog.Info(
+			"starting at the start date, leaving earlier days in place",
+			slog.String("startDate", a.startDate.Format(time.DateOnly)),
+			slog.String("earliest", day.Format(time.DateOnly)),
+		)
+		return a.startDate
+	}
+	return day
+}
@@ -0,0 +1,381 @@
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/watch"
+)
+
+// StreamCheckpointPath is the location in the store of the resume token of the last document archived from the
+// change stream, allowing a restarted stream to continue where it left off
+const StreamCheckpointPath = "_archiver/stream.checkpoint.json"
+
+const (
+	defaultStreamRollDocuments = 10000
+	defaultStreamRollInterval  = time.Minute * 5
+
+	// streamPollInterval is how long to wait before checking for further inserts, once caught up with the stream
+	streamPollInterval = time.Second
+)
+
+// insertWatcher is implemented by sources able to stream inserted documents
+type insertWatcher interface {
+	WatchInserts(ctx context.Context, resumeAfter json.RawMessage) (source.InsertStream, error)
+}
+
+type streamConfig struct {
+	rollDocuments int
+	rollInterval  time.Duration
+}
+
+// streamCheckpoint records the position in the change stream up to which documents have been archived
+type streamCheckpoint struct {
+	ResumeToken json.RawMessage `json:"resumeToken"`
+}
+
+// WithStreamRoll overrides how many documents, and for how long, a file is written to when streaming before it is
+// closed and its documents deleted
+func WithStreamRoll(documents int, interval time.Duration) Option {
+	return func(a *Archiver) {
+		a.stream = &streamConfig{
+			rollDocuments: documents,
+			rollInterval:  interval,
+		}
+	}
+}
+
+func (a *Archiver) checkStreamSupported() error {
+	if _, ok := a.source.(insertWatcher); !ok {
+		return errors.New("source does not support streaming inserts")
+	}
+	if _, ok := a.source.(exactDeleter); !ok && !a.skipDelete {
+		return errors.New("source does not support deleting by id, which streaming requires")
+	}
+	if _, ok := a.store.(opener); !ok {
+		return errors.New("store does not support reading, so the stream checkpoint cannot be read back")
+	}
+	switch {
+	case a.resume != nil:
+		return errors.New("streaming cannot be combined with resuming")
+	case a.partition != nil:
+		return errors.New("streaming cannot be combined with partitioning")
+	case a.fileHeader != nil:
+		return errors.New("streaming cannot be combined with file headers")
+	case a.schema:
+		return errors.New("streaming cannot be combined with schemas")
+	case a.bounds:
+		return errors.New("streaming cannot be combined with bounds")
+	case a.oversize != nil && a.oversize.policy == OversizeDeadLetter:
+		return errors.New("streaming cannot be combined with dead lettering")
+	case a.requiredFields != nil && a.requiredFields.policy != MissingFieldFail:
+		return errors.New("streaming cannot be combined with skipping or dead lettering invalid documents")
+	case a.adaptiveCompression != nil:
+		return errors.New("streaming cannot be combined with adaptive compression")
+	case a.successMarker:
+		return errors.New("streaming cannot be combined with success markers")
+	case a.skipEmptyDays:
+		return errors.New("streaming cannot be combined with skipping empty days")
+	case a.completeDaysOnly:
+		return errors.New("streaming cannot be combined with archiving complete days only")
+	case a.layout != nil:
+		return errors.New("streaming cannot be combined with writing the layout")
+	case a.deleteGuard != nil:
+		return errors.New("streaming cannot be combined with guarding deletes")
+	case a.progress != nil:
+		return errors.New("streaming cannot be combined with writing progress")
+	case a.emptyRunManifest:
+		return errors.New("streaming cannot be combined with empty run manifests")
+	case a.deleteCancelGrace > 0:
+		return errors.New("streaming cannot be combined with a delete cancel grace")
+	case a.keepDays > 0:
+		return errors.New("streaming cannot be combined with keeping days")
+	case a.explode != nil:
+		return errors.New("streaming cannot be combined with exploding documents")
+	case a.rollup:
+		return errors.New("streaming cannot be combined with rollups")
+	case a.specialTypes != nil:
+		return errors.New("streaming cannot be combined with detecting special types")
+	}
+	return nil
+}
+
+// Stream archives documents as they age beyond the retention, as an alternative to repeatedly scanning for eligible
+// days. Inserts are consumed from a change stream in order, with each waiting until its createdAt falls beyond the
+// retention before being appended to a rolling file for its day. A file is rolled when the day changes, or once it
+// reaches the configured number of documents or age, at which point the resume token is checkpointed and the
+// documents in the file are deleted.
+//
+// As the stream lags the present by the retention, the oplog must cover at least the retention, which suits short
+// retentions of hot collections. Documents are archived as inserted, so it also suits collections whose documents
+// aren't updated once inserted. Cancelling the context closes the current file, returning nil.
+func (a *Archiver) Stream(ctx context.Context, clock watch.Clock, retention time.Duration) (err error) {
+	if err = a.checkStreamSupported(); err != nil {
+		return err
+	}
+	cfg := a.stream
+	if cfg == nil {
+		cfg = &streamConfig{
+			rollDocuments: defaultStreamRollDocuments,
+			rollInterval:  defaultStreamRollInterval,
+		}
+	}
+
+	cp, err := a.readStreamCheckpoint(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read stream checkpoint: %w", err)
+	}
+
+	slog.Info(
+		"streaming",
+		slog.Duration("retention", retention),
+		slog.Bool("resuming", cp.ResumeToken != nil),
+	)
+
+	stream, err := a.source.(insertWatcher).WatchInserts(ctx, cp.ResumeToken)
+	if err != nil {
+		return fmt.Errorf("failed to watch inserts: %w", err)
+	}
+	defer func() {
+		if cErr := stream.Close(context.WithoutCancel(ctx)); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close stream: %w", cErr))
+		}
+	}()
+
+	r := &streamRoller{archiver: a, clock: clock, config: cfg}
+	defer func() {
+		// Whatever was written before stopping is still archived, even though the context has been cancelled
+		if rErr := r.roll(context.WithoutCancel(ctx)); rErr != nil {
+			err = errors.Join(err, rErr)
+		}
+	}()
+
+	for ctx.Err() == nil {
+		ev, ok := stream.TryNext(ctx)
+		if !ok {
+			if err = stream.Err(); err != nil {
+				if ctx.Err() != nil {
+					break
+				}
+				return fmt.Errorf("change stream failed: %w", err)
+			}
+			// Caught up, so wait for further inserts
+			if err = r.wait(ctx, clock.Now().Add(streamPollInterval)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err = r.wait(ctx, ev.CreatedAt.Add(retention)); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			// Stopped whilst waiting for the document to become eligible, so it's left for the next stream
+			break
+		}
+		if err = r.write(ctx, ev); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("stream stopped")
+	return nil
+}
+
+// streamRoller writes streamed documents to rolling files
+type streamRoller struct {
+	archiver *Archiver
+	clock    watch.Clock
+	config   *streamConfig
+
+	file   *gzipFile
+	name   string
+	date   time.Time
+	opened time.Time
+	ids    []json.RawMessage
+	token  json.RawMessage
+	stamp  int64 // distinguishes the name of the current file from those before it
+}
+
+// wait blocks until the supplied time, rolling the current file should it become due to be rolled in the meantime.
+// Cancellation isn't an error, as the caller is expected to stop.
+func (r *streamRoller) wait(ctx context.Context, until time.Time) error {
+	for {
+		now := r.clock.Now()
+		if r.file != nil && !now.Before(r.rollDue()) {
+			if err := r.roll(ctx); err != nil {
+				return err
+			}
+		}
+		if !now.Before(until) {
+			return nil
+		}
+
+		next := until
+		if r.file != nil {
+			next = minTime(next, r.rollDue())
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-r.clock.After(next.Sub(now)):
+		}
+	}
+}
+
+// rollDue returns the time at which the current file is due to be rolled
+func (r *streamRoller) rollDue() time.Time {
+	return r.opened.Add(r.config.rollInterval)
+}
+
+// write appends the document to the file for its day, rolling files as needed
+func (r *streamRoller) write(ctx context.Context, ev source.InsertEvent) error {
+	a := r.archiver
+
+	if _, err := a.checkRequiredFields(ev.Document); err != nil {
+		return err
+	}
+	if _, err := a.checkDocumentSize(ev.Document); err != nil {
+		return err
+	}
+
+	day := a.dayOf(ev.CreatedAt)
+	if r.file != nil && !r.date.Equal(day) {
+		if err := r.roll(ctx); err != nil {
+			return err
+		}
+	}
+	if r.file == nil {
+		if err := r.open(ctx, day); err != nil {
+			return err
+		}
+	}
+
+	id, err := documentID(ev.Document)
+	if err != nil {
+		return err
+	}
+	if err = r.file.write(ev.Document); err != nil {
+		return err
+	}
+	r.ids = append(r.ids, id)
+	r.token = ev.ResumeToken
+
+	if r.file.written >= r.config.rollDocuments {
+		return r.roll(ctx)
+	}
+	return nil
+}
+
+// open creates a new file for the day, named after the time it was opened so that successive files don't collide.
+// Files opened within the same nanosecond are named as though opened a nanosecond apart.
+func (r *streamRoller) open(ctx context.Context, day time.Time) error {
+	a := r.archiver
+
+	r.opened = r.clock.Now()
+	r.date = day
+	r.stamp = max(r.opened.UnixNano(), r.stamp+1)
+	r.name = dayPath(day) + "-stream-" + strconv.FormatInt(r.stamp, 10) + "." + a.fileExtension + a.codecSuffix()
+
+	exists, err := a.exists(ctx, r.name)
+	if err != nil {
+		return fmt.Errorf("%w: failed to check if file exists: %w", ErrStorage, err)
+	}
+	if exists {
+		return fmt.Errorf("%w: target file exists: %s", ErrIntegrity, r.name)
+	}
+	r.file, err = a.createIndexedFile(ctx, r.name, gzip.DefaultCompression)
+	return err
+}
+
+// roll closes the current file, checkpoints the stream position and then deletes the documents written to the file.
+// Checkpointing first means a failure in between leaves documents in the collection, to be archived by a later scan,
+// rather than archiving them twice.
+func (r *streamRoller) roll(ctx context.Context) error {
+	if r.file == nil {
+		return nil
+	}
+	a := r.archiver
+
+	f := r.file
+	r.file = nil
+	if err := f.close(); err != nil {
+		return err
+	}
+	res := f.result(r.name)
+
+	if err := a.writeStreamCheckpoint(ctx, streamCheckpoint{ResumeToken: r.token}); err != nil {
+		return fmt.Errorf("failed to write stream checkpoint: %w", err)
+	}
+
+	deleted := 0
+	if !a.skipDelete {
+		var err error
+		if deleted, err = a.source.(exactDeleter).DeleteByIDs(ctx, r.ids); err != nil {
+			return fmt.Errorf("failed to delete documents: %w", err)
+		}
+	}
+	r.ids = r.ids[:0]
+
+	slog.Info(
+		"stream file archived",
+		slog.String("file", res.name),
+		slog.String("date", r.date.Format(time.DateOnly)),
+		slog.Int("written", res.written),
+		slog.Int64("uncompressedBytes", res.uncompressedBytes),
+		slog.Int64("compressedBytes", res.compressedBytes),
+		slog.Int("deleted", deleted),
+	)
+	return nil
+}
+
+// readStreamCheckpoint returns the stream checkpoint, which is empty if the stream hasn't previously been run
+func (a *Archiver) readStreamCheckpoint(ctx context.Context) (cp streamCheckpoint, err error) {
+	exists, err := a.exists(ctx, StreamCheckpointPath)
+	if err != nil || !exists {
+		return cp, err
+	}
+
+	r, err := a.store.(opener).Open(ctx, StreamCheckpointPath)
+	if err != nil {
+		return cp, err
+	}
+	defer func() {
+		if cErr := r.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close checkpoint: %w", cErr))
+		}
+	}()
+
+	if err = json.NewDecoder(r).Decode(&cp); err != nil && !errors.Is(err, io.EOF) {
+		return cp, fmt.Errorf("failed to decode checkpoint: %w", err)
+	}
+	return cp, nil
+}
+
+func (a *Archiver) writeStreamCheckpoint(ctx context.Context, cp streamCheckpoint) (err error) {
+	w, err := a.store.Create(ctx, StreamCheckpointPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cErr := w.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close checkpoint: %w", cErr))
+		}
+	}()
+	return json.NewEncoder(w).Encode(cp)
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
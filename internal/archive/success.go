@@ -0,0 +1,41 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"time"
+)
+
+// successMarker is the name of the empty file marking a day as complete, following the Hive and Spark convention
+const successMarker = "_SUCCESS"
+
+// WithSuccessMarker writes an empty _SUCCESS file within each day's directory (e.g. 2024/11/01/_SUCCESS) once the
+// day has been archived in full, having been written, verified, and deleted unless skipping deletes. Pipelines can
+// then process only completed days, as a day failing at any point is left without one.
+func WithSuccessMarker() Option {
+	return func(a *Archiver) {
+		a.successMarker = true
+	}
+}
+
+// successMarkerName returns the name of the success marker of the day
+func successMarkerName(date time.Time) string {
+	return path.Join(dayPath(date), successMarker)
+}
+
+// writeSuccessMarker marks the day as complete
+func (a *Archiver) writeSuccessMarker(ctx context.Context, date time.Time) error {
+	name := successMarkerName(date)
+	slog.Info("writing success marker", slog.String("fileName", name))
+
+	w, err := a.store.Create(ctx, name)
+	if err != nil {
+		return fmt.Errorf("%w: failed to create file: %w", ErrStorage, err)
+	}
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("%w: failed to close file: %w", ErrStorage, err)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package archive
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// CatchUpTTL archives and deletes the documents which a TTL index expiring documents ttl after creation should already
+// have removed, standing in for a TTL index which is lagging or has been disabled. Unlike Run, which archives the day
+// holding the target in full, only days whose every document expired before now are archived, so that documents yet
+// to expire are left in place.
+func (a *Archiver) CatchUpTTL(ctx context.Context, now time.Time, ttl time.Duration) error {
+	a.ttl = ttl
+	a.completeDaysOnly = true
+
+	threshold := now.UTC().Add(-ttl)
+	slog.Info(
+		"catching up on ttl",
+		slog.Duration("ttl", ttl),
+		slog.String("threshold", threshold.String()),
+	)
+
+	// The day holding the threshold is yet to fully expire, so the run stops short of it
+	return a.Run(ctx, threshold)
+}
+
+// logArchivingExpired logs the day about to be archived, along with when its documents should have been removed by the
+// TTL index
+func (a *Archiver) logArchivingExpired(date time.Time) {
+	slog.Info(
+		"archiving documents past ttl",
+		slog.String("date", date.String()),
+		slog.String("expiredBy", date.AddDate(0, 0, 1).Add(a.ttl).String()),
+	)
+}
@@ -0,0 +1,48 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WithDeleteUnarchived disables the safety refusing to delete a day for which no documents were written, yet whose
+// delete would still match documents. Nothing being read for such a day is a sign the read and delete have diverged,
+// e.g. through a misconfigured date expression, id range or delete collection, so deleting would lose documents that
+// were never archived. It's only worth disabling where documents are knowingly deleted without being read.
+func WithDeleteUnarchived() Option {
+	return func(a *Archiver) {
+		a.deleteUnarchived = true
+	}
+}
+
+// deletableCounter is implemented by sources able to count the documents deleting a day would delete, which may be
+// held by a different collection to the one documents are read from
+type deletableCounter interface {
+	CountDeletable(ctx context.Context, date time.Time) (int, error)
+}
+
+// checkUnarchivedDelete fails should nothing have been written for the day whilst deleting it would delete documents.
+// Deleting exactly only ever deletes documents that were archived, and days skipped as already archived were written
+// by a previous run, so neither are checked, nor are days of sources unable to count them.
+func (a *Archiver) checkUnarchivedDelete(ctx context.Context, date time.Time, res *dayResult) error {
+	if a.deleteUnarchived || a.exactDelete || res.skipped || res.written > 0 {
+		return nil
+	}
+	c, ok := a.source.(deletableCounter)
+	if !ok {
+		return nil
+	}
+	count, err := c.CountDeletable(ctx, date)
+	if err != nil {
+		return fmt.Errorf("failed to count documents: %w", err)
+	}
+	if count > 0 {
+		return fmt.Errorf(
+			"%w: refusing to delete %d documents, as none were archived for the day, suggesting the filters used to "+
+				"read and delete documents differ",
+			ErrIntegrity, count,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package archive
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// WithCompressionValidation decompresses each file as it's written, in parallel with its upload, checking that the
+// gzip stream is well-formed before the file is committed. Should it not be, the file is aborted rather than closed,
+// for stores able to discard files being written, and the day fails without anything being deleted. This guards
+// against a faulty compressor producing archives that can't be read back, at the cost of the CPU to decompress them.
+func WithCompressionValidation() Option {
+	return func(a *Archiver) {
+		a.validateCompression = true
+	}
+}
+
+func (a *Archiver) checkCompressionValidationSupported() error {
+	switch {
+	case a.resume != nil:
+		return errors.New("compression validation cannot be combined with resuming")
+	case a.compression == CompressionStore:
+		return errors.New("compression validation cannot be combined with store compression")
+	}
+	return nil
+}
+
+// aborter is implemented by the files of stores able to discard a file being written, rather than committing it
+type aborter interface {
+	Abort() error
+}
+
+// compressor creates the gzip writer of a file, which is replaced when compressing in parallel, and in tests
+type compressor func(w io.Writer, level int) (io.WriteCloser, error)
+
+func newGzipWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, level)
+}
+
+// compressionValidator decompresses everything written to it in the background, reporting whether it was well-formed
+// once closed
+type compressionValidator struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newCompressionValidator() *compressionValidator {
+	pr, pw := io.Pipe()
+	v := &compressionValidator{
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+	go func() {
+		err := decompress(pr)
+		// Unblocks any further writes should the stream be found to be malformed part way through
+		pr.CloseWithError(err)
+		v.done <- err
+	}()
+	return v
+}
+
+// decompress reads the gzip stream through to its end, which checks its checksums
+func decompress(r io.Reader) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(io.Discard, gr); err != nil {
+		return err
+	}
+	return gr.Close()
+}
+
+func (v *compressionValidator) Write(p []byte) (int, error) {
+	// Once the stream is found to be malformed writes fail, which is reported by wait rather than failing the file's
+	// own writes
+	_, _ = v.pw.Write(p)
+	return len(p), nil
+}
+
+// wait ends the stream, returning an error should it be malformed
+func (v *compressionValidator) wait() error {
+	_ = v.pw.Close()
+	if err := <-v.done; err != nil {
+		return fmt.Errorf("%w: malformed compressed stream: %w", ErrIntegrity, err)
+	}
+	return nil
+}
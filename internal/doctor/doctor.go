@@ -0,0 +1,132 @@
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrSkip is wrapped by errors of checks which don't apply to the deployment, which are reported as skipped rather
+// than failed
+var ErrSkip = errors.New("skipped")
+
+// ProbePath is the location of the file written and removed to probe storage
+const ProbePath = "_archiver/doctor"
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	Pass    Status = "PASS"
+	Fail    Status = "FAIL"
+	Skipped Status = "SKIP"
+)
+
+// Check is a single check that the archiver is able to run against the deployment
+type Check struct {
+	Name string
+	// Hint suggests how the check failing may be resolved
+	Hint string
+	// Required checks skip those following them should they fail, as they would only fail for the same reason
+	Required bool
+	Run      func(ctx context.Context) error
+}
+
+// Result is the outcome of a check, with the error it failed or was skipped with
+type Result struct {
+	Name   string
+	Status Status
+	Err    error
+	Hint   string
+}
+
+// Run runs each check in turn, returning the result of each
+func Run(ctx context.Context, checks []Check) []Result {
+	results := make([]Result, 0, len(checks))
+	var failed string
+	for _, check := range checks {
+		if failed != "" {
+			err := fmt.Errorf("%w: %s failed", ErrSkip, failed)
+			results = append(results, Result{Name: check.Name, Status: Skipped, Err: err})
+			continue
+		}
+
+		res := Result{Name: check.Name, Status: Pass, Err: check.Run(ctx)}
+		switch {
+		case errors.Is(res.Err, ErrSkip):
+			res.Status = Skipped
+		case res.Err != nil:
+			res.Status = Fail
+			res.Hint = check.Hint
+			if check.Required {
+				failed = check.Name
+			}
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// Write reports each result on its own line, followed by the hint of each failure, returning the number of checks
+// which failed
+func Write(w io.Writer, results []Result) (int, error) {
+	var failures int
+	for _, res := range results {
+		line := fmt.Sprintf("%s  %s", res.Status, res.Name)
+		if res.Err != nil {
+			line += ": " + res.Err.Error()
+		}
+		if res.Status == Fail {
+			failures++
+			if res.Hint != "" {
+				line += "\n      " + res.Hint
+			}
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return failures, err
+		}
+	}
+	return failures, nil
+}
+
+type store interface {
+	Create(ctx context.Context, path string) (io.WriteCloser, error)
+}
+
+// remover is implemented by stores able to remove files
+type remover interface {
+	Remove(ctx context.Context, path string) error
+}
+
+// ProbeWrite writes a small file to ProbePath, to be removed by ProbeDelete. Stores unable to remove files are skipped,
+// as the probe would be left behind, or in the case of streaming sinks, delivered to consumers.
+func ProbeWrite(ctx context.Context, s store) (err error) {
+	if _, ok := s.(remover); !ok {
+		return fmt.Errorf("%w: store does not support removing files, so isn't written to", ErrSkip)
+	}
+	w, err := s.Create(ctx, ProbePath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer func() {
+		if cErr := w.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close file: %w", cErr))
+		}
+	}()
+	_, err = fmt.Fprintf(w, "written by the archiver's doctor at %s\n", time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// ProbeDelete removes the file written by ProbeWrite
+func ProbeDelete(ctx context.Context, s store) error {
+	r, ok := s.(remover)
+	if !ok {
+		return fmt.Errorf("%w: store does not support removing files", ErrSkip)
+	}
+	if err := r.Remove(ctx, ProbePath); err != nil {
+		return fmt.Errorf("failed to remove file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package doctor_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/doctor"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/storage"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	pass := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errors.New("unauthorized") }
+	skip := func(context.Context) error { return fmt.Errorf("%w: deleting disabled", doctor.ErrSkip) }
+
+	t.Run("reports each check", func(t *testing.T) {
+		t.Parallel()
+
+		results := doctor.Run(ctx, []doctor.Check{
+			{Name: "passing", Run: pass},
+			{Name: "failing", Hint: "grant the find action", Run: fail},
+			{Name: "not applicable", Hint: "unused", Run: skip},
+			{Name: "after failing", Run: pass},
+		})
+
+		require.Len(t, results, 4)
+		assert.Equal(t, doctor.Result{Name: "passing", Status: doctor.Pass}, results[0])
+		assert.Equal(t, doctor.Fail, results[1].Status)
+		assert.EqualError(t, results[1].Err, "unauthorized")
+		assert.Equal(t, "grant the find action", results[1].Hint)
+		assert.Equal(t, doctor.Skipped, results[2].Status)
+		assert.Empty(t, results[2].Hint)
+		assert.Equal(t, doctor.Pass, results[3].Status)
+	})
+
+	t.Run("required check failing skips the remainder", func(t *testing.T) {
+		t.Parallel()
+
+		var ran bool
+		results := doctor.Run(ctx, []doctor.Check{
+			{Name: "ping", Required: true, Run: fail},
+			{Name: "index", Run: func(context.Context) error {
+				ran = true
+				return nil
+			}},
+		})
+
+		require.Len(t, results, 2)
+		assert.Equal(t, doctor.Fail, results[0].Status)
+		assert.Equal(t, doctor.Skipped, results[1].Status)
+		assert.EqualError(t, results[1].Err, "skipped: ping failed")
+		assert.False(t, ran)
+	})
+
+	t.Run("required check passing", func(t *testing.T) {
+		t.Parallel()
+
+		results := doctor.Run(ctx, []doctor.Check{
+			{Name: "ping", Required: true, Run: pass},
+			{Name: "index", Run: fail},
+		})
+
+		require.Len(t, results, 2)
+		assert.Equal(t, doctor.Pass, results[0].Status)
+		assert.Equal(t, doctor.Fail, results[1].Status)
+	})
+}
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	failures, err := doctor.Write(&out, []doctor.Result{
+		{Name: "mongo ping", Status: doctor.Pass},
+		{Name: "date index", Status: doctor.Fail, Err: errors.New("no createdAt index"), Hint: "create one"},
+		{Name: "delete permission", Status: doctor.Skipped, Err: errors.New("skipped: deleting disabled")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, failures)
+	assert.Equal(
+		t,
+		"PASS  mongo ping\n"+
+			"FAIL  date index: no createdAt index\n"+
+			"      create one\n"+
+			"SKIP  delete permission: skipped: deleting disabled\n",
+		out.String(),
+	)
+}
+
+// writeOnlyStore is a store unable to remove files, e.g. a streaming sink
+type writeOnlyStore struct {
+	created int
+}
+
+func (s *writeOnlyStore) Create(_ context.Context, _ string) (io.WriteCloser, error) {
+	s.created++
+	return nopWriteCloser{Writer: io.Discard}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// failingStore fails to create or remove files, e.g. for want of permissions
+type failingStore struct{}
+
+func (failingStore) Create(_ context.Context, _ string) (io.WriteCloser, error) {
+	return nil, errors.New("permission denied")
+}
+
+func (failingStore) Remove(_ context.Context, _ string) error {
+	return errors.New("permission denied")
+}
+
+func TestProbe(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("writes and removes the probe", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		store, err := storage.FromURL(ctx, "file://"+dir)
+		require.NoError(t, err)
+
+		require.NoError(t, doctor.ProbeWrite(ctx, store))
+		assert.FileExists(t, filepath.Join(dir, doctor.ProbePath))
+
+		require.NoError(t, doctor.ProbeDelete(ctx, store))
+		_, err = os.Stat(filepath.Join(dir, doctor.ProbePath))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("failing store", func(t *testing.T) {
+		t.Parallel()
+
+		assert.ErrorContains(t, doctor.ProbeWrite(ctx, failingStore{}), "failed to create file: permission denied")
+		assert.ErrorContains(t, doctor.ProbeDelete(ctx, failingStore{}), "failed to remove file: permission denied")
+	})
+
+	t.Run("store unable to remove files is skipped", func(t *testing.T) {
+		t.Parallel()
+
+		store := &writeOnlyStore{}
+		assert.ErrorIs(t, doctor.ProbeWrite(ctx, store), doctor.ErrSkip)
+		assert.ErrorIs(t, doctor.ProbeDelete(ctx, store), doctor.ErrSkip)
+		assert.Zero(t, store.created)
+	})
+}
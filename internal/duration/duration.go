@@ -0,0 +1,122 @@
+package duration
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// units holds the additional units supported on top of those understood by time.ParseDuration
+var units = map[string]time.Duration{
+	"d": time.Hour * 24,
+	"w": time.Hour * 24 * 7,
+}
+
+// Parse parses a duration string. In addition to the units accepted by time.ParseDuration, "d" (days) and "w" (weeks)
+// are supported, and may be mixed with the standard units, e.g. "1w3d12h"
+func Parse(s string) (time.Duration, error) {
+	orig := s
+
+	var neg bool
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	if s == "0" {
+		return 0, nil
+	}
+	if s == "" {
+		return 0, fmt.Errorf("invalid duration %q", orig)
+	}
+
+	// Split into number/unit pairs - extended units are resolved here, anything else is left to time.ParseDuration
+	var total time.Duration
+	var rest strings.Builder
+	for s != "" {
+		i := 0
+		for i < len(s) && (s[i] == '.' || isDigit(s[i])) {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+		num := s[:i]
+		s = s[i:]
+
+		j := 0
+		for j < len(s) && s[j] != '.' && !isDigit(s[j]) {
+			j++
+		}
+		unit := s[:j]
+		s = s[j:]
+
+		multiplier, ok := units[unit]
+		if !ok {
+			rest.WriteString(num + unit)
+			continue
+		}
+		f, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+		// Anything at or beyond 2^63 wraps when converted, so is rejected as time.ParseDuration does
+		d := f * float64(multiplier)
+		if d >= math.MaxInt64 {
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+		sum, ok := add(total, time.Duration(d))
+		if !ok {
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+		total = sum
+	}
+
+	if rest.Len() > 0 {
+		d, err := time.ParseDuration(rest.String())
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+		sum, ok := add(total, d)
+		if !ok {
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+		total = sum
+	}
+
+	if neg {
+		total = -total
+	}
+	return total, nil
+}
+
+// add sums the non-negative durations, reporting false should the sum overflow
+func add(a, b time.Duration) (time.Duration, bool) {
+	if a > math.MaxInt64-b {
+		return 0, false
+	}
+	return a + b, true
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// Value wraps a time.Duration so that it can be used as a flag value, parsing input with Parse
+type Value time.Duration
+
+// Set parses and assigns the supplied duration string
+func (v *Value) Set(s string) error {
+	d, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*v = Value(d)
+	return nil
+}
+
+// String returns the duration in Go duration format
+func (v *Value) String() string {
+	return time.Duration(*v).String()
+}
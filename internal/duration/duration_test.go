@@ -0,0 +1,70 @@
+package duration_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/duration"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	day := time.Hour * 24
+	week := day * 7
+
+	valid := map[string]time.Duration{
+		"0":        0,
+		"30s":      time.Second * 30,
+		"2160h":    time.Hour * 2160,
+		"1h30m":    time.Hour + time.Minute*30,
+		"90d":      day * 90,
+		"12w":      week * 12,
+		"1w3d":     week + day*3,
+		"1w3d12h":  week + day*3 + time.Hour*12,
+		"1d12h30m": day + time.Hour*12 + time.Minute*30,
+		"12h1d":    day + time.Hour*12,
+		"1.5d":     day + time.Hour*12,
+		"-2d":      day * -2,
+		"+1w":      week,
+		"106751d":  day * 106751,
+	}
+	for input, expected := range valid {
+		t.Run(input, func(t *testing.T) {
+			t.Parallel()
+			d, err := duration.Parse(input)
+			require.NoError(t, err)
+			assert.Equal(t, expected, d)
+		})
+	}
+
+	invalid := []string{
+		"", "-", "d", "90", "1x", "1w3", "1..5d", "w1",
+		// Overflowing durations
+		"200000d", "-200000d", "106752d", "15251w", "106750d48h", "2562047h106751d",
+	}
+	for _, input := range invalid {
+		t.Run("invalid "+input, func(t *testing.T) {
+			t.Parallel()
+			_, err := duration.Parse(input)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestValue(t *testing.T) {
+	t.Parallel()
+
+	var d time.Duration
+	v := (*duration.Value)(&d)
+
+	require.NoError(t, v.Set("1w1d"))
+	assert.Equal(t, time.Hour*24*8, d)
+	assert.Equal(t, "192h0m0s", v.String())
+
+	assert.Error(t, v.Set("bogus"))
+	assert.Equal(t, time.Hour*24*8, d) // unchanged on error
+}
@@ -0,0 +1,75 @@
+package exitcode
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/archive"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/storage"
+)
+
+// Exit codes, allowing scripts wrapping the archiver to distinguish between categories of failure
+const (
+	// Success indicates the run completed
+	Success = 0
+	// Unknown indicates a failure that doesn't fall into any other category
+	Unknown = 1
+	// Config indicates invalid configuration, in which case nothing was attempted
+	Config = 2
+	// MongoConnection indicates mongo could not be reached
+	MongoConnection = 3
+	// Storage indicates a failure reading from or writing to storage
+	Storage = 4
+	// Partial indicates the run was stopped before reaching its target, e.g. by a signal
+	Partial = 5
+	// DataIntegrity indicates archived data could not be verified, or would have been overwritten
+	DataIntegrity = 6
+)
+
+// Error associates an exit code with an error
+type Error struct {
+	Code int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WithCode associates the exit code with the supplied error. A nil error remains nil.
+func WithCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Err: err}
+}
+
+// For resolves the exit code for the supplied error. Explicitly associated codes take precedence, otherwise the code
+// is inferred from the error chain.
+func For(err error) int {
+	var codeErr *Error
+	var selectionErr topology.ServerSelectionError
+	switch {
+	case err == nil:
+		return Success
+	case errors.As(err, &codeErr):
+		return codeErr.Code
+	case errors.Is(err, archive.ErrIntegrity), errors.Is(err, storage.ErrChecksumMismatch):
+		return DataIntegrity
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return Partial
+	case errors.Is(err, archive.ErrStorage), errors.Is(err, storage.ErrInsufficientSpace):
+		return Storage
+	case errors.As(err, &selectionErr), mongo.IsNetworkError(err):
+		return MongoConnection
+	default:
+		return Unknown
+	}
+}
@@ -0,0 +1,77 @@
+package exitcode_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/archive"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/exitcode"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/storage"
+)
+
+func TestFor(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		err      error
+		expected int
+	}{
+		"nil": {
+			err:      nil,
+			expected: exitcode.Success,
+		},
+		"unknown": {
+			err:      errors.New("boom"),
+			expected: exitcode.Unknown,
+		},
+		"explicit code": {
+			err:      exitcode.WithCode(exitcode.Config, errors.New("bad flag")),
+			expected: exitcode.Config,
+		},
+		"wrapped explicit code": {
+			err:      fmt.Errorf("wrapped: %w", exitcode.WithCode(exitcode.Storage, errors.New("no bucket"))),
+			expected: exitcode.Storage,
+		},
+		"integrity": {
+			err:      fmt.Errorf("archival failed: %w", fmt.Errorf("%w: target file exists", archive.ErrIntegrity)),
+			expected: exitcode.DataIntegrity,
+		},
+		"checksum mismatch": {
+			err:      fmt.Errorf("%w: failed to close file: %w", archive.ErrStorage, storage.ErrChecksumMismatch),
+			expected: exitcode.DataIntegrity,
+		},
+		"storage": {
+			err:      fmt.Errorf("%w: failed to close file: %w", archive.ErrStorage, errors.New("upload failed")),
+			expected: exitcode.Storage,
+		},
+		"insufficient space": {
+			err:      fmt.Errorf("failed: %w", storage.ErrInsufficientSpace),
+			expected: exitcode.Storage,
+		},
+		"cancelled": {
+			err:      fmt.Errorf("archival failed: %w", context.Canceled),
+			expected: exitcode.Partial,
+		},
+		"mongo server selection": {
+			err:      fmt.Errorf("failed to get earliest created at: %w", topology.ServerSelectionError{}),
+			expected: exitcode.MongoConnection,
+		},
+		"joined": {
+			err:      errors.Join(errors.New("boom"), fmt.Errorf("%w: mismatch", archive.ErrIntegrity)),
+			expected: exitcode.DataIntegrity,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.expected, exitcode.For(tt.err))
+		})
+	}
+
+	assert.NoError(t, exitcode.WithCode(exitcode.Config, nil))
+}
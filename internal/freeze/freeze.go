@@ -0,0 +1,90 @@
+package freeze
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Calendar holds the days on which the archiver mustn't run, e.g. weekends or change freezes. Days are UTC, as are
+// the days documents are archived by.
+type Calendar struct {
+	weekdays map[time.Weekday]bool
+	ranges   []dateRange
+}
+
+// dateRange is an inclusive range of days
+type dateRange struct {
+	from, to time.Time
+}
+
+// weekdays maps the full and abbreviated names of each day of the week
+var weekdays = func() map[string]time.Weekday {
+	names := make(map[string]time.Weekday, 14)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		names[name] = d
+		names[name[:3]] = d
+	}
+	return names
+}()
+
+// Parse parses the days to skip, each of which is either a day of the week, e.g. sat or saturday, a date, e.g.
+// 2024-12-25, or an inclusive range of dates, e.g. 2024-12-20..2025-01-02
+func Parse(values []string) (Calendar, error) {
+	c := Calendar{weekdays: make(map[time.Weekday]bool)}
+	for _, value := range values {
+		if d, ok := weekdays[strings.ToLower(value)]; ok {
+			c.weekdays[d] = true
+			continue
+		}
+
+		rawFrom, rawTo, isRange := strings.Cut(value, "..")
+		from, err := time.Parse(time.DateOnly, rawFrom)
+		if err != nil {
+			return Calendar{}, fmt.Errorf("invalid skip date %q, expected a weekday, date or date..date", value)
+		}
+		to := from
+		if isRange {
+			if to, err = time.Parse(time.DateOnly, rawTo); err != nil {
+				return Calendar{}, fmt.Errorf("invalid skip date %q, expected a weekday, date or date..date", value)
+			}
+			if to.Before(from) {
+				return Calendar{}, fmt.Errorf("invalid skip date %q, the range ends before it starts", value)
+			}
+		}
+		c.ranges = append(c.ranges, dateRange{from: from, to: to})
+	}
+	return c, nil
+}
+
+// Contains reports whether the day holding t is to be skipped
+func (c Calendar) Contains(t time.Time) bool {
+	t = t.UTC()
+	if c.weekdays[t.Weekday()] {
+		return true
+	}
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	for _, r := range c.ranges {
+		if !day.Before(r.from) && !day.After(r.to) {
+			return true
+		}
+	}
+	return false
+}
+
+// Skip wraps fn, which archives everything eligible as of now, so that nothing is done on days in the calendar.
+// Whatever would have been archived is left for the first run on a day which isn't skipped.
+func (c Calendar) Skip(
+	fn func(ctx context.Context, now time.Time) error,
+) func(ctx context.Context, now time.Time) error {
+	return func(ctx context.Context, now time.Time) error {
+		if c.Contains(now) {
+			slog.Info("skipping run on a skip date", slog.String("date", now.UTC().Format(time.DateOnly)))
+			return nil
+		}
+		return fn(ctx, now)
+	}
+}
@@ -0,0 +1,73 @@
+package freeze_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/freeze"
+)
+
+func TestCalendar(t *testing.T) {
+	t.Parallel()
+
+	t.Run("skips runs on days in the calendar", func(t *testing.T) {
+		t.Parallel()
+
+		calendar, err := freeze.Parse([]string{"Sat", "sunday", "2024-12-25", "2024-12-30..2025-01-01"})
+		require.NoError(t, err)
+
+		var ran []string
+		run := calendar.Skip(func(_ context.Context, now time.Time) error {
+			ran = append(ran, now.Format(time.DateOnly))
+			return nil
+		})
+
+		// Two runs a day, so that both the start and end of each day are covered
+		for now := time.Date(2024, time.December, 20, 0, 0, 0, 0, time.UTC); now.Year() < 2025 || now.Day() < 4; {
+			require.NoError(t, run(context.Background(), now))
+			now = now.Add(time.Hour * 23)
+			require.NoError(t, run(context.Background(), now))
+			now = now.Add(time.Hour)
+		}
+
+		assert.Equal(t, []string{
+			"2024-12-20", "2024-12-20", // Friday
+			"2024-12-23", "2024-12-23",
+			"2024-12-24", "2024-12-24",
+			"2024-12-26", "2024-12-26",
+			"2024-12-27", "2024-12-27",
+			"2025-01-02", "2025-01-02",
+			"2025-01-03", "2025-01-03",
+		}, ran)
+	})
+
+	t.Run("days are UTC", func(t *testing.T) {
+		t.Parallel()
+
+		calendar, err := freeze.Parse([]string{"2024-12-25"})
+		require.NoError(t, err)
+
+		tz := time.FixedZone("UTC+2", 2*60*60)
+		assert.False(t, calendar.Contains(time.Date(2024, time.December, 25, 1, 0, 0, 0, tz)))
+		assert.True(t, calendar.Contains(time.Date(2024, time.December, 26, 1, 0, 0, 0, tz)))
+	})
+
+	invalid := map[string]string{
+		"someday":                "invalid skip date",
+		"2024-13-01":             "invalid skip date",
+		"2024-12-25..":           "invalid skip date",
+		"2025-01-02..2024-12-30": "the range ends before it starts",
+	}
+	for value, expected := range invalid {
+		t.Run("invalid "+value, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := freeze.Parse([]string{value})
+			assert.ErrorContains(t, err, expected)
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Command runs a shell command for each archived day. The URL of each file is passed as a positional argument (i.e.
+// $1, $2, ...), with the event also supplied as JSON on stdin, and in ARCHIVE_* environment variables.
+type Command struct {
+	command string
+}
+
+func NewCommand(command string) *Command {
+	return &Command{command: command}
+}
+
+func (c *Command) Notify(ctx context.Context, ev Event) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	urls := make([]string, 0, len(ev.Files))
+	for _, f := range ev.Files {
+		urls = append(urls, f.URL)
+	}
+
+	// The first argument following the command is $0, naming the script
+	cmd := exec.CommandContext(ctx, "sh", append([]string{"-c", c.command, "post-archive"}, urls...)...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(
+		os.Environ(),
+		"ARCHIVE_DATABASE="+ev.Database,
+		"ARCHIVE_COLLECTION="+ev.Collection,
+		"ARCHIVE_DATE="+ev.Date,
+		"ARCHIVE_FILES="+strings.Join(urls, " "),
+		"ARCHIVE_DOCUMENTS="+strconv.Itoa(ev.Documents),
+		"ARCHIVE_DELETED="+strconv.Itoa(ev.Deleted),
+	)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("post archive command failed: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (c *Command) Close() error {
+	return nil
+}
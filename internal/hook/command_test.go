@@ -0,0 +1,61 @@
+package hook_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/hook"
+)
+
+func TestCommand(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ev := hook.Event{
+		Database:   "tenant1",
+		Collection: "events",
+		Date:       "2024-11-01",
+		Files: []hook.File{
+			{URL: "file:///archive/2024/11/01.json.gz", Documents: 2},
+			{URL: "file:///archive/2024/11/01.deadletter.json.gz", Documents: 1},
+		},
+		Documents: 3,
+		Deleted:   3,
+	}
+
+	cmd := hook.NewCommand(
+		`printf '%s\n' "$@" > ` + filepath.Join(dir, "args") + `;` +
+			`echo "$ARCHIVE_DATABASE $ARCHIVE_COLLECTION $ARCHIVE_DATE $ARCHIVE_DOCUMENTS $ARCHIVE_DELETED" > ` +
+			filepath.Join(dir, "env") + `;` +
+			`cat > ` + filepath.Join(dir, "stdin"),
+	)
+	require.NoError(t, cmd.Notify(context.Background(), ev))
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{ev.Files[0].URL, ev.Files[1].URL}, strings.Fields(string(args)))
+
+	env, err := os.ReadFile(filepath.Join(dir, "env"))
+	require.NoError(t, err)
+	assert.Equal(t, "tenant1 events 2024-11-01 3 3\n", string(env))
+
+	stdin, err := os.ReadFile(filepath.Join(dir, "stdin"))
+	require.NoError(t, err)
+	var received hook.Event
+	require.NoError(t, json.Unmarshal(stdin, &received))
+	assert.Equal(t, ev, received)
+}
+
+func TestCommand_Failure(t *testing.T) {
+	t.Parallel()
+
+	err := hook.NewCommand("echo downstream unavailable >&2; exit 3").Notify(context.Background(), hook.Event{})
+	assert.ErrorContains(t, err, "exit status 3: downstream unavailable")
+}
@@ -0,0 +1,95 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/archive"
+)
+
+// Event describes a day once it has been archived, for downstream processes to act upon
+type Event struct {
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+	Date       string `json:"date"`
+	Files      []File `json:"files"`
+	Documents  int    `json:"documents"`
+	Deleted    int    `json:"deleted"`
+}
+
+// File describes a single file written for the day
+type File struct {
+	URL               string `json:"url"`
+	Documents         int    `json:"documents"`
+	UncompressedBytes int64  `json:"uncompressedBytes"`
+	CompressedBytes   int64  `json:"compressedBytes"`
+}
+
+// Hook is notified of each archived day
+type Hook interface {
+	Notify(ctx context.Context, ev Event) error
+	Close() error
+}
+
+// FileURL returns the URL of the named file within the store at the supplied storage URL. The query string, which
+// configures how the store is connected to, isn't part of the file's URL so is dropped.
+func FileURL(storageURL, name string) (string, error) {
+	u, err := url.Parse(storageURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, name)
+	u.RawQuery = ""
+	return u.String(), nil
+}
+
+// NewEvent returns the event describing a day archived from the collection to the store at the storage URL
+func NewEvent(database, collection, storageURL string, day archive.ArchivedDay) (Event, error) {
+	ev := Event{
+		Database:   database,
+		Collection: collection,
+		Date:       day.Date.Format(time.DateOnly),
+		Files:      make([]File, 0, len(day.Files)),
+		Documents:  day.Documents,
+		Deleted:    day.Deleted,
+	}
+	for _, f := range day.Files {
+		fileURL, err := FileURL(storageURL, f.Name)
+		if err != nil {
+			return Event{}, err
+		}
+		ev.Files = append(ev.Files, File{
+			URL:               fileURL,
+			Documents:         f.Documents,
+			UncompressedBytes: f.UncompressedBytes,
+			CompressedBytes:   f.CompressedBytes,
+		})
+	}
+	return ev, nil
+}
+
+// Multi notifies each of the hooks in turn, so that a failure of one doesn't prevent the others being notified
+type Multi []Hook
+
+func (m Multi) Notify(ctx context.Context, ev Event) error {
+	var errs []error
+	for _, h := range m {
+		if err := h.Notify(ctx, ev); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m Multi) Close() error {
+	var errs []error
+	for _, h := range m {
+		if err := h.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
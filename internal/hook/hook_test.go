@@ -0,0 +1,91 @@
+package hook_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/archive"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/hook"
+)
+
+func TestNewEvent(t *testing.T) {
+	t.Parallel()
+
+	ev, err := hook.NewEvent("tenant1", "events", "gcs://bucket/archives/tenant1", archive.ArchivedDay{
+		Date: time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC),
+		Files: []archive.ArchivedFile{
+			{Name: "region=eu/2024/11/01.json.gz", Documents: 2, UncompressedBytes: 100, CompressedBytes: 40},
+			{Name: "region=us/2024/11/01.json.gz", Documents: 1, UncompressedBytes: 50, CompressedBytes: 30},
+		},
+		Documents: 3,
+		Deleted:   3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, hook.Event{
+		Database:   "tenant1",
+		Collection: "events",
+		Date:       "2024-11-01",
+		Files: []hook.File{
+			{
+				URL:               "gcs://bucket/archives/tenant1/region=eu/2024/11/01.json.gz",
+				Documents:         2,
+				UncompressedBytes: 100,
+				CompressedBytes:   40,
+			},
+			{
+				URL:               "gcs://bucket/archives/tenant1/region=us/2024/11/01.json.gz",
+				Documents:         1,
+				UncompressedBytes: 50,
+				CompressedBytes:   30,
+			},
+		},
+		Documents: 3,
+		Deleted:   3,
+	}, ev)
+}
+
+func TestFileURL(t *testing.T) {
+	t.Parallel()
+
+	fileURL, err := hook.FileURL("gcs://bucket/archives?endpoint=https://gcs.internal&insecure=true", "2024/11/01.json.gz")
+	require.NoError(t, err)
+	assert.Equal(t, "gcs://bucket/archives/2024/11/01.json.gz", fileURL)
+}
+
+func TestMulti(t *testing.T) {
+	t.Parallel()
+
+	first := &recordingHook{err: errors.New("first failed")}
+	second := &recordingHook{}
+
+	ev := hook.Event{Date: "2024-11-01"}
+	err := hook.Multi{first, second}.Notify(context.Background(), ev)
+	assert.ErrorContains(t, err, "first failed")
+	assert.Equal(t, []hook.Event{ev}, first.events)
+	assert.Equal(t, []hook.Event{ev}, second.events) // notified despite the failure of the first
+
+	require.NoError(t, hook.Multi{first, second}.Close())
+	assert.True(t, first.closed)
+	assert.True(t, second.closed)
+}
+
+type recordingHook struct {
+	events []hook.Event
+	err    error
+	closed bool
+}
+
+func (r *recordingHook) Notify(_ context.Context, ev hook.Event) error {
+	r.events = append(r.events, ev)
+	return r.err
+}
+
+func (r *recordingHook) Close() error {
+	r.closed = true
+	return nil
+}
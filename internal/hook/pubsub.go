@@ -0,0 +1,60 @@
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/option"
+)
+
+// PubSub publishes a message for each archived day, holding the event as JSON
+type PubSub struct {
+	client *pubsub.Client
+	topic  *pubsub.Topic
+}
+
+// NewPubSub returns a hook publishing to the topic, given as projects/<project>/topics/<topic>
+func NewPubSub(ctx context.Context, topic string, opts ...option.ClientOption) (*PubSub, error) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[1] == "" || parts[2] != "topics" || parts[3] == "" {
+		return nil, fmt.Errorf("invalid pubsub topic %q, expected projects/<project>/topics/<topic>", topic)
+	}
+
+	client, err := pubsub.NewClient(ctx, parts[1], opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pubsub client: %w", err)
+	}
+	return &PubSub{
+		client: client,
+		topic:  client.Topic(parts[3]),
+	}, nil
+}
+
+func (p *PubSub) Notify(ctx context.Context, ev Event) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	// Attributes allow subscriptions to filter without decoding the payload
+	res := p.topic.Publish(ctx, &pubsub.Message{
+		Data: payload,
+		Attributes: map[string]string{
+			"database":   ev.Database,
+			"collection": ev.Collection,
+			"date":       ev.Date,
+		},
+	})
+	if _, err = res.Get(ctx); err != nil {
+		return fmt.Errorf("failed to publish to pubsub: %w", err)
+	}
+	return nil
+}
+
+func (p *PubSub) Close() error {
+	p.topic.Stop()
+	return p.client.Close()
+}
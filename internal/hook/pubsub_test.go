@@ -0,0 +1,73 @@
+package hook_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/hook"
+)
+
+func TestPubSub(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv := pstest.NewServer()
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	conn, err := grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	// The topic is expected to exist already
+	admin, err := pubsub.NewClient(ctx, "project", option.WithGRPCConn(conn))
+	require.NoError(t, err)
+	_, err = admin.CreateTopic(ctx, "archived")
+	require.NoError(t, err)
+
+	p, err := hook.NewPubSub(ctx, "projects/project/topics/archived", option.WithGRPCConn(conn))
+	require.NoError(t, err)
+
+	ev := hook.Event{
+		Database:   "tenant1",
+		Collection: "events",
+		Date:       "2024-11-01",
+		Files:      []hook.File{{URL: "gcs://bucket/2024/11/01.json.gz", Documents: 2}},
+		Documents:  2,
+		Deleted:    2,
+	}
+	require.NoError(t, p.Notify(ctx, ev))
+	p.Close()
+
+	msgs := srv.Messages()
+	require.Len(t, msgs, 1)
+	assert.Equal(t, map[string]string{
+		"database":   "tenant1",
+		"collection": "events",
+		"date":       "2024-11-01",
+	}, msgs[0].Attributes)
+	var published hook.Event
+	require.NoError(t, json.Unmarshal(msgs[0].Data, &published))
+	assert.Equal(t, ev, published)
+}
+
+func TestNewPubSub_InvalidTopic(t *testing.T) {
+	t.Parallel()
+
+	for _, topic := range []string{"archived", "projects/project/archived", "projects//topics/archived"} {
+		_, err := hook.NewPubSub(context.Background(), topic)
+		assert.ErrorContains(t, err, "expected projects/<project>/topics/<topic>", topic)
+	}
+}
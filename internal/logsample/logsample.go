@@ -0,0 +1,89 @@
+// Package logsample thins out repetitive log lines, such as those logged for every day of a large backfill, so that
+// their volume doesn't become a cost of its own
+package logsample
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// Handler passes on only every Nth record of each message at info level or below, starting with the first, so that
+// one-off lines are always logged whilst those repeated per day or per document are sampled. Warnings and errors are
+// never sampled. Sampled records carry the number of times their message has been logged, including suppressed ones.
+type Handler struct {
+	next  slog.Handler
+	every int
+	state *state // shared with handlers derived by WithAttrs and WithGroup
+}
+
+type state struct {
+	mu     sync.Mutex
+	counts map[string]int // by message
+}
+
+// NewHandler returns a handler passing every Nth record of each message on to next
+func NewHandler(next slog.Handler, every int) *Handler {
+	return &Handler{
+		next:  next,
+		every: max(every, 1),
+		state: &state{counts: make(map[string]int)},
+	}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level > slog.LevelInfo || h.every == 1 {
+		return h.next.Handle(ctx, r)
+	}
+
+	h.state.mu.Lock()
+	h.state.counts[r.Message]++
+	n := h.state.counts[r.Message]
+	h.state.mu.Unlock()
+
+	if (n-1)%h.every != 0 {
+		return nil
+	}
+	if n > 1 {
+		r = r.Clone()
+		r.AddAttrs(slog.Int("occurrence", n), slog.Int("sampledEvery", h.every))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{next: h.next.WithAttrs(attrs), every: h.every, state: h.state}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), every: h.every, state: h.state}
+}
+
+// Summarize logs an aggregate line for each message of which records were suppressed, with the total number of times
+// it was logged, so that nothing sampled goes wholly unaccounted for
+func (h *Handler) Summarize(ctx context.Context) {
+	h.state.mu.Lock()
+	counts := maps.Clone(h.state.counts)
+	h.state.mu.Unlock()
+
+	logger := slog.New(h.next)
+	for _, msg := range slices.Sorted(maps.Keys(counts)) {
+		n := counts[msg]
+		if n <= 1 || h.every == 1 {
+			continue
+		}
+		logger.InfoContext(
+			ctx,
+			"sampled log lines",
+			slog.String("message", msg),
+			slog.Int("occurrences", n),
+			slog.Int("suppressed", n-(n+h.every-1)/h.every),
+		)
+	}
+}
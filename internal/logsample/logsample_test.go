@@ -0,0 +1,165 @@
+package logsample_test
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/logsample"
+)
+
+// capturingHandler records every record handled, for inspection
+type capturingHandler struct {
+	mu      *sync.Mutex // shared with derived handlers, as are the records
+	records *[]slog.Record
+	attrs   []slog.Attr
+}
+
+func newCapturingHandler() *capturingHandler {
+	return &capturingHandler{mu: &sync.Mutex{}, records: &[]slog.Record{}}
+}
+
+func (h *capturingHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *capturingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r = r.Clone()
+	r.AddAttrs(h.attrs...)
+	*h.records = append(*h.records, r)
+	return nil
+}
+
+func (h *capturingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &capturingHandler{mu: h.mu, records: h.records, attrs: append(h.attrs, attrs...)}
+}
+
+func (h *capturingHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// messages returns the message of each record captured
+func (h *capturingHandler) messages() []string {
+	var msgs []string
+	for _, r := range *h.records {
+		msgs = append(msgs, r.Message)
+	}
+	return msgs
+}
+
+// attr returns the value of the record's attribute by key
+func attr(r slog.Record, key string) slog.Value {
+	var v slog.Value
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == key {
+			v = a.Value
+			return false
+		}
+		return true
+	})
+	return v
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	t.Run("samples repeated messages", func(t *testing.T) {
+		t.Parallel()
+
+		capture := newCapturingHandler()
+		logger := slog.New(logsample.NewHandler(capture, 10))
+
+		logger.Info("received configuration")
+		for i := range 100 {
+			logger.Info("day archived", slog.Int("day", i))
+			logger.Info("documents deleted", slog.Int("day", i))
+		}
+		for range 3 {
+			logger.Warn("document exceeds maximum size")
+		}
+
+		msgs := capture.messages()
+		assert.Len(t, msgs, 1+10+10+3)
+		assert.Equal(t, 10, countOf(msgs, "day archived"))
+		assert.Equal(t, 10, countOf(msgs, "documents deleted"))
+		assert.Equal(t, 1, countOf(msgs, "received configuration"))
+		assert.Equal(t, 3, countOf(msgs, "document exceeds maximum size")) // warnings are never sampled
+
+		// Every 10th record is logged, starting with the first, carrying its occurrence once sampled
+		var days, occurrences []int64
+		for _, r := range *capture.records {
+			if r.Message == "day archived" {
+				days = append(days, attr(r, "day").Int64())
+				if o := attr(r, "occurrence"); o.Kind() == slog.KindInt64 {
+					occurrences = append(occurrences, o.Int64())
+				}
+			}
+		}
+		assert.Equal(t, []int64{0, 10, 20, 30, 40, 50, 60, 70, 80, 90}, days)
+		assert.Equal(t, []int64{11, 21, 31, 41, 51, 61, 71, 81, 91}, occurrences)
+	})
+
+	t.Run("shares counts with derived handlers", func(t *testing.T) {
+		t.Parallel()
+
+		capture := newCapturingHandler()
+		logger := slog.New(logsample.NewHandler(capture, 4))
+
+		for i := range 8 {
+			logger.With(slog.String("database", "tenant")).Info("day archived", slog.Int("day", i))
+		}
+		assert.Equal(t, []string{"day archived", "day archived"}, capture.messages())
+		assert.Equal(t, "tenant", attr((*capture.records)[1], "database").String())
+	})
+
+	t.Run("sampling every record logs everything", func(t *testing.T) {
+		t.Parallel()
+
+		capture := newCapturingHandler()
+		h := logsample.NewHandler(capture, 1)
+		logger := slog.New(h)
+		for range 5 {
+			logger.Info("day archived")
+		}
+		h.Summarize(context.Background())
+		assert.Len(t, capture.messages(), 5)
+	})
+
+	t.Run("summarizes suppressed records", func(t *testing.T) {
+		t.Parallel()
+
+		capture := newCapturingHandler()
+		h := logsample.NewHandler(capture, 10)
+		logger := slog.New(h)
+		for range 25 {
+			logger.Info("day archived")
+		}
+		logger.Info("run complete")
+
+		h.Summarize(context.Background())
+		records := *capture.records
+		require.Len(t, records, 3+1+1)
+
+		summary := records[len(records)-1]
+		assert.Equal(t, "sampled log lines", summary.Message)
+		assert.Equal(t, "day archived", attr(summary, "message").String())
+		assert.Equal(t, int64(25), attr(summary, "occurrences").Int64())
+		assert.Equal(t, int64(22), attr(summary, "suppressed").Int64())
+	})
+}
+
+func countOf(msgs []string, msg string) int {
+	var n int
+	for _, m := range msgs {
+		if m == msg {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/hook"
+)
+
+// Job is the job the metrics of each run are grouped under
+const Job = "mongo-collection-archiver"
+
+// Run accumulates the metrics of a single run, being every day archived during it, across databases when archiving
+// several at once
+type Run struct {
+	mu                sync.Mutex
+	started           time.Time
+	days              int
+	documentsArchived int
+	documentsDeleted  int
+	uncompressedBytes int64
+	compressedBytes   int64
+}
+
+// Start resets the metrics, ready for a run starting at the supplied time
+func (r *Run) Start(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started = now
+	r.days, r.documentsArchived, r.documentsDeleted = 0, 0, 0
+	r.uncompressedBytes, r.compressedBytes = 0, 0
+}
+
+// Notify adds the archived day to the metrics of the run, so that runs are observed as post archive hooks are
+func (r *Run) Notify(_ context.Context, ev hook.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.days++
+	r.documentsArchived += ev.Documents
+	r.documentsDeleted += ev.Deleted
+	for _, f := range ev.Files {
+		r.uncompressedBytes += f.UncompressedBytes
+		r.compressedBytes += f.CompressedBytes
+	}
+	return nil
+}
+
+// Close does nothing, as there's nothing to release until the metrics are pushed
+func (r *Run) Close() error {
+	return nil
+}
+
+// Write renders the metrics of the run, as finished at the supplied time, in the Prometheus text exposition format
+func (r *Run) Write(w io.Writer, finished time.Time, success bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var succeeded float64
+	if success {
+		succeeded = 1
+	}
+	metrics := []struct {
+		name, help string
+		value      float64
+	}{
+		{"archiver_days_archived", "Days archived by the last run.", float64(r.days)},
+		{"archiver_documents_archived", "Documents archived by the last run.", float64(r.documentsArchived)},
+		{"archiver_documents_deleted", "Documents deleted by the last run.", float64(r.documentsDeleted)},
+		{"archiver_uncompressed_bytes", "Bytes archived by the last run, before compression.", float64(r.uncompressedBytes)},
+		{"archiver_compressed_bytes", "Bytes written to storage by the last run.", float64(r.compressedBytes)},
+		{"archiver_duration_seconds", "How long the last run took.", finished.Sub(r.started).Seconds()},
+		{"archiver_success", "Whether the last run succeeded, 1 if so, 0 otherwise.", succeeded},
+		{"archiver_last_run_timestamp_seconds", "When the last run finished.", float64(finished.UnixNano()) / 1e9},
+	}
+	for _, m := range metrics {
+		value := strconv.FormatFloat(m.value, 'g', -1, 64)
+		_, err := fmt.Fprintf(w, "# HELP %[1]s %[2]s\n# TYPE %[1]s gauge\n%[1]s %[3]s\n", m.name, m.help, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pushgateway pushes the metrics of each run to a Prometheus Pushgateway, for short-lived runs such as cron jobs, which
+// aren't around long enough to be scraped
+type Pushgateway struct {
+	url    string
+	client *http.Client
+}
+
+// NewPushgateway returns a Pushgateway pushing to the gateway at the supplied base URL, e.g. http://pushgateway:9091
+func NewPushgateway(gatewayURL string) (*Pushgateway, error) {
+	u, err := url.Parse(gatewayURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid pushgateway URL %q, expected a URL such as http://pushgateway:9091", gatewayURL)
+	}
+	return &Pushgateway{
+		url:    strings.TrimSuffix(gatewayURL, "/"),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Push replaces the metrics grouped under the job and collection with those of the finished run
+func (p *Pushgateway) Push(ctx context.Context, collection string, r *Run, finished time.Time, success bool) error {
+	var body bytes.Buffer
+	if err := r.Write(&body, finished, success); err != nil {
+		return err
+	}
+
+	pushURL := p.url + "/metrics/" + groupingLabel("job", Job) + "/" + groupingLabel("collection", collection)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, pushURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("failed to push metrics: %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// groupingLabel encodes a grouping label for the push URL path, using the base64 form the Pushgateway accepts for
+// values which can't otherwise appear in a path segment
+func groupingLabel(name, value string) string {
+	switch {
+	case value == "":
+		return name + "@base64/="
+	case strings.Contains(value, "/"):
+		return name + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	default:
+		return name + "/" + url.PathEscape(value)
+	}
+}
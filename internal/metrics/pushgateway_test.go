@@ -0,0 +1,116 @@
+package metrics_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/hook"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/metrics"
+)
+
+func TestPushgateway(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	started := time.Date(2024, time.November, 2, 3, 0, 0, 0, time.UTC)
+
+	var run metrics.Run
+	run.Start(started)
+	require.NoError(t, run.Notify(ctx, hook.Event{
+		Collection: "events",
+		Date:       "2024-11-01",
+		Files: []hook.File{
+			{URL: "file:///archive/2024/11/01.json.gz", Documents: 3, UncompressedBytes: 300, CompressedBytes: 120},
+			{URL: "file:///archive/2024/11/01.dead.json.gz", Documents: 1, UncompressedBytes: 900, CompressedBytes: 80},
+		},
+		Documents: 4,
+		Deleted:   4,
+	}))
+	require.NoError(t, run.Notify(ctx, hook.Event{
+		Collection: "events",
+		Date:       "2024-11-02",
+		Files:      []hook.File{{URL: "file:///archive/2024/11/02.json.gz", Documents: 2, UncompressedBytes: 200}},
+		Documents:  2,
+	}))
+
+	type push struct {
+		method, path, contentType, body string
+	}
+	newGateway := func(t *testing.T, status int) (*metrics.Pushgateway, chan push) {
+		pushes := make(chan push, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			pushes <- push{r.Method, r.URL.EscapedPath(), r.Header.Get("Content-Type"), string(body)}
+			w.WriteHeader(status)
+		}))
+		t.Cleanup(server.Close)
+
+		gateway, err := metrics.NewPushgateway(server.URL + "/")
+		require.NoError(t, err)
+		return gateway, pushes
+	}
+
+	t.Run("pushes the metrics of the run grouped by collection", func(t *testing.T) {
+		t.Parallel()
+
+		gateway, pushes := newGateway(t, http.StatusOK)
+		require.NoError(t, gateway.Push(ctx, "events", &run, started.Add(90*time.Second), true))
+
+		p := <-pushes
+		assert.Equal(t, http.MethodPut, p.method)
+		assert.Equal(t, "/metrics/job/mongo-collection-archiver/collection/events", p.path)
+		assert.Equal(t, "text/plain; version=0.0.4", p.contentType)
+		for _, line := range []string{
+			"# TYPE archiver_days_archived gauge\narchiver_days_archived 2\n",
+			"archiver_documents_archived 6\n",
+			"archiver_documents_deleted 4\n",
+			"archiver_uncompressed_bytes 1400\n",
+			"archiver_compressed_bytes 200\n",
+			"archiver_duration_seconds 90\n",
+			"archiver_success 1\n",
+			"archiver_last_run_timestamp_seconds 1.73051649e+09\n",
+		} {
+			assert.Contains(t, p.body, line)
+		}
+	})
+
+	t.Run("reports failure", func(t *testing.T) {
+		t.Parallel()
+
+		gateway, pushes := newGateway(t, http.StatusAccepted)
+		require.NoError(t, gateway.Push(ctx, "events", &run, started, false))
+		assert.Contains(t, (<-pushes).body, "archiver_success 0\n")
+	})
+
+	t.Run("encodes collections which can't be path segments", func(t *testing.T) {
+		t.Parallel()
+
+		gateway, pushes := newGateway(t, http.StatusOK)
+		require.NoError(t, gateway.Push(ctx, "a/b", &run, started, true))
+		assert.Equal(t, "/metrics/job/mongo-collection-archiver/collection@base64/YS9i", (<-pushes).path)
+	})
+
+	t.Run("fails on rejected pushes", func(t *testing.T) {
+		t.Parallel()
+
+		gateway, _ := newGateway(t, http.StatusBadRequest)
+		err := gateway.Push(ctx, "events", &run, started, true)
+		assert.ErrorContains(t, err, "400 Bad Request")
+	})
+}
+
+func TestNewPushgateway_InvalidURL(t *testing.T) {
+	t.Parallel()
+
+	for _, gatewayURL := range []string{"pushgateway:9091", "ftp://pushgateway", "http://"} {
+		_, err := metrics.NewPushgateway(gatewayURL)
+		assert.ErrorContains(t, err, "invalid pushgateway URL", gatewayURL)
+	}
+}
@@ -0,0 +1,331 @@
+package predicate
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Predicate is a boolean expression comparing numeric variables and literals, e.g. "count > 1e6 AND avgObjSize < 1024".
+// Comparisons (<, <=, >, >=, ==, !=) may be combined with AND, OR and NOT (or &&, || and !), and grouped with
+// parentheses. Nothing but comparisons can be expressed, so evaluating an expression is always safe.
+type Predicate struct {
+	expr string
+	root node
+}
+
+// Parse parses the expression, which may only refer to the supplied variables
+func Parse(expr string, variables []string) (*Predicate, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", expr, err)
+	}
+	p := &parser{tokens: tokens, variables: variables}
+	root, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = p.unexpected()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", expr, err)
+	}
+	return &Predicate{expr: expr, root: root}, nil
+}
+
+// Eval reports whether the predicate holds for the values of its variables, with any missing taken to be zero
+func (p *Predicate) Eval(values map[string]float64) bool {
+	return p.root.eval(values)
+}
+
+// String returns the expression the predicate was parsed from
+func (p *Predicate) String() string {
+	return p.expr
+}
+
+type node interface {
+	eval(values map[string]float64) bool
+}
+
+type and struct {
+	left, right node
+}
+
+func (n and) eval(values map[string]float64) bool {
+	return n.left.eval(values) && n.right.eval(values)
+}
+
+type or struct {
+	left, right node
+}
+
+func (n or) eval(values map[string]float64) bool {
+	return n.left.eval(values) || n.right.eval(values)
+}
+
+type not struct {
+	operand node
+}
+
+func (n not) eval(values map[string]float64) bool {
+	return !n.operand.eval(values)
+}
+
+type comparison struct {
+	op          string
+	left, right operand
+}
+
+func (n comparison) eval(values map[string]float64) bool {
+	l, r := n.left.value(values), n.right.value(values)
+	switch n.op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	case ">=":
+		return l >= r
+	case "==":
+		return l == r
+	default: // !=
+		return l != r
+	}
+}
+
+// operand is either a variable, referred to by name, or a literal
+type operand struct {
+	name    string
+	literal float64
+}
+
+func (o operand) value(values map[string]float64) float64 {
+	if o.name == "" {
+		return o.literal
+	}
+	return values[o.name]
+}
+
+type parser struct {
+	tokens    []token
+	pos       int
+	variables []string
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) unexpected() error {
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return errors.New("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = or{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = and{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	switch p.peek().kind {
+	case tokenNot:
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return not{operand: operand}, nil
+	case tokenLParen:
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokenRParen {
+			return nil, p.unexpected()
+		}
+		p.next()
+		return n, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenCompare {
+		return nil, p.unexpected()
+	}
+	op := p.next().text
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return comparison{op: op, left: left, right: right}, nil
+}
+
+func (p *parser) parseOperand() (operand, error) {
+	switch t := p.peek(); t.kind {
+	case tokenNumber:
+		p.next()
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return operand{}, fmt.Errorf("invalid number %q at position %d", t.text, t.pos)
+		}
+		return operand{literal: f}, nil
+	case tokenIdent:
+		p.next()
+		if !slices.Contains(p.variables, t.text) {
+			return operand{}, fmt.Errorf("unknown variable %q, expected one of %v", t.text, p.variables)
+		}
+		return operand{name: t.text}, nil
+	}
+	return operand{}, p.unexpected()
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenIdent
+	tokenCompare
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // offset of the token in the expression, from 0
+}
+
+// keywords maps the case-insensitive logical operators which would otherwise lex as identifiers
+var keywords = map[string]tokenKind{
+	"AND": tokenAnd,
+	"OR":  tokenOr,
+	"NOT": tokenNot,
+}
+
+// symbols maps the operators spelt with symbols, longest first so that e.g. <= isn't lexed as <
+var symbols = []struct {
+	text string
+	kind tokenKind
+}{
+	{"<=", tokenCompare},
+	{">=", tokenCompare},
+	{"==", tokenCompare},
+	{"!=", tokenCompare},
+	{"&&", tokenAnd},
+	{"||", tokenOr},
+	{"<", tokenCompare},
+	{">", tokenCompare},
+	{"!", tokenNot},
+	{"(", tokenLParen},
+	{")", tokenRParen},
+}
+
+func lex(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case isDigit(c) || c == '.':
+			j := i
+			for j < len(expr) && (isDigit(expr[j]) || expr[j] == '.') {
+				j++
+			}
+			if j < len(expr) && (expr[j] == 'e' || expr[j] == 'E') {
+				j++
+				if j < len(expr) && (expr[j] == '+' || expr[j] == '-') {
+					j++
+				}
+				for j < len(expr) && isDigit(expr[j]) {
+					j++
+				}
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: expr[i:j], pos: i})
+			i = j
+		case isLetter(c):
+			j := i
+			for j < len(expr) && (isLetter(expr[j]) || isDigit(expr[j])) {
+				j++
+			}
+			word := expr[i:j]
+			kind, ok := keywords[strings.ToUpper(word)]
+			if !ok {
+				kind = tokenIdent
+			}
+			tokens = append(tokens, token{kind: kind, text: word, pos: i})
+			i = j
+		default:
+			var matched bool
+			for _, s := range symbols {
+				if strings.HasPrefix(expr[i:], s.text) {
+					tokens = append(tokens, token{kind: s.kind, text: s.text, pos: i})
+					i += len(s.text)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(expr)}), nil
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_'
+}
@@ -0,0 +1,96 @@
+package predicate_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/predicate"
+)
+
+func TestPredicate(t *testing.T) {
+	t.Parallel()
+
+	variables := []string{"count", "size", "avgObjSize", "nindexes"}
+
+	// Collections with varying stats, named after what sets them apart
+	collections := map[string]map[string]float64{
+		"empty":        {},
+		"small":        {"count": 1000, "size": 512000, "avgObjSize": 512, "nindexes": 1},
+		"large":        {"count": 5e6, "size": 2.5e9, "avgObjSize": 500, "nindexes": 3},
+		"large blobs":  {"count": 2e6, "size": 8e9, "avgObjSize": 4096, "nindexes": 2},
+		"many indexes": {"count": 3e6, "size": 1.5e9, "avgObjSize": 500, "nindexes": 12},
+	}
+
+	tests := []struct {
+		expr     string
+		expected []string
+	}{
+		{
+			expr:     "count > 1e6 AND avgObjSize < 1024",
+			expected: []string{"large", "many indexes"},
+		},
+		{
+			expr:     "count > 1e6 && avgObjSize < 1024 && !(nindexes >= 10)",
+			expected: []string{"large"},
+		},
+		{
+			expr:     "count == 0 or size > 5e9",
+			expected: []string{"empty", "large blobs"},
+		},
+		{
+			expr:     "NOT (count <= 1000) AND (avgObjSize > 1024 OR nindexes != 3)",
+			expected: []string{"large blobs", "many indexes"},
+		},
+		{
+			expr:     "1000 <= count",
+			expected: []string{"small", "large", "large blobs", "many indexes"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := predicate.Parse(tt.expr, variables)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expr, p.String())
+
+			var matched []string
+			for name, stats := range collections {
+				if p.Eval(stats) {
+					matched = append(matched, name)
+				}
+			}
+			assert.ElementsMatch(t, tt.expected, matched)
+		})
+	}
+
+	invalid := map[string]string{
+		"":                       "unexpected end of expression",
+		"count":                  "unexpected end of expression",
+		"count > ":               "unexpected end of expression",
+		"count > 1 AND":          "unexpected end of expression",
+		"(count > 1":             "unexpected end of expression",
+		"count > 1)":             `unexpected ")" at position 9`,
+		"count = 1":              `unexpected '=' at position 6`,
+		"count > 1 size < 2":     `unexpected "size" at position 10`,
+		"storageSize > 1":        `unknown variable "storageSize"`,
+		"count > 1..5":           `invalid number "1..5"`,
+		"count > 1 AND size":     "unexpected end of expression",
+		"count > 1; drop":        `unexpected ';' at position 9`,
+		"count > 1 AND > 2":      `unexpected ">" at position 14`,
+		"count > 1 OR NOT":       "unexpected end of expression",
+		"count > nindexes > 1":   `unexpected ">" at position 17`,
+		"count > 1 AND (size)":   `unexpected ")" at position 19`,
+		"count > 1 AND size < x": `unknown variable "x"`,
+	}
+	for expr, expected := range invalid {
+		t.Run("invalid "+expr, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := predicate.Parse(expr, variables)
+			assert.ErrorContains(t, err, expected)
+		})
+	}
+}
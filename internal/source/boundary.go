@@ -0,0 +1,83 @@
+package source
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Boundary controls which day a document created exactly at midnight is assigned to
+type Boundary int
+
+const (
+	// LeftInclusive assigns documents at midnight to the day that is starting, i.e. days cover [start, end)
+	LeftInclusive Boundary = iota
+	// RightInclusive assigns documents at midnight to the day that is ending, i.e. days cover (start, end]
+	RightInclusive
+)
+
+// ParseBoundary parses a boundary from its flag representation, either "left-inclusive" or "right-inclusive"
+func ParseBoundary(s string) (Boundary, error) {
+	switch s {
+	case "left-inclusive":
+		return LeftInclusive, nil
+	case "right-inclusive":
+		return RightInclusive, nil
+	default:
+		return 0, fmt.Errorf("invalid boundary %q, expected left-inclusive or right-inclusive", s)
+	}
+}
+
+// String returns the flag representation of the boundary
+func (b Boundary) String() string {
+	if b == RightInclusive {
+		return "right-inclusive"
+	}
+	return "left-inclusive"
+}
+
+// Set parses the boundary from its flag representation, allowing it to be used as a flag value
+func (b *Boundary) Set(s string) error {
+	parsed, err := ParseBoundary(s)
+	if err != nil {
+		return err
+	}
+	*b = parsed
+	return nil
+}
+
+// Day returns the start of the day to which a document created at t is assigned
+func (b Boundary) Day(t time.Time) time.Time {
+	if b == RightInclusive {
+		// Midnight belongs to the preceding day, so step back before truncating
+		return t.Add(-time.Nanosecond).Truncate(time.Hour * 24)
+	}
+	return t.Truncate(time.Hour * 24)
+}
+
+// operators returns the comparison operators matching the start and end of a day
+func (b Boundary) operators() (start, end string) {
+	if b == RightInclusive {
+		return "$gt", "$lte"
+	}
+	return "$gte", "$lt"
+}
+
+// dayFilter matches all documents with a createdAt on the supplied date
+func (b Boundary) dayFilter(date time.Time) bson.M {
+	start, end := b.operators()
+	t := date.Truncate(time.Hour * 24)
+	return bson.M{
+		"createdAt": bson.M{
+			start: t,
+			end:   t.AddDate(0, 0, 1),
+		},
+	}
+}
+
+// beforeFilter matches all documents assigned to a day ending at or before the supplied time
+func (b Boundary) beforeFilter(before time.Time) bson.M {
+	_, end := b.operators()
+	return bson.M{"createdAt": bson.M{end: before}}
+}
@@ -0,0 +1,49 @@
+package source_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+)
+
+func TestParseBoundary(t *testing.T) {
+	t.Parallel()
+
+	b, err := source.ParseBoundary("left-inclusive")
+	require.NoError(t, err)
+	assert.Equal(t, source.LeftInclusive, b)
+
+	b, err = source.ParseBoundary("right-inclusive")
+	require.NoError(t, err)
+	assert.Equal(t, source.RightInclusive, b)
+
+	_, err = source.ParseBoundary("inclusive")
+	assert.Error(t, err)
+}
+
+func TestBoundary_Day(t *testing.T) {
+	t.Parallel()
+
+	midnight := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	previous := midnight.AddDate(0, 0, -1)
+
+	tests := []struct {
+		boundary source.Boundary
+		t        time.Time
+		expected time.Time
+	}{
+		{boundary: source.LeftInclusive, t: midnight, expected: midnight},
+		{boundary: source.LeftInclusive, t: midnight.Add(-time.Millisecond), expected: previous},
+		{boundary: source.LeftInclusive, t: midnight.Add(time.Hour), expected: midnight},
+		{boundary: source.RightInclusive, t: midnight, expected: previous},
+		{boundary: source.RightInclusive, t: midnight.Add(-time.Millisecond), expected: previous},
+		{boundary: source.RightInclusive, t: midnight.Add(time.Millisecond), expected: midnight},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, tt.boundary.Day(tt.t), "%s %s", tt.boundary, tt.t)
+	}
+}
@@ -0,0 +1,28 @@
+package source
+
+import "sync"
+
+// maxPooledBufferSize bounds the capacity of buffers returned to the pool, so that a single very large document
+// doesn't hold on to its memory for the lifetime of the process
+const maxPooledBufferSize = 1 << 20
+
+// buffers pools the buffers documents are rendered into, so that streaming results share buffers across days rather
+// than each allocating their own
+var buffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 16*1024)
+		return &buf
+	},
+}
+
+func getBuffer() *[]byte {
+	return buffers.Get().(*[]byte)
+}
+
+func putBuffer(buf *[]byte) {
+	if cap(*buf) > maxPooledBufferSize {
+		return
+	}
+	*buf = (*buf)[:0]
+	buffers.Put(buf)
+}
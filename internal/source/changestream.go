@@ -0,0 +1,112 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InsertEvent is a document inserted into the collection, as observed by a change stream
+type InsertEvent struct {
+	// Document is the inserted document, rendered as extended JSON as by FindAllFromDate
+	Document []byte
+	// CreatedAt is the createdAt of the inserted document
+	CreatedAt time.Time
+	// ResumeToken identifies the event, as extended JSON, so that a later stream can resume after it
+	ResumeToken json.RawMessage
+}
+
+// InsertStream streams the documents inserted into a collection
+type InsertStream interface {
+	// TryNext returns the next event, if one is available without waiting for further inserts
+	TryNext(ctx context.Context) (InsertEvent, bool)
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// WatchInserts opens a change stream of the documents inserted into the collection, resuming after the supplied
+// token if there is one. Documents without a createdAt date are not streamed, as they don't belong to any day. Change
+// streams are only available against replica sets and sharded clusters.
+func (a *MongoDB) WatchInserts(ctx context.Context, resumeAfter json.RawMessage) (InsertStream, error) {
+	if !a.dateExpr.IsZero() {
+		return nil, errors.New("watching inserts cannot be combined with a date expression")
+	}
+
+	opts := options.ChangeStream()
+	if len(resumeAfter) > 0 {
+		var token bson.Raw
+		if err := bson.UnmarshalExtJSON(resumeAfter, true, &token); err != nil {
+			return nil, fmt.Errorf("invalid resume token: %w", err)
+		}
+		opts.SetResumeAfter(token)
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+	cs, err := a.collection.Watch(ctx, pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &mongoInsertStream{
+		cs: cs,
+		render: &mongoStreamingResult{
+			plainFields:      a.plainFields,
+			canonicalSpecial: a.canonicalSpecial,
+			renames:          a.renames,
+		},
+	}, nil
+}
+
+type mongoInsertStream struct {
+	cs     *mongo.ChangeStream
+	render *mongoStreamingResult
+	err    error
+}
+
+func (s *mongoInsertStream) TryNext(ctx context.Context) (InsertEvent, bool) {
+	for s.err == nil && s.cs.TryNext(ctx) {
+		var change struct {
+			FullDocument bson.Raw `bson:"fullDocument"`
+		}
+		if err := s.cs.Decode(&change); err != nil {
+			s.err = err
+			return InsertEvent{}, false
+		}
+
+		createdAt, ok := change.FullDocument.Lookup("createdAt").TimeOK()
+		if !ok {
+			continue
+		}
+
+		// Events are retained by the caller, so are rendered into buffers of their own
+		doc, err := s.render.marshal(nil, change.FullDocument)
+		if err != nil {
+			s.err = err
+			return InsertEvent{}, false
+		}
+		token, err := bson.MarshalExtJSON(s.cs.ResumeToken(), true, false)
+		if err != nil {
+			s.err = err
+			return InsertEvent{}, false
+		}
+		return InsertEvent{
+			Document:    doc,
+			CreatedAt:   createdAt.UTC(),
+			ResumeToken: token,
+		}, true
+	}
+	return InsertEvent{}, false
+}
+
+func (s *mongoInsertStream) Err() error {
+	return errors.Join(s.err, s.cs.Err())
+}
+
+func (s *mongoInsertStream) Close(ctx context.Context) error {
+	return s.cs.Close(ctx)
+}
@@ -0,0 +1,116 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ParseDateExpr parses an aggregation expression supplied as extended JSON, e.g. {"$max":["$createdAt","$updatedAt"]}
+// or {"$ifNull":["$completedAt","$createdAt"]}
+func ParseDateExpr(expr string) (bson.RawValue, error) {
+	var wrapper bson.Raw
+	if err := bson.UnmarshalExtJSON([]byte(`{"expr":`+expr+`}`), false, &wrapper); err != nil {
+		return bson.RawValue{}, fmt.Errorf("invalid date expression: %w", err)
+	}
+	return wrapper.Lookup("expr"), nil
+}
+
+// WithDateExpr buckets documents into days by the date computed by the aggregation expression, rather than by
+// createdAt. The expression is evaluated with $expr when finding, counting and deleting, so each operation selects
+// the same documents without needing to collect their _ids. Such queries can't make use of indexes on the underlying
+// fields, so are best reserved for collections where that is acceptable.
+func WithDateExpr(expr bson.RawValue) MongoDBOption {
+	return func(m *MongoDB) {
+		m.dateExpr = expr
+	}
+}
+
+// dayFilter matches all documents assigned to the supplied date, within the id range if there is one
+func (a *MongoDB) dayFilter(date time.Time) bson.M {
+	return a.scoped(a.dateFilter(date))
+}
+
+// dateFilter matches all documents assigned to the supplied date
+func (a *MongoDB) dateFilter(date time.Time) bson.M {
+	if a.useID {
+		t := date.Truncate(time.Hour * 24)
+		return idRange(t, t.AddDate(0, 0, 1))
+	}
+	if a.dateExpr.IsZero() {
+		return a.boundary.dayFilter(date)
+	}
+	start, end := a.boundary.operators()
+	t := date.Truncate(time.Hour * 24)
+	return bson.M{
+		"$expr": bson.M{
+			"$and": bson.A{
+				bson.M{start: bson.A{a.dateExpr, t}},
+				bson.M{end: bson.A{a.dateExpr, t.AddDate(0, 0, 1)}},
+			},
+		},
+	}
+}
+
+// beforeFilter matches all documents assigned to a day ending at or before the supplied time, within the id range if
+// there is one
+func (a *MongoDB) beforeFilter(before time.Time) bson.M {
+	return a.scoped(a.dateBeforeFilter(before))
+}
+
+// dateBeforeFilter matches all documents assigned to a day ending at or before the supplied time
+func (a *MongoDB) dateBeforeFilter(before time.Time) bson.M {
+	if a.useID {
+		return bson.M{"_id": bson.M{"$lt": primitive.NewObjectIDFromTimestamp(before)}}
+	}
+	if a.dateExpr.IsZero() {
+		return a.boundary.beforeFilter(before)
+	}
+	_, end := a.boundary.operators()
+	// Documents for which the expression is null or missing sort before any date, so must be excluded explicitly
+	return bson.M{
+		"$expr": bson.M{
+			"$and": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$type": a.dateExpr}, "date"}},
+				bson.M{end: bson.A{a.dateExpr, before}},
+			},
+		},
+	}
+}
+
+// earliestComputed returns the earliest date computed by the date expression across the documents matching the filter
+func (a *MongoDB) earliestComputed(ctx context.Context, filter bson.M) (time.Time, error) {
+	cursor, err := a.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: a.scoped(filter)}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "earliest", Value: bson.D{{Key: "$min", Value: a.dateExpr}}},
+		}}},
+	}, a.aggregateOptions())
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer cursor.Close(ctx)
+
+	if !cursor.Next(ctx) {
+		if err = cursor.Err(); err != nil {
+			return time.Time{}, err
+		}
+		return time.Time{}, mongo.ErrNoDocuments
+	}
+	var result struct {
+		Earliest *time.Time `bson:"earliest"`
+	}
+	if err = cursor.Decode(&result); err != nil {
+		return time.Time{}, err
+	}
+	if result.Earliest == nil {
+		// No document has a date computed by the expression
+		return time.Time{}, mongo.ErrNoDocuments
+	}
+	return result.Earliest.UTC(), nil
+}
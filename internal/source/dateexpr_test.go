@@ -0,0 +1,27 @@
+package source_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+)
+
+func TestParseDateExpr(t *testing.T) {
+	t.Parallel()
+
+	expr, err := source.ParseDateExpr(`{"$max":["$createdAt","$updatedAt"]}`)
+	require.NoError(t, err)
+	assert.Equal(t, bson.TypeEmbeddedDocument, expr.Type)
+	assert.Equal(t, `{"$max": ["$createdAt","$updatedAt"]}`, expr.String())
+
+	expr, err = source.ParseDateExpr(`"$updatedAt"`)
+	require.NoError(t, err)
+	assert.Equal(t, "$updatedAt", expr.StringValue())
+
+	_, err = source.ParseDateExpr(`{"$max":`)
+	assert.ErrorContains(t, err, "invalid date expression")
+}
@@ -0,0 +1,106 @@
+package source
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FindOptions exposes the options applied to queries selecting documents by createdAt
+func (a *MongoDB) FindOptions() *options.FindOptions {
+	return a.findOptions()
+}
+
+// CountOptions exposes the options applied to counts of documents by createdAt
+func (a *MongoDB) CountOptions() *options.CountOptions {
+	return a.countOptions()
+}
+
+// FindOneOptions exposes the options applied to queries for a single document
+func (a *MongoDB) FindOneOptions() *options.FindOneOptions {
+	return a.findOneOptions()
+}
+
+// AggregateOptions exposes the options applied to aggregations
+func (a *MongoDB) AggregateOptions() *options.AggregateOptions {
+	return a.aggregateOptions()
+}
+
+// Apply exposes the renaming of fields within a document
+func (r Renames) Apply(doc bson.Raw) (bson.Raw, error) {
+	return r.apply(doc, "")
+}
+
+// MarshalDocument renders the document into dst, as a streaming result configured with the supplied plain fields would
+func MarshalDocument(dst []byte, raw bson.Raw, plain []string) ([]byte, error) {
+	sr := &mongoStreamingResult{plainFields: newPlainFields(plain)}
+	return sr.marshal(dst, raw)
+}
+
+// MarshalDocumentCanonicalSpecial renders the document as MarshalDocument does, though with documents holding special
+// types rendered as canonical extended JSON
+func MarshalDocumentCanonicalSpecial(dst []byte, raw bson.Raw, plain []string) ([]byte, error) {
+	sr := &mongoStreamingResult{plainFields: newPlainFields(plain), canonicalSpecial: true}
+	return sr.marshal(dst, raw)
+}
+
+// RetryDelete exposes the retrying of deletes failing with a retryable error
+func (a *MongoDB) RetryDelete(
+	ctx context.Context,
+	del func(ctx context.Context) (*mongo.DeleteResult, error),
+) (*mongo.DeleteResult, error) {
+	return a.retryDelete(ctx, del)
+}
+
+// CheckReplSetStatus exposes the check of the replica set's health from the output of replSetGetStatus
+func CheckReplSetStatus(status bson.Raw) error {
+	return checkReplSetStatus(status)
+}
+
+// LeadingKey exposes the first key of an index's keys document
+func LeadingKey(keys bson.Raw) string {
+	return leadingKey(keys)
+}
+
+// DayCuts exposes the times splitting a day into sub-ranges read in parallel
+func DayCuts(day time.Time, n int) []time.Time {
+	return dayCuts(day, n)
+}
+
+// NewParallelResult returns a result streaming each set of documents at once, as sub-ranges read in parallel are
+func NewParallelResult(sets ...[]any) (StreamingResult, error) {
+	return NewLimitedParallelResult(nil, func() {}, sets...)
+}
+
+// NewLimitedParallelResult returns a result streaming each set of documents at once, bounded by the limiter, invoking
+// onFind as each set's query is run
+func NewLimitedParallelResult(l *OpLimiter, onFind func(), sets ...[]any) (StreamingResult, error) {
+	pr := &parallelStreamingResult{}
+	for _, docs := range sets {
+		cursor, err := mongo.NewCursorFromDocuments(docs, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		pr.results = append(pr.results, &mongoStreamingResult{
+			find: func(context.Context) (*mongo.Cursor, error) {
+				onFind()
+				return cursor, nil
+			},
+			limiter: l,
+		})
+	}
+	return pr, nil
+}
+
+// InUse exposes the number of operations holding a slot of the limiter
+func (l *OpLimiter) InUse() int {
+	return len(l.slots)
+}
+
+// Acquire exposes waiting for a slot of the limiter
+func (l *OpLimiter) Acquire(ctx context.Context) (func(), error) {
+	return l.acquire(ctx, "test")
+}
@@ -0,0 +1,88 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WithObjectIDFallback allows collections lacking createdAt altogether to be archived by the timestamp embedded in
+// each ObjectID _id, on the basis that ids are assigned in insert order. Whether to fall back is decided by
+// EarliestCreatedAt, at the start of each run, and only when no document in the collection holds createdAt. Days are
+// then selected by ranges of _id, so the boundary and index hint don't apply, and documents inserted exactly at
+// midnight belong to the day that is starting.
+func WithObjectIDFallback() MongoDBOption {
+	return func(m *MongoDB) {
+		m.idFallback = true
+	}
+}
+
+// fallBackToObjectID decides whether days are selected by _id, which is the case when the fallback is enabled and the
+// collection holds documents, none of which have createdAt. The earliest _id timestamp is returned when falling back.
+func (a *MongoDB) fallBackToObjectID(ctx context.Context) (earliest time.Time, ok bool, err error) {
+	a.useID = false
+	if !a.idFallback {
+		return time.Time{}, false, nil
+	}
+
+	countOpts := options.Count().SetLimit(1)
+	if a.maxTime > 0 {
+		countOpts.SetMaxTime(a.maxTime)
+	}
+	n, err := a.collection.CountDocuments(
+		ctx,
+		a.scoped(bson.M{"createdAt": bson.M{"$exists": true}}),
+		countOpts,
+	)
+	if err != nil || n > 0 {
+		return time.Time{}, false, err
+	}
+
+	res := a.collection.FindOne(
+		ctx,
+		a.scoped(bson.M{}),
+		a.findOneOptions().
+			SetSort(bson.M{"_id": 1}).
+			SetProjection(bson.M{"_id": 1}),
+	)
+	var projection struct {
+		ID bson.RawValue `bson:"_id"`
+	}
+	if err = res.Decode(&projection); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// An empty collection has nothing to fall back on
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+	id, isObjectID := projection.ID.ObjectIDOK()
+	if !isObjectID {
+		return time.Time{}, false, fmt.Errorf("collection lacks createdAt, and its _id %s is not an ObjectID", projection.ID)
+	}
+
+	a.useID = true
+	earliest = id.Timestamp().UTC()
+	slog.Warn(
+		"collection lacks createdAt, falling back to ObjectID timestamps",
+		slog.String("collection", a.collection.Name()),
+		slog.Time("earliest", earliest),
+	)
+	return earliest, true, nil
+}
+
+// idRange matches all documents whose _id was generated within [start, end)
+func idRange(start, end time.Time) bson.M {
+	return bson.M{
+		"_id": bson.M{
+			"$gte": primitive.NewObjectIDFromTimestamp(start),
+			"$lt":  primitive.NewObjectIDFromTimestamp(end),
+		},
+	}
+}
@@ -0,0 +1,18 @@
+package source
+
+// WithIDRangeFastPath selects each day's documents by the range of ObjectID _ids generated within it, rather than by
+// createdAt, when finding, counting and deleting them, so that every operation is served by the _id index rather than
+// scanning a createdAt index. Documents are archived in _id order, unless sorted by another field, and the earliest
+// day is taken from the earliest _id.
+//
+// It's only correct for collections where createdAt is monotonic with _id, i.e. each document's createdAt is the time
+// its _id was generated, as when both are assigned on insert. A document whose createdAt falls on a different day to
+// its _id is archived and deleted with the day of its _id, and documents whose _id isn't an ObjectID are never
+// archived. As ObjectID timestamps are whole seconds, days cover [start, end), so the boundary and index hint don't
+// apply.
+func WithIDRangeFastPath() MongoDBOption {
+	return func(m *MongoDB) {
+		m.idFastPath = true
+		m.useID = true
+	}
+}
@@ -0,0 +1,74 @@
+package source
+
+import (
+	"bytes"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IDRange bounds the documents archived by their ObjectID _id, with both bounds inclusive. A zero bound is unbounded.
+type IDRange struct {
+	Min primitive.ObjectID
+	Max primitive.ObjectID
+}
+
+// ParseIDRange parses the bounds of an id range from hex ObjectIDs, either of which may be empty to leave that end
+// unbounded
+func ParseIDRange(minID, maxID string) (IDRange, error) {
+	var r IDRange
+	for _, bound := range []struct {
+		name string
+		hex  string
+		id   *primitive.ObjectID
+	}{
+		{name: "min", hex: minID, id: &r.Min},
+		{name: "max", hex: maxID, id: &r.Max},
+	} {
+		if bound.hex == "" {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(bound.hex)
+		if err != nil {
+			return IDRange{}, fmt.Errorf("invalid %s id %q, expected a hex ObjectID: %w", bound.name, bound.hex, err)
+		}
+		*bound.id = id
+	}
+	if !r.Min.IsZero() && !r.Max.IsZero() && bytes.Compare(r.Min[:], r.Max[:]) > 0 {
+		return IDRange{}, fmt.Errorf("min id %s is greater than max id %s", r.Min.Hex(), r.Max.Hex())
+	}
+	return r, nil
+}
+
+// IsZero reports whether the range is unbounded at both ends
+func (r IDRange) IsZero() bool {
+	return r.Min.IsZero() && r.Max.IsZero()
+}
+
+// WithIDRange constrains finding, counting and deleting to documents whose _id falls within the range, intersected
+// with each day as usual, for surgically archiving a known slice of a collection, e.g. one identified by an incident.
+// The earliest day is also resolved from within the range, so days before it aren't visited.
+func WithIDRange(r IDRange) MongoDBOption {
+	return func(m *MongoDB) {
+		m.idRange = r
+	}
+}
+
+// scoped constrains the filter to the documents the source archives, being those within the id range, if there is one,
+// and yet to be marked for expiry, when marking rather than deleting
+func (a *MongoDB) scoped(filter bson.M) bson.M {
+	filter = a.unmarked(filter)
+	if a.idRange.IsZero() {
+		return filter
+	}
+	bounds := bson.M{}
+	if !a.idRange.Min.IsZero() {
+		bounds["$gte"] = a.idRange.Min
+	}
+	if !a.idRange.Max.IsZero() {
+		bounds["$lte"] = a.idRange.Max
+	}
+	// Combined with $and, as the filter may itself constrain _id
+	return bson.M{"$and": bson.A{filter, bson.M{"_id": bounds}}}
+}
@@ -0,0 +1,42 @@
+package source_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+)
+
+func TestParseIDRange(t *testing.T) {
+	t.Parallel()
+
+	minID, err := primitive.ObjectIDFromHex("672418000000000000000000")
+	require.NoError(t, err)
+	maxID, err := primitive.ObjectIDFromHex("672569800000000000000000")
+	require.NoError(t, err)
+
+	r, err := source.ParseIDRange(minID.Hex(), maxID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, source.IDRange{Min: minID, Max: maxID}, r)
+
+	// Either end may be left unbounded
+	r, err = source.ParseIDRange(minID.Hex(), "")
+	require.NoError(t, err)
+	assert.Equal(t, source.IDRange{Min: minID}, r)
+
+	r, err = source.ParseIDRange("", "")
+	require.NoError(t, err)
+	assert.True(t, r.IsZero())
+
+	_, err = source.ParseIDRange("not-an-id", "")
+	assert.ErrorContains(t, err, `invalid min id "not-an-id"`)
+
+	_, err = source.ParseIDRange("", "67241800")
+	assert.ErrorContains(t, err, `invalid max id "67241800"`)
+
+	_, err = source.ParseIDRange(maxID.Hex(), minID.Hex())
+	assert.ErrorContains(t, err, "is greater than max id")
+}
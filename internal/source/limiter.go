@@ -0,0 +1,61 @@
+package source
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// OpLimiter bounds the number of mongo operations in flight at once, across every source sharing it, so that
+// concurrent days, collections, sub-ranges and deletes can't together exhaust the client's connection pool. A nil
+// OpLimiter leaves operations unbounded.
+type OpLimiter struct {
+	slots chan struct{}
+}
+
+// NewOpLimiter returns a limiter allowing at most n operations at once
+func NewOpLimiter(n int) *OpLimiter {
+	return &OpLimiter{
+		slots: make(chan struct{}, n),
+	}
+}
+
+// WithOpLimiter bounds the operations of the source by the limiter, which may be shared with other sources. A slot
+// is held for the duration of each operation, or for finds, until their cursor has been read in full and closed, as
+// the cursor fetches further batches over a connection as it's read.
+func WithOpLimiter(l *OpLimiter) MongoDBOption {
+	return func(m *MongoDB) {
+		m.limiter = l
+	}
+}
+
+// acquire waits for a slot to perform the operation, logging should it have to wait, returning a func releasing the
+// slot once the operation is done
+func (l *OpLimiter) acquire(ctx context.Context, op string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	default:
+	}
+
+	started := time.Now()
+	slog.Info("waiting for a mongo operation slot", slog.String("operation", op), slog.Int("limit", cap(l.slots)))
+	select {
+	case l.slots <- struct{}{}:
+		slog.Debug(
+			"acquired a mongo operation slot",
+			slog.String("operation", op),
+			slog.Duration("waited", time.Since(started)),
+		)
+		return l.release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *OpLimiter) release() {
+	<-l.slots
+}
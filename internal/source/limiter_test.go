@@ -0,0 +1,65 @@
+package source_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+)
+
+func TestOpLimiter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("bounds concurrent finds", func(t *testing.T) {
+		t.Parallel()
+
+		const limit = 2
+		l := source.NewOpLimiter(limit)
+
+		sets := make([][]any, 8)
+		for i := range 800 {
+			sets[i%len(sets)] = append(sets[i%len(sets)], bson.D{{Key: "_id", Value: i}})
+		}
+
+		var mu sync.Mutex
+		var finds, maxInUse int
+		res, err := source.NewLimitedParallelResult(l, func() {
+			mu.Lock()
+			finds++
+			maxInUse = max(maxInUse, l.InUse())
+			mu.Unlock()
+			time.Sleep(time.Millisecond * 10)
+		}, sets...)
+		require.NoError(t, err)
+
+		var found int
+		for range res.Iter(context.Background()) {
+			found++
+		}
+		require.NoError(t, res.Err())
+		assert.Equal(t, 800, found)
+		assert.Equal(t, len(sets), finds)
+		assert.LessOrEqual(t, maxInUse, limit)
+		assert.Zero(t, l.InUse())
+	})
+
+	t.Run("stops waiting once cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		l := source.NewOpLimiter(1)
+		release, err := l.Acquire(context.Background())
+		require.NoError(t, err)
+		defer release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		_, err = l.Acquire(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
@@ -0,0 +1,125 @@
+package source_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+)
+
+func TestMarshalDocument(t *testing.T) {
+	t.Parallel()
+
+	raw := sampleDocument(t)
+
+	tests := []struct {
+		name     string
+		plain    []string
+		expected string
+	}{
+		{
+			name:  "extended JSON",
+			plain: nil,
+			expected: `{"_id":{"$oid":"5d6fd699ee45770009e17140"},` +
+				`"createdAt":{"$date":{"$numberLong":"1730430000000"}},"amount":{"$numberDouble":"12.5"},` +
+				`"count":{"$numberLong":"3"},"name":"<café> \"quoted\"","tags":["a",{"$numberInt":"1"},null],` +
+				`"meta":{"when":{"$date":{"$numberLong":"1730332800000"}},"weird key\n":true,` +
+				`"nested":{"n":{"$numberInt":"7"}}}}`,
+		},
+		{
+			name:  "plain fields",
+			plain: []string{"amount", "meta.when", "tags"},
+			expected: `{"_id":{"$oid":"5d6fd699ee45770009e17140"},` +
+				`"createdAt":{"$date":{"$numberLong":"1730430000000"}},"amount":12.5,` +
+				`"count":{"$numberLong":"3"},"name":"<café> \"quoted\"","tags":["a",1,null],` +
+				`"meta":{"when":"2024-10-31T00:00:00Z","weird key\n":true,` +
+				`"nested":{"n":{"$numberInt":"7"}}}}`,
+		},
+		{
+			name:  "plain document",
+			plain: []string{"meta"},
+			expected: `{"_id":{"$oid":"5d6fd699ee45770009e17140"},` +
+				`"createdAt":{"$date":{"$numberLong":"1730430000000"}},"amount":{"$numberDouble":"12.5"},` +
+				`"count":{"$numberLong":"3"},"name":"<café> \"quoted\"","tags":["a",{"$numberInt":"1"},null],` +
+				`"meta":{"when":"2024-10-31T00:00:00Z","weird key\n":true,"nested":{"n":7}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			out, err := source.MarshalDocument(nil, raw, tt.plain)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(out))
+
+			// Reusing a buffer which previously held a larger document leaves no trace of it
+			buf := []byte(tt.expected + tt.expected)
+			out, err = source.MarshalDocument(buf[:0], raw, tt.plain)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(out))
+		})
+	}
+}
+
+func BenchmarkMarshalDocument(b *testing.B) {
+	raw := sampleDocument(b)
+
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, err := bson.MarshalExtJSON(raw, true, false); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("reused", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for range b.N {
+			var err error
+			if buf, err = source.MarshalDocument(buf[:0], raw, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("plain fields reused", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for range b.N {
+			var err error
+			if buf, err = source.MarshalDocument(buf[:0], raw, []string{"amount"}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func sampleDocument(t testing.TB) bson.Raw {
+	t.Helper()
+
+	id, err := primitive.ObjectIDFromHex("5d6fd699ee45770009e17140")
+	require.NoError(t, err)
+	raw, err := bson.Marshal(bson.D{
+		{Key: "_id", Value: id},
+		{Key: "createdAt", Value: primitive.NewDateTimeFromTime(time.Date(2024, time.November, 1, 3, 0, 0, 0, time.UTC))},
+		{Key: "amount", Value: 12.5},
+		{Key: "count", Value: int64(3)},
+		{Key: "name", Value: "<café> \"quoted\""},
+		{Key: "tags", Value: bson.A{"a", int32(1), nil}},
+		{Key: "meta", Value: bson.D{
+			{Key: "when", Value: primitive.NewDateTimeFromTime(time.Date(2024, time.October, 31, 0, 0, 0, 0, time.UTC))},
+			{Key: "weird key\n", Value: true},
+			{Key: "nested", Value: bson.D{{Key: "n", Value: int32(7)}}},
+		}},
+	})
+	require.NoError(t, err)
+	return raw
+}
@@ -0,0 +1,24 @@
+package source
+
+import (
+	"context"
+	"time"
+)
+
+// WithMaxTime sets maxTimeMS on the queries finding, counting and aggregating documents, so that the server itself
+// kills a runaway operation rather than it running on after the client has given up. A cursor's limit covers the
+// server time spent producing all of its batches, so it must allow for reading the largest day. The driver offers no
+// maxTimeMS for deletes, so each delete is instead bounded by a context deadline of the same duration.
+func WithMaxTime(d time.Duration) MongoDBOption {
+	return func(m *MongoDB) {
+		m.maxTime = d
+	}
+}
+
+// deleteContext bounds a delete by the max time, if there is one
+func (a *MongoDB) deleteContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if a.maxTime <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, a.maxTime)
+}
@@ -0,0 +1,184 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DaySource is a source of documents by day, as merged by Merged
+type DaySource interface {
+	FindAllFromDate(ctx context.Context, date time.Time) StreamingResult
+	DeleteAllFromDate(ctx context.Context, date time.Time) (int, error)
+	EarliestCreatedAt(ctx context.Context) (time.Time, error)
+	EarliestCreatedAtFrom(ctx context.Context, from time.Time) (time.Time, bool, error)
+	CountFromDate(ctx context.Context, date time.Time) (int, error)
+	DayOf(t time.Time) time.Time
+}
+
+// NamedSource is a source merged under the name of the collection it reads from
+type NamedSource struct {
+	Name string
+	DaySource
+}
+
+// Merged reads the documents of several sources as one, so that related collections, e.g. events and
+// event_metadata, are archived together into a single file per day. Each document is tagged with the name of the
+// source it was read from, as the first field of the document, and each day is deleted from every source. Days are
+// assigned by the first source, so every source should share the same boundary.
+type Merged struct {
+	field   string
+	sources []NamedSource
+}
+
+// NewMerged returns a source reading each day from every one of the supplied sources in turn, tagging documents with
+// the name of their source under field
+func NewMerged(field string, sources ...NamedSource) *Merged {
+	return &Merged{
+		field:   field,
+		sources: sources,
+	}
+}
+
+// FindAllFromDate returns the documents of every source assigned to the date, one source after another
+func (m *Merged) FindAllFromDate(_ context.Context, date time.Time) StreamingResult {
+	return &mergedStreamingResult{merged: m, date: date}
+}
+
+// DeleteAllFromDate removes the documents assigned to the date from every source, reporting the total deleted
+func (m *Merged) DeleteAllFromDate(ctx context.Context, date time.Time) (int, error) {
+	var total int
+	for _, s := range m.sources {
+		n, err := s.DeleteAllFromDate(ctx, date)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", s.Name, err)
+		}
+	}
+	return total, nil
+}
+
+// CountFromDate returns the number of documents assigned to the date across every source
+func (m *Merged) CountFromDate(ctx context.Context, date time.Time) (int, error) {
+	var total int
+	for _, s := range m.sources {
+		n, err := s.CountFromDate(ctx, date)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", s.Name, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// CountDeletable returns the number of documents DeleteAllFromDate would delete from every source for the date.
+// Sources unable to count what they'd delete are counted by the documents assigned to the date instead.
+func (m *Merged) CountDeletable(ctx context.Context, date time.Time) (int, error) {
+	var total int
+	for _, s := range m.sources {
+		count := s.CountFromDate
+		if d, ok := s.DaySource.(interface {
+			CountDeletable(ctx context.Context, date time.Time) (int, error)
+		}); ok {
+			count = d.CountDeletable
+		}
+		n, err := count(ctx, date)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", s.Name, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// EarliestCreatedAt returns the earliest of the sources' earliest documents, ignoring empty sources, failing with
+// mongo.ErrNoDocuments should every source be empty
+func (m *Merged) EarliestCreatedAt(ctx context.Context) (time.Time, error) {
+	var earliest time.Time
+	for _, s := range m.sources {
+		t, err := s.EarliestCreatedAt(ctx)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		if earliest.IsZero() || t.Before(earliest) {
+			earliest = t
+		}
+	}
+	if earliest.IsZero() {
+		return time.Time{}, mongo.ErrNoDocuments
+	}
+	return earliest, nil
+}
+
+// EarliestCreatedAtFrom returns the earliest of the sources' earliest documents at or after from, reporting false
+// should none of them have any
+func (m *Merged) EarliestCreatedAtFrom(ctx context.Context, from time.Time) (time.Time, bool, error) {
+	var earliest time.Time
+	for _, s := range m.sources {
+		t, ok, err := s.EarliestCreatedAtFrom(ctx, from)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if ok && (earliest.IsZero() || t.Before(earliest)) {
+			earliest = t
+		}
+	}
+	return earliest, !earliest.IsZero(), nil
+}
+
+// DayOf returns the day to which a document created at t is assigned by the first source
+func (m *Merged) DayOf(t time.Time) time.Time {
+	return m.sources[0].DayOf(t)
+}
+
+// tag returns a copy of the document with the member, the field and name of the source, prepended
+func tag(doc, member []byte) []byte {
+	tagged := make([]byte, 0, len(doc)+len(member)+1)
+	tagged = append(tagged, doc[0])
+	tagged = append(tagged, member...)
+	if len(doc) > 2 {
+		tagged = append(tagged, ',')
+	}
+	return append(tagged, doc[1:]...)
+}
+
+// mergedStreamingResult streams the documents of each source in turn, only querying a source once those before it
+// have been read in full
+type mergedStreamingResult struct {
+	merged *Merged
+	date   time.Time
+	err    error
+}
+
+func (mr *mergedStreamingResult) Iter(ctx context.Context) iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		for _, s := range mr.merged.sources {
+			field, _ := json.Marshal(mr.merged.field) // strings always marshal
+			name, _ := json.Marshal(s.Name)
+			member := append(append(field, ':'), name...)
+
+			res := s.FindAllFromDate(ctx, mr.date)
+			for doc := range res.Iter(ctx) {
+				if !yield(tag(doc, member)) {
+					return
+				}
+			}
+			if err := res.Err(); err != nil {
+				mr.err = fmt.Errorf("%s: %w", s.Name, err)
+				return
+			}
+		}
+	}
+}
+
+func (mr *mergedStreamingResult) Err() error {
+	return mr.err
+}
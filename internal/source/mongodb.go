@@ -2,56 +2,254 @@ package source
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"iter"
+	"slices"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
 // MongoDB is a mongodb source of documents
 type MongoDB struct {
-	collection *mongo.Collection
+	collection  *mongo.Collection
+	deletes     *mongo.Collection // collection documents are deleted from, which is the read collection by default
+	sortField   string
+	plainFields plainFields
+	causal      bool
+	boundary    Boundary
+	indexHint   string
+	renames     Renames
+	dateExpr    bson.RawValue
+	idFallback  bool
+	idFastPath  bool
+	useID       bool // whether days are currently selected by _id, as decided by EarliestCreatedAt
+	deleteRetry *deleteRetryConfig
+	idRange     IDRange
+	maxTime     time.Duration
+	rollup      *rollupConfig
+	limiter     *OpLimiter
+	ttlMark     *ttlMarkConfig
+
+	intraDayParallelism int
+	canonicalSpecial    bool // whether documents holding special types ignore plain fields
+}
+
+// MongoDBOption configures optional behaviour of a MongoDB source
+type MongoDBOption func(*MongoDB)
+
+// WithSortField causes documents returned by FindAllFromDate to be sorted in ascending order of the supplied field.
+// The sort is performed server side, so fields without a supporting index may be expensive to sort on large days.
+func WithSortField(field string) MongoDBOption {
+	return func(m *MongoDB) {
+		m.sortField = field
+	}
+}
+
+// WithPlainFields renders the supplied dotted field paths as plain JSON rather than canonical extended JSON. This is
+// lossy - BSON types such as int64, dates and object ids cannot be recovered from the output - so should only be used
+// where downstream consumers don't need BSON fidelity.
+func WithPlainFields(paths []string) MongoDBOption {
+	return func(m *MongoDB) {
+		m.plainFields = newPlainFields(paths)
+	}
+}
+
+// WithCausalConsistency causes the operations for a day, as scoped by WithDaySession, to run within a single causally
+// consistent session, using majority read and write concerns. This ensures the delete observes at least the state that
+// was read whilst archiving, even when the operations are served by different members of the replica set.
+func WithCausalConsistency() MongoDBOption {
+	return func(m *MongoDB) {
+		m.causal = true
+	}
+}
+
+// WithBoundary controls which day documents created exactly at midnight are assigned to. The boundary is applied
+// consistently when finding, counting and deleting documents, so each document belongs to exactly one day.
+func WithBoundary(boundary Boundary) MongoDBOption {
+	return func(m *MongoDB) {
+		m.boundary = boundary
+	}
+}
+
+// WithIndexHint forces queries selecting documents by createdAt to use the named index, for cases where the query
+// planner would otherwise pick a less suitable one
+func WithIndexHint(name string) MongoDBOption {
+	return func(m *MongoDB) {
+		m.indexHint = name
+	}
+}
+
+// WithRenameFields causes documents to be returned with fields renamed, leaving the stored documents untouched. Plain
+// fields are matched against the renamed paths.
+func WithRenameFields(renames Renames) MongoDBOption {
+	return func(m *MongoDB) {
+		m.renames = renames
+	}
+}
+
+// WithDeleteCollection causes documents to be deleted from the supplied collection, rather than the collection they
+// are read from. This allows archiving from a view, which can't be deleted from, whilst deleting from the collection it
+// is defined over. Documents are deleted by the same createdAt range as they are read, or by _id, so the view must
+// expose both unchanged.
+func WithDeleteCollection(collection *mongo.Collection) MongoDBOption {
+	return func(m *MongoDB) {
+		m.deletes = collection
+	}
 }
 
 // NewMongoDB initializes and returns a MongoDB instance
-func NewMongoDB(collection *mongo.Collection) *MongoDB {
-	return &MongoDB{
+func NewMongoDB(collection *mongo.Collection, opts ...MongoDBOption) *MongoDB {
+	m := &MongoDB{
 		collection: collection,
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.deletes == nil {
+		m.deletes = m.collection
+	}
+	if m.causal {
+		m.collection = majorityCollection(m.collection)
+		m.deletes = majorityCollection(m.deletes)
+	}
+	return m
 }
 
-// FindAllFromDate resolves all documents with a createdAt on the supplied date
-func (a *MongoDB) FindAllFromDate(ctx context.Context, date time.Time) StreamingResult {
-	t := date.Truncate(time.Hour * 24)
+// majorityCollection returns the collection with majority read and write concerns
+func majorityCollection(collection *mongo.Collection) *mongo.Collection {
+	return collection.Database().Collection(
+		collection.Name(),
+		options.Collection().
+			SetReadConcern(readconcern.Majority()).
+			SetWriteConcern(writeconcern.Majority()),
+	)
+}
+
+// ErrView is returned when the collection to be deleted from is a view, which doesn't support deletes
+var ErrView = errors.New("collection is a view")
+
+// CheckDeletable refuses with ErrView should the collection documents are deleted from be a view, as deletes would
+// otherwise only fail once the first day had been archived. When marking documents for expiry, it likewise refuses with
+// ErrNoTTLIndex should no TTL index remove marked documents.
+func (a *MongoDB) CheckDeletable(ctx context.Context) error {
+	specs, err := a.deletes.Database().ListCollectionSpecifications(ctx, bson.M{"name": a.deletes.Name()})
+	if err != nil {
+		return fmt.Errorf("failed to list collections: %w", err)
+	}
+	for _, spec := range specs {
+		if spec.Type == "view" {
+			return fmt.Errorf("%w: %s cannot be deleted from", ErrView, a.deletes.Name())
+		}
+	}
+	return a.checkTTLIndex(ctx)
+}
 
-	cursor, err := a.collection.Find(
+// WithDaySession invokes fn with a context bound to a causally consistent session, if enabled. Otherwise, fn is
+// invoked with the supplied context.
+func (a *MongoDB) WithDaySession(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !a.causal {
+		return fn(ctx)
+	}
+	return a.collection.Database().Client().UseSessionWithOptions(
 		ctx,
-		bson.M{
-			"createdAt": bson.M{
-				"$gte": t,
-				"$lt":  t.AddDate(0, 0, 1),
-			},
+		options.Session().SetCausalConsistency(true),
+		func(sc mongo.SessionContext) error {
+			return fn(sc)
 		},
 	)
+}
+
+// FindAllFromDate resolves all documents with a createdAt on the supplied date
+func (a *MongoDB) FindAllFromDate(_ context.Context, date time.Time) StreamingResult {
+	opts := a.findOptions()
+	switch {
+	case a.sortField != "":
+		// Unindexed sorts exceeding the server memory limit would otherwise fail, so allow spilling to disk
+		opts.SetSort(bson.D{{Key: a.sortField, Value: 1}}).SetAllowDiskUse(true)
+	case a.idFastPath:
+		// Served by the _id index the day is selected by, so costs nothing
+		opts.SetSort(bson.D{{Key: "_id", Value: 1}})
+	}
+
+	if a.intraDayParallelism > 1 {
+		return a.findDayInParallel(date, opts)
+	}
+
+	filter := a.dayFilter(date)
+	return &mongoStreamingResult{
+		find: func(ctx context.Context) (*mongo.Cursor, error) {
+			return a.collection.Find(ctx, filter, opts)
+		},
+		limiter:          a.limiter,
+		plainFields:      a.plainFields,
+		canonicalSpecial: a.canonicalSpecial,
+		renames:          a.renames,
+	}
+}
+
+// FindAllFromDateAfterID resolves all documents with a createdAt on the supplied date, in _id order. If afterID is
+// supplied, as extended JSON, only documents with a greater _id are returned.
+func (a *MongoDB) FindAllFromDateAfterID(
+	ctx context.Context,
+	date time.Time,
+	afterID json.RawMessage,
+) StreamingResult {
+	filter := a.dayFilter(date)
+	if len(afterID) > 0 {
+		id, err := idFromExtJSON(afterID)
+		if err != nil {
+			return &mongoStreamingResult{err: err}
+		}
+		// Days selected by _id already constrain it, so the constraints are combined rather than replaced
+		filter = bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$gt": id}}}}
+	}
+
 	return &mongoStreamingResult{
-		cursor: cursor,
-		err:    err,
+		find: func(ctx context.Context) (*mongo.Cursor, error) {
+			return a.collection.Find(ctx, filter, a.findOptions().SetSort(bson.D{{Key: "_id", Value: 1}}))
+		},
+		limiter:          a.limiter,
+		plainFields:      a.plainFields,
+		canonicalSpecial: a.canonicalSpecial,
+		renames:          a.renames,
 	}
 }
 
-// EarliestCreatedAt returns the earliest createdAt time in the underlying collection
+// EarliestCreatedAt returns the earliest createdAt time in the underlying collection, the earliest computed date when
+// using a date expression, or the earliest _id timestamp when falling back to ObjectIDs or taking the _id fast path
 func (a *MongoDB) EarliestCreatedAt(ctx context.Context) (time.Time, error) {
+	release, err := a.limiter.acquire(ctx, "earliest")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer release()
+
+	if !a.dateExpr.IsZero() {
+		return a.earliestComputed(ctx, bson.M{})
+	}
+	if a.idFastPath {
+		// Only ObjectIDs compare with the nil ObjectID, so ids of other types are ignored
+		return a.earliestField(ctx, "_id", primitive.NilObjectID)
+	}
+	if earliest, ok, err := a.fallBackToObjectID(ctx); err != nil || ok {
+		return earliest, err
+	}
 	res := a.collection.FindOne(
 		ctx,
-		bson.M{
+		a.scoped(bson.M{
 			"createdAt": bson.M{
 				"$exists": true,
 			},
-		},
-		options.FindOne().
+		}),
+		a.findOneOptions().
 			SetSort(bson.M{"createdAt": 1}).
 			SetProjection(bson.M{"createdAt": 1}),
 	)
@@ -64,19 +262,31 @@ func (a *MongoDB) EarliestCreatedAt(ctx context.Context) (time.Time, error) {
 	return projection.CreatedAt, nil
 }
 
-// DeleteAllFromDate removes all documents with a createdAt on the supplied date
-func (a *MongoDB) DeleteAllFromDate(ctx context.Context, date time.Time) (int, error) {
-	t := date.Truncate(time.Hour * 24)
+// DayOf returns the start of the day to which a document created at t is assigned, according to the boundary
+func (a *MongoDB) DayOf(t time.Time) time.Time {
+	if a.useID {
+		return t.Truncate(time.Hour * 24)
+	}
+	return a.boundary.Day(t)
+}
 
-	res, err := a.collection.DeleteMany(
-		ctx,
-		bson.M{
-			"createdAt": bson.M{
-				"$gte": t,
-				"$lt":  t.AddDate(0, 0, 1),
-			},
-		},
-	)
+// DeleteAllFromDate removes all documents with a createdAt on the supplied date, or marks them for expiry, as
+// configured by WithTTLMark
+func (a *MongoDB) DeleteAllFromDate(ctx context.Context, date time.Time) (int, error) {
+	opts := options.Delete()
+	if a.indexHint != "" && !a.useID {
+		opts.SetHint(a.indexHint)
+	}
+	res, err := a.retryDelete(ctx, func(ctx context.Context) (*mongo.DeleteResult, error) {
+		release, err := a.limiter.acquire(ctx, "delete")
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		ctx, cancel := a.deleteContext(ctx)
+		defer cancel()
+		return a.deleteMany(ctx, a.deletes, a.dayFilter(date), opts)
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -84,14 +294,189 @@ func (a *MongoDB) DeleteAllFromDate(ctx context.Context, date time.Time) (int, e
 	return int(res.DeletedCount), nil
 }
 
+// deleteBatchSize bounds the number of ids supplied to a single delete, keeping each command well within the maximum
+// BSON document size
+const deleteBatchSize = 1000
+
+// DeleteByIDs removes the documents with the supplied _ids, given as extended JSON. Deletes are acknowledged by a
+// majority of the replica set.
+func (a *MongoDB) DeleteByIDs(ctx context.Context, ids []json.RawMessage) (int, error) {
+	collection, err := a.deletes.Clone(options.Collection().SetWriteConcern(writeconcern.Majority()))
+	if err != nil {
+		return 0, err
+	}
+
+	var total int
+	for batch := range slices.Chunk(ids, deleteBatchSize) {
+		values := make(bson.A, 0, len(batch))
+		for _, id := range batch {
+			value, err := idFromExtJSON(id)
+			if err != nil {
+				return total, err
+			}
+			values = append(values, value)
+		}
+		res, err := a.retryDelete(ctx, func(ctx context.Context) (*mongo.DeleteResult, error) {
+			release, err := a.limiter.acquire(ctx, "delete")
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+			ctx, cancel := a.deleteContext(ctx)
+			defer cancel()
+			return a.deleteMany(ctx, collection, bson.M{"_id": bson.M{"$in": values}}, nil)
+		})
+		if err != nil {
+			return total, err
+		}
+		total += int(res.DeletedCount)
+	}
+	return total, nil
+}
+
+// idFromExtJSON parses an _id supplied as extended JSON
+func idFromExtJSON(id json.RawMessage) (bson.RawValue, error) {
+	var wrapper bson.Raw
+	if err := bson.UnmarshalExtJSON([]byte(`{"_id":`+string(id)+`}`), true, &wrapper); err != nil {
+		return bson.RawValue{}, fmt.Errorf("invalid _id: %w", err)
+	}
+	return wrapper.Lookup("_id"), nil
+}
+
+// CountFromDate returns the number of documents with a createdAt on the supplied date
+func (a *MongoDB) CountFromDate(ctx context.Context, date time.Time) (int, error) {
+	release, err := a.limiter.acquire(ctx, "count")
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	count, err := a.collection.CountDocuments(ctx, a.dayFilter(date), a.countOptions())
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// CountDeletable returns the number of documents DeleteAllFromDate would delete, or mark, for the supplied date,
+// counting the collection documents are deleted from with the same filter
+func (a *MongoDB) CountDeletable(ctx context.Context, date time.Time) (int, error) {
+	release, err := a.limiter.acquire(ctx, "count")
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	count, err := a.deletes.CountDocuments(ctx, a.dayFilter(date), a.countOptions())
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// CountBefore returns the number of documents assigned to days ending at or before the supplied time
+func (a *MongoDB) CountBefore(ctx context.Context, before time.Time) (int, error) {
+	release, err := a.limiter.acquire(ctx, "count")
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	count, err := a.collection.CountDocuments(ctx, a.beforeFilter(before), a.countOptions())
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// HasIndex reports whether the collection has an index with the supplied name
+func (a *MongoDB) HasIndex(ctx context.Context, name string) (bool, error) {
+	specs, err := a.collection.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(specs, func(spec *mongo.IndexSpecification) bool {
+		return spec.Name == name
+	}), nil
+}
+
+// findOptions returns the options common to all queries selecting documents by createdAt
+func (a *MongoDB) findOptions() *options.FindOptions {
+	opts := options.Find()
+	if a.indexHint != "" && !a.useID {
+		opts.SetHint(a.indexHint)
+	}
+	if a.maxTime > 0 {
+		opts.SetMaxTime(a.maxTime)
+	}
+	return opts
+}
+
+// countOptions returns the options common to all counts of documents by createdAt
+func (a *MongoDB) countOptions() *options.CountOptions {
+	opts := options.Count()
+	if a.indexHint != "" && !a.useID {
+		opts.SetHint(a.indexHint)
+	}
+	if a.maxTime > 0 {
+		opts.SetMaxTime(a.maxTime)
+	}
+	return opts
+}
+
+// findOneOptions returns the options common to all queries for a single document
+func (a *MongoDB) findOneOptions() *options.FindOneOptions {
+	opts := options.FindOne()
+	if a.maxTime > 0 {
+		opts.SetMaxTime(a.maxTime)
+	}
+	return opts
+}
+
+// aggregateOptions returns the options common to all aggregations
+func (a *MongoDB) aggregateOptions() *options.AggregateOptions {
+	opts := options.Aggregate()
+	if a.maxTime > 0 {
+		opts.SetMaxTime(a.maxTime)
+	}
+	return opts
+}
+
+// AverageDocumentSize returns the average size in bytes of documents in the collection, as reported by collStats
+func (a *MongoDB) AverageDocumentSize(ctx context.Context) (int, error) {
+	release, err := a.limiter.acquire(ctx, "stats")
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	var stats struct {
+		AvgObjSize float64 `bson:"avgObjSize"`
+	}
+	err = a.collection.Database().
+		RunCommand(ctx, bson.D{{Key: "collStats", Value: a.collection.Name()}}).
+		Decode(&stats)
+	if err != nil {
+		return 0, err
+	}
+	return int(stats.AvgObjSize), nil
+}
+
+// StreamingResult streams the documents found by a query, as extended JSON. The memory backing each document yielded
+// by Iter may be reused once iteration continues, so documents must be copied should they need to be retained.
 type StreamingResult interface {
 	Iter(ctx context.Context) iter.Seq[[]byte]
 	Err() error
 }
 
 type mongoStreamingResult struct {
-	err    error
-	cursor *mongo.Cursor
+	err         error
+	find        func(ctx context.Context) (*mongo.Cursor, error) // runs the query, once the result is iterated
+	limiter     *OpLimiter
+	plainFields plainFields
+	renames     Renames
+
+	canonicalSpecial bool
 }
 
 func (sr *mongoStreamingResult) Iter(ctx context.Context) iter.Seq[[]byte] {
@@ -100,27 +485,40 @@ func (sr *mongoStreamingResult) Iter(ctx context.Context) iter.Seq[[]byte] {
 			return
 		}
 
+		// The query is only run once iterated, so that the slot it holds until its cursor is closed is only taken
+		// once it's read, e.g. by sub-ranges read in parallel
+		release, err := sr.limiter.acquire(ctx, "find")
+		if err != nil {
+			sr.err = err
+			return
+		}
+		defer release()
+		cursor, err := sr.find(ctx)
+		if err != nil {
+			sr.err = err
+			return
+		}
 		defer func() {
-			if err := sr.cursor.Err(); err != nil {
+			if err := cursor.Err(); err != nil {
 				sr.err = errors.Join(sr.err, err)
 			}
-			if err := sr.cursor.Close(ctx); err != nil {
+			if err := cursor.Close(ctx); err != nil {
 				sr.err = errors.Join(sr.err, err)
 			}
 		}()
 
-		for sr.cursor.Next(ctx) {
-			var raw bson.Raw
-			if err := sr.cursor.Decode(&raw); err != nil {
-				sr.err = err
-				return
-			}
+		// Each document is rendered into the same buffer, rather than a fresh allocation per document
+		buf := getBuffer()
+		defer putBuffer(buf)
 
-			doc, err := bson.MarshalExtJSON(raw, true, false)
+		for cursor.Next(ctx) {
+			// The current document is only valid until the cursor advances, which it won't until rendered
+			doc, err := sr.marshal((*buf)[:0], cursor.Current)
 			if err != nil {
 				sr.err = err
 				return
 			}
+			*buf = doc
 
 			if !yield(doc) {
 				return
@@ -129,6 +527,27 @@ func (sr *mongoStreamingResult) Iter(ctx context.Context) iter.Seq[[]byte] {
 	}
 }
 
+// marshal renders the document as extended JSON into dst, which must be empty though may have spare capacity
+func (sr *mongoStreamingResult) marshal(dst []byte, raw bson.Raw) ([]byte, error) {
+	if !sr.renames.empty() {
+		var err error
+		if raw, err = sr.renames.apply(raw, ""); err != nil {
+			return nil, err
+		}
+	}
+	if len(sr.plainFields) > 0 {
+		if sr.canonicalSpecial {
+			if _, _, special, err := FindSpecialType(raw); err != nil {
+				return nil, err
+			} else if special {
+				return bson.MarshalExtJSONAppend(dst, raw, true, false)
+			}
+		}
+		return sr.plainFields.appendDocument(dst, raw, "")
+	}
+	return bson.MarshalExtJSONAppend(dst, raw, true, false)
+}
+
 func (sr *mongoStreamingResult) Err() error {
 	return sr.err
 }
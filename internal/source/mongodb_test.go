@@ -2,6 +2,7 @@ package source_test
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -10,7 +11,10 @@ import (
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/predicate"
 	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
 	"github.com/e-flux-platform/mongo-collection-archiver/internal/testutil"
 )
@@ -61,6 +65,653 @@ func TestMongoDB(t *testing.T) {
 		assert.Equal(t, jsonDoc3, docs[1])
 	})
 
+	t.Run("FindAllFromDate with sort field", func(t *testing.T) {
+		t.Parallel()
+
+		date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		doc1 := bson.M{
+			"_id":       objectIDFromHex(t, "5d6fdf658a583b0009929c06"),
+			"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour)),
+		}
+		doc2 := bson.M{
+			"_id":       objectIDFromHex(t, "5d6fd699ee45770009e17140"),
+			"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 3)),
+		}
+		doc3 := bson.M{
+			"_id":       objectIDFromHex(t, "5d6fd8ec10ca90000998cf31"),
+			"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 2)),
+		}
+
+		collection := client.Database(uuid.NewString()).Collection("test")
+		_, err := collection.InsertMany(ctx, []any{doc1, doc2, doc3})
+		require.NoError(t, err)
+
+		for field, expected := range map[string][]string{
+			"_id":       {"5d6fd699ee45770009e17140", "5d6fd8ec10ca90000998cf31", "5d6fdf658a583b0009929c06"},
+			"createdAt": {"5d6fdf658a583b0009929c06", "5d6fd8ec10ca90000998cf31", "5d6fd699ee45770009e17140"},
+		} {
+			var ids []string
+			res := source.NewMongoDB(collection, source.WithSortField(field)).FindAllFromDate(ctx, date)
+			for doc := range res.Iter(ctx) {
+				var decoded struct {
+					ID primitive.ObjectID `bson:"_id"`
+				}
+				require.NoError(t, bson.UnmarshalExtJSON(doc, true, &decoded))
+				ids = append(ids, decoded.ID.Hex())
+			}
+			require.NoError(t, res.Err())
+			assert.Equal(t, expected, ids, field)
+		}
+	})
+
+	t.Run("FindAllFromDateAfterID", func(t *testing.T) {
+		t.Parallel()
+
+		date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		doc1 := bson.M{
+			"_id":       objectIDFromHex(t, "5d6fdf658a583b0009929c06"),
+			"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour)),
+		}
+		doc2 := bson.M{
+			"_id":       objectIDFromHex(t, "5d6fd699ee45770009e17140"),
+			"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 2)),
+		}
+		doc3 := bson.M{
+			"_id":       objectIDFromHex(t, "5d6fd8ec10ca90000998cf31"),
+			"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 3)),
+		}
+		doc4 := bson.M{
+			"_id":       objectIDFromHex(t, "5d6fd8ec10ca90000998cf32"),
+			"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 24)),
+		}
+
+		collection := client.Database(uuid.NewString()).Collection("test")
+		_, err := collection.InsertMany(ctx, []any{doc1, doc2, doc3, doc4})
+		require.NoError(t, err)
+
+		for afterID, expected := range map[string][]string{
+			"":                                    {"5d6fd699ee45770009e17140", "5d6fd8ec10ca90000998cf31", "5d6fdf658a583b0009929c06"},
+			`{"$oid":"5d6fd699ee45770009e17140"}`: {"5d6fd8ec10ca90000998cf31", "5d6fdf658a583b0009929c06"},
+			`{"$oid":"5d6fdf658a583b0009929c06"}`: nil,
+		} {
+			var ids []string
+			res := source.NewMongoDB(collection).FindAllFromDateAfterID(ctx, date, json.RawMessage(afterID))
+			for doc := range res.Iter(ctx) {
+				var decoded struct {
+					ID primitive.ObjectID `bson:"_id"`
+				}
+				require.NoError(t, bson.UnmarshalExtJSON(doc, true, &decoded))
+				ids = append(ids, decoded.ID.Hex())
+			}
+			require.NoError(t, res.Err())
+			assert.Equal(t, expected, ids, afterID)
+		}
+	})
+
+	t.Run("FindAllFromDate with plain fields", func(t *testing.T) {
+		t.Parallel()
+
+		date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		doc := bson.D{
+			{Key: "_id", Value: objectIDFromHex(t, "5d6fd8ec10ca90000998cf31")},
+			{Key: "createdAt", Value: primitive.NewDateTimeFromTime(date)},
+			{Key: "amount", Value: int64(1500)},
+			{Key: "meta", Value: bson.D{
+				{Key: "count", Value: int64(2)},
+				{Key: "total", Value: int64(3)},
+			}},
+		}
+
+		collection := client.Database(uuid.NewString()).Collection("test")
+		_, err := collection.InsertOne(ctx, doc)
+		require.NoError(t, err)
+
+		var docs []string
+		res := source.NewMongoDB(collection, source.WithPlainFields([]string{"createdAt", "amount", "meta.count"})).
+			FindAllFromDate(ctx, date)
+		for doc := range res.Iter(ctx) {
+			docs = append(docs, string(doc))
+		}
+		require.NoError(t, res.Err())
+		require.Len(t, docs, 1)
+
+		expected := `{"_id":{"$oid":"5d6fd8ec10ca90000998cf31"},"createdAt":"2024-11-01T00:00:00Z","amount":1500,` +
+			`"meta":{"count":2,"total":{"$numberLong":"3"}}}`
+		assert.Equal(t, expected, docs[0])
+	})
+
+	t.Run("FindAllFromDate with renamed fields", func(t *testing.T) {
+		t.Parallel()
+
+		date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		doc := bson.D{
+			{Key: "_id", Value: objectIDFromHex(t, "5d6fd8ec10ca90000998cf31")},
+			{Key: "createdAt", Value: primitive.NewDateTimeFromTime(date)},
+			{Key: "userId", Value: "u1"},
+			{Key: "meta", Value: bson.D{
+				{Key: "count", Value: int64(2)},
+			}},
+		}
+
+		collection := client.Database(uuid.NewString()).Collection("test")
+		_, err := collection.InsertOne(ctx, doc)
+		require.NoError(t, err)
+
+		renames, err := source.ParseRenames([]string{"userId=user_id", "meta.count=total"})
+		require.NoError(t, err)
+
+		var docs []string
+		res := source.NewMongoDB(
+			collection,
+			source.WithRenameFields(renames),
+			source.WithPlainFields([]string{"meta.total"}),
+		).FindAllFromDate(ctx, date)
+		for doc := range res.Iter(ctx) {
+			docs = append(docs, string(doc))
+		}
+		require.NoError(t, res.Err())
+		require.Len(t, docs, 1)
+
+		expected := `{"_id":{"$oid":"5d6fd8ec10ca90000998cf31"},"createdAt":{"$date":{"$numberLong":"1730419200000"}},` +
+			`"user_id":"u1","meta":{"total":2}}`
+		assert.Equal(t, expected, docs[0])
+
+		// The stored document is untouched
+		count, err := collection.CountDocuments(ctx, bson.M{"userId": "u1", "meta.count": 2})
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, count)
+	})
+
+	t.Run("DeleteByIDs", func(t *testing.T) {
+		t.Parallel()
+
+		doc1 := bson.M{"_id": objectIDFromHex(t, "5d6fd699ee45770009e17140")}
+		doc2 := bson.M{"_id": objectIDFromHex(t, "5d6fd8ec10ca90000998cf31")}
+		doc3 := bson.M{"_id": objectIDFromHex(t, "5d6fdf658a583b0009929c06")}
+
+		collection := client.Database(uuid.NewString()).Collection("test")
+		_, err := collection.InsertMany(ctx, []any{doc1, doc2, doc3})
+		require.NoError(t, err)
+
+		total, err := source.NewMongoDB(collection).DeleteByIDs(ctx, []json.RawMessage{
+			json.RawMessage(`{"$oid":"5d6fd699ee45770009e17140"}`),
+			json.RawMessage(`{"$oid":"5d6fdf658a583b0009929c06"}`),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, total)
+
+		count, err := collection.CountDocuments(ctx, bson.M{})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count) // doc2
+	})
+
+	t.Run("CheckDeletable", func(t *testing.T) {
+		t.Parallel()
+
+		database := client.Database(uuid.NewString())
+		base := database.Collection("base")
+		_, err := base.InsertOne(ctx, bson.M{"createdAt": time.Now()})
+		require.NoError(t, err)
+		require.NoError(t, database.CreateView(ctx, "view", "base", mongo.Pipeline{}))
+		view := database.Collection("view")
+
+		assert.NoError(t, source.NewMongoDB(base).CheckDeletable(ctx))
+		assert.NoError(t, source.NewMongoDB(view, source.WithDeleteCollection(base)).CheckDeletable(ctx))
+		assert.ErrorIs(t, source.NewMongoDB(view).CheckDeletable(ctx), source.ErrView)
+		assert.ErrorIs(t, source.NewMongoDB(base, source.WithDeleteCollection(view)).CheckDeletable(ctx), source.ErrView)
+	})
+
+	t.Run("probes", func(t *testing.T) {
+		t.Parallel()
+
+		database := client.Database(uuid.NewString())
+		base := database.Collection("base")
+		_, err := base.InsertOne(ctx, bson.M{"createdAt": time.Now()})
+		require.NoError(t, err)
+		require.NoError(t, database.CreateView(ctx, "view", "base", mongo.Pipeline{}))
+		view := database.Collection("view")
+		empty := database.Collection("empty")
+
+		src := source.NewMongoDB(base)
+		require.NoError(t, src.Ping(ctx))
+
+		// Reading and deleting are probed without deleting anything
+		assert.NoError(t, src.ProbeRead(ctx))
+		assert.NoError(t, source.NewMongoDB(empty).ProbeRead(ctx))
+		assert.NoError(t, src.ProbeDelete(ctx))
+		count, err := base.CountDocuments(ctx, bson.M{})
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, count)
+		assert.ErrorIs(t, source.NewMongoDB(view).ProbeDelete(ctx), source.ErrView)
+
+		// Only indexes leading with createdAt are usable
+		assert.ErrorIs(t, src.CheckDateIndex(ctx), source.ErrNoDateIndex)
+		_, err = base.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "createdAt", Value: 1}},
+		})
+		require.NoError(t, err)
+		assert.ErrorIs(t, src.CheckDateIndex(ctx), source.ErrNoDateIndex)
+		_, err = base.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}},
+		})
+		require.NoError(t, err)
+		assert.NoError(t, src.CheckDateIndex(ctx))
+	})
+
+	t.Run("WithDaySession", func(t *testing.T) {
+		t.Parallel()
+
+		collection := client.Database(uuid.NewString()).Collection("test")
+
+		for causal, expected := range map[bool]bool{true: true, false: false} {
+			var opts []source.MongoDBOption
+			if causal {
+				opts = append(opts, source.WithCausalConsistency())
+			}
+			err := source.NewMongoDB(collection, opts...).WithDaySession(ctx, func(ctx context.Context) error {
+				sess := mongo.SessionFromContext(ctx)
+				assert.Equal(t, expected, sess != nil)
+				return nil
+			})
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("CountFromDate", func(t *testing.T) {
+		t.Parallel()
+
+		date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		collection := client.Database(uuid.NewString()).Collection("test")
+		_, err := collection.InsertMany(ctx, []any{
+			bson.M{"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Second * -1))},
+			bson.M{"createdAt": primitive.NewDateTimeFromTime(date)},
+			bson.M{"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 3))},
+			bson.M{"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 24))},
+		})
+		require.NoError(t, err)
+
+		count, err := source.NewMongoDB(collection).CountFromDate(ctx, date)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("CountDeletable", func(t *testing.T) {
+		t.Parallel()
+
+		date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		db := client.Database(uuid.NewString())
+		collection := db.Collection("test")
+		deletes := db.Collection("deletes")
+		_, err := deletes.InsertMany(ctx, []any{
+			bson.M{"createdAt": primitive.NewDateTimeFromTime(date)},
+			bson.M{"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 3))},
+			bson.M{"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 24))},
+		})
+		require.NoError(t, err)
+
+		// Nothing is read from the collection for the day, whilst deleting it would delete from the delete collection
+		src := source.NewMongoDB(collection, source.WithDeleteCollection(deletes))
+		count, err := src.CountFromDate(ctx, date)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+		count, err = src.CountDeletable(ctx, date)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		deleted, err := src.DeleteAllFromDate(ctx, date)
+		require.NoError(t, err)
+		assert.Equal(t, count, deleted)
+	})
+
+	t.Run("CountBefore", func(t *testing.T) {
+		t.Parallel()
+
+		date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		collection := client.Database(uuid.NewString()).Collection("test")
+		_, err := collection.InsertMany(ctx, []any{
+			bson.M{"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * -48))},
+			bson.M{"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Second * -1))},
+			bson.M{"createdAt": primitive.NewDateTimeFromTime(date)},
+		})
+		require.NoError(t, err)
+
+		count, err := source.NewMongoDB(collection).CountBefore(ctx, date)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("boundary", func(t *testing.T) {
+		t.Parallel()
+
+		date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		tests := []struct {
+			boundary source.Boundary
+			expected []time.Time
+		}{
+			{
+				boundary: source.LeftInclusive,
+				expected: []time.Time{date, date.Add(time.Hour * 3)},
+			},
+			{
+				boundary: source.RightInclusive,
+				expected: []time.Time{date.Add(time.Hour * 3), date.Add(time.Hour * 24)},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.boundary.String(), func(t *testing.T) {
+				t.Parallel()
+
+				collection := client.Database(uuid.NewString()).Collection("test")
+				_, err := collection.InsertMany(ctx, []any{
+					bson.M{"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Second * -1))},
+					bson.M{"createdAt": primitive.NewDateTimeFromTime(date)},
+					bson.M{"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 3))},
+					bson.M{"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 24))},
+				})
+				require.NoError(t, err)
+
+				src := source.NewMongoDB(collection, source.WithBoundary(tt.boundary))
+
+				var found []time.Time
+				res := src.FindAllFromDate(ctx, date)
+				for doc := range res.Iter(ctx) {
+					var decoded struct {
+						CreatedAt struct {
+							Date struct {
+								NumberLong int64 `json:"$numberLong,string"`
+							} `json:"$date"`
+						} `json:"createdAt"`
+					}
+					require.NoError(t, json.Unmarshal(doc, &decoded))
+					found = append(found, time.UnixMilli(decoded.CreatedAt.Date.NumberLong).UTC())
+				}
+				require.NoError(t, res.Err())
+				assert.ElementsMatch(t, tt.expected, found)
+
+				count, err := src.CountFromDate(ctx, date)
+				require.NoError(t, err)
+				assert.Equal(t, len(tt.expected), count)
+
+				deleted, err := src.DeleteAllFromDate(ctx, date)
+				require.NoError(t, err)
+				assert.Equal(t, len(tt.expected), deleted)
+
+				remaining, err := collection.CountDocuments(ctx, bson.M{})
+				require.NoError(t, err)
+				assert.EqualValues(t, 4-len(tt.expected), remaining)
+			})
+		}
+	})
+
+	t.Run("date expression", func(t *testing.T) {
+		t.Parallel()
+
+		date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		// The computed date of each document differs from both of its fields
+		collection := client.Database(uuid.NewString()).Collection("test")
+		_, err := collection.InsertMany(ctx, []any{
+			bson.M{ // created the day before, but updated on the day
+				"_id":       1,
+				"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * -2)),
+				"updatedAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 2)),
+			},
+			bson.M{ // created on the day, but updated the day after
+				"_id":       2,
+				"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 2)),
+				"updatedAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 26)),
+			},
+			bson.M{ // created and never updated
+				"_id":       3,
+				"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 3)),
+			},
+			bson.M{ // created two days before, and updated the day before
+				"_id":       4,
+				"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * -40)),
+				"updatedAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * -20)),
+			},
+		})
+		require.NoError(t, err)
+
+		expr, err := source.ParseDateExpr(`{"$max":["$createdAt",{"$ifNull":["$updatedAt","$createdAt"]}]}`)
+		require.NoError(t, err)
+		src := source.NewMongoDB(collection, source.WithDateExpr(expr))
+
+		earliest, err := src.EarliestCreatedAt(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, date.Add(time.Hour*-20), earliest)
+
+		var ids []string
+		res := src.FindAllFromDate(ctx, date)
+		for doc := range res.Iter(ctx) {
+			var decoded struct {
+				ID json.RawMessage `json:"_id"`
+			}
+			require.NoError(t, json.Unmarshal(doc, &decoded))
+			ids = append(ids, string(decoded.ID))
+		}
+		require.NoError(t, res.Err())
+		assert.ElementsMatch(t, []string{`{"$numberInt":"1"}`, `{"$numberInt":"3"}`}, ids)
+
+		count, err := src.CountFromDate(ctx, date)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		count, err = src.CountBefore(ctx, date.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		assert.Equal(t, 3, count) // 1, 3 and 4
+
+		deleted, err := src.DeleteAllFromDate(ctx, date)
+		require.NoError(t, err)
+		assert.Equal(t, 2, deleted)
+
+		remaining, err := collection.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": bson.A{2, 4}}})
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, remaining)
+	})
+
+	t.Run("ServerTime", func(t *testing.T) {
+		t.Parallel()
+
+		// The container shares the host's clock, so the server time should be close to the local time
+		before := time.Now().Add(-time.Second)
+		now, err := source.ServerTime(ctx, client)
+		require.NoError(t, err)
+		assert.WithinRange(t, now, before, time.Now().Add(time.Second))
+		assert.Equal(t, time.UTC, now.Location())
+	})
+
+	t.Run("HasIndex", func(t *testing.T) {
+		t.Parallel()
+
+		collection := client.Database(uuid.NewString()).Collection("test")
+		_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "createdAt", Value: 1}},
+			Options: options.Index().SetName("createdAt_1"),
+		})
+		require.NoError(t, err)
+
+		src := source.NewMongoDB(collection, source.WithIndexHint("createdAt_1"))
+
+		exists, err := src.HasIndex(ctx, "createdAt_1")
+		require.NoError(t, err)
+		assert.True(t, exists)
+
+		exists, err = src.HasIndex(ctx, "missing_1")
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		// The hint is accepted by the server
+		count, err := src.CountFromDate(ctx, time.Now())
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+
+	t.Run("CheckScan", func(t *testing.T) {
+		t.Parallel()
+
+		date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		docs := make([]any, 0, 10)
+		for i := range 10 {
+			docs = append(docs, bson.M{"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * time.Duration(i)))})
+		}
+
+		collection := client.Database(uuid.NewString()).Collection("test")
+		_, err := collection.InsertMany(ctx, docs)
+		require.NoError(t, err)
+
+		src := source.NewMongoDB(collection)
+
+		// Small enough collections are allowed to be scanned
+		require.NoError(t, src.CheckScan(ctx, 10))
+
+		// Unindexed, so every document would be scanned
+		err = src.CheckScan(ctx, 5)
+		assert.ErrorIs(t, err, source.ErrCollectionScan)
+
+		_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "createdAt", Value: 1}}})
+		require.NoError(t, err)
+
+		assert.NoError(t, src.CheckScan(ctx, 5))
+
+		// Sorting by an unindexed field is still served by the createdAt index
+		assert.NoError(t, source.NewMongoDB(collection, source.WithSortField("other")).CheckScan(ctx, 5))
+	})
+
+	t.Run("CheckSize", func(t *testing.T) {
+		t.Parallel()
+
+		docs := make([]any, 0, 10)
+		for range 10 {
+			docs = append(docs, bson.M{"createdAt": primitive.NewDateTimeFromTime(time.Now())})
+		}
+
+		collection := client.Database(uuid.NewString()).Collection("test")
+		_, err := collection.InsertMany(ctx, docs)
+		require.NoError(t, err)
+
+		src := source.NewMongoDB(collection)
+
+		// Within the thresholds, which are inclusive
+		require.NoError(t, src.CheckSize(ctx, 10, 10))
+		require.NoError(t, src.CheckSize(ctx, 0, 0))
+
+		// Below the minimum
+		err = src.CheckSize(ctx, 11, 0)
+		assert.ErrorIs(t, err, source.ErrCollectionSize)
+		assert.ErrorContains(t, err, "fewer than the minimum of 11")
+
+		// Above the maximum
+		err = src.CheckSize(ctx, 0, 9)
+		assert.ErrorIs(t, err, source.ErrCollectionSize)
+		assert.ErrorContains(t, err, "more than the maximum of 9")
+
+		// A collection that doesn't exist holds no documents
+		missing := source.NewMongoDB(client.Database(uuid.NewString()).Collection("test"))
+		assert.ErrorIs(t, missing.CheckSize(ctx, 1, 0), source.ErrCollectionSize)
+	})
+
+	t.Run("CheckStats", func(t *testing.T) {
+		t.Parallel()
+
+		docs := make([]any, 0, 10)
+		for range 10 {
+			docs = append(docs, bson.M{"createdAt": primitive.NewDateTimeFromTime(time.Now())})
+		}
+
+		collection := client.Database(uuid.NewString()).Collection("test")
+		_, err := collection.InsertMany(ctx, docs)
+		require.NoError(t, err)
+
+		src := source.NewMongoDB(collection)
+		check := func(expr string) error {
+			p, err := predicate.Parse(expr, source.StatsVariables)
+			require.NoError(t, err)
+			return src.CheckStats(ctx, p)
+		}
+
+		require.NoError(t, check("count == 10 AND avgObjSize > 0 AND nindexes == 1"))
+		require.NoError(t, check("count > 100 OR size < 1e6"))
+
+		err = check("count > 100")
+		assert.ErrorIs(t, err, source.ErrCollectionStats)
+		assert.ErrorContains(t, err, "does not satisfy count > 100")
+	})
+
+	t.Run("EarliestCreatedAtFrom", func(t *testing.T) {
+		t.Parallel()
+
+		day1 := time.Date(2024, time.November, 1, 12, 0, 0, 0, time.UTC)
+		day5 := day1.AddDate(0, 0, 4)
+
+		t.Run("by createdAt", func(t *testing.T) {
+			t.Parallel()
+
+			collection := client.Database(uuid.NewString()).Collection("test")
+			_, err := collection.InsertMany(ctx, []any{
+				bson.M{"createdAt": primitive.NewDateTimeFromTime(day1)},
+				bson.M{"createdAt": primitive.NewDateTimeFromTime(day5)},
+			})
+			require.NoError(t, err)
+
+			src := source.NewMongoDB(collection)
+
+			// Days in between hold no documents, so are skipped over
+			earliest, ok, err := src.EarliestCreatedAtFrom(ctx, day1.AddDate(0, 0, 1))
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, day5, earliest)
+
+			_, ok, err = src.EarliestCreatedAtFrom(ctx, day5.Add(time.Second))
+			require.NoError(t, err)
+			assert.False(t, ok)
+		})
+
+		t.Run("by ObjectID fallback", func(t *testing.T) {
+			t.Parallel()
+
+			collection := client.Database(uuid.NewString()).Collection("test")
+			_, err := collection.InsertMany(ctx, []any{
+				bson.M{"_id": primitive.NewObjectIDFromTimestamp(day1)},
+				bson.M{"_id": primitive.NewObjectIDFromTimestamp(day5)},
+			})
+			require.NoError(t, err)
+
+			src := source.NewMongoDB(collection, source.WithObjectIDFallback())
+			_, err = src.EarliestCreatedAt(ctx)
+			require.NoError(t, err)
+
+			earliest, ok, err := src.EarliestCreatedAtFrom(ctx, day1.AddDate(0, 0, 1))
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, day5, earliest)
+		})
+	})
+
+	t.Run("AverageDocumentSize", func(t *testing.T) {
+		t.Parallel()
+
+		collection := client.Database(uuid.NewString()).Collection("test")
+		_, err := collection.InsertMany(ctx, []any{
+			bson.M{"createdAt": primitive.NewDateTimeFromTime(time.Now())},
+			bson.M{"createdAt": primitive.NewDateTimeFromTime(time.Now())},
+		})
+		require.NoError(t, err)
+
+		size, err := source.NewMongoDB(collection).AverageDocumentSize(ctx)
+		require.NoError(t, err)
+		assert.Positive(t, size)
+	})
+
 	t.Run("EarliestCreatedAt", func(t *testing.T) {
 		t.Parallel()
 
@@ -84,6 +735,316 @@ func TestMongoDB(t *testing.T) {
 		assert.Equal(t, expected, earliest)
 	})
 
+	t.Run("IDRange", func(t *testing.T) {
+		t.Parallel()
+
+		date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		ids := make([]primitive.ObjectID, 0, 5)
+		collection := client.Database(uuid.NewString()).Collection("test")
+		for i := range 5 {
+			id := primitive.NewObjectIDFromTimestamp(date.Add(time.Hour * time.Duration(i)))
+			ids = append(ids, id)
+			_, err := collection.InsertOne(ctx, bson.M{
+				"_id":       id,
+				"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * time.Duration(i))),
+			})
+			require.NoError(t, err)
+		}
+		// Within the range, but on the following day
+		next := primitive.NewObjectIDFromTimestamp(date.Add(time.Hour * 25))
+		_, err := collection.InsertOne(ctx, bson.M{
+			"_id":       next,
+			"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 25)),
+		})
+		require.NoError(t, err)
+
+		src := source.NewMongoDB(collection, source.WithIDRange(source.IDRange{Min: ids[1], Max: next}))
+
+		earliest, err := src.EarliestCreatedAt(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, date.Add(time.Hour), earliest.UTC())
+
+		var found []string
+		res := src.FindAllFromDate(ctx, date)
+		for doc := range res.Iter(ctx) {
+			var decoded struct {
+				ID struct {
+					OID string `json:"$oid"`
+				} `json:"_id"`
+			}
+			require.NoError(t, json.Unmarshal(doc, &decoded))
+			found = append(found, decoded.ID.OID)
+		}
+		require.NoError(t, res.Err())
+		assert.ElementsMatch(t, []string{ids[1].Hex(), ids[2].Hex(), ids[3].Hex(), ids[4].Hex()}, found)
+
+		count, err := src.CountFromDate(ctx, date)
+		require.NoError(t, err)
+		assert.Equal(t, 4, count)
+
+		// Only the documents within the range are deleted, leaving the first
+		deleted, err := src.DeleteAllFromDate(ctx, date)
+		require.NoError(t, err)
+		assert.Equal(t, 4, deleted)
+
+		remaining, err := collection.CountDocuments(ctx, bson.M{})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), remaining)
+		assert.NoError(t, collection.FindOne(ctx, bson.M{"_id": ids[0]}).Err())
+
+		// An upper bound alone excludes the following day
+		src = source.NewMongoDB(collection, source.WithIDRange(source.IDRange{Max: ids[4]}))
+		count, err = src.CountBefore(ctx, date.AddDate(0, 0, 2))
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("ObjectID fallback", func(t *testing.T) {
+		t.Parallel()
+
+		date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		ids := []primitive.ObjectID{
+			primitive.NewObjectIDFromTimestamp(date.Add(time.Hour * -2)),
+			primitive.NewObjectIDFromTimestamp(date),
+			primitive.NewObjectIDFromTimestamp(date.Add(time.Hour * 3)),
+			primitive.NewObjectIDFromTimestamp(date.Add(time.Hour * 24)),
+		}
+
+		// No document holds createdAt
+		collection := client.Database(uuid.NewString()).Collection("test")
+		for _, id := range ids {
+			_, err := collection.InsertOne(ctx, bson.M{"_id": id, "type": "event"})
+			require.NoError(t, err)
+		}
+
+		src := source.NewMongoDB(collection, source.WithObjectIDFallback())
+		earliest, err := src.EarliestCreatedAt(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, date.Add(time.Hour*-2), earliest)
+		assert.Equal(t, date.AddDate(0, 0, -1), src.DayOf(earliest))
+
+		var found []string
+		res := src.FindAllFromDate(ctx, date)
+		for doc := range res.Iter(ctx) {
+			var decoded struct {
+				ID struct {
+					OID string `json:"$oid"`
+				} `json:"_id"`
+			}
+			require.NoError(t, json.Unmarshal(doc, &decoded))
+			found = append(found, decoded.ID.OID)
+		}
+		require.NoError(t, res.Err())
+		assert.ElementsMatch(t, []string{ids[1].Hex(), ids[2].Hex()}, found)
+
+		count, err := src.CountFromDate(ctx, date)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		count, err = src.CountBefore(ctx, date.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		assert.Equal(t, 3, count)
+
+		deleted, err := src.DeleteAllFromDate(ctx, date)
+		require.NoError(t, err)
+		assert.Equal(t, 2, deleted)
+
+		remaining, err := collection.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": bson.A{ids[0], ids[3]}}})
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, remaining)
+
+		// Without the fallback, there's no earliest createdAt to be found
+		_, err = source.NewMongoDB(collection).EarliestCreatedAt(ctx)
+		assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+	})
+
+	t.Run("ID range fast path", func(t *testing.T) {
+		t.Parallel()
+
+		date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		offsets := []time.Duration{
+			time.Hour * -2,
+			time.Millisecond * -500, // the _id timestamp truncates to the same second
+			0,
+			time.Hour * 3,
+			time.Hour*24 - time.Millisecond,
+			time.Hour * 24,
+			time.Hour * 30,
+		}
+
+		// createdAt is monotonic with _id, as when both are assigned on insert. Each document is inserted into two
+		// collections, so that deletes by either path can be compared.
+		standard := client.Database(uuid.NewString()).Collection("test")
+		fast := client.Database(uuid.NewString()).Collection("test")
+		for i := len(offsets) - 1; i >= 0; i-- {
+			createdAt := date.Add(offsets[i])
+			doc := bson.M{
+				"_id":       primitive.NewObjectIDFromTimestamp(createdAt),
+				"createdAt": primitive.NewDateTimeFromTime(createdAt),
+			}
+			_, err := standard.InsertOne(ctx, doc)
+			require.NoError(t, err)
+			_, err = fast.InsertOne(ctx, doc)
+			require.NoError(t, err)
+		}
+
+		standardSrc := source.NewMongoDB(standard)
+		fastSrc := source.NewMongoDB(fast, source.WithIDRangeFastPath())
+
+		standardEarliest, err := standardSrc.EarliestCreatedAt(ctx)
+		require.NoError(t, err)
+		fastEarliest, err := fastSrc.EarliestCreatedAt(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, standardSrc.DayOf(standardEarliest), fastSrc.DayOf(fastEarliest))
+
+		ids := func(res source.StreamingResult) []string {
+			var found []string
+			for doc := range res.Iter(ctx) {
+				var decoded struct {
+					ID struct {
+						OID string `json:"$oid"`
+					} `json:"_id"`
+				}
+				require.NoError(t, json.Unmarshal(doc, &decoded))
+				found = append(found, decoded.ID.OID)
+			}
+			require.NoError(t, res.Err())
+			return found
+		}
+
+		for day := date.AddDate(0, 0, -1); day.Before(date.AddDate(0, 0, 3)); day = day.AddDate(0, 0, 1) {
+			standardIDs := ids(standardSrc.FindAllFromDate(ctx, day))
+			fastIDs := ids(fastSrc.FindAllFromDate(ctx, day))
+			assert.ElementsMatch(t, standardIDs, fastIDs, day)
+			assert.IsIncreasing(t, fastIDs, day)
+
+			standardCount, err := standardSrc.CountFromDate(ctx, day)
+			require.NoError(t, err)
+			fastCount, err := fastSrc.CountFromDate(ctx, day)
+			require.NoError(t, err)
+			assert.Equal(t, standardCount, fastCount, day)
+
+			standardBefore, err := standardSrc.CountBefore(ctx, day)
+			require.NoError(t, err)
+			fastBefore, err := fastSrc.CountBefore(ctx, day)
+			require.NoError(t, err)
+			assert.Equal(t, standardBefore, fastBefore, day)
+		}
+
+		// Resuming within a day keeps to the day's range of _ids
+		afterID := primitive.NewObjectIDFromTimestamp(date)
+		resumed := ids(fastSrc.FindAllFromDateAfterID(ctx, date, json.RawMessage(`{"$oid":"`+afterID.Hex()+`"}`)))
+		assert.Equal(t, []string{
+			primitive.NewObjectIDFromTimestamp(date.Add(time.Hour * 3)).Hex(),
+			primitive.NewObjectIDFromTimestamp(date.Add(time.Hour*24 - time.Millisecond)).Hex(),
+		}, resumed)
+
+		for day := date.AddDate(0, 0, -1); day.Before(date.AddDate(0, 0, 2)); day = day.AddDate(0, 0, 1) {
+			standardDeleted, err := standardSrc.DeleteAllFromDate(ctx, day)
+			require.NoError(t, err)
+			fastDeleted, err := fastSrc.DeleteAllFromDate(ctx, day)
+			require.NoError(t, err)
+			assert.Equal(t, standardDeleted, fastDeleted, day)
+		}
+		for _, collection := range []*mongo.Collection{standard, fast} {
+			remaining, err := collection.CountDocuments(ctx, bson.M{})
+			require.NoError(t, err)
+			assert.Zero(t, remaining)
+		}
+	})
+
+	t.Run("intra day parallelism", func(t *testing.T) {
+		t.Parallel()
+
+		date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		// Documents spread across the day, including either side of each sub-range's bounds, and either side of the day
+		collection := client.Database(uuid.NewString()).Collection("test")
+		var want []string
+		for offset := -time.Hour; offset <= time.Hour*25; offset += time.Minute * 7 {
+			createdAt := date.Add(offset)
+			id := primitive.NewObjectIDFromTimestamp(createdAt)
+			_, err := collection.InsertOne(ctx, bson.M{"_id": id, "createdAt": primitive.NewDateTimeFromTime(createdAt)})
+			require.NoError(t, err)
+			if !createdAt.Before(date) && createdAt.Before(date.AddDate(0, 0, 1)) {
+				want = append(want, id.Hex())
+			}
+		}
+		for _, cut := range source.DayCuts(date, 4) {
+			for _, createdAt := range []time.Time{cut.Add(-time.Millisecond), cut} {
+				id := primitive.NewObjectID()
+				_, err := collection.InsertOne(ctx, bson.M{"_id": id, "createdAt": primitive.NewDateTimeFromTime(createdAt)})
+				require.NoError(t, err)
+				want = append(want, id.Hex())
+			}
+		}
+
+		ids := func(res source.StreamingResult) []string {
+			var found []string
+			for doc := range res.Iter(ctx) {
+				var decoded struct {
+					ID struct {
+						OID string `json:"$oid"`
+					} `json:"_id"`
+				}
+				require.NoError(t, json.Unmarshal(doc, &decoded))
+				found = append(found, decoded.ID.OID)
+			}
+			require.NoError(t, res.Err())
+			return found
+		}
+
+		src := source.NewMongoDB(collection, source.WithIntraDayParallelism(4))
+		assert.ElementsMatch(t, want, ids(src.FindAllFromDate(ctx, date)))
+
+		// Stopping early cancels the remaining reads, without reporting an error
+		res := src.FindAllFromDate(ctx, date)
+		for range res.Iter(ctx) {
+			break
+		}
+		assert.NoError(t, res.Err())
+
+		// The delete covers the whole day at once
+		deleted, err := src.DeleteAllFromDate(ctx, date)
+		require.NoError(t, err)
+		assert.Equal(t, len(want), deleted)
+		assert.Empty(t, ids(src.FindAllFromDate(ctx, date)))
+	})
+
+	t.Run("ObjectID fallback with createdAt", func(t *testing.T) {
+		t.Parallel()
+
+		date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		// A single document holding createdAt means the field exists, so there's nothing to fall back from
+		collection := client.Database(uuid.NewString()).Collection("test")
+		_, err := collection.InsertMany(ctx, []any{
+			bson.M{"_id": primitive.NewObjectIDFromTimestamp(date.AddDate(0, 0, -10))},
+			bson.M{"_id": primitive.NewObjectIDFromTimestamp(date), "createdAt": primitive.NewDateTimeFromTime(date)},
+		})
+		require.NoError(t, err)
+
+		src := source.NewMongoDB(collection, source.WithObjectIDFallback())
+		earliest, err := src.EarliestCreatedAt(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, date, earliest)
+
+		count, err := src.CountFromDate(ctx, date.AddDate(0, 0, -10))
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+
+	t.Run("ObjectID fallback without ObjectIDs", func(t *testing.T) {
+		t.Parallel()
+
+		collection := client.Database(uuid.NewString()).Collection("test")
+		_, err := collection.InsertOne(ctx, bson.M{"_id": 1})
+		require.NoError(t, err)
+
+		_, err = source.NewMongoDB(collection, source.WithObjectIDFallback()).EarliestCreatedAt(ctx)
+		assert.ErrorContains(t, err, "is not an ObjectID")
+	})
+
 	t.Run("DeleteAllFromDate", func(t *testing.T) {
 		t.Parallel()
 
@@ -127,6 +1088,155 @@ func TestMongoDB(t *testing.T) {
 		assert.Equal(t, "5d6fd699ee45770009e17140", docs[0].ID.Hex()) // doc1
 		assert.Equal(t, "5d6fdf85451f58001939950a", docs[1].ID.Hex()) // doc4
 	})
+
+	t.Run("TTL mark", func(t *testing.T) {
+		t.Parallel()
+
+		date := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+		window := time.Hour * 24 * 7
+
+		doc1 := bson.M{
+			"_id":       objectIDFromHex(t, "5d6fd699ee45770009e17140"),
+			"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour)),
+		}
+		doc2 := bson.M{
+			"_id":       objectIDFromHex(t, "5d6fd8ec10ca90000998cf31"),
+			"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 3)),
+		}
+		doc3 := bson.M{
+			"_id":       objectIDFromHex(t, "5d6fdf658a583b0009929c06"),
+			"createdAt": primitive.NewDateTimeFromTime(date.Add(time.Hour * 24)),
+		}
+
+		newCollection := func(t *testing.T) *mongo.Collection {
+			collection := client.Database(uuid.NewString()).Collection("test")
+			_, err := collection.InsertMany(ctx, []any{doc1, doc2, doc3})
+			require.NoError(t, err)
+			_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.M{"deleteAfter": 1},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			})
+			require.NoError(t, err)
+			return collection
+		}
+
+		// expiries returns the deleteAfter of each document still in the collection, by _id
+		expiries := func(t *testing.T, collection *mongo.Collection) map[string]time.Time {
+			cursor, err := collection.Find(ctx, bson.M{})
+			require.NoError(t, err)
+			var docs []struct {
+				ID          primitive.ObjectID `bson:"_id"`
+				DeleteAfter time.Time          `bson:"deleteAfter"`
+			}
+			require.NoError(t, cursor.All(ctx, &docs))
+			expiries := make(map[string]time.Time, len(docs))
+			for _, doc := range docs {
+				expiries[doc.ID.Hex()] = doc.DeleteAfter
+			}
+			return expiries
+		}
+
+		t.Run("marks rather than deletes the day", func(t *testing.T) {
+			t.Parallel()
+
+			collection := newCollection(t)
+			src := source.NewMongoDB(collection, source.WithTTLMark("deleteAfter", window))
+			require.NoError(t, src.CheckDeletable(ctx))
+
+			before := time.Now()
+			total, err := src.DeleteAllFromDate(ctx, date)
+			require.NoError(t, err)
+			assert.Equal(t, 2, total) // doc1 and doc2
+
+			// Every document remains, with those of the day marked to expire once the window has passed
+			marked := expiries(t, collection)
+			require.Len(t, marked, 3)
+			for _, id := range []string{"5d6fd699ee45770009e17140", "5d6fd8ec10ca90000998cf31"} {
+				assert.WithinDuration(t, before.Add(window), marked[id], time.Minute)
+			}
+			assert.True(t, marked["5d6fdf658a583b0009929c06"].IsZero()) // doc3
+
+			// Marked documents are no longer found, counted or marked again
+			res := src.FindAllFromDate(ctx, date)
+			for range res.Iter(ctx) {
+				assert.Fail(t, "marked document found")
+			}
+			require.NoError(t, res.Err())
+			count, err := src.CountFromDate(ctx, date)
+			require.NoError(t, err)
+			assert.Zero(t, count)
+			total, err = src.DeleteAllFromDate(ctx, date)
+			require.NoError(t, err)
+			assert.Zero(t, total)
+
+			earliest, err := src.EarliestCreatedAt(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, date.Add(time.Hour*24), earliest) // doc3
+		})
+
+		t.Run("marks rather than deletes by id", func(t *testing.T) {
+			t.Parallel()
+
+			collection := newCollection(t)
+			src := source.NewMongoDB(collection, source.WithTTLMark("deleteAfter", window))
+
+			total, err := src.DeleteByIDs(ctx, []json.RawMessage{json.RawMessage(`{"$oid":"5d6fd8ec10ca90000998cf31"}`)})
+			require.NoError(t, err)
+			assert.Equal(t, 1, total)
+
+			marked := expiries(t, collection)
+			require.Len(t, marked, 3)
+			assert.False(t, marked["5d6fd8ec10ca90000998cf31"].IsZero()) // doc2
+			assert.True(t, marked["5d6fd699ee45770009e17140"].IsZero())  // doc1
+		})
+
+		t.Run("requires a TTL index", func(t *testing.T) {
+			t.Parallel()
+
+			collection := client.Database(uuid.NewString()).Collection("test")
+			_, err := collection.InsertOne(ctx, doc1)
+			require.NoError(t, err)
+			_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.M{"deleteAfter": 1}})
+			require.NoError(t, err)
+
+			err = source.NewMongoDB(collection, source.WithTTLMark("deleteAfter", window)).CheckDeletable(ctx)
+			assert.ErrorIs(t, err, source.ErrNoTTLIndex)
+			assert.NoError(t, source.NewMongoDB(collection).CheckDeletable(ctx))
+		})
+	})
+}
+
+func TestMongoDB_IndexHint(t *testing.T) {
+	t.Parallel()
+
+	src := source.NewMongoDB(nil)
+	assert.Nil(t, src.FindOptions().Hint)
+	assert.Nil(t, src.CountOptions().Hint)
+
+	src = source.NewMongoDB(nil, source.WithIndexHint("createdAt_1"))
+	assert.Equal(t, "createdAt_1", src.FindOptions().Hint)
+	assert.Equal(t, "createdAt_1", src.CountOptions().Hint)
+}
+
+func TestMongoDB_MaxTime(t *testing.T) {
+	t.Parallel()
+
+	src := source.NewMongoDB(nil)
+	assert.Nil(t, src.FindOptions().MaxTime)
+	assert.Nil(t, src.CountOptions().MaxTime)
+	assert.Nil(t, src.FindOneOptions().MaxTime)
+	assert.Nil(t, src.AggregateOptions().MaxTime)
+
+	src = source.NewMongoDB(nil, source.WithMaxTime(30*time.Second))
+	for _, maxTime := range []*time.Duration{
+		src.FindOptions().MaxTime,
+		src.CountOptions().MaxTime,
+		src.FindOneOptions().MaxTime,
+		src.AggregateOptions().MaxTime,
+	} {
+		require.NotNil(t, maxTime)
+		assert.Equal(t, 30*time.Second, *maxTime)
+	}
 }
 
 func objectIDFromHex(t *testing.T, hex string) primitive.ObjectID {
@@ -0,0 +1,64 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// EarliestCreatedAtFrom returns the earliest createdAt at or after from, the earliest computed date when using a date
+// expression, or the earliest _id timestamp when falling back to ObjectIDs, reporting false should there be none. It
+// allows days without documents to be skipped in a single query, rather than by visiting each of them.
+func (a *MongoDB) EarliestCreatedAtFrom(ctx context.Context, from time.Time) (time.Time, bool, error) {
+	release, err := a.limiter.acquire(ctx, "earliest")
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer release()
+
+	var earliest time.Time
+	switch {
+	case !a.dateExpr.IsZero():
+		earliest, err = a.earliestComputed(ctx, bson.M{
+			"$expr": bson.M{
+				"$and": bson.A{
+					bson.M{"$eq": bson.A{bson.M{"$type": a.dateExpr}, "date"}},
+					bson.M{"$gte": bson.A{a.dateExpr, from}},
+				},
+			},
+		})
+	case a.useID:
+		earliest, err = a.earliestField(ctx, "_id", primitive.NewObjectIDFromTimestamp(from))
+	default:
+		earliest, err = a.earliestField(ctx, "createdAt", from)
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return time.Time{}, false, nil
+	}
+	return earliest, err == nil, err
+}
+
+// earliestField returns the time held by the earliest of the documents whose field is at least from, being either
+// createdAt or an ObjectID _id
+func (a *MongoDB) earliestField(ctx context.Context, field string, from any) (time.Time, error) {
+	res := a.collection.FindOne(
+		ctx,
+		a.scoped(bson.M{field: bson.M{"$gte": from}}),
+		a.findOneOptions().
+			SetSort(bson.M{field: 1}).
+			SetProjection(bson.M{field: 1}),
+	)
+	raw, err := res.Raw()
+	if err != nil {
+		return time.Time{}, err
+	}
+	value := raw.Lookup(field)
+	if id, ok := value.ObjectIDOK(); ok {
+		return id.Timestamp().UTC(), nil
+	}
+	return value.Time().UTC(), nil
+}
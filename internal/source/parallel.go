@@ -0,0 +1,133 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"slices"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// parallelBufferSize is the number of documents each cursor may read ahead of those yielded
+const parallelBufferSize = 64
+
+// WithIntraDayParallelism splits each day into n equal sub-ranges of createdAt, or of _id when days are selected by
+// _id, which are read by n cursors at once, so that reading a single enormous day isn't bound by one cursor. Documents
+// from every sub-range are interleaved in whatever order they're read, so documents are only in order within each
+// sub-range, regardless of sorting. Deletes and counts still cover the whole day at once. Date expressions are
+// unsupported, as sub-ranges can't be served by an index on a computed date.
+func WithIntraDayParallelism(n int) MongoDBOption {
+	return func(m *MongoDB) {
+		m.intraDayParallelism = n
+	}
+}
+
+// dayCuts returns the n-1 times splitting the day starting at the supplied time into n equal sub-ranges
+func dayCuts(day time.Time, n int) []time.Time {
+	cuts := make([]time.Time, 0, n-1)
+	for i := 1; i < n; i++ {
+		cuts = append(cuts, day.Add(time.Hour*24*time.Duration(i)/time.Duration(n)))
+	}
+	return cuts
+}
+
+// subRangeFilter matches documents created within [from, until), with a zero time leaving that end unbounded. The
+// first and last sub-ranges of a day are left unbounded, so that together they cover the day whatever its boundary.
+func (a *MongoDB) subRangeFilter(from, until time.Time) bson.M {
+	field, bound := "createdAt", func(t time.Time) any { return t }
+	if a.useID {
+		field, bound = "_id", func(t time.Time) any { return primitive.NewObjectIDFromTimestamp(t) }
+	}
+	within := bson.M{}
+	if !from.IsZero() {
+		within["$gte"] = bound(from)
+	}
+	if !until.IsZero() {
+		within["$lt"] = bound(until)
+	}
+	return bson.M{field: within}
+}
+
+// findDayInParallel resolves all documents assigned to the date by reading each of its sub-ranges at once
+func (a *MongoDB) findDayInParallel(date time.Time, opts *options.FindOptions) StreamingResult {
+	cuts := dayCuts(date.Truncate(time.Hour*24), a.intraDayParallelism)
+	bounds := append(append([]time.Time{{}}, cuts...), time.Time{})
+
+	pr := &parallelStreamingResult{}
+	for i := range len(bounds) - 1 {
+		filter := bson.M{"$and": bson.A{a.dayFilter(date), a.subRangeFilter(bounds[i], bounds[i+1])}}
+		pr.results = append(pr.results, &mongoStreamingResult{
+			find: func(ctx context.Context) (*mongo.Cursor, error) {
+				return a.collection.Find(ctx, filter, opts)
+			},
+			limiter:          a.limiter,
+			plainFields:      a.plainFields,
+			canonicalSpecial: a.canonicalSpecial,
+			renames:          a.renames,
+		})
+	}
+	return pr
+}
+
+// parallelStreamingResult streams the documents of several results at once, yielding each as it's read
+type parallelStreamingResult struct {
+	results []*mongoStreamingResult
+	stopped bool // whether iteration was stopped early, cancelling the remaining reads
+}
+
+func (pr *parallelStreamingResult) Iter(ctx context.Context) iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		docs := make(chan []byte, len(pr.results)*parallelBufferSize)
+		var wg sync.WaitGroup
+		for _, res := range pr.results {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for doc := range res.Iter(ctx) {
+					// Copied, as the memory backing each document is reused once the cursor advances
+					select {
+					case docs <- slices.Clone(doc):
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+		}
+		go func() {
+			wg.Wait()
+			close(docs)
+		}()
+
+		for doc := range docs {
+			if !yield(doc) {
+				pr.stopped = true
+				cancel()
+				for range docs {
+					// Drained until every read has stopped
+				}
+				return
+			}
+		}
+	}
+}
+
+// Err reports the errors of every sub-range, other than those caused by iteration being stopped early
+func (pr *parallelStreamingResult) Err() error {
+	if pr.stopped {
+		return nil
+	}
+	errs := make([]error, 0, len(pr.results))
+	for _, res := range pr.results {
+		errs = append(errs, res.Err())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,84 @@
+package source_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+)
+
+func TestDayCuts(t *testing.T) {
+	t.Parallel()
+
+	day := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Empty(t, source.DayCuts(day, 1))
+	assert.Equal(t, []time.Time{day.Add(time.Hour * 12)}, source.DayCuts(day, 2))
+	assert.Equal(t, []time.Time{
+		day.Add(time.Hour * 6),
+		day.Add(time.Hour * 12),
+		day.Add(time.Hour * 18),
+	}, source.DayCuts(day, 4))
+
+	// Days not dividing evenly are split as evenly as they can be
+	cuts := source.DayCuts(day, 7)
+	assert.Len(t, cuts, 6)
+	assert.IsIncreasing(t, cuts)
+	assert.True(t, cuts[5].Before(day.AddDate(0, 0, 1)))
+}
+
+func TestParallelStreamingResult(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sets := make([][]any, 4)
+	var want []int
+	for i := range 1000 {
+		sets[i%len(sets)] = append(sets[i%len(sets)], bson.D{{Key: "_id", Value: i}})
+		want = append(want, i)
+	}
+
+	t.Run("yields every document of every sub-range", func(t *testing.T) {
+		t.Parallel()
+
+		res, err := source.NewParallelResult(sets...)
+		require.NoError(t, err)
+
+		var found []int
+		for doc := range res.Iter(ctx) {
+			var decoded struct {
+				ID struct {
+					Int string `json:"$numberInt"`
+				} `json:"_id"`
+			}
+			require.NoError(t, json.Unmarshal(doc, &decoded))
+			var id int
+			require.NoError(t, json.Unmarshal([]byte(decoded.ID.Int), &id))
+			found = append(found, id)
+		}
+		require.NoError(t, res.Err())
+		assert.ElementsMatch(t, want, found)
+	})
+
+	t.Run("stops early", func(t *testing.T) {
+		t.Parallel()
+
+		res, err := source.NewParallelResult(sets...)
+		require.NoError(t, err)
+
+		var found int
+		for range res.Iter(ctx) {
+			if found++; found == 10 {
+				break
+			}
+		}
+		assert.Equal(t, 10, found)
+		assert.NoError(t, res.Err())
+	})
+}
@@ -0,0 +1,171 @@
+package source
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// plainFields holds dotted field paths which should be rendered as plain JSON rather than extended JSON
+type plainFields map[string]struct{}
+
+func newPlainFields(paths []string) plainFields {
+	fields := make(plainFields, len(paths))
+	for _, p := range paths {
+		fields[p] = struct{}{}
+	}
+	return fields
+}
+
+// within reports whether any plain field is nested beneath the supplied path
+func (pf plainFields) within(path string) bool {
+	for p := range pf {
+		if strings.HasPrefix(p, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// appendDocument appends the document to dst, rendered as canonical extended JSON aside from the plain fields which are
+// rendered as plain JSON. Field order is preserved.
+func (pf plainFields) appendDocument(dst []byte, doc bson.Raw, prefix string) ([]byte, error) {
+	elems, err := doc.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	dst = append(dst, '{')
+	for i, elem := range elems {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		if dst, err = appendJSONString(dst, elem.Key()); err != nil {
+			return nil, err
+		}
+		dst = append(dst, ':')
+
+		path := elem.Key()
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+
+		val := elem.Value()
+		switch _, plain := pf[path]; {
+		case plain:
+			var out []byte
+			if out, err = plainValue(val); err == nil {
+				dst = append(dst, out...)
+			}
+		case val.Type == bson.TypeEmbeddedDocument && pf.within(path):
+			dst, err = pf.appendDocument(dst, val.Document(), path)
+		default:
+			dst, err = appendExtJSONValue(dst, val)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(dst, '}'), nil
+}
+
+// appendJSONString appends the string to dst as a JSON string, exactly as json.Marshal would render it. Strings
+// needing no escaping, as almost all field names do, are appended directly, avoiding an allocation.
+func appendJSONString(dst []byte, s string) ([]byte, error) {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c >= 0x80 || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			quoted, err := json.Marshal(s)
+			if err != nil {
+				return nil, err
+			}
+			return append(dst, quoted...), nil
+		}
+	}
+	dst = append(dst, '"')
+	dst = append(dst, s...)
+	return append(dst, '"'), nil
+}
+
+// appendExtJSONValue appends a single value to dst, rendered as canonical extended JSON
+func appendExtJSONValue(dst []byte, val bson.RawValue) ([]byte, error) {
+	// The driver only appends correctly to an empty slice, so the value is rendered into a scratch buffer first
+	scratch := getBuffer()
+	defer putBuffer(scratch)
+
+	wrapped, err := bson.MarshalExtJSONAppend((*scratch)[:0], bson.D{{Key: "v", Value: val}}, true, false)
+	if err != nil {
+		return nil, err
+	}
+	*scratch = wrapped
+	// Strip the wrapping document, i.e. {"v":...}
+	return append(dst, wrapped[len(`{"v":`):len(wrapped)-1]...), nil
+}
+
+// plainValue renders a value as plain JSON. This is lossy - numeric types are not distinguished, dates become RFC 3339
+// strings, and object ids and decimals become strings. Types without an obvious plain form fall back to relaxed
+// extended JSON.
+func plainValue(val bson.RawValue) ([]byte, error) {
+	switch val.Type {
+	case bson.TypeInt32:
+		return json.Marshal(val.Int32())
+	case bson.TypeInt64:
+		return json.Marshal(val.Int64())
+	case bson.TypeDouble:
+		f := val.Double()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return json.Marshal(val.String())
+		}
+		return json.Marshal(f)
+	case bson.TypeDecimal128:
+		return json.Marshal(val.Decimal128().String())
+	case bson.TypeDateTime:
+		return json.Marshal(val.Time().UTC().Format(time.RFC3339Nano))
+	case bson.TypeObjectID:
+		return json.Marshal(val.ObjectID().Hex())
+	case bson.TypeString:
+		return json.Marshal(val.StringValue())
+	case bson.TypeBoolean:
+		return json.Marshal(val.Boolean())
+	case bson.TypeNull, bson.TypeUndefined:
+		return []byte("null"), nil
+	case bson.TypeEmbeddedDocument:
+		elems, err := val.Document().Elements()
+		if err != nil {
+			return nil, err
+		}
+		fields := make(plainFields, len(elems))
+		for _, elem := range elems {
+			fields[elem.Key()] = struct{}{}
+		}
+		return fields.appendDocument(nil, val.Document(), "")
+	case bson.TypeArray:
+		values, err := val.Array().Values()
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		buf.WriteByte('[')
+		for i, v := range values {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			out, err := plainValue(v)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(out)
+		}
+		buf.WriteByte(']')
+		return buf.Bytes(), nil
+	default:
+		wrapped, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: val}}, false, false)
+		if err != nil {
+			return nil, err
+		}
+		return wrapped[len(`{"v":`) : len(wrapped)-1], nil
+	}
+}
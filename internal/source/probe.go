@@ -0,0 +1,66 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// ErrNoDateIndex is returned when no index of the collection leads with createdAt, so every query selecting documents
+// by day would scan the collection
+var ErrNoDateIndex = errors.New("no createdAt index")
+
+// Ping checks that the primary of the deployment can be reached, as deletes must be sent to it
+func (a *MongoDB) Ping(ctx context.Context) error {
+	return a.collection.Database().Client().Ping(ctx, readpref.Primary())
+}
+
+// CheckDateIndex refuses with ErrNoDateIndex should no index of the collection lead with createdAt
+func (a *MongoDB) CheckDateIndex(ctx context.Context) error {
+	specs, err := a.collection.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list indexes: %w", err)
+	}
+	if !slices.ContainsFunc(specs, func(spec *mongo.IndexSpecification) bool {
+		return leadingKey(spec.KeysDocument) == "createdAt"
+	}) {
+		return fmt.Errorf("%w: %s", ErrNoDateIndex, a.collection.Name())
+	}
+	return nil
+}
+
+// leadingKey returns the first key of an index's keys document, or an empty string should it have none
+func leadingKey(keys bson.Raw) string {
+	elems, err := keys.Elements()
+	if err != nil || len(elems) == 0 {
+		return ""
+	}
+	return elems[0].Key()
+}
+
+// ProbeRead checks that documents can be read from the collection, reading at most a single _id. An empty collection
+// passes, as the query is still authorized.
+func (a *MongoDB) ProbeRead(ctx context.Context) error {
+	err := a.collection.FindOne(ctx, bson.M{}, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	return nil
+}
+
+// ProbeDelete checks that documents can be deleted from the collection documents are deleted from, without deleting
+// any. The collection mustn't be a view, and a delete matching no documents is sent, which the server authorizes as
+// any other.
+func (a *MongoDB) ProbeDelete(ctx context.Context) error {
+	if err := a.CheckDeletable(ctx); err != nil {
+		return err
+	}
+	_, err := a.deletes.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": bson.A{}}})
+	return err
+}
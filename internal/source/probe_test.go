@@ -0,0 +1,51 @@
+package source_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+)
+
+func TestLeadingKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		keys     bson.D
+		expected string
+	}{
+		{
+			name:     "single key",
+			keys:     bson.D{{Key: "createdAt", Value: 1}},
+			expected: "createdAt",
+		},
+		{
+			name:     "compound",
+			keys:     bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: 1}},
+			expected: "createdAt",
+		},
+		{
+			name:     "trailing key",
+			keys:     bson.D{{Key: "tenant", Value: 1}, {Key: "createdAt", Value: 1}},
+			expected: "tenant",
+		},
+		{
+			name:     "empty",
+			keys:     bson.D{},
+			expected: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			keys, err := bson.Marshal(tt.keys)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, source.LeadingKey(keys))
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Provenance identifies the mongo deployment documents are archived from, so that readers of an archive know exactly
+// where its data came from when several clusters are archived to the same place
+type Provenance struct {
+	// Host is the host and port of the member connected to, or the hostname of a standalone server
+	Host string `json:"host,omitempty"`
+	// ReplicaSet is the name of the replica set connected to, if any
+	ReplicaSet    string `json:"replicaSet,omitempty"`
+	ServerVersion string `json:"serverVersion"`
+}
+
+// ServerProvenance resolves the provenance of the server connected to from the hello and buildInfo commands, neither
+// of which modify anything. Standalone servers don't report their address to hello, so their hostname is resolved
+// from hostInfo where permitted.
+func ServerProvenance(ctx context.Context, client *mongo.Client) (Provenance, error) {
+	admin := client.Database("admin")
+
+	var hello struct {
+		Me      string `bson:"me"`
+		SetName string `bson:"setName"`
+	}
+	if err := admin.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return Provenance{}, fmt.Errorf("failed to run hello: %w", err)
+	}
+	var buildInfo struct {
+		Version string `bson:"version"`
+	}
+	if err := admin.RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&buildInfo); err != nil {
+		return Provenance{}, fmt.Errorf("failed to run buildInfo: %w", err)
+	}
+	if buildInfo.Version == "" {
+		return Provenance{}, errors.New("server did not report its version")
+	}
+
+	p := Provenance{
+		Host:          hello.Me,
+		ReplicaSet:    hello.SetName,
+		ServerVersion: buildInfo.Version,
+	}
+	if p.Host == "" {
+		var hostInfo struct {
+			System struct {
+				Hostname string `bson:"hostname"`
+			} `bson:"system"`
+		}
+		// Requires the hostInfo privilege, without which the host is left unknown
+		if err := admin.RunCommand(ctx, bson.D{{Key: "hostInfo", Value: 1}}).Decode(&hostInfo); err == nil {
+			p.Host = hostInfo.System.Hostname
+		}
+	}
+	return p, nil
+}
+
+// Metadata renders the provenance as object metadata, omitting anything unknown
+func (p Provenance) Metadata() map[string]string {
+	metadata := map[string]string{"mongo-server-version": p.ServerVersion}
+	if p.Host != "" {
+		metadata["mongo-host"] = p.Host
+	}
+	if p.ReplicaSet != "" {
+		metadata["mongo-replica-set"] = p.ReplicaSet
+	}
+	return metadata
+}
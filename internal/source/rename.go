@@ -0,0 +1,131 @@
+package source
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Renames holds the fields which should be archived under a different name, grouped by the path of the document
+// holding them. Fields are only ever renamed in place, never moved to a different parent document.
+type Renames struct {
+	byParent map[string]map[string]string // parent path -> old name -> new name
+}
+
+// ParseRenames parses renames supplied in the form old=new, e.g. userId=user_id. Nested fields are addressed by their
+// dotted path, with the new name either being the new field name alone or a path beneath the same parent, e.g.
+// meta.userId=user_id or meta.userId=meta.user_id.
+func ParseRenames(values []string) (Renames, error) {
+	r := Renames{byParent: make(map[string]map[string]string)}
+	targets := make(map[string]string)
+	for _, value := range values {
+		from, to, ok := strings.Cut(value, "=")
+		if !ok || from == "" || to == "" {
+			return Renames{}, fmt.Errorf("invalid rename %q, expected old=new", value)
+		}
+
+		parent, oldName := splitPath(from)
+		if strings.Contains(to, ".") {
+			toParent, newName := splitPath(to)
+			if toParent != parent {
+				return Renames{}, fmt.Errorf("invalid rename %q, fields cannot be moved to a different parent", value)
+			}
+			to = newName
+		}
+
+		if _, exists := r.byParent[parent][oldName]; exists {
+			return Renames{}, fmt.Errorf("invalid rename %q, %s is renamed more than once", value, from)
+		}
+		target := joinPath(parent, to)
+		if other, exists := targets[target]; exists {
+			return Renames{}, fmt.Errorf("invalid rename %q, %s is also renamed to %s", value, other, target)
+		}
+		targets[target] = from
+
+		if r.byParent[parent] == nil {
+			r.byParent[parent] = make(map[string]string)
+		}
+		r.byParent[parent][oldName] = to
+	}
+	return r, nil
+}
+
+// empty reports whether there are no renames
+func (r Renames) empty() bool {
+	return len(r.byParent) == 0
+}
+
+// beneath reports whether any renamed field is nested beneath the supplied path
+func (r Renames) beneath(path string) bool {
+	for parent := range r.byParent {
+		if parent == path || strings.HasPrefix(parent, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns a copy of the document with fields renamed. Renaming a field to the name of another field in the same
+// document, which is not itself renamed, is an error, as one of the values would otherwise be lost.
+func (r Renames) apply(doc bson.Raw, prefix string) (bson.Raw, error) {
+	elems, err := doc.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	renamed := r.byParent[prefix]
+	out := make(bson.D, 0, len(elems))
+	seen := make(map[string]string, len(elems))
+	for _, elem := range elems {
+		key := elem.Key()
+		path := joinPath(prefix, key)
+
+		newKey := key
+		if to, ok := renamed[key]; ok {
+			newKey = to
+		}
+		if other, exists := seen[newKey]; exists {
+			from := key
+			if key == newKey {
+				from = other
+			}
+			return nil, fmt.Errorf(
+				"cannot rename %s to %s, as the field already exists",
+				joinPath(prefix, from),
+				joinPath(prefix, newKey),
+			)
+		}
+		seen[newKey] = key
+
+		val := elem.Value()
+		if val.Type == bson.TypeEmbeddedDocument && r.beneath(path) {
+			nested, err := r.apply(val.Document(), path)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, bson.E{Key: newKey, Value: nested})
+			continue
+		}
+		out = append(out, bson.E{Key: newKey, Value: val})
+	}
+
+	return bson.Marshal(out)
+}
+
+// splitPath splits a dotted path into the path of its parent and its final field name
+func splitPath(path string) (parent, name string) {
+	i := strings.LastIndex(path, ".")
+	if i < 0 {
+		return "", path
+	}
+	return path[:i], path[i+1:]
+}
+
+// joinPath appends the field name to the parent path
+func joinPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
@@ -0,0 +1,101 @@
+package source_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+)
+
+func TestParseRenames(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		values []string
+		err    string
+	}{
+		"top level":           {values: []string{"userId=user_id"}},
+		"nested name":         {values: []string{"meta.userId=user_id"}},
+		"nested path":         {values: []string{"meta.userId=meta.user_id"}},
+		"swap":                {values: []string{"a=b", "b=a"}},
+		"missing separator":   {values: []string{"userId"}, err: "expected old=new"},
+		"missing new name":    {values: []string{"userId="}, err: "expected old=new"},
+		"different parent":    {values: []string{"meta.userId=other.user_id"}, err: "different parent"},
+		"renamed twice":       {values: []string{"a=b", "a=c"}, err: "renamed more than once"},
+		"same target":         {values: []string{"a=c", "b=c"}, err: "also renamed to c"},
+		"same nested target":  {values: []string{"m.a=c", "m.b=m.c"}, err: "also renamed to m.c"},
+		"distinct parents ok": {values: []string{"m.a=c", "n.a=c"}},
+		"top level vs nested": {values: []string{"a=c", "m.a=c"}},
+		"empty old name":      {values: []string{"=c"}, err: "expected old=new"},
+		"leading dot":         {values: []string{"m.a=.c"}, err: "different parent"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := source.ParseRenames(tt.values)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRenames_Apply(t *testing.T) {
+	t.Parallel()
+
+	doc, err := bson.Marshal(bson.D{
+		{Key: "_id", Value: 1},
+		{Key: "userId", Value: "u1"},
+		{Key: "meta", Value: bson.D{
+			{Key: "source", Value: "api"},
+			{Key: "deviceId", Value: "d1"},
+		}},
+		{Key: "a", Value: 1},
+		{Key: "b", Value: 2},
+	})
+	require.NoError(t, err)
+
+	t.Run("renames", func(t *testing.T) {
+		t.Parallel()
+
+		renames, err := source.ParseRenames([]string{"userId=user_id", "meta.deviceId=device_id", "a=b", "b=a"})
+		require.NoError(t, err)
+
+		out, err := renames.Apply(doc)
+		require.NoError(t, err)
+
+		ext, err := bson.MarshalExtJSON(out, false, false)
+		require.NoError(t, err)
+		assert.Equal(
+			t,
+			`{"_id":1,"user_id":"u1","meta":{"source":"api","device_id":"d1"},"b":1,"a":2}`,
+			string(ext),
+		)
+	})
+
+	t.Run("collision", func(t *testing.T) {
+		t.Parallel()
+
+		renames, err := source.ParseRenames([]string{"userId=a"})
+		require.NoError(t, err)
+
+		_, err = renames.Apply(doc)
+		assert.EqualError(t, err, "cannot rename userId to a, as the field already exists")
+	})
+
+	t.Run("nested collision", func(t *testing.T) {
+		t.Parallel()
+
+		renames, err := source.ParseRenames([]string{"meta.deviceId=source"})
+		require.NoError(t, err)
+
+		_, err = renames.Apply(doc)
+		assert.EqualError(t, err, "cannot rename meta.deviceId to meta.source, as the field already exists")
+	})
+}
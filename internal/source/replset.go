@@ -0,0 +1,71 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrReplicaSetUnhealthy is returned when the replica set has no primary, or a majority of its members aren't healthy
+var ErrReplicaSetUnhealthy = errors.New("replica set unhealthy")
+
+// Member states, as reported by replSetGetStatus, in which a member is considered healthy
+const (
+	memberStatePrimary   = 1
+	memberStateSecondary = 2
+	memberStateArbiter   = 7
+)
+
+// replSetStatus is the subset of the output of replSetGetStatus describing the health of each member
+type replSetStatus struct {
+	Members []struct {
+		Name     string  `bson:"name"`
+		Health   float64 `bson:"health"`
+		State    int     `bson:"state"`
+		StateStr string  `bson:"stateStr"`
+	} `bson:"members"`
+}
+
+// CheckReplicaSetHealth refuses with ErrReplicaSetUnhealthy should the replica set have no primary, or should a
+// majority of its members not be healthy, i.e. up and either primary, secondary or an arbiter, as replSetGetStatus
+// reports them. Deployments which aren't replica sets fail to report a status, so fail the check.
+func (a *MongoDB) CheckReplicaSetHealth(ctx context.Context) error {
+	status, err := a.collection.Database().Client().Database("admin").
+		RunCommand(ctx, bson.D{{Key: "replSetGetStatus", Value: 1}}).Raw()
+	if err != nil {
+		return fmt.Errorf("failed to get replica set status: %w", err)
+	}
+	return checkReplSetStatus(status)
+}
+
+// checkReplSetStatus checks the health of the replica set from the output of replSetGetStatus
+func checkReplSetStatus(raw bson.Raw) error {
+	var status replSetStatus
+	if err := bson.Unmarshal(raw, &status); err != nil {
+		return fmt.Errorf("failed to decode replica set status: %w", err)
+	}
+
+	var primary bool
+	var healthy int
+	for _, m := range status.Members {
+		if m.Health != 1 {
+			continue
+		}
+		switch m.State {
+		case memberStatePrimary:
+			primary = true
+			healthy++
+		case memberStateSecondary, memberStateArbiter:
+			healthy++
+		}
+	}
+	switch {
+	case !primary:
+		return fmt.Errorf("%w: no primary", ErrReplicaSetUnhealthy)
+	case healthy*2 <= len(status.Members):
+		return fmt.Errorf("%w: %d of %d members healthy", ErrReplicaSetUnhealthy, healthy, len(status.Members))
+	}
+	return nil
+}
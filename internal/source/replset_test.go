@@ -0,0 +1,74 @@
+package source_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+)
+
+func TestCheckReplSetStatus(t *testing.T) {
+	t.Parallel()
+
+	member := func(name string, health float64, state int, stateStr string) bson.M {
+		return bson.M{"name": name, "health": health, "state": state, "stateStr": stateStr}
+	}
+	primary := member("a:27017", 1, 1, "PRIMARY")
+	secondary := member("b:27017", 1, 2, "SECONDARY")
+	arbiter := member("c:27017", 1, 7, "ARBITER")
+	down := member("c:27017", 0, 8, "(not reachable/healthy)")
+	recovering := member("c:27017", 1, 3, "RECOVERING")
+
+	tests := []struct {
+		name     string
+		members  []bson.M
+		expected string // the reason the replica set is unhealthy, or empty when healthy
+	}{
+		{
+			name:    "all healthy",
+			members: []bson.M{primary, secondary, member("c:27017", 1, 2, "SECONDARY")},
+		},
+		{
+			name:    "with an arbiter",
+			members: []bson.M{primary, secondary, arbiter},
+		},
+		{
+			name:    "minority down",
+			members: []bson.M{primary, secondary, down},
+		},
+		{
+			name:     "no primary",
+			members:  []bson.M{member("a:27017", 1, 2, "SECONDARY"), secondary, arbiter},
+			expected: "no primary",
+		},
+		{
+			name:     "majority down",
+			members:  []bson.M{primary, member("b:27017", 0, 8, "(not reachable/healthy)"), down},
+			expected: "1 of 3 members healthy",
+		},
+		{
+			name:     "majority unavailable",
+			members:  []bson.M{primary, recovering, member("d:27017", 1, 5, "STARTUP2"), secondary},
+			expected: "2 of 4 members healthy",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			status, err := bson.Marshal(bson.M{"set": "rs0", "members": tt.members, "ok": 1.0})
+			require.NoError(t, err)
+
+			err = source.CheckReplSetStatus(status)
+			if tt.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, source.ErrReplicaSetUnhealthy)
+			assert.ErrorContains(t, err, tt.expected)
+		})
+	}
+}
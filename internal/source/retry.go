@@ -0,0 +1,86 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// retryableDeleteCodes are the server error codes with which a delete fails transiently, under lock contention or
+// whilst the primary is stepping down or shutting down, such that it may succeed once retried
+var retryableDeleteCodes = []int{
+	24,    // LockTimeout
+	46,    // LockBusy
+	91,    // ShutdownInProgress
+	112,   // WriteConflict
+	189,   // PrimarySteppedDown
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+}
+
+type deleteRetryConfig struct {
+	retries int
+	backoff time.Duration
+}
+
+// WithDeleteRetries retries deletes, of whole days or by _id, failing with a retryable server error, e.g. 189
+// PrimarySteppedDown, up to retries times, waiting backoff before the first retry and doubling it before each
+// subsequent one. Other errors fail the delete straight away. A delete interrupted part way through may have removed
+// some documents before failing, which the retry then doesn't count, so fewer may be reported deleted than were.
+func WithDeleteRetries(retries int, backoff time.Duration) MongoDBOption {
+	return func(m *MongoDB) {
+		m.deleteRetry = &deleteRetryConfig{
+			retries: retries,
+			backoff: backoff,
+		}
+	}
+}
+
+// retryDelete runs the delete, retrying it should it fail with a retryable error
+func (a *MongoDB) retryDelete(
+	ctx context.Context,
+	del func(ctx context.Context) (*mongo.DeleteResult, error),
+) (*mongo.DeleteResult, error) {
+	res, err := del(ctx)
+	if a.deleteRetry == nil {
+		return res, err
+	}
+
+	wait := a.deleteRetry.backoff
+	for attempt := 1; attempt <= a.deleteRetry.retries && isRetryableDeleteError(err); attempt++ {
+		slog.Warn(
+			"delete failed, retrying",
+			slog.String("error", err.Error()),
+			slog.Int("attempt", attempt),
+			slog.Duration("retryIn", wait),
+		)
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Join(err, ctx.Err())
+		case <-time.After(wait):
+		}
+
+		res, err = del(ctx)
+		wait *= 2
+	}
+	return res, err
+}
+
+// isRetryableDeleteError reports whether the delete failed with one of the retryable server error codes
+func isRetryableDeleteError(err error) bool {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	for _, code := range retryableDeleteCodes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package source_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+)
+
+// failingDelete fails with each of the errors in turn, and then succeeds
+type failingDelete struct {
+	errs  []error
+	calls int
+}
+
+func (d *failingDelete) delete(context.Context) (*mongo.DeleteResult, error) {
+	d.calls++
+	if d.calls <= len(d.errs) {
+		return nil, d.errs[d.calls-1]
+	}
+	return &mongo.DeleteResult{DeletedCount: 3}, nil
+}
+
+func TestMongoDB_RetryDelete(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	for _, code := range []int32{11600, 91, 189, 112} {
+		t.Run(fmt.Sprintf("retries code %d", code), func(t *testing.T) {
+			t.Parallel()
+
+			m := source.NewMongoDB(nil, source.WithDeleteRetries(3, time.Millisecond))
+			d := &failingDelete{errs: []error{
+				mongo.CommandError{Code: code},
+				mongo.WriteException{WriteConcernError: &mongo.WriteConcernError{Code: int(code)}},
+			}}
+
+			res, err := m.RetryDelete(ctx, d.delete)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), res.DeletedCount)
+			assert.Equal(t, 3, d.calls)
+		})
+	}
+
+	t.Run("does not retry other errors", func(t *testing.T) {
+		t.Parallel()
+
+		m := source.NewMongoDB(nil, source.WithDeleteRetries(3, time.Millisecond))
+		for _, err := range []error{mongo.CommandError{Code: 2}, errors.New("connection refused")} {
+			d := &failingDelete{errs: []error{err}}
+			_, retryErr := m.RetryDelete(ctx, d.delete)
+			assert.Equal(t, err, retryErr)
+			assert.Equal(t, 1, d.calls)
+		}
+	})
+
+	t.Run("gives up after retries", func(t *testing.T) {
+		t.Parallel()
+
+		m := source.NewMongoDB(nil, source.WithDeleteRetries(2, time.Millisecond))
+		stepdown := mongo.CommandError{Code: 189}
+		d := &failingDelete{errs: []error{stepdown, stepdown, stepdown, stepdown}}
+
+		_, err := m.RetryDelete(ctx, d.delete)
+		assert.Equal(t, stepdown, err)
+		assert.Equal(t, 3, d.calls)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+
+		m := source.NewMongoDB(nil)
+		stepdown := mongo.CommandError{Code: 189}
+		d := &failingDelete{errs: []error{stepdown}}
+
+		_, err := m.RetryDelete(ctx, d.delete)
+		assert.Equal(t, stepdown, err)
+		assert.Equal(t, 1, d.calls)
+	})
+
+	t.Run("stops waiting once cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		m := source.NewMongoDB(nil, source.WithDeleteRetries(3, time.Hour))
+		d := &failingDelete{errs: []error{mongo.CommandError{Code: 189}}}
+
+		_, err := m.RetryDelete(ctx, d.delete)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, d.calls)
+	})
+}
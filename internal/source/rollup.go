@@ -0,0 +1,137 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ParseRollupPipeline parses an aggregation pipeline supplied as an extended JSON array of stages, e.g.
+// [{"$group":{"_id":{"device":"$deviceId","day":{"$dateTrunc":{"date":"$createdAt","unit":"day"}}},"n":{"$sum":1}}}]
+func ParseRollupPipeline(pipeline string) (bson.A, error) {
+	var wrapper struct {
+		Stages bson.A `bson:"stages"`
+	}
+	if err := bson.UnmarshalExtJSON([]byte(`{"stages":`+pipeline+`}`), false, &wrapper); err != nil {
+		return nil, fmt.Errorf("invalid rollup pipeline: %w", err)
+	}
+	if len(wrapper.Stages) == 0 {
+		return nil, errors.New("invalid rollup pipeline: no stages")
+	}
+	for _, stage := range wrapper.Stages {
+		d, ok := stage.(bson.D)
+		if !ok || len(d) != 1 {
+			return nil, errors.New("invalid rollup pipeline: each stage must be a document with a single operator")
+		}
+		if d[0].Key == "$merge" || d[0].Key == "$out" {
+			return nil, fmt.Errorf("invalid rollup pipeline: %s is added to the pipeline, so must not be supplied", d[0].Key)
+		}
+	}
+	return wrapper.Stages, nil
+}
+
+type rollupConfig struct {
+	collection *mongo.Collection
+	pipeline   bson.A
+	dayField   string
+}
+
+// WithRollup pre-aggregates each day's documents into the supplied collection before they're archived, by running
+// the pipeline over the day's documents and merging its output into the collection, with the day stamped on each
+// rollup document under dayField. Rollup documents are merged on _id, replacing any already there, so rolling up a
+// day again is idempotent provided _id distinguishes the days rolled up.
+func WithRollup(collection *mongo.Collection, pipeline bson.A, dayField string) MongoDBOption {
+	return func(m *MongoDB) {
+		m.rollup = &rollupConfig{
+			collection: collection,
+			pipeline:   pipeline,
+			dayField:   dayField,
+		}
+	}
+}
+
+// RollupResult describes the outcome of rolling up a single day
+type RollupResult struct {
+	// Documents is the number of the day's documents rolled up
+	Documents int
+	// Rollups is the number of rollup documents held for the day once merged
+	Rollups int
+}
+
+// RollupDay runs the rollup pipeline over the documents assigned to the date, merging its output into the rollup
+// collection. The day's documents are counted either side of the aggregation, which fails should the day change
+// whilst being rolled up, as the rollup can't be known to cover the documents that would then be archived.
+func (a *MongoDB) RollupDay(ctx context.Context, date time.Time) (RollupResult, error) {
+	if a.rollup == nil {
+		return RollupResult{}, errors.New("rollups are not configured")
+	}
+	before, err := a.CountFromDate(ctx, date)
+	if err != nil {
+		return RollupResult{}, fmt.Errorf("failed to count documents: %w", err)
+	}
+
+	t := date.Truncate(time.Hour * 24)
+	pipeline := append(bson.A{bson.M{"$match": a.dayFilter(date)}}, a.rollup.pipeline...)
+	pipeline = append(
+		pipeline,
+		bson.M{"$set": bson.M{a.rollup.dayField: t}},
+		bson.M{"$merge": bson.M{
+			"into": bson.M{
+				"db":   a.rollup.collection.Database().Name(),
+				"coll": a.rollup.collection.Name(),
+			},
+			"on":             "_id",
+			"whenMatched":    "replace",
+			"whenNotMatched": "insert",
+		}},
+	)
+	if err = a.aggregateRollup(ctx, pipeline); err != nil {
+		return RollupResult{}, fmt.Errorf("failed to aggregate: %w", err)
+	}
+
+	after, err := a.CountFromDate(ctx, date)
+	if err != nil {
+		return RollupResult{}, fmt.Errorf("failed to count documents: %w", err)
+	}
+	if after != before {
+		return RollupResult{}, fmt.Errorf(
+			"day changed whilst being rolled up, from %d documents to %d, so may not be complete", before, after,
+		)
+	}
+	rollups, err := a.countRollups(ctx, t)
+	if err != nil {
+		return RollupResult{}, fmt.Errorf("failed to count rollup documents: %w", err)
+	}
+	return RollupResult{Documents: before, Rollups: rollups}, nil
+}
+
+// aggregateRollup runs the pipeline merging the day into the rollup collection
+func (a *MongoDB) aggregateRollup(ctx context.Context, pipeline bson.A) error {
+	release, err := a.limiter.acquire(ctx, "rollup")
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	cursor, err := a.collection.Aggregate(ctx, pipeline, a.aggregateOptions())
+	if err != nil {
+		return err
+	}
+	return cursor.Close(ctx)
+}
+
+// countRollups returns the number of rollup documents for the day
+func (a *MongoDB) countRollups(ctx context.Context, day time.Time) (int, error) {
+	release, err := a.limiter.acquire(ctx, "count")
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	count, err := a.rollup.collection.CountDocuments(ctx, bson.M{a.rollup.dayField: day})
+	return int(count), err
+}
@@ -0,0 +1,32 @@
+package source_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+)
+
+func TestParseRollupPipeline(t *testing.T) {
+	t.Parallel()
+
+	pipeline, err := source.ParseRollupPipeline(`[{"$match":{"kind":"reading"}},{"$group":{"_id":"$device","n":{"$sum":1}}}]`)
+	require.NoError(t, err)
+	require.Len(t, pipeline, 2)
+	assert.Equal(t, bson.D{{Key: "$match", Value: bson.D{{Key: "kind", Value: "reading"}}}}, pipeline[0])
+
+	for pipeline, expected := range map[string]string{
+		`[{"$group":`:                     "invalid rollup pipeline",
+		`[]`:                              "no stages",
+		`[1]`:                             "single operator",
+		`[{"$match":{},"$limit":1}]`:      "single operator",
+		`[{"$merge":{"into":"rollups"}}]`: "$merge is added to the pipeline",
+		`[{"$out":"rollups"}]`:            "$out is added to the pipeline",
+	} {
+		_, err = source.ParseRollupPipeline(pipeline)
+		assert.ErrorContains(t, err, expected, pipeline)
+	}
+}
@@ -0,0 +1,110 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrCollectionScan is returned when a query would scan more of the collection than permitted
+var ErrCollectionScan = errors.New("collection scan")
+
+// CheckScan explains the queries used to find the earliest document and each day's documents, refusing with
+// ErrCollectionScan should either be planned as a collection scan over a collection holding more than maxDocs
+// documents. Only the query plan is requested, so the queries themselves are not executed.
+func (a *MongoDB) CheckScan(ctx context.Context, maxDocs int64) error {
+	total, err := a.collection.EstimatedDocumentCount(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to count documents: %w", err)
+	}
+	if total <= maxDocs {
+		return nil
+	}
+
+	earliest := bson.D{
+		{Key: "find", Value: a.collection.Name()},
+		{Key: "filter", Value: bson.M{"createdAt": bson.M{"$exists": true}}},
+		{Key: "sort", Value: bson.D{{Key: "createdAt", Value: 1}}},
+		{Key: "limit", Value: 1},
+	}
+	day := bson.D{
+		{Key: "find", Value: a.collection.Name()},
+		{Key: "filter", Value: a.dayFilter(time.Now())},
+	}
+	if a.sortField != "" {
+		day = append(day, bson.E{Key: "sort", Value: bson.D{{Key: a.sortField, Value: 1}}})
+	}
+	if a.indexHint != "" {
+		day = append(day, bson.E{Key: "hint", Value: a.indexHint})
+	}
+
+	queries := []struct {
+		name string
+		find bson.D
+	}{
+		{name: "earliest", find: earliest},
+		{name: "day", find: day},
+	}
+	for _, q := range queries {
+		scan, err := a.collectionScan(ctx, q.find)
+		if err != nil {
+			return fmt.Errorf("failed to explain %s query: %w", q.name, err)
+		}
+		if scan {
+			return fmt.Errorf(
+				"%w: %s query would scan all %d documents, exceeding the maximum of %d",
+				ErrCollectionScan,
+				q.name,
+				total,
+				maxDocs,
+			)
+		}
+	}
+	return nil
+}
+
+// collectionScan reports whether the winning plan for the find command includes a collection scan
+func (a *MongoDB) collectionScan(ctx context.Context, find bson.D) (bool, error) {
+	var explained struct {
+		QueryPlanner struct {
+			WinningPlan bson.Raw `bson:"winningPlan"`
+		} `bson:"queryPlanner"`
+	}
+	err := a.collection.Database().
+		RunCommand(ctx, bson.D{{Key: "explain", Value: find}, {Key: "verbosity", Value: "queryPlanner"}}).
+		Decode(&explained)
+	if err != nil {
+		return false, err
+	}
+	return hasStage(explained.QueryPlanner.WinningPlan, "COLLSCAN"), nil
+}
+
+// hasStage reports whether the plan, or any plan nested within it, is the named stage. Nesting differs between
+// server versions and query engines (e.g. inputStage, inputStages, queryPlan), so the whole plan is searched.
+func hasStage(plan bson.Raw, stage string) bool {
+	elems, err := plan.Elements()
+	if err != nil {
+		return false
+	}
+	for _, elem := range elems {
+		val := elem.Value()
+		switch val.Type {
+		case bson.TypeString:
+			if elem.Key() == "stage" && val.StringValue() == stage {
+				return true
+			}
+		case bson.TypeEmbeddedDocument:
+			if hasStage(val.Document(), stage) {
+				return true
+			}
+		case bson.TypeArray:
+			if hasStage(bson.Raw(val.Array()), stage) {
+				return true
+			}
+		}
+	}
+	return false
+}
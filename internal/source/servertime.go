@@ -0,0 +1,27 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ServerTime returns the current time according to the clock of the mongo server, as reported by the hello command.
+// Using it in place of the local clock guards against the host running the archiver being skewed ahead of the server
+// which assigned the dates being archived.
+func ServerTime(ctx context.Context, client *mongo.Client) (time.Time, error) {
+	var hello struct {
+		LocalTime time.Time `bson:"localTime"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if hello.LocalTime.IsZero() {
+		return time.Time{}, errors.New("server did not report its local time")
+	}
+	return hello.LocalTime.UTC(), nil
+}
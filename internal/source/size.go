@@ -0,0 +1,64 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/predicate"
+)
+
+// ErrCollectionSize is returned when a collection holds fewer or more documents than permitted
+var ErrCollectionSize = errors.New("collection size")
+
+// CheckSize refuses with ErrCollectionSize should the collection hold fewer than minDocs documents, or more than
+// maxDocs, either being ignored when zero. The count is taken from the collection's metadata, as collStats reports
+// it, so is cheap regardless of the size of the collection but may be approximate.
+func (a *MongoDB) CheckSize(ctx context.Context, minDocs, maxDocs int64) error {
+	total, err := a.collection.EstimatedDocumentCount(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to count documents: %w", err)
+	}
+	switch {
+	case minDocs > 0 && total < minDocs:
+		return fmt.Errorf("%w: %d documents, fewer than the minimum of %d", ErrCollectionSize, total, minDocs)
+	case maxDocs > 0 && total > maxDocs:
+		return fmt.Errorf("%w: %d documents, more than the maximum of %d", ErrCollectionSize, total, maxDocs)
+	}
+	return nil
+}
+
+// ErrCollectionStats is returned when a collection's statistics fail to satisfy a predicate
+var ErrCollectionStats = errors.New("collection stats")
+
+// StatsVariables are the statistics of a collection which predicates checked by CheckStats may refer to, named as
+// collStats reports them
+var StatsVariables = []string{"count", "size", "avgObjSize", "storageSize", "nindexes", "totalIndexSize"}
+
+// CheckStats refuses with ErrCollectionStats should the collection's statistics, as collStats reports them, not
+// satisfy the predicate. A collection that doesn't exist has zero for each.
+func (a *MongoDB) CheckStats(ctx context.Context, p *predicate.Predicate) error {
+	var stats bson.M
+	err := a.collection.Database().RunCommand(ctx, bson.D{{Key: "collStats", Value: a.collection.Name()}}).Decode(&stats)
+	if err != nil {
+		return fmt.Errorf("failed to read collection stats: %w", err)
+	}
+
+	values := make(map[string]float64, len(StatsVariables))
+	for _, name := range StatsVariables {
+		switch v := stats[name].(type) {
+		case int32:
+			values[name] = float64(v)
+		case int64:
+			values[name] = float64(v)
+		case float64:
+			values[name] = v
+		}
+	}
+	if !p.Eval(values) {
+		return fmt.Errorf("%w: %v does not satisfy %s", ErrCollectionStats, values, p)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package source
+
+import (
+	"fmt"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// WithCanonicalSpecialTypes renders documents holding a value of a special type, as found by FindSpecialType, entirely
+// as canonical extended JSON, ignoring any plain fields, so that such values are never rendered lossily, e.g. a
+// Decimal128 as a plain string
+func WithCanonicalSpecialTypes() MongoDBOption {
+	return func(m *MongoDB) {
+		m.canonicalSpecial = true
+	}
+}
+
+// FindSpecialType returns the dotted path and type of the first value in the document whose type doesn't round-trip
+// cleanly through extended JSON, reporting false should there be none. Special types are Decimal128, which is only
+// preserved by canonical extended JSON, the deprecated undefined, DBPointer, symbol and code with scope types, which
+// many consumers of extended JSON can't parse, and binary data of the deprecated old binary and old UUID subtypes, or
+// of user defined subtypes, whose meaning is lost outside of the application that wrote them.
+func FindSpecialType(doc bson.Raw) (path, typ string, found bool, err error) {
+	elems, err := doc.Elements()
+	if err != nil {
+		return "", "", false, err
+	}
+	for _, elem := range elems {
+		if path, typ, found, err = findSpecialValue(elem.Key(), elem.Value()); found || err != nil {
+			return path, typ, found, err
+		}
+	}
+	return "", "", false, nil
+}
+
+// findSpecialValue returns the path and type of the value, or of the first special value within it, should it be
+// special
+func findSpecialValue(path string, val bson.RawValue) (string, string, bool, error) {
+	switch val.Type {
+	case bson.TypeDecimal128:
+		return path, "decimal", true, nil
+	case bson.TypeUndefined:
+		return path, "undefined", true, nil
+	case bson.TypeDBPointer:
+		return path, "dbPointer", true, nil
+	case bson.TypeSymbol:
+		return path, "symbol", true, nil
+	case bson.TypeCodeWithScope:
+		return path, "javascriptWithScope", true, nil
+	case bson.TypeBinary:
+		subtype, _ := val.Binary()
+		if subtype == bson.TypeBinaryBinaryOld || subtype == bson.TypeBinaryUUIDOld || subtype >= 0x80 {
+			return path, fmt.Sprintf("binData subtype %#02x", subtype), true, nil
+		}
+	case bson.TypeEmbeddedDocument:
+		elems, err := val.Document().Elements()
+		if err != nil {
+			return "", "", false, err
+		}
+		for _, elem := range elems {
+			if p, t, found, err := findSpecialValue(path+"."+elem.Key(), elem.Value()); found || err != nil {
+				return p, t, found, err
+			}
+		}
+	case bson.TypeArray:
+		values, err := val.Array().Values()
+		if err != nil {
+			return "", "", false, err
+		}
+		for i, v := range values {
+			if p, t, found, err := findSpecialValue(path+"."+strconv.Itoa(i), v); found || err != nil {
+				return p, t, found, err
+			}
+		}
+	}
+	return "", "", false, nil
+}
@@ -0,0 +1,130 @@
+package source_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
+)
+
+func TestFindSpecialType(t *testing.T) {
+	t.Parallel()
+
+	decimal, err := primitive.ParseDecimal128("12.50")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		doc   bson.D
+		path  string
+		typ   string
+		found bool
+	}{
+		{
+			name: "none",
+			doc: bson.D{
+				{Key: "amount", Value: 12.5},
+				{Key: "blob", Value: primitive.Binary{Subtype: bson.TypeBinaryGeneric, Data: []byte{1}}},
+				{Key: "uuid", Value: primitive.Binary{Subtype: bson.TypeBinaryUUID, Data: make([]byte, 16)}},
+			},
+		},
+		{
+			name:  "decimal",
+			doc:   bson.D{{Key: "name", Value: "x"}, {Key: "amount", Value: decimal}},
+			path:  "amount",
+			typ:   "decimal",
+			found: true,
+		},
+		{
+			name: "old uuid nested in an array",
+			doc: bson.D{{Key: "meta", Value: bson.D{{Key: "refs", Value: bson.A{
+				"a",
+				primitive.Binary{Subtype: bson.TypeBinaryUUIDOld, Data: make([]byte, 16)},
+			}}}}},
+			path:  "meta.refs.1",
+			typ:   "binData subtype 0x03",
+			found: true,
+		},
+		{
+			name:  "user defined binary",
+			doc:   bson.D{{Key: "blob", Value: primitive.Binary{Subtype: 0x80, Data: []byte{1}}}},
+			path:  "blob",
+			typ:   "binData subtype 0x80",
+			found: true,
+		},
+		{
+			name:  "symbol",
+			doc:   bson.D{{Key: "sym", Value: primitive.Symbol("s")}},
+			path:  "sym",
+			typ:   "symbol",
+			found: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			raw, err := bson.Marshal(tt.doc)
+			require.NoError(t, err)
+
+			path, typ, found, err := source.FindSpecialType(raw)
+			require.NoError(t, err)
+			assert.Equal(t, tt.found, found)
+			assert.Equal(t, tt.path, path)
+			assert.Equal(t, tt.typ, typ)
+		})
+	}
+}
+
+func TestMarshalDocumentCanonicalSpecial(t *testing.T) {
+	t.Parallel()
+
+	decimal, err := primitive.ParseDecimal128("12.50")
+	require.NoError(t, err)
+
+	t.Run("decimal ignores plain fields", func(t *testing.T) {
+		t.Parallel()
+
+		raw, err := bson.Marshal(bson.D{{Key: "count", Value: int64(3)}, {Key: "amount", Value: decimal}})
+		require.NoError(t, err)
+
+		out, err := source.MarshalDocumentCanonicalSpecial(nil, raw, []string{"count", "amount"})
+		require.NoError(t, err)
+		assert.Equal(t, `{"count":{"$numberLong":"3"},"amount":{"$numberDecimal":"12.50"}}`, string(out))
+
+		// Without, the decimal is rendered as a plain string, losing its type
+		out, err = source.MarshalDocument(nil, raw, []string{"count", "amount"})
+		require.NoError(t, err)
+		assert.Equal(t, `{"count":3,"amount":"12.50"}`, string(out))
+	})
+
+	t.Run("old binary subtype ignores plain fields", func(t *testing.T) {
+		t.Parallel()
+
+		raw, err := bson.Marshal(bson.D{
+			{Key: "count", Value: int64(3)},
+			{Key: "ref", Value: primitive.Binary{Subtype: bson.TypeBinaryUUIDOld, Data: []byte{0, 1, 2, 3}}},
+		})
+		require.NoError(t, err)
+
+		out, err := source.MarshalDocumentCanonicalSpecial(nil, raw, []string{"count", "ref"})
+		require.NoError(t, err)
+		assert.Equal(t, `{"count":{"$numberLong":"3"},"ref":{"$binary":{"base64":"AAECAw==","subType":"03"}}}`, string(out))
+	})
+
+	t.Run("other documents keep plain fields", func(t *testing.T) {
+		t.Parallel()
+
+		raw, err := bson.Marshal(bson.D{{Key: "count", Value: int64(3)}, {Key: "name", Value: "x"}})
+		require.NoError(t, err)
+
+		out, err := source.MarshalDocumentCanonicalSpecial(nil, raw, []string{"count"})
+		require.NoError(t, err)
+		assert.Equal(t, `{"count":3,"name":"x"}`, string(out))
+	})
+}
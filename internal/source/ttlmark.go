@@ -0,0 +1,89 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNoTTLIndex is returned when marking documents for expiry, yet no TTL index of the collection would remove them
+var ErrNoTTLIndex = errors.New("no ttl index")
+
+type ttlMarkConfig struct {
+	field  string
+	window time.Duration
+}
+
+// WithTTLMark marks documents for expiry rather than deleting them, as a middle ground between deleting documents once
+// archived and keeping them. Deletes instead set the field to the server's current time plus the window, so that a TTL
+// index on the field removes them once the window has passed, leaving them in the collection to be recovered from
+// should their archive be found faulty. Marked documents are no longer found, counted or deleted, so each is still
+// archived once. The TTL index is expected to expire documents after 0 seconds, as the window is already added.
+func WithTTLMark(field string, window time.Duration) MongoDBOption {
+	return func(m *MongoDB) {
+		m.ttlMark = &ttlMarkConfig{
+			field:  field,
+			window: window,
+		}
+	}
+}
+
+// checkTTLIndex refuses with ErrNoTTLIndex should the collection documents are deleted from have no TTL index on the
+// field documents are marked by, as marked documents would otherwise never be removed
+func (a *MongoDB) checkTTLIndex(ctx context.Context) error {
+	if a.ttlMark == nil {
+		return nil
+	}
+	specs, err := a.deletes.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list indexes: %w", err)
+	}
+	// TTL indexes only ever hold a single field
+	if !slices.ContainsFunc(specs, func(spec *mongo.IndexSpecification) bool {
+		return spec.ExpireAfterSeconds != nil && leadingKey(spec.KeysDocument) == a.ttlMark.field
+	}) {
+		return fmt.Errorf("%w: %s has none on %s", ErrNoTTLIndex, a.deletes.Name(), a.ttlMark.field)
+	}
+	return nil
+}
+
+// unmarked constrains the filter to documents yet to be marked for expiry, when marking rather than deleting
+func (a *MongoDB) unmarked(filter bson.M) bson.M {
+	if a.ttlMark == nil {
+		return filter
+	}
+	return bson.M{"$and": bson.A{filter, bson.M{a.ttlMark.field: bson.M{"$exists": false}}}}
+}
+
+// deleteMany deletes the documents matching the filter from the collection, or marks those yet to be marked for expiry
+// when marking, reporting the documents marked as deleted
+func (a *MongoDB) deleteMany(
+	ctx context.Context,
+	collection *mongo.Collection,
+	filter bson.M,
+	opts *options.DeleteOptions,
+) (*mongo.DeleteResult, error) {
+	if a.ttlMark == nil {
+		return collection.DeleteMany(ctx, filter, opts)
+	}
+
+	// Expiry is set from the server's clock, which its TTL monitor also runs by
+	update := bson.A{bson.M{"$set": bson.M{
+		a.ttlMark.field: bson.M{"$add": bson.A{"$$NOW", a.ttlMark.window.Milliseconds()}},
+	}}}
+	updateOpts := options.Update()
+	if opts != nil && opts.Hint != nil {
+		updateOpts.SetHint(opts.Hint)
+	}
+	res, err := collection.UpdateMany(ctx, a.unmarked(filter), update, updateOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &mongo.DeleteResult{DeletedCount: res.ModifiedCount}, nil
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"strings"
+	"sync"
+)
+
+// Count describes a file written to a Counting store
+type Count struct {
+	// Bytes is the number of bytes written to the store, i.e. the compressed size of archives
+	Bytes int64
+	// Documents is the number of newline delimited documents, counted after decompressing gzipped files
+	Documents int
+}
+
+// Counting is a store which discards file contents, only recording the size and number of documents of each file
+// written. It allows large dry runs to be verified without retaining what was written.
+type Counting struct {
+	mu     sync.Mutex
+	counts map[string]Count
+}
+
+func newCounting() *Counting {
+	return &Counting{
+		counts: make(map[string]Count),
+	}
+}
+
+func (c *Counting) Create(_ context.Context, path string) (io.WriteCloser, error) {
+	f := &countingFile{
+		store: c,
+		path:  path,
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		f.content = &f.lines
+		return f, nil
+	}
+
+	// Documents can only be counted once decompressed, which happens as the file is written
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := countCompressedLines(pr, &f.lines)
+		_ = pr.CloseWithError(err)
+		done <- err
+	}()
+	f.content = pw
+	f.wait = func() error {
+		_ = pw.Close()
+		return <-done
+	}
+	return f, nil
+}
+
+// Exists reports whether a file has previously been written to the store
+func (c *Counting) Exists(_ context.Context, path string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, exists := c.counts[path]
+	return exists, nil
+}
+
+// Counts returns the counts recorded for each file written, keyed by path
+func (c *Counting) Counts() map[string]Count {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return maps.Clone(c.counts)
+}
+
+func (c *Counting) Close() error {
+	return nil
+}
+
+func (c *Counting) record(path string, count Count) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counts[path] = count
+}
+
+// countingFile counts the bytes written to it, passing them on to have their lines counted
+type countingFile struct {
+	store   *Counting
+	path    string
+	bytes   int64
+	lines   lineCounter
+	content io.Writer
+	wait    func() error
+}
+
+func (f *countingFile) Write(p []byte) (int, error) {
+	n, err := f.content.Write(p)
+	f.bytes += int64(n)
+	return n, err
+}
+
+func (f *countingFile) Close() error {
+	if f.wait != nil {
+		if err := f.wait(); err != nil {
+			return fmt.Errorf("failed to count documents: %w", err)
+		}
+	}
+
+	count := Count{Bytes: f.bytes, Documents: int(f.lines)}
+	f.store.record(f.path, count)
+
+	slog.Info(
+		"counted file",
+		slog.String("fileName", f.path),
+		slog.Int64("bytes", count.Bytes),
+		slog.Int("documents", count.Documents),
+	)
+	return nil
+}
+
+// countCompressedLines decompresses the gzip stream, counting the lines within it
+func countCompressedLines(r io.Reader, lines *lineCounter) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil // nothing was written
+		}
+		return err
+	}
+	_, err = io.Copy(lines, gr)
+	return err
+}
+
+// lineCounter counts the newlines written to it
+type lineCounter int
+
+func (l *lineCounter) Write(p []byte) (int, error) {
+	*l += lineCounter(bytes.Count(p, []byte{'\n'}))
+	return len(p), nil
+}
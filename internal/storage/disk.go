@@ -5,17 +5,33 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 )
 
+// diskPartialSuffix marks files still being written by disk stores writing atomically
+const diskPartialSuffix = ".partial"
+
+// ErrInsufficientSpace is returned when a disk store has less free space than its configured minimum, or runs out of
+// space whilst writing
+var ErrInsufficientSpace = errors.New("insufficient free space")
+
 type Disk struct {
-	basePath string
+	basePath     string
+	minFreeBytes uint64
+	atomic       bool
+	create       func(name string) (*os.File, error)
 }
 
-func newDisk(basePath string) *Disk {
+func newDisk(basePath string, minFreeBytes uint64, atomic bool) *Disk {
 	return &Disk{
-		basePath: basePath,
+		basePath:     basePath,
+		minFreeBytes: minFreeBytes,
+		atomic:       atomic,
+		create:       os.Create,
 	}
 }
 
@@ -26,9 +42,106 @@ func (d *Disk) Create(_ context.Context, relativePath string) (io.WriteCloser, e
 	}
 	absDir := filepath.Dir(absPath)
 	if err = os.MkdirAll(absDir, 0700); err != nil {
+		return nil, describeDiskError(err, absDir)
+	}
+	if err = d.checkFreeSpace(absDir); err != nil {
 		return nil, err
 	}
-	return os.Create(absPath)
+	if !d.atomic {
+		f, err := d.create(absPath)
+		if err != nil {
+			return nil, describeDiskError(err, absDir)
+		}
+		return &diskFile{File: f, name: absPath, dir: absDir}, nil
+	}
+
+	partialPath := absPath + diskPartialSuffix
+	f, err := d.create(partialPath)
+	if err != nil {
+		return nil, describeDiskError(err, absDir)
+	}
+	return &atomicFile{diskFile: &diskFile{File: f, name: partialPath, dir: absDir}, path: absPath}, nil
+}
+
+// diskFile is a file being written to a disk store, which can be committed by syncing it, or aborted by removing it
+type diskFile struct {
+	*os.File
+	name string
+	dir  string
+}
+
+func (f *diskFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	return n, describeDiskError(err, f.dir)
+}
+
+func (f *diskFile) Commit() error {
+	return describeDiskError(f.File.Sync(), f.dir)
+}
+
+func (f *diskFile) Abort() error {
+	return errors.Join(f.File.Close(), os.Remove(f.name))
+}
+
+// atomicFile is a file being written to a disk store as a partial file beside its path, which is only renamed into
+// place once closed having been written in full. Should writing fail, the partial file is removed once closed, so
+// that nothing is ever left at the file's path and the day can be archived again.
+type atomicFile struct {
+	*diskFile
+	path   string
+	failed error // the first write to fail, after which the file can only be discarded
+}
+
+func (f *atomicFile) Write(p []byte) (int, error) {
+	if f.failed != nil {
+		return 0, f.failed
+	}
+	n, err := f.diskFile.Write(p)
+	if err != nil {
+		f.failed = err
+	}
+	return n, err
+}
+
+func (f *atomicFile) Close() error {
+	if f.failed != nil {
+		return errors.Join(fmt.Errorf("discarded partial file: %w", f.failed), f.Abort())
+	}
+	if err := f.Commit(); err != nil {
+		return errors.Join(err, f.Abort())
+	}
+	if err := f.File.Close(); err != nil {
+		return errors.Join(describeDiskError(err, f.dir), os.Remove(f.name))
+	}
+	if err := os.Rename(f.name, f.path); err != nil {
+		return errors.Join(describeDiskError(err, f.dir), os.Remove(f.name))
+	}
+	return nil
+}
+
+// describeDiskError distinguishes the failures of writing to disk an operator can act upon, the directory being
+// unwritable and the disk being full, from any others, which are returned as is
+func describeDiskError(err error, dir string) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, fs.ErrPermission):
+		return fmt.Errorf("permission denied writing to %s, check that it's writable by the archiver: %w", dir, err)
+	case errors.Is(err, syscall.ENOSPC):
+		return fmt.Errorf("%w: no space left on the device holding %s: %w", ErrInsufficientSpace, dir, err)
+	default:
+		return err
+	}
+}
+
+// appendedFile is an existing file being continued by a disk store, which can be committed by syncing it. It can't be
+// aborted, as removing it would discard what was written before.
+type appendedFile struct {
+	*os.File
+}
+
+func (f *appendedFile) Commit() error {
+	return f.File.Sync()
 }
 
 func (d *Disk) Exists(_ context.Context, relativePath string) (bool, error) {
@@ -45,6 +158,80 @@ func (d *Disk) Exists(_ context.Context, relativePath string) (bool, error) {
 	return true, nil
 }
 
+func (d *Disk) Open(_ context.Context, relativePath string) (io.ReadCloser, error) {
+	absPath, err := filepath.Abs(filepath.Join(d.basePath, relativePath))
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(absPath)
+}
+
+// Append opens an existing file for writing, discarding anything beyond offset
+func (d *Disk) Append(_ context.Context, relativePath string, offset int64) (io.WriteCloser, error) {
+	absPath, err := filepath.Abs(filepath.Join(d.basePath, relativePath))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(absPath, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Truncate(offset); err != nil {
+		return nil, errors.Join(err, f.Close())
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return nil, errors.Join(err, f.Close())
+	}
+	return &appendedFile{File: f}, nil
+}
+
+// List returns the path of every file beneath the base path, relative to it and in lexical order
+func (d *Disk) List(ctx context.Context) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(d.basePath, func(absPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() || (d.atomic && strings.HasSuffix(entry.Name(), diskPartialSuffix)) {
+			return nil
+		}
+		relativePath, err := filepath.Rel(d.basePath, absPath)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, filepath.ToSlash(relativePath))
+		return nil
+	})
+	return paths, err
+}
+
+func (d *Disk) Remove(_ context.Context, relativePath string) error {
+	absPath, err := filepath.Abs(filepath.Join(d.basePath, relativePath))
+	if err != nil {
+		return err
+	}
+	return os.Remove(absPath)
+}
+
+// checkFreeSpace refuses writes when free space is below the configured minimum, since running out part way through
+// a file would leave it truncated
+func (d *Disk) checkFreeSpace(dir string) error {
+	if d.minFreeBytes == 0 {
+		return nil
+	}
+	free, err := freeBytes(dir)
+	if err != nil {
+		return fmt.Errorf("failed to determine free space: %w", err)
+	}
+	if free < d.minFreeBytes {
+		return fmt.Errorf("%w: %d bytes free, %d required", ErrInsufficientSpace, free, d.minFreeBytes)
+	}
+	return nil
+}
+
 func (d *Disk) Close() error {
 	return nil
 }
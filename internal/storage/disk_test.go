@@ -0,0 +1,255 @@
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/storage"
+)
+
+func TestDisk_MinFreeBytes(t *testing.T) {
+	t.Parallel()
+
+	switch runtime.GOOS {
+	case "linux", "darwin", "freebsd":
+	default:
+		t.Skip("free space check not supported on this platform")
+	}
+
+	ctx := context.Background()
+	baseDir := t.TempDir()
+
+	t.Run("below threshold", func(t *testing.T) {
+		t.Parallel()
+
+		store, err := storage.FromURL(ctx, fmt.Sprintf("file://%s", baseDir), storage.WithMinFreeBytes(math.MaxUint64))
+		require.NoError(t, err)
+
+		_, err = store.Create(ctx, "2024/11/01.json.gz")
+		assert.ErrorIs(t, err, storage.ErrInsufficientSpace)
+
+		_, err = os.Stat(baseDir + "/2024/11/01.json.gz")
+		assert.ErrorIs(t, err, os.ErrNotExist) // no partial file left behind
+	})
+
+	t.Run("above threshold", func(t *testing.T) {
+		t.Parallel()
+
+		store, err := storage.FromURL(ctx, fmt.Sprintf("file://%s", baseDir), storage.WithMinFreeBytes(1))
+		require.NoError(t, err)
+
+		w, err := store.Create(ctx, "2024/11/02.json.gz")
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	})
+}
+
+func TestDisk_Abort(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	baseDir := t.TempDir()
+	store, err := storage.FromURL(ctx, fmt.Sprintf("file://%s", baseDir))
+	require.NoError(t, err)
+
+	w, err := store.Create(ctx, "2024/11/01.json.gz")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("some malformed data"))
+	require.NoError(t, err)
+	require.Implements(t, (*storage.Aborter)(nil), w)
+	require.NoError(t, w.(storage.Aborter).Abort())
+
+	_, err = os.Stat(baseDir + "/2024/11/01.json.gz")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestDisk_AtomicWrites(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("renamed into place once closed", func(t *testing.T) {
+		t.Parallel()
+
+		baseDir := t.TempDir()
+		store, err := storage.FromURL(ctx, fmt.Sprintf("file://%s", baseDir), storage.WithAtomicWrites())
+		require.NoError(t, err)
+
+		w, err := store.Create(ctx, "2024/11/01.json.gz")
+		require.NoError(t, err)
+		_, err = w.Write([]byte("some data"))
+		require.NoError(t, err)
+
+		exists, err := store.(storage.Exister).Exists(ctx, "2024/11/01.json.gz")
+		require.NoError(t, err)
+		assert.False(t, exists)
+		paths, err := store.(storage.Lister).List(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, paths) // the partial file isn't listed
+
+		require.NoError(t, w.Close())
+		data, err := os.ReadFile(baseDir + "/2024/11/01.json.gz")
+		require.NoError(t, err)
+		assert.Equal(t, "some data", string(data))
+		_, err = os.Stat(baseDir + "/2024/11/01.json.gz.partial")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("removes the partial file once writing fails", func(t *testing.T) {
+		t.Parallel()
+
+		baseDir := t.TempDir()
+		// Files are opened read only, so that writes fail after they've been created
+		store := storage.NewDiskWithCreate(baseDir, true, func(name string) (*os.File, error) {
+			f, err := os.Create(name)
+			if err != nil {
+				return nil, err
+			}
+			if err = f.Close(); err != nil {
+				return nil, err
+			}
+			return os.Open(name)
+		})
+
+		w, err := store.Create(ctx, "2024/11/01.json.gz")
+		require.NoError(t, err)
+		_, err = os.Stat(baseDir + "/2024/11/01.json.gz.partial")
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte("some data"))
+		require.Error(t, err)
+		_, err = w.Write([]byte("more data"))
+		require.Error(t, err) // nothing more is written once a write has failed
+		assert.ErrorContains(t, w.Close(), "discarded partial file")
+
+		for _, name := range []string{"2024/11/01.json.gz", "2024/11/01.json.gz.partial"} {
+			_, err = os.Stat(baseDir + "/" + name)
+			assert.ErrorIs(t, err, os.ErrNotExist, name)
+		}
+
+		// Nothing is left in the way of archiving the day again
+		retry, err := storage.FromURL(ctx, fmt.Sprintf("file://%s", baseDir), storage.WithAtomicWrites())
+		require.NoError(t, err)
+		exists, err := retry.(storage.Exister).Exists(ctx, "2024/11/01.json.gz")
+		require.NoError(t, err)
+		assert.False(t, exists)
+		w, err = retry.Create(ctx, "2024/11/01.json.gz")
+		require.NoError(t, err)
+		_, err = w.Write([]byte("some data"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	})
+
+	t.Run("aborted", func(t *testing.T) {
+		t.Parallel()
+
+		baseDir := t.TempDir()
+		store, err := storage.FromURL(ctx, fmt.Sprintf("file://%s", baseDir), storage.WithAtomicWrites())
+		require.NoError(t, err)
+
+		w, err := store.Create(ctx, "2024/11/01.json.gz")
+		require.NoError(t, err)
+		require.NoError(t, w.(storage.Aborter).Abort())
+
+		entries, err := os.ReadDir(baseDir + "/2024/11")
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("unsupported by other schemes", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := storage.FromURL(ctx, "noop://", storage.WithAtomicWrites())
+		assert.ErrorContains(t, err, "does not support atomic writes")
+	})
+}
+
+func TestDisk_WriteErrors(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	failing := func(errno syscall.Errno) func(string) (*os.File, error) {
+		return func(name string) (*os.File, error) {
+			return nil, &os.PathError{Op: "open", Path: name, Err: errno}
+		}
+	}
+
+	t.Run("permission denied", func(t *testing.T) {
+		t.Parallel()
+
+		baseDir := t.TempDir()
+		_, err := storage.NewDiskWithCreate(baseDir, false, failing(syscall.EACCES)).Create(ctx, "2024/11/01.json.gz")
+		assert.ErrorIs(t, err, fs.ErrPermission)
+		assert.ErrorContains(t, err, fmt.Sprintf("permission denied writing to %s/2024/11", baseDir))
+	})
+
+	t.Run("no space left", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := storage.NewDiskWithCreate(t.TempDir(), true, failing(syscall.ENOSPC)).Create(ctx, "2024/11/01.json.gz")
+		assert.ErrorIs(t, err, storage.ErrInsufficientSpace)
+		assert.ErrorContains(t, err, "no space left on the device")
+	})
+}
+
+func TestDisk_List(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	baseDir := t.TempDir()
+	store, err := storage.FromURL(ctx, fmt.Sprintf("file://%s", baseDir))
+	require.NoError(t, err)
+
+	for _, name := range []string{"2024/11/02.json.gz", "2024/11/01.json.gz", "2024/11/01.json.gz.sha256"} {
+		w, err := store.Create(ctx, name)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	}
+
+	paths, err := store.(storage.Lister).List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2024/11/01.json.gz", "2024/11/01.json.gz.sha256", "2024/11/02.json.gz"}, paths)
+}
+
+func TestExpandCollection(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	baseDir := t.TempDir()
+	rawURL, err := storage.ExpandCollection(fmt.Sprintf("file://%s/{collection}/archive", baseDir), "events")
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("file://%s/events/archive", baseDir), rawURL)
+
+	store, err := storage.FromURL(ctx, rawURL)
+	require.NoError(t, err)
+	w, err := store.Create(ctx, "2024/11/01.json.gz")
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	_, err = os.Stat(baseDir + "/events/archive/2024/11/01.json.gz")
+	assert.NoError(t, err)
+
+	// URLs may equally be templates
+	rawURL, err = storage.ExpandCollection("gcs://bucket/{{.Collection}}/archive", "events")
+	require.NoError(t, err)
+	assert.Equal(t, "gcs://bucket/events/archive", rawURL)
+
+	_, err = storage.ExpandCollection("gcs://bucket/{{.Tenant}}", "events")
+	assert.ErrorContains(t, err, "invalid storage url template")
+	_, err = storage.ExpandCollection("gcs://bucket/{{.Collection", "events")
+	assert.ErrorContains(t, err, "invalid storage url template")
+
+	// URLs without a placeholder are left as they are
+	rawURL, err = storage.ExpandCollection("gcs://bucket/archive", "events")
+	require.NoError(t, err)
+	assert.Equal(t, "gcs://bucket/archive", rawURL)
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"os"
+
+	"google.golang.org/api/option"
+)
+
+// NewKafkaWithWriter exposes the Kafka sink with a substitute writer, so tests can run without a broker
+var NewKafkaWithWriter = newKafkaWithWriter
+
+// NewVerifyingWriter exposes the GCS object writer wrapper, so it can be tested against substitute object attributes
+var NewVerifyingWriter = newVerifyingWriter
+
+// NewGCS exposes the GCS store constructor, so it can be pointed at a substitute endpoint
+var NewGCS = newGCS
+
+// GCSClientOptions exposes the GCS client options resolved from the supplied options
+func GCSClientOptions(opts ...Option) []option.ClientOption {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return gcsClientOptions(o)
+}
+
+// NewLimited exposes the store wrapper bounding concurrent uploads, so it can wrap an instrumented store
+var NewLimited = newLimited
+
+// SpoolDir exposes the spool directory of the store at the URL, so tests can leave files behind in it
+var SpoolDir = spoolDir
+
+// NewDiskWithCreate exposes the disk store with a substitute for creating files, so tests can simulate failing disks
+func NewDiskWithCreate(basePath string, atomic bool, create func(name string) (*os.File, error)) *Disk {
+	d := newDisk(basePath, 0, atomic)
+	d.create = create
+	return d
+}
@@ -0,0 +1,167 @@
+package storage
+
+// Capabilities of an underlying store which wrappers forward to it, each detected independently
+const (
+	canExist = 1 << iota
+	canOpen
+	canList
+	canRemove
+	canAppend
+)
+
+// forward extends the wrapper with each of the read side capabilities of the underlying store, so that they may still
+// be detected by the archiver. Wrappers only change how files are written, so everything else goes straight to the
+// underlying store. Appends are wrapped like Create, so are only forwarded when the wrapper supplies an appender, and
+// as resuming also reads back and removes files, only along with those.
+func forward(w, underlying Store, a Appender) Store {
+	e, _ := underlying.(Exister)
+	o, _ := underlying.(Opener)
+	l, _ := underlying.(Lister)
+	r, _ := underlying.(Remover)
+
+	var caps int
+	if e != nil {
+		caps |= canExist
+	}
+	if o != nil {
+		caps |= canOpen
+	}
+	if l != nil {
+		caps |= canList
+	}
+	if r != nil {
+		caps |= canRemove
+	}
+	if a != nil && o != nil && r != nil {
+		caps |= canAppend
+	}
+
+	switch caps {
+	case canExist:
+		return struct {
+			Store
+			Exister
+		}{w, e}
+	case canOpen:
+		return struct {
+			Store
+			Opener
+		}{w, o}
+	case canList:
+		return struct {
+			Store
+			Lister
+		}{w, l}
+	case canRemove:
+		return struct {
+			Store
+			Remover
+		}{w, r}
+	case canExist | canOpen:
+		return struct {
+			Store
+			Exister
+			Opener
+		}{w, e, o}
+	case canExist | canList:
+		return struct {
+			Store
+			Exister
+			Lister
+		}{w, e, l}
+	case canExist | canRemove:
+		return struct {
+			Store
+			Exister
+			Remover
+		}{w, e, r}
+	case canOpen | canList:
+		return struct {
+			Store
+			Opener
+			Lister
+		}{w, o, l}
+	case canOpen | canRemove:
+		return struct {
+			Store
+			Opener
+			Remover
+		}{w, o, r}
+	case canList | canRemove:
+		return struct {
+			Store
+			Lister
+			Remover
+		}{w, l, r}
+	case canExist | canOpen | canList:
+		return struct {
+			Store
+			Exister
+			Opener
+			Lister
+		}{w, e, o, l}
+	case canExist | canOpen | canRemove:
+		return struct {
+			Store
+			Exister
+			Opener
+			Remover
+		}{w, e, o, r}
+	case canExist | canList | canRemove:
+		return struct {
+			Store
+			Exister
+			Lister
+			Remover
+		}{w, e, l, r}
+	case canOpen | canList | canRemove:
+		return struct {
+			Store
+			Opener
+			Lister
+			Remover
+		}{w, o, l, r}
+	case canExist | canOpen | canList | canRemove:
+		return struct {
+			Store
+			Exister
+			Opener
+			Lister
+			Remover
+		}{w, e, o, l, r}
+	case canAppend | canOpen | canRemove:
+		return struct {
+			Store
+			Appender
+			Opener
+			Remover
+		}{w, a, o, r}
+	case canAppend | canExist | canOpen | canRemove:
+		return struct {
+			Store
+			Appender
+			Exister
+			Opener
+			Remover
+		}{w, a, e, o, r}
+	case canAppend | canOpen | canList | canRemove:
+		return struct {
+			Store
+			Appender
+			Opener
+			Lister
+			Remover
+		}{w, a, o, l, r}
+	case canAppend | canExist | canOpen | canList | canRemove:
+		return struct {
+			Store
+			Appender
+			Exister
+			Opener
+			Lister
+			Remover
+		}{w, a, e, o, l, r}
+	default:
+		return w
+	}
+}
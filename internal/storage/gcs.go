@@ -1,38 +1,188 @@
 package storage
 
 import (
+	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
+	"net/http"
+	"net/url"
 	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
+// ErrChecksumMismatch is returned when a finalized object does not hold exactly the bytes that were written to it
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
 type GCS struct {
-	bucket   *storage.BucketHandle
-	basePath string
-	closer   io.Closer
+	bucket    *storage.BucketHandle
+	basePath  string
+	metadata  map[string]string
+	compress  bool
+	retention time.Duration // how long objects are locked for once written, if at all
+	closer    io.Closer
+
+	// finalizeRetries is the number of times an upload failing to finalize is retried in full, waiting finalizeBackoff
+	// longer before each
+	finalizeRetries int
+	finalizeBackoff time.Duration
 }
 
-func newGCS(ctx context.Context, bucket, basePath string) (*GCS, error) {
-	client, err := storage.NewClient(ctx)
+func newGCS(
+	ctx context.Context,
+	bucket, basePath string,
+	metadata map[string]string,
+	compress bool,
+	retention time.Duration,
+	opts ...option.ClientOption,
+) (*GCS, error) {
+	client, err := storage.NewClient(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return &GCS{
-		bucket:   client.Bucket(bucket),
-		basePath: basePath,
-		closer:   client,
+		bucket:    client.Bucket(bucket),
+		basePath:  basePath,
+		metadata:  metadata,
+		compress:  compress,
+		retention: retention,
+		closer:    client,
 	}, nil
 }
 
+// gcsEndpointOptions returns the client options for on-prem stores and emulators exposing the GCS JSON API, as
+// configured by the query string of the storage URL, e.g. gcs://bucket/path?endpoint=https://gcs.internal. The
+// parameters are:
+//   - endpoint: base URL of the store, to which the JSON API path is appended
+//   - insecure: skip verifying the store's TLS certificate, e.g. one signed by an internal CA
+//   - anonymous: send requests without credentials, as emulators such as fake-gcs-server expect
+//
+// Objects are always addressed by path, as the GCS APIs do, so there's no path-style option to set.
+func gcsEndpointOptions(
+	ctx context.Context,
+	query url.Values,
+	auth []option.ClientOption,
+) ([]option.ClientOption, error) {
+	for key := range query {
+		switch key {
+		case "endpoint", "insecure", "anonymous":
+		default:
+			return nil, fmt.Errorf("unsupported GCS storage URL parameter %q", key)
+		}
+	}
+
+	opts := auth
+	anonymous, err := boolParam(query, "anonymous")
+	if err != nil {
+		return nil, err
+	}
+	if anonymous {
+		if len(auth) > 0 {
+			return nil, errors.New("anonymous GCS access cannot be combined with credentials")
+		}
+		opts = []option.ClientOption{option.WithoutAuthentication()}
+	}
+
+	if endpoint := query.Get("endpoint"); endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid GCS endpoint %q, expected a URL such as https://gcs.internal", endpoint)
+		}
+		u.Path = path.Join(u.Path, "storage/v1") + "/"
+		opts = append(opts, option.WithEndpoint(u.String()))
+	}
+
+	insecure, err := boolParam(query, "insecure")
+	if err != nil || !insecure {
+		return opts, err
+	}
+	// Supplying an HTTP client takes over authentication, so credentials are applied by its transport instead
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	transport, err := htransport.NewTransport(ctx, base, append(opts, option.WithScopes(storage.ScopeFullControl))...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS transport: %w", err)
+	}
+	return append(opts, option.WithHTTPClient(&http.Client{Transport: transport})), nil
+}
+
+// boolParam parses the named boolean query parameter, which is false when absent
+func boolParam(query url.Values, name string) (bool, error) {
+	if !query.Has(name) {
+		return false, nil
+	}
+	v, err := strconv.ParseBool(query.Get(name))
+	if err != nil {
+		return false, fmt.Errorf("invalid storage URL parameter %s=%q, expected true or false", name, query.Get(name))
+	}
+	return v, nil
+}
+
+// gcsClientOptions returns the client options for explicitly supplied credentials. Without any, the client falls back
+// to application default credentials.
+func gcsClientOptions(o options) []option.ClientOption {
+	switch {
+	case len(o.gcsCredentialsJSON) > 0:
+		return []option.ClientOption{option.WithCredentialsJSON(o.gcsCredentialsJSON)}
+	case o.gcsCredentialsFile != "":
+		return []option.ClientOption{option.WithCredentialsFile(o.gcsCredentialsFile)}
+	default:
+		return nil
+	}
+}
+
 func (gcs *GCS) Create(ctx context.Context, relativePath string) (io.WriteCloser, error) {
 	fullPath := path.Join(gcs.basePath, relativePath)
+
+	var w io.WriteCloser
+	if gcs.finalizeRetries > 0 {
+		rw, err := gcs.newReplayingWriter(ctx, fullPath)
+		if err != nil {
+			return nil, err
+		}
+		w = rw
+	} else {
+		w = gcs.newObjectWriter(ctx, fullPath)
+	}
+	if !gcs.compress {
+		return w, nil
+	}
+	// Compressed as it's uploaded
+	return &gzipWriteCloser{Writer: gzip.NewWriter(w), w: w}, nil
+}
+
+// newObjectWriter starts uploading the object at the full path
+func (gcs *GCS) newObjectWriter(ctx context.Context, fullPath string) *verifyingWriter {
+	// Cancelling the upload before it's closed discards the object, which is how it's aborted
+	ctx, cancel := context.WithCancel(ctx)
 	wc := gcs.bucket.Object(fullPath).NewWriter(ctx)
 	wc.ChunkSize = 0
-	return wc, nil
+	wc.Metadata = gcs.metadata
+	if gcs.retention > 0 {
+		// Locked, so that neither the object nor its retention can be changed until it expires
+		wc.Retention = &storage.ObjectRetention{
+			Mode:        "Locked",
+			RetainUntil: time.Now().Add(gcs.retention).UTC(),
+		}
+	}
+	if gcs.compress {
+		// Marked as compressed, so that it's transparently decompressed when downloaded
+		wc.ContentEncoding = "gzip"
+	}
+	vw := newVerifyingWriter(wc)
+	vw.cancel = cancel
+	return vw
 }
 
 func (gcs *GCS) Exists(ctx context.Context, relativePath string) (bool, error) {
@@ -46,6 +196,125 @@ func (gcs *GCS) Exists(ctx context.Context, relativePath string) (bool, error) {
 	return true, nil
 }
 
+// Open reads back the object at the path. Objects uploaded with store compression are decompressed as they're read.
+func (gcs *GCS) Open(ctx context.Context, relativePath string) (io.ReadCloser, error) {
+	fullPath := path.Join(gcs.basePath, relativePath)
+	return gcs.bucket.Object(fullPath).NewReader(ctx)
+}
+
+// List returns the path of every object beneath the base path, relative to it and in lexical order
+func (gcs *GCS) List(ctx context.Context) ([]string, error) {
+	var prefix string
+	if gcs.basePath != "" {
+		prefix = path.Join(gcs.basePath) + "/"
+	}
+	query := &storage.Query{Prefix: prefix}
+	if err := query.SetAttrSelection([]string{"Name"}); err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	it := gcs.bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return paths, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+		paths = append(paths, strings.TrimPrefix(attrs.Name, prefix))
+	}
+}
+
+func (gcs *GCS) Remove(ctx context.Context, relativePath string) error {
+	fullPath := path.Join(gcs.basePath, relativePath)
+	return gcs.bucket.Object(fullPath).Delete(ctx)
+}
+
 func (gcs *GCS) Close() error {
 	return gcs.closer.Close()
 }
+
+// gzipWriteCloser compresses everything written to the underlying writer, closing it once the gzip stream is complete
+type gzipWriteCloser struct {
+	*gzip.Writer
+	w io.WriteCloser
+}
+
+func (g *gzipWriteCloser) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		return errors.Join(fmt.Errorf("failed to close gzip writer: %w", err), g.w.Close())
+	}
+	return g.w.Close()
+}
+
+func (g *gzipWriteCloser) Abort() error {
+	if a, ok := g.w.(Aborter); ok {
+		return a.Abort()
+	}
+	return g.w.Close()
+}
+
+// objectWriter writes an object, exposing its attributes once finalized, it is satisfied by *storage.Writer
+type objectWriter interface {
+	io.WriteCloser
+	Attrs() *storage.ObjectAttrs
+}
+
+// verifyingWriter accumulates the CRC32C and size of everything written, which are compared against the attributes
+// of the finalized object on Close. This guards against an upload being silently truncated, in which case the
+// documents would otherwise be deleted without being durably stored.
+type verifyingWriter struct {
+	w      objectWriter
+	crc    hash.Hash32
+	size   int64
+	cancel context.CancelFunc // cancels the upload, if it can be
+}
+
+func newVerifyingWriter(w objectWriter) *verifyingWriter {
+	return &verifyingWriter{
+		w:   w,
+		crc: crc32.New(crc32.MakeTable(crc32.Castagnoli)),
+	}
+}
+
+func (v *verifyingWriter) Write(p []byte) (int, error) {
+	n, err := v.w.Write(p)
+	v.crc.Write(p[:n])
+	v.size += int64(n)
+	return n, err
+}
+
+func (v *verifyingWriter) Close() error {
+	if v.cancel != nil {
+		defer v.cancel()
+	}
+	if err := v.w.Close(); err != nil {
+		return err
+	}
+	attrs := v.w.Attrs()
+	if attrs == nil {
+		return fmt.Errorf("%w: object attributes unavailable", ErrChecksumMismatch)
+	}
+	if attrs.Size != v.size {
+		return fmt.Errorf("%w: wrote %d bytes, object holds %d", ErrChecksumMismatch, v.size, attrs.Size)
+	}
+	if crc := v.crc.Sum32(); attrs.CRC32C != crc {
+		return fmt.Errorf("%w: wrote crc32c %08x, object has %08x", ErrChecksumMismatch, crc, attrs.CRC32C)
+	}
+	return nil
+}
+
+// Abort cancels the upload, so that the object is never finalized
+func (v *verifyingWriter) Abort() error {
+	if v.cancel == nil {
+		return errors.New("upload cannot be aborted")
+	}
+	v.cancel()
+	// Closing a cancelled upload reports the cancellation, which is expected
+	if err := v.w.Close(); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
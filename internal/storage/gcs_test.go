@@ -0,0 +1,554 @@
+package storage_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"maps"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+	raw "google.golang.org/api/storage/v1"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/storage"
+)
+
+func TestVerifyingWriter(t *testing.T) {
+	t.Parallel()
+
+	data := []byte("some archived data")
+	crc := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+
+	tests := map[string]struct {
+		attrs    *gcs.ObjectAttrs
+		mismatch bool
+	}{
+		"match": {
+			attrs: &gcs.ObjectAttrs{Size: int64(len(data)), CRC32C: crc},
+		},
+		"truncated": {
+			attrs:    &gcs.ObjectAttrs{Size: int64(len(data)) - 1, CRC32C: crc},
+			mismatch: true,
+		},
+		"crc mismatch": {
+			attrs:    &gcs.ObjectAttrs{Size: int64(len(data)), CRC32C: crc + 1},
+			mismatch: true,
+		},
+		"missing attributes": {
+			mismatch: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			w := storage.NewVerifyingWriter(&mockObjectWriter{attrs: tt.attrs})
+			_, err := w.Write(data[:5])
+			require.NoError(t, err)
+			_, err = w.Write(data[5:])
+			require.NoError(t, err)
+
+			err = w.Close()
+			if tt.mismatch {
+				assert.ErrorIs(t, err, storage.ErrChecksumMismatch)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+type mockObjectWriter struct {
+	bytes.Buffer
+	attrs *gcs.ObjectAttrs
+}
+
+func (m *mockObjectWriter) Close() error {
+	return nil
+}
+
+func (m *mockObjectWriter) Attrs() *gcs.ObjectAttrs {
+	return m.attrs
+}
+
+func TestGCSClientOptions(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, storage.GCSClientOptions())
+	assert.Empty(t, storage.GCSClientOptions(storage.WithMinFreeBytes(1)))
+
+	assert.Equal(
+		t,
+		[]option.ClientOption{option.WithCredentialsFile("/secrets/key.json")},
+		storage.GCSClientOptions(storage.WithGCSCredentialsFile("/secrets/key.json")),
+	)
+
+	key := []byte(`{"type":"service_account"}`)
+	assert.Equal(
+		t,
+		[]option.ClientOption{option.WithCredentialsJSON(key)},
+		storage.GCSClientOptions(storage.WithGCSCredentialsJSON(key)),
+	)
+}
+
+// fakeGCS serves multipart uploads, recording each object along with its content
+type fakeGCS struct {
+	mu       sync.Mutex
+	objects  map[string]*raw.Object
+	contents map[string][]byte
+	uploads  int
+	// failUploads is the number of uploads failing to be finalized before they succeed
+	failUploads int
+}
+
+// newFakeGCS starts a fake GCS server, returning it along with the client options pointing at it
+func newFakeGCS(t *testing.T) (*fakeGCS, []option.ClientOption) {
+	t.Helper()
+
+	f := &fakeGCS{
+		objects:  make(map[string]*raw.Object),
+		contents: make(map[string][]byte),
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	return f, []option.ClientOption{
+		option.WithEndpoint(srv.URL + "/storage/v1/"),
+		option.WithoutAuthentication(),
+	}
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		f.serveRead(w, r)
+		return
+	}
+
+	// Uploads are multipart, the first part being the object resource and the second its content
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+
+	var object raw.Object
+	part, err := mr.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&object)
+	}
+	var content []byte
+	if err == nil {
+		if part, err = mr.NextPart(); err == nil {
+			content, err = io.ReadAll(part)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum(content, crc32.MakeTable(crc32.Castagnoli)))
+	object.Bucket = "bucket"
+	object.Size = uint64(len(content))
+	object.Crc32c = base64.StdEncoding.EncodeToString(crc)
+
+	f.mu.Lock()
+	f.uploads++
+	if f.failUploads > 0 {
+		f.failUploads--
+		f.mu.Unlock()
+		http.Error(w, "backend error", http.StatusServiceUnavailable)
+		return
+	}
+	f.objects[object.Name] = &object
+	f.contents[object.Name] = content
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&object)
+}
+
+// serveRead serves listing objects via the JSON API, and downloading them via the XML API
+func (f *fakeGCS) serveRead(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/storage/v1/b/bucket/o" {
+		var list raw.Objects
+		for _, name := range slices.Sorted(maps.Keys(f.objects)) {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				list.Items = append(list.Items, f.objects[name])
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&list)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	content, ok := f.contents[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if encoding := f.objects[name].ContentEncoding; encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	_, _ = w.Write(content)
+}
+
+func TestGCS_Read(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	_, opts := newFakeGCS(t)
+	store, err := storage.NewGCS(ctx, "bucket", "archive", nil, false, 0, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	other, err := storage.NewGCS(ctx, "bucket", "archive-other", nil, true, 0, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = other.Close()
+	})
+
+	for _, s := range []*storage.GCS{store, other} {
+		for _, name := range []string{"2024/11/02.json", "2024/11/01.json"} {
+			w, err := s.Create(ctx, name)
+			require.NoError(t, err)
+			_, err = w.Write([]byte(`{"_id":1}` + "\n"))
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+		}
+	}
+
+	// Only the objects beneath the base path are listed, relative to it
+	paths, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2024/11/01.json", "2024/11/02.json"}, paths)
+
+	// Objects are read back as written, including those compressed by the store
+	for _, s := range []*storage.GCS{store, other} {
+		r, err := s.Open(ctx, "2024/11/01.json")
+		require.NoError(t, err)
+		content, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		assert.Equal(t, `{"_id":1}`+"\n", string(content))
+	}
+
+	_, err = store.Open(ctx, "2024/11/03.json")
+	assert.ErrorIs(t, err, gcs.ErrObjectNotExist)
+}
+
+func TestGCS_ObjectMetadata(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	fake, opts := newFakeGCS(t)
+	metadata := map[string]string{"tier": "cold", "collection": "events"}
+	store, err := storage.NewGCS(ctx, "bucket", "archive", metadata, false, 0, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	w, err := store.Create(ctx, "2024/11/01.json.gz")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("some archived data"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	require.Contains(t, fake.objects, "archive/2024/11/01.json.gz")
+	assert.Equal(t, metadata, fake.objects["archive/2024/11/01.json.gz"].Metadata)
+	assert.Empty(t, fake.objects["archive/2024/11/01.json.gz"].ContentEncoding)
+}
+
+func TestGCS_StoreCompression(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	fake, opts := newFakeGCS(t)
+	store, err := storage.NewGCS(ctx, "bucket", "archive", nil, true, 0, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	docs := `{"_id":1}` + "\n" + `{"_id":2}` + "\n"
+	w, err := store.Create(ctx, "2024/11/01.json")
+	require.NoError(t, err)
+	_, err = w.Write([]byte(docs))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	require.Contains(t, fake.objects, "archive/2024/11/01.json")
+	assert.Equal(t, "gzip", fake.objects["archive/2024/11/01.json"].ContentEncoding)
+
+	// The object is held compressed, to be decompressed by GCS as it's downloaded
+	gr, err := gzip.NewReader(bytes.NewReader(fake.contents["archive/2024/11/01.json"]))
+	require.NoError(t, err)
+	decompressed, err := io.ReadAll(gr)
+	require.NoError(t, err)
+	assert.Equal(t, docs, string(decompressed))
+}
+
+func TestParseMetadata(t *testing.T) {
+	t.Parallel()
+
+	metadata, err := storage.ParseMetadata([]string{"tier=cold", "collection=events", "empty="})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"tier": "cold", "collection": "events", "empty": ""}, metadata)
+
+	_, err = storage.ParseMetadata([]string{"tier"})
+	assert.ErrorContains(t, err, "expected key=value")
+
+	_, err = storage.ParseMetadata([]string{"=cold"})
+	assert.ErrorContains(t, err, "expected key=value")
+
+	_, err = storage.ParseMetadata([]string{"tier=cold", "tier=hot"})
+	assert.ErrorContains(t, err, "more than once")
+}
+
+func TestFromURL_ObjectMetadataUnsupported(t *testing.T) {
+	t.Parallel()
+
+	_, err := storage.FromURL(
+		context.Background(),
+		"file://"+t.TempDir(),
+		storage.WithObjectMetadata(map[string]string{"tier": "cold"}),
+	)
+	assert.ErrorContains(t, err, "does not support object metadata")
+}
+
+func TestGCS_Abort(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	fake, opts := newFakeGCS(t)
+	store, err := storage.NewGCS(ctx, "bucket", "archive", nil, false, 0, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	w, err := store.Create(ctx, "2024/11/01.json.gz")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("some malformed data"))
+	require.NoError(t, err)
+	require.Implements(t, (*storage.Aborter)(nil), w)
+	require.NoError(t, w.(storage.Aborter).Abort())
+
+	// The upload is cancelled before it's finalized, so no object is created
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.NotContains(t, fake.objects, "archive/2024/11/01.json.gz")
+}
+
+func TestFromURL_GCSEndpoint(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// An on-prem store whose certificate isn't trusted, e.g. as it's signed by an internal CA
+	fake := &fakeGCS{
+		objects:  make(map[string]*raw.Object),
+		contents: make(map[string][]byte),
+	}
+	srv := httptest.NewTLSServer(fake)
+	t.Cleanup(srv.Close)
+
+	write := func(storageURL string) error {
+		store, err := storage.FromURL(ctx, storageURL)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		w, err := store.Create(ctx, "2024/11/01.json.gz")
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write([]byte("some archived data")); err != nil {
+			return err
+		}
+		return w.Close()
+	}
+
+	t.Run("insecure", func(t *testing.T) {
+		t.Parallel()
+
+		require.NoError(t, write("gcs://bucket/insecure?anonymous=true&insecure=true&endpoint="+url.QueryEscape(srv.URL)))
+
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		assert.Equal(t, []byte("some archived data"), fake.contents["insecure/2024/11/01.json.gz"])
+	})
+
+	t.Run("verified", func(t *testing.T) {
+		t.Parallel()
+
+		err := write("gcs://bucket/verified?anonymous=true&endpoint=" + url.QueryEscape(srv.URL))
+		assert.ErrorContains(t, err, "certificate")
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		t.Parallel()
+
+		for query, expected := range map[string]string{
+			"?path-style=true":       `unsupported GCS storage URL parameter "path-style"`,
+			"?insecure=maybe":        `invalid storage URL parameter insecure="maybe"`,
+			"?endpoint=gcs.internal": `invalid GCS endpoint "gcs.internal"`,
+		} {
+			_, err := storage.FromURL(ctx, "gcs://bucket/archive"+query)
+			assert.ErrorContains(t, err, expected)
+		}
+
+		_, err := storage.FromURL(
+			ctx,
+			"gcs://bucket/archive?anonymous=true",
+			storage.WithGCSCredentialsJSON([]byte(`{"type":"service_account"}`)),
+		)
+		assert.ErrorContains(t, err, "anonymous GCS access cannot be combined with credentials")
+	})
+}
+
+func TestGCS_WORMRetention(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	fake, opts := newFakeGCS(t)
+	retention := 7 * 365 * 24 * time.Hour
+	store, err := storage.NewGCS(ctx, "bucket", "archive", nil, false, retention, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	before := time.Now()
+	w, err := store.Create(ctx, "2024/11/01.json.gz")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("some archived data"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	after := time.Now()
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	require.Contains(t, fake.objects, "archive/2024/11/01.json.gz")
+	objectRetention := fake.objects["archive/2024/11/01.json.gz"].Retention
+	require.NotNil(t, objectRetention)
+	assert.Equal(t, "Locked", objectRetention.Mode)
+
+	// Retained for the duration from when the object was created
+	retainUntil, err := time.Parse(time.RFC3339, objectRetention.RetainUntilTime)
+	require.NoError(t, err)
+	assert.False(t, retainUntil.Before(before.Add(retention).Truncate(time.Second)))
+	assert.False(t, retainUntil.After(after.Add(retention)))
+}
+
+func TestFromURL_WORMRetentionUnsupported(t *testing.T) {
+	t.Parallel()
+
+	for _, storageURL := range []string{"file:///tmp/archive", "noop://", "kafka://localhost:9092/topic"} {
+		_, err := storage.FromURL(context.Background(), storageURL, storage.WithWORMRetention(time.Hour))
+		assert.ErrorContains(t, err, "does not support object lock retention")
+	}
+}
+
+func TestGCS_FinalizeRetries(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("some archived data\n"), 1000)
+
+	write := func(t *testing.T, fake *fakeGCS, retries int) error {
+		t.Helper()
+
+		srv := httptest.NewServer(fake)
+		t.Cleanup(srv.Close)
+		store, err := storage.FromURL(
+			ctx,
+			"gcs://bucket/archive?anonymous=true&endpoint="+url.QueryEscape(srv.URL),
+			storage.WithGCSFinalizeRetries(retries, time.Millisecond),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = store.Close()
+		})
+
+		w, err := store.Create(ctx, "2024/11/01.json.gz")
+		require.NoError(t, err)
+		for chunk := range slices.Chunk(data, 4096) {
+			_, err = w.Write(chunk)
+			require.NoError(t, err)
+		}
+		return w.Close()
+	}
+
+	t.Run("committed once finalize succeeds", func(t *testing.T) {
+		t.Parallel()
+
+		fake := &fakeGCS{
+			objects:     make(map[string]*raw.Object),
+			contents:    make(map[string][]byte),
+			failUploads: 1,
+		}
+		// Close succeeding is what allows the archiver to go on to delete the day's documents
+		require.NoError(t, write(t, fake, 2))
+
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		assert.Equal(t, 2, fake.uploads)
+		assert.Equal(t, data, fake.contents["archive/2024/11/01.json.gz"])
+	})
+
+	t.Run("fails once retries are exhausted", func(t *testing.T) {
+		t.Parallel()
+
+		fake := &fakeGCS{
+			objects:     make(map[string]*raw.Object),
+			contents:    make(map[string][]byte),
+			failUploads: 3,
+		}
+		require.Error(t, write(t, fake, 2))
+
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		assert.Equal(t, 3, fake.uploads)
+		assert.NotContains(t, fake.objects, "archive/2024/11/01.json.gz")
+	})
+}
+
+func TestFromURL_FinalizeRetriesUnsupported(t *testing.T) {
+	t.Parallel()
+
+	_, err := storage.FromURL(context.Background(), "file://"+t.TempDir(), storage.WithGCSFinalizeRetries(1, time.Second))
+	assert.ErrorContains(t, err, "does not support finalize retries")
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// WithGCSFinalizeRetries causes GCS uploads failing to finalize, which only happens once the file is closed, to be
+// retried in full up to the supplied number of times, waiting backoff longer before each attempt. Everything written
+// is also written to a temporary file, from which the object is uploaded again, so each file being written takes up
+// as much temporary disk space as it does uncompressed by the store. Closing the file only succeeds once an upload has
+// been finalized and verified, so documents are never deleted before then.
+func WithGCSFinalizeRetries(retries int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.finalizeRetries = retries
+		o.finalizeBackoff = backoff
+	}
+}
+
+// replayingWriter uploads an object whilst keeping a copy of everything written in a temporary file, from which the
+// whole upload is retried should it fail to be finalized. Uploads can't be resumed once they've failed, but they can
+// be restarted, as the object is only created once finalized.
+type replayingWriter struct {
+	ctx      context.Context
+	gcs      *GCS
+	fullPath string
+	upload   *verifyingWriter
+	replay   *os.File
+	// uploadErr is the error the upload failed with whilst being written, after which only the copy is written to,
+	// with the upload restarted once closed
+	uploadErr error
+}
+
+func (gcs *GCS) newReplayingWriter(ctx context.Context, fullPath string) (*replayingWriter, error) {
+	replay, err := os.CreateTemp("", "gcs-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload copy: %w", err)
+	}
+	return &replayingWriter{
+		ctx:      ctx,
+		gcs:      gcs,
+		fullPath: fullPath,
+		upload:   gcs.newObjectWriter(ctx, fullPath),
+		replay:   replay,
+	}, nil
+}
+
+func (r *replayingWriter) Write(p []byte) (int, error) {
+	if n, err := r.replay.Write(p); err != nil {
+		return n, fmt.Errorf("failed to write upload copy: %w", err)
+	}
+	if r.uploadErr == nil {
+		if _, err := r.upload.Write(p); err != nil {
+			r.uploadErr = err
+		}
+	}
+	return len(p), nil
+}
+
+// Close finalizes the upload, restarting it from the copy should it fail, until it succeeds or the retries are
+// exhausted
+func (r *replayingWriter) Close() error {
+	defer r.discard()
+
+	err := r.uploadErr
+	if err != nil {
+		err = errors.Join(err, r.upload.Abort())
+	} else {
+		err = r.upload.Close()
+	}
+	for attempt := 1; err != nil; attempt++ {
+		if attempt > r.gcs.finalizeRetries || r.ctx.Err() != nil {
+			return err
+		}
+		slog.Warn(
+			"failed to finalize object, retrying upload",
+			slog.String("fileName", r.fullPath),
+			slog.Int("attempt", attempt),
+			slog.Any("error", err),
+		)
+		select {
+		case <-r.ctx.Done():
+			return errors.Join(err, r.ctx.Err())
+		case <-time.After(r.gcs.finalizeBackoff * time.Duration(attempt)):
+		}
+		err = r.retry()
+	}
+	return nil
+}
+
+// retry uploads the object again from the copy
+func (r *replayingWriter) retry() error {
+	if _, err := r.replay.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind upload copy: %w", err)
+	}
+	r.upload = r.gcs.newObjectWriter(r.ctx, r.fullPath)
+	if _, err := io.Copy(r.upload, r.replay); err != nil {
+		return errors.Join(err, r.upload.Abort())
+	}
+	return r.upload.Close()
+}
+
+// Abort cancels the upload, so that the object is never finalized
+func (r *replayingWriter) Abort() error {
+	defer r.discard()
+	return r.upload.Abort()
+}
+
+// discard removes the copy
+func (r *replayingWriter) discard() {
+	_ = r.replay.Close()
+	if err := os.Remove(r.replay.Name()); err != nil {
+		slog.Warn("failed to remove upload copy", slog.String("fileName", r.replay.Name()), slog.Any("error", err))
+	}
+}
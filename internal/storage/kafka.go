@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// kafkaBatchSize is the number of documents produced per request
+	kafkaBatchSize = 1000
+
+	// kafkaMaxDocumentSize bounds the size of a single document read from an archive
+	kafkaMaxDocumentSize = 64 << 20
+
+	// kafkaFileHeader is the message header holding the archive file a document was written to
+	kafkaFileHeader = "file"
+)
+
+// messageWriter produces messages to a topic, it is satisfied by *kafka.Writer
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Kafka is a write-only sink which publishes each archived document as a message, rather than storing files. Archives
+// are decompressed as they are written, with each newline delimited document produced keyed by its _id.
+type Kafka struct {
+	writer messageWriter
+}
+
+func newKafka(brokers, topic string) (*Kafka, error) {
+	if brokers == "" || topic == "" {
+		return nil, fmt.Errorf("kafka storage URL must be of the form kafka://broker[,broker]/topic")
+	}
+	return newKafkaWithWriter(&kafka.Writer{
+		Addr:  kafka.TCP(strings.Split(brokers, ",")...),
+		Topic: topic,
+		// Documents are deleted from mongo once the archive is closed, so every replica must have acknowledged them
+		RequiredAcks: kafka.RequireAll,
+		// Messages for the same _id are always routed to the same partition
+		Balancer:     &kafka.Hash{},
+		BatchSize:    kafkaBatchSize,
+		BatchTimeout: time.Millisecond * 10,
+	}), nil
+}
+
+func newKafkaWithWriter(writer messageWriter) *Kafka {
+	return &Kafka{writer: writer}
+}
+
+// Create returns a writer accepting a gzip compressed archive. Documents are produced as they are decompressed, with
+// Close only returning once all documents have been acknowledged.
+func (k *Kafka) Create(ctx context.Context, relativePath string) (io.WriteCloser, error) {
+	if !strings.HasSuffix(relativePath, ".gz") {
+		return nil, fmt.Errorf("kafka storage only accepts gzip compressed archives, cannot write %s", relativePath)
+	}
+
+	pr, pw := io.Pipe()
+	w := &kafkaArchiveWriter{
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+	go func() {
+		err := k.produce(ctx, relativePath, pr)
+		// Unblock any pending writes should producing fail part way through
+		_ = pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+// Exists always reports false, as published documents cannot be looked up by file
+func (k *Kafka) Exists(_ context.Context, _ string) (bool, error) {
+	return false, nil
+}
+
+func (k *Kafka) Close() error {
+	return k.writer.Close()
+}
+
+func (k *Kafka) produce(ctx context.Context, file string, r io.Reader) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	scanner := bufio.NewScanner(gr)
+	scanner.Buffer(nil, kafkaMaxDocumentSize)
+
+	batch := make([]kafka.Message, 0, kafkaBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := k.writer.WriteMessages(ctx, batch...); err != nil {
+			return fmt.Errorf("failed to produce messages: %w", err)
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for scanner.Scan() {
+		doc := bytes.Clone(scanner.Bytes())
+		batch = append(batch, kafka.Message{
+			Key:     documentKey(doc),
+			Value:   doc,
+			Headers: []kafka.Header{{Key: kafkaFileHeader, Value: []byte(file)}},
+		})
+		if len(batch) == kafkaBatchSize {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	return flush()
+}
+
+// documentKey returns the _id of the document as extended JSON, or nil if it has none
+func documentKey(doc []byte) []byte {
+	var decoded struct {
+		ID json.RawMessage `json:"_id"`
+	}
+	if err := json.Unmarshal(doc, &decoded); err != nil {
+		return nil
+	}
+	return decoded.ID
+}
+
+// kafkaArchiveWriter streams an archive to the goroutine producing its documents
+type kafkaArchiveWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *kafkaArchiveWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *kafkaArchiveWriter) Close() error {
+	_ = w.pw.Close()
+	return <-w.done
+}
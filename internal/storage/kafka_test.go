@@ -0,0 +1,116 @@
+package storage_test
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/storage"
+)
+
+func TestKafka(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("produces each document", func(t *testing.T) {
+		t.Parallel()
+
+		writer := &mockMessageWriter{}
+		store := storage.NewKafkaWithWriter(writer)
+
+		w, err := store.Create(ctx, "2024/11/01.json.gz")
+		require.NoError(t, err)
+
+		gw := gzip.NewWriter(w)
+		for i := range 1500 {
+			_, err = fmt.Fprintf(gw, `{"_id":{"$oid":"%024x"},"n":%d}`+"\n", i, i)
+			require.NoError(t, err)
+		}
+		_, err = gw.Write([]byte(`{"n":1500}` + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+		require.NoError(t, w.Close())
+
+		require.Len(t, writer.messages, 1501)
+		assert.Equal(t, 2, writer.calls) // batched
+
+		first := writer.messages[0]
+		assert.Equal(t, `{"$oid":"000000000000000000000000"}`, string(first.Key))
+		assert.Equal(t, `{"_id":{"$oid":"000000000000000000000000"},"n":0}`, string(first.Value))
+		assert.Equal(t, []kafka.Header{{Key: "file", Value: []byte("2024/11/01.json.gz")}}, first.Headers)
+
+		last := writer.messages[1500]
+		assert.Nil(t, last.Key)
+		assert.Equal(t, `{"n":1500}`, string(last.Value))
+
+		exists, err := store.Exists(ctx, "2024/11/01.json.gz")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("produce failure", func(t *testing.T) {
+		t.Parallel()
+
+		writer := &mockMessageWriter{err: errors.New("not enough replicas")}
+		store := storage.NewKafkaWithWriter(writer)
+
+		w, err := store.Create(ctx, "2024/11/01.json.gz")
+		require.NoError(t, err)
+
+		gw := gzip.NewWriter(w)
+		_, err = gw.Write([]byte(`{"_id":1}` + "\n"))
+		require.NoError(t, err)
+		_ = gw.Close()
+
+		err = w.Close()
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "not enough replicas")
+	})
+
+	t.Run("uncompressed file", func(t *testing.T) {
+		t.Parallel()
+
+		store := storage.NewKafkaWithWriter(&mockMessageWriter{})
+
+		_, err := store.Create(ctx, "2024/11/01.header.json")
+		assert.Error(t, err)
+	})
+}
+
+type mockMessageWriter struct {
+	mu       sync.Mutex
+	messages []kafka.Message
+	calls    int
+	err      error
+}
+
+func (m *mockMessageWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+	m.calls++
+	m.messages = append(m.messages, msgs...)
+	return nil
+}
+
+func (m *mockMessageWriter) Close() error {
+	return nil
+}
+
+func TestFromURL_KafkaStoreCompressionUnsupported(t *testing.T) {
+	t.Parallel()
+
+	_, err := storage.FromURL(context.Background(), "kafka://localhost:9092/archive", storage.WithStoreCompression())
+	assert.ErrorContains(t, err, "does not support store compression")
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+// limited bounds the number of concurrent upload operations against the underlying store. Each call to Create, and
+// to Write and Close on the files it creates, holds a slot only for its own duration, so any number of files may be
+// open at once without risk of deadlock, with only the operations which transfer data being bounded.
+type limited struct {
+	store Store
+	slots chan struct{}
+}
+
+// limitedAppender bounds the appends of the underlying store as for Create
+type limitedAppender struct {
+	*limited
+	appender Appender
+}
+
+// newLimited wraps the store, preserving each of the optional capabilities of the underlying store so that they may
+// still be detected by the archiver. Reads, listing, removes and existence checks aren't uploads so aren't bounded.
+func newLimited(store Store, n int) Store {
+	l := &limited{
+		store: store,
+		slots: make(chan struct{}, n),
+	}
+	var a Appender
+	if s, ok := store.(Appender); ok {
+		a = &limitedAppender{limited: l, appender: s}
+	}
+	return forward(l, store, a)
+}
+
+func (l *limited) Create(ctx context.Context, path string) (io.WriteCloser, error) {
+	if err := l.acquireContext(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+
+	w, err := l.store.Create(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return l.newLimitedWriter(w), nil
+}
+
+func (l *limitedAppender) Append(ctx context.Context, path string, offset int64) (io.WriteCloser, error) {
+	if err := l.acquireContext(ctx); err != nil {
+		return nil, err
+	}
+	defer l.release()
+
+	w, err := l.appender.Append(ctx, path, offset)
+	if err != nil {
+		return nil, err
+	}
+	return l.newLimitedWriter(w), nil
+}
+
+func (l *limited) Close() error {
+	return l.store.Close()
+}
+
+// acquireContext waits for a slot, giving up should the context be cancelled first
+func (l *limited) acquireContext(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *limited) acquire() {
+	l.slots <- struct{}{}
+}
+
+func (l *limited) release() {
+	<-l.slots
+}
+
+type limitedWriter struct {
+	w       io.WriteCloser
+	limited *limited
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	w.limited.acquire()
+	defer w.limited.release()
+
+	return w.w.Write(p)
+}
+
+func (w *limitedWriter) Close() error {
+	w.limited.acquire()
+	defer w.limited.release()
+
+	return w.w.Close()
+}
+
+// limitedAborter is a limited file whose underlying file can be aborted, which isn't an upload so isn't bounded
+type limitedAborter struct {
+	*limitedWriter
+	aborter Aborter
+}
+
+func (w *limitedAborter) Abort() error {
+	return w.aborter.Abort()
+}
+
+// limitedCommitter is a limited file whose underlying file can be committed, with commits bounded as for writes
+type limitedCommitter struct {
+	*limitedWriter
+	committer Committer
+}
+
+func (w *limitedCommitter) Commit() error {
+	w.limited.acquire()
+	defer w.limited.release()
+
+	return w.committer.Commit()
+}
+
+// limitedAbortCommitter is a limited file whose underlying file can be both aborted and committed
+type limitedAbortCommitter struct {
+	*limitedCommitter
+	aborter Aborter
+}
+
+func (w *limitedAbortCommitter) Abort() error {
+	return w.aborter.Abort()
+}
+
+// newLimitedWriter wraps the file, preserving its ability to be aborted and committed
+func (l *limited) newLimitedWriter(w io.WriteCloser) io.WriteCloser {
+	lw := &limitedWriter{w: w, limited: l}
+	a, canAbort := w.(Aborter)
+	c, canCommit := w.(Committer)
+	switch {
+	case canAbort && canCommit:
+		return &limitedAbortCommitter{limitedCommitter: &limitedCommitter{limitedWriter: lw, committer: c}, aborter: a}
+	case canAbort:
+		return &limitedAborter{limitedWriter: lw, aborter: a}
+	case canCommit:
+		return &limitedCommitter{limitedWriter: lw, committer: c}
+	default:
+		return lw
+	}
+}
@@ -0,0 +1,216 @@
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/storage"
+)
+
+func TestLimited(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("bounds concurrent uploads", func(t *testing.T) {
+		t.Parallel()
+
+		inner := &instrumentedStore{}
+		store := storage.NewLimited(inner, 3)
+
+		var wg sync.WaitGroup
+		for i := range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				w, err := store.Create(ctx, fmt.Sprintf("%02d.json.gz", i))
+				if !assert.NoError(t, err) {
+					return
+				}
+				for range 5 {
+					_, err = w.Write([]byte("{}\n"))
+					assert.NoError(t, err)
+				}
+				assert.NoError(t, w.Close())
+			}()
+		}
+		wg.Wait()
+
+		assert.LessOrEqual(t, inner.peak.Load(), int64(3))
+		assert.Equal(t, int64(20*7), inner.operations.Load()) // a create, five writes and a close each
+	})
+
+	t.Run("files held open do not hold slots", func(t *testing.T) {
+		t.Parallel()
+
+		store := storage.NewLimited(&instrumentedStore{}, 1)
+
+		first, err := store.Create(ctx, "first.json.gz")
+		require.NoError(t, err)
+		second, err := store.Create(ctx, "second.json.gz")
+		require.NoError(t, err)
+
+		_, err = first.Write([]byte("{}\n"))
+		require.NoError(t, err)
+		_, err = second.Write([]byte("{}\n"))
+		require.NoError(t, err)
+		require.NoError(t, first.Close())
+		require.NoError(t, second.Close())
+	})
+
+	t.Run("create cancelled whilst waiting", func(t *testing.T) {
+		t.Parallel()
+
+		inner := &instrumentedStore{block: make(chan struct{})}
+		store := storage.NewLimited(inner, 1)
+
+		go func() {
+			_, _ = store.Create(ctx, "blocked.json.gz")
+		}()
+		require.Eventually(t, func() bool { return inner.active.Load() == 1 }, time.Second, time.Millisecond)
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := store.Create(cancelled, "cancelled.json.gz")
+		assert.ErrorIs(t, err, context.Canceled)
+
+		close(inner.block)
+	})
+
+	t.Run("preserves optional capabilities", func(t *testing.T) {
+		t.Parallel()
+
+		_, ok := storage.NewLimited(&instrumentedStore{}, 1).(storage.Exister)
+		assert.False(t, ok)
+
+		dir := t.TempDir()
+		disk, err := storage.FromURL(ctx, "file://"+dir)
+		require.NoError(t, err)
+		limited := storage.NewLimited(disk, 1)
+		_, ok = limited.(storage.Exister)
+		assert.True(t, ok)
+		_, ok = limited.(interface {
+			Open(ctx context.Context, path string) (io.ReadCloser, error)
+		})
+		assert.True(t, ok)
+
+		w, err := limited.Create(ctx, "file.json")
+		require.NoError(t, err)
+		_, ok = w.(storage.Aborter)
+		assert.True(t, ok)
+		_, err = w.Write([]byte("{}\n{}\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.(storage.Committer).Commit())
+		require.NoError(t, w.Close())
+
+		w, err = limited.(interface {
+			Append(ctx context.Context, path string, offset int64) (io.WriteCloser, error)
+		}).Append(ctx, "file.json", 3)
+		require.NoError(t, err)
+		_, ok = w.(storage.Aborter)
+		assert.False(t, ok) // aborting would remove what was written before
+		_, err = w.Write([]byte("[]\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.(storage.Committer).Commit())
+		require.NoError(t, w.Close())
+
+		content, err := os.ReadFile(filepath.Join(dir, "file.json"))
+		require.NoError(t, err)
+		assert.Equal(t, "{}\n[]\n", string(content))
+	})
+
+	t.Run("keeps the method sets of disk and GCS stores", func(t *testing.T) {
+		t.Parallel()
+
+		disk, err := storage.FromURL(ctx, "file://"+t.TempDir())
+		require.NoError(t, err)
+		_, opts := newFakeGCS(t)
+		gcs, err := storage.NewGCS(ctx, "bucket", "archive", nil, false, 0, opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = gcs.Close()
+		})
+
+		capabilities := map[string]func(s storage.Store) bool{
+			"Exister":  func(s storage.Store) bool { _, ok := s.(storage.Exister); return ok },
+			"Opener":   func(s storage.Store) bool { _, ok := s.(storage.Opener); return ok },
+			"Lister":   func(s storage.Store) bool { _, ok := s.(storage.Lister); return ok },
+			"Remover":  func(s storage.Store) bool { _, ok := s.(storage.Remover); return ok },
+			"Appender": func(s storage.Store) bool { _, ok := s.(storage.Appender); return ok },
+		}
+		for name, store := range map[string]storage.Store{"disk": disk, "gcs": gcs} {
+			limited := storage.NewLimited(store, 1)
+			for capability, has := range capabilities {
+				assert.Equal(t, has(store), has(limited), "%s %s", name, capability)
+			}
+		}
+
+		// Every capability of disk stores is kept, including those GCS lacks
+		for capability, has := range capabilities {
+			assert.True(t, has(storage.NewLimited(disk, 1)), capability)
+		}
+		assert.True(t, capabilities["Remover"](storage.NewLimited(gcs, 1)))
+	})
+}
+
+// instrumentedStore records the peak number of operations in progress at once, each taking long enough for others to
+// overlap with it
+type instrumentedStore struct {
+	active     atomic.Int64
+	peak       atomic.Int64
+	operations atomic.Int64
+	block      chan struct{} // when set, operations wait for it to be closed
+}
+
+func (s *instrumentedStore) Create(_ context.Context, _ string) (io.WriteCloser, error) {
+	s.operate()
+	return &instrumentedWriter{store: s}, nil
+}
+
+func (s *instrumentedStore) Close() error {
+	return nil
+}
+
+func (s *instrumentedStore) operate() {
+	active := s.active.Add(1)
+	defer s.active.Add(-1)
+
+	for {
+		peak := s.peak.Load()
+		if active <= peak || s.peak.CompareAndSwap(peak, active) {
+			break
+		}
+	}
+	s.operations.Add(1)
+
+	if s.block != nil {
+		<-s.block
+		return
+	}
+	time.Sleep(time.Millisecond)
+}
+
+type instrumentedWriter struct {
+	store *instrumentedStore
+}
+
+func (w *instrumentedWriter) Write(p []byte) (int, error) {
+	w.store.operate()
+	return len(p), nil
+}
+
+func (w *instrumentedWriter) Close() error {
+	w.store.operate()
+	return nil
+}
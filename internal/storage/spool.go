@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// spoolPartialSuffix marks spooled files which are still being written, and are discarded should they be orphaned
+	spoolPartialSuffix = ".partial"
+
+	// spoolUploadAttempts is the number of times uploading a spooled file is attempted before giving up
+	spoolUploadAttempts = 3
+	spoolUploadBackoff  = time.Second
+)
+
+// spooled writes each file to a local spool directory, uploading it to the underlying store only once it has been
+// written in full and verified, so that compressing and writing a file is decoupled from uploading it, and a failed
+// upload is retried from the spooled copy rather than by rewriting the file. Files are spooled as <name>.partial
+// whilst being written, and renamed to <name> once complete, so that files left behind by a crash can be told apart:
+// complete files are recovered by uploading them, whereas partial ones are discarded.
+type spooled struct {
+	store Store
+	dir   string
+}
+
+// spoolDir returns the spool directory of the store at the URL beneath the root spool directory, so that stores
+// sharing a root, e.g. those of tenants, never recover each other's files
+func spoolDir(root, rawURL string) string {
+	return filepath.Join(root, url.PathEscape(rawURL))
+}
+
+// newSpooled wraps the store, spooling files in the directory. Files are uploaded as they're closed, so reading back,
+// listing, removing and checking for files are forwarded to the underlying store, keeping overwrite protection and
+// verification working. Appends aren't, as they'd bypass the spool. Any complete files left in the directory by a
+// previous process are uploaded first.
+func newSpooled(ctx context.Context, store Store, dir string) (Store, error) {
+	s := &spooled{
+		store: store,
+		dir:   dir,
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	if err := s.recover(ctx); err != nil {
+		return nil, fmt.Errorf("failed to recover spooled files: %w", err)
+	}
+	return forward(s, store, nil), nil
+}
+
+func (s *spooled) Create(ctx context.Context, path string) (io.WriteCloser, error) {
+	name := filepath.Join(s.dir, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(name + spoolPartialSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &spoolFile{
+		ctx:     ctx,
+		spooled: s,
+		path:    path,
+		name:    name,
+		file:    f,
+	}, nil
+}
+
+func (s *spooled) Close() error {
+	return s.store.Close()
+}
+
+// recover uploads the complete files left in the spool directory, and discards the partial ones
+func (s *spooled) recover(ctx context.Context) error {
+	return filepath.WalkDir(s.dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasSuffix(name, spoolPartialSuffix) {
+			slog.Warn("discarding partially spooled file", slog.String("fileName", name))
+			return os.Remove(name)
+		}
+
+		rel, err := filepath.Rel(s.dir, name)
+		if err != nil {
+			return err
+		}
+		slog.Warn("recovering spooled file", slog.String("fileName", name))
+		return s.upload(ctx, filepath.ToSlash(rel), name)
+	})
+}
+
+// upload uploads the spooled file to the path in the underlying store, retrying should it fail, and then removes it
+func (s *spooled) upload(ctx context.Context, path, name string) error {
+	for attempt := 1; ; attempt++ {
+		err := s.uploadOnce(ctx, path, name)
+		if err == nil {
+			return os.Remove(name)
+		}
+		if attempt == spoolUploadAttempts {
+			return fmt.Errorf("failed to upload spooled file %s: %w", path, err)
+		}
+
+		slog.Warn(
+			"failed to upload spooled file, retrying",
+			slog.String("fileName", path),
+			slog.Int("attempt", attempt),
+			slog.Any("error", err),
+		)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(spoolUploadBackoff * time.Duration(attempt)):
+		}
+	}
+}
+
+func (s *spooled) uploadOnce(ctx context.Context, path, name string) error {
+	r, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := s.store.Create(ctx, path)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		// Abandon the upload rather than committing part of the file
+		if a, ok := w.(Aborter); ok {
+			return errors.Join(err, a.Abort())
+		}
+		return errors.Join(err, w.Close())
+	}
+	return w.Close()
+}
+
+// spoolFile is a file being written to the spool directory, which is uploaded once closed
+type spoolFile struct {
+	ctx     context.Context
+	spooled *spooled
+	path    string // the path in the underlying store
+	name    string // the path of the spooled copy, once complete
+	file    *os.File
+	written int64
+}
+
+func (f *spoolFile) Write(p []byte) (int, error) {
+	n, err := f.file.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+// Close completes the spooled copy, verifying it holds everything written, and then uploads it
+func (f *spoolFile) Close() error {
+	if err := f.complete(); err != nil {
+		return errors.Join(err, os.Remove(f.file.Name()))
+	}
+	return f.spooled.upload(f.ctx, f.path, f.name)
+}
+
+func (f *spoolFile) complete() error {
+	if err := f.file.Sync(); err != nil {
+		return errors.Join(fmt.Errorf("failed to sync spooled file: %w", err), f.file.Close())
+	}
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close spooled file: %w", err)
+	}
+	info, err := os.Stat(f.file.Name())
+	if err != nil {
+		return err
+	}
+	if info.Size() != f.written {
+		return fmt.Errorf("spooled file holds %d bytes, expected %d", info.Size(), f.written)
+	}
+	return os.Rename(f.file.Name(), f.name)
+}
+
+// Abort discards the spooled copy, so nothing is uploaded
+func (f *spoolFile) Abort() error {
+	return errors.Join(f.file.Close(), os.Remove(f.file.Name()))
+}
@@ -0,0 +1,131 @@
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/storage"
+)
+
+func TestSpooled(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("uploads once closed", func(t *testing.T) {
+		t.Parallel()
+
+		baseDir, spoolRoot := t.TempDir(), t.TempDir()
+		rawURL := fmt.Sprintf("file://%s", baseDir)
+		store, err := storage.FromURL(ctx, rawURL, storage.WithSpoolDir(spoolRoot))
+		require.NoError(t, err)
+		_, ok := store.(storage.Exister)
+		assert.True(t, ok)
+
+		w, err := store.Create(ctx, "2024/01/01.json")
+		require.NoError(t, err)
+		_, err = w.Write([]byte("{}\n{}\n"))
+		require.NoError(t, err)
+
+		assert.NoFileExists(t, filepath.Join(baseDir, "2024/01/01.json"))
+		assert.FileExists(t, filepath.Join(storage.SpoolDir(spoolRoot, rawURL), "2024/01/01.json.partial"))
+
+		require.NoError(t, w.Close())
+
+		content, err := os.ReadFile(filepath.Join(baseDir, "2024/01/01.json"))
+		require.NoError(t, err)
+		assert.Equal(t, "{}\n{}\n", string(content))
+		assert.NoFileExists(t, filepath.Join(storage.SpoolDir(spoolRoot, rawURL), "2024/01/01.json"))
+	})
+
+	t.Run("aborted files are not uploaded", func(t *testing.T) {
+		t.Parallel()
+
+		baseDir, spoolRoot := t.TempDir(), t.TempDir()
+		rawURL := fmt.Sprintf("file://%s", baseDir)
+		store, err := storage.FromURL(ctx, rawURL, storage.WithSpoolDir(spoolRoot))
+		require.NoError(t, err)
+
+		w, err := store.Create(ctx, "2024/01/01.json")
+		require.NoError(t, err)
+		_, err = w.Write([]byte("{}\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.(storage.Aborter).Abort())
+
+		assert.NoFileExists(t, filepath.Join(baseDir, "2024/01/01.json"))
+		assert.NoFileExists(t, filepath.Join(storage.SpoolDir(spoolRoot, rawURL), "2024/01/01.json.partial"))
+	})
+
+	t.Run("recovers orphaned files", func(t *testing.T) {
+		t.Parallel()
+
+		baseDir, spoolRoot := t.TempDir(), t.TempDir()
+		rawURL := fmt.Sprintf("file://%s", baseDir)
+
+		// Left behind by a process which crashed whilst uploading the first day, and whilst writing the second
+		dir := storage.SpoolDir(spoolRoot, rawURL)
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "2024/01"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "2024/01/01.json"), []byte("{}\n"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "2024/01/02.json.partial"), []byte("{"), 0o644))
+
+		// Spooled for another store, which must be left alone
+		other := storage.SpoolDir(spoolRoot, "file:///elsewhere")
+		require.NoError(t, os.MkdirAll(other, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(other, "01.json"), []byte("{}\n"), 0o644))
+
+		_, err := storage.FromURL(ctx, rawURL, storage.WithSpoolDir(spoolRoot))
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(filepath.Join(baseDir, "2024/01/01.json"))
+		require.NoError(t, err)
+		assert.Equal(t, "{}\n", string(content))
+		assert.NoFileExists(t, filepath.Join(baseDir, "2024/01/02.json"))
+		assert.NoFileExists(t, filepath.Join(dir, "2024/01/01.json"))
+		assert.NoFileExists(t, filepath.Join(dir, "2024/01/02.json.partial"))
+		assert.FileExists(t, filepath.Join(other, "01.json"))
+	})
+
+	t.Run("forwards reads to the underlying store", func(t *testing.T) {
+		t.Parallel()
+
+		baseDir, spoolRoot := t.TempDir(), t.TempDir()
+		store, err := storage.FromURL(ctx, fmt.Sprintf("file://%s", baseDir), storage.WithSpoolDir(spoolRoot))
+		require.NoError(t, err)
+		require.Implements(t, (*storage.Opener)(nil), store)
+		require.Implements(t, (*storage.Lister)(nil), store)
+		require.Implements(t, (*storage.Remover)(nil), store)
+
+		// Appending would bypass the spool, so isn't forwarded
+		_, appendable := store.(interface {
+			Append(ctx context.Context, path string, offset int64) (io.WriteCloser, error)
+		})
+		assert.False(t, appendable)
+
+		w, err := store.Create(ctx, "2024/01/01.json")
+		require.NoError(t, err)
+		_, err = w.Write([]byte("{}\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		paths, err := store.(storage.Lister).List(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2024/01/01.json"}, paths)
+
+		r, err := store.(storage.Opener).Open(ctx, "2024/01/01.json")
+		require.NoError(t, err)
+		content, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		assert.Equal(t, "{}\n", string(content))
+
+		require.NoError(t, store.(storage.Remover).Remove(ctx, "2024/01/01.json"))
+		assert.NoFileExists(t, filepath.Join(baseDir, "2024/01/01.json"))
+	})
+}
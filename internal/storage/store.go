@@ -6,27 +6,267 @@ import (
 	"io"
 	"net/url"
 	"strings"
+	"text/template"
+	"time"
 )
 
 type Store interface {
 	Create(ctx context.Context, path string) (io.WriteCloser, error)
-	Exists(ctx context.Context, path string) (bool, error)
 	io.Closer
 }
 
-func FromURL(ctx context.Context, rawURL string) (Store, error) {
+// Exister is implemented by stores which are able to check for the existence of a file. It is optional, so that
+// write-only stores can be supported.
+type Exister interface {
+	Exists(ctx context.Context, path string) (bool, error)
+}
+
+// Committer is implemented by the files of stores which are able to make everything written so far durable and
+// visible, without finishing the file, so that progress through a long running file survives a crash
+type Committer interface {
+	Commit() error
+}
+
+// Lister is implemented by stores which are able to enumerate the files they hold, e.g. to audit them
+type Lister interface {
+	List(ctx context.Context) ([]string, error)
+}
+
+// Opener is implemented by stores which are able to read back the files they hold, e.g. to verify them
+type Opener interface {
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+}
+
+// Remover is implemented by stores which are able to remove the files they hold
+type Remover interface {
+	Remove(ctx context.Context, path string) error
+}
+
+// Appender is implemented by stores which are able to continue writing a file they hold, e.g. to resume it
+type Appender interface {
+	Append(ctx context.Context, path string, offset int64) (io.WriteCloser, error)
+}
+
+// Aborter is implemented by the files of stores which are able to discard a file being written, rather than
+// committing it, e.g. should its contents be found to be malformed before it is closed
+type Aborter interface {
+	Abort() error
+}
+
+// Option configures optional behaviour of the store resolved by FromURL
+type Option func(*options)
+
+type options struct {
+	minFreeBytes       uint64
+	gcsCredentialsFile string
+	gcsCredentialsJSON []byte
+	maxUploads         int
+	metadata           map[string]string
+	storeCompression   bool
+	wormRetention      time.Duration
+	spoolDir           string
+	finalizeRetries    int
+	finalizeBackoff    time.Duration
+	atomicWrites       bool
+}
+
+// WithMinFreeBytes causes disk stores to refuse to create files while less than the supplied number of bytes are free
+func WithMinFreeBytes(n uint64) Option {
+	return func(o *options) {
+		o.minFreeBytes = n
+	}
+}
+
+// WithAtomicWrites causes disk stores to write each file as a partial file beside it, renamed into place once it has
+// been written in full, so that a file is never left part written at its path, e.g. should the disk fill up or its
+// directory become unwritable part way through. Partial files are removed once writing them fails.
+func WithAtomicWrites() Option {
+	return func(o *options) {
+		o.atomicWrites = true
+	}
+}
+
+// WithGCSCredentialsFile causes GCS stores to authenticate using the service account key file at the supplied path,
+// rather than application default credentials
+func WithGCSCredentialsFile(path string) Option {
+	return func(o *options) {
+		o.gcsCredentialsFile = path
+	}
+}
+
+// WithGCSCredentialsJSON causes GCS stores to authenticate using the supplied service account key, rather than
+// application default credentials
+func WithGCSCredentialsJSON(json []byte) Option {
+	return func(o *options) {
+		o.gcsCredentialsJSON = json
+	}
+}
+
+// WithMaxConcurrentUploads bounds the number of operations uploading to the store at once, counting each creation of,
+// write to and close of a file, e.g. to remain within the rate limits of cloud storage. Zero means unbounded.
+func WithMaxConcurrentUploads(n int) Option {
+	return func(o *options) {
+		o.maxUploads = n
+	}
+}
+
+// WithObjectMetadata attaches the supplied key value pairs as custom metadata to every object written, so that bucket
+// lifecycle rules can act upon them. Only object stores support metadata.
+func WithObjectMetadata(metadata map[string]string) Option {
+	return func(o *options) {
+		o.metadata = metadata
+	}
+}
+
+// WithStoreCompression causes GCS stores to gzip files as they're uploaded, with Content-Encoding: gzip set so that
+// they're transparently decompressed when downloaded. It's for files the archiver leaves uncompressed, which other
+// stores hold as written, e.g. relying on the compression of the underlying filesystem.
+func WithStoreCompression() Option {
+	return func(o *options) {
+		o.storeCompression = true
+	}
+}
+
+// WithWORMRetention locks every object written for the supplied duration, using object retention, so that archives
+// can be neither deleted nor overwritten until it expires, e.g. for regulatory retention. Only GCS stores support
+// object locks, and their bucket must have object retention enabled.
+func WithWORMRetention(d time.Duration) Option {
+	return func(o *options) {
+		o.wormRetention = d
+	}
+}
+
+// WithSpoolDir causes files to be written in full to a spool directory beneath the supplied one, and verified, before
+// they're uploaded, retrying failed uploads from the spooled copy. Complete files left spooled by a previous process
+// are uploaded when the store is resolved, and partial ones discarded.
+func WithSpoolDir(dir string) Option {
+	return func(o *options) {
+		o.spoolDir = dir
+	}
+}
+
+// ParseMetadata parses object metadata from key=value pairs
+func ParseMetadata(values []string) (map[string]string, error) {
+	metadata := make(map[string]string, len(values))
+	for _, value := range values {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid object metadata %q, expected key=value", value)
+		}
+		if _, exists := metadata[key]; exists {
+			return nil, fmt.Errorf("invalid object metadata %q, %s is supplied more than once", value, key)
+		}
+		metadata[key] = val
+	}
+	return metadata, nil
+}
+
+// CollectionPlaceholder is replaced in storage URLs by the name the collection is archived under, e.g.
+// gcs://bucket/{collection}, so that each collection is archived beneath its own path. The URL may equally be a
+// template referencing the name as {{.Collection}}, e.g. gcs://bucket/{{.Collection}}.
+const CollectionPlaceholder = "{collection}"
+
+// ExpandCollection fills the storage URL with the supplied collection name, executing it as a template should it hold
+// any actions, and replacing each collection placeholder
+func ExpandCollection(rawURL, collection string) (string, error) {
+	if strings.Contains(rawURL, "{{") {
+		tmpl, err := template.New("storage-url").Parse(rawURL)
+		if err != nil {
+			return "", fmt.Errorf("invalid storage url template: %w", err)
+		}
+		var b strings.Builder
+		if err = tmpl.Execute(&b, struct{ Collection string }{collection}); err != nil {
+			return "", fmt.Errorf("invalid storage url template: %w", err)
+		}
+		rawURL = b.String()
+	}
+	return strings.ReplaceAll(rawURL, CollectionPlaceholder, collection), nil
+}
+
+// SupportsObjectMetadata reports whether the store at the URL is able to attach metadata to the objects it writes
+func SupportsObjectMetadata(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Scheme == "gcs"
+}
+
+func FromURL(ctx context.Context, rawURL string, opts ...Option) (Store, error) {
 	u, err := url.Parse(rawURL)
 	if err != nil {
 		return nil, err
 	}
 
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	store, err := fromScheme(ctx, u, o)
+	if err != nil {
+		return nil, err
+	}
+	if o.maxUploads > 0 {
+		store = newLimited(store, o.maxUploads)
+	}
+	if o.spoolDir != "" {
+		store, err = newSpooled(ctx, store, spoolDir(o.spoolDir, rawURL))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+func fromScheme(ctx context.Context, u *url.URL, o options) (Store, error) {
+	if len(o.metadata) > 0 && u.Scheme != "gcs" {
+		return nil, fmt.Errorf("storage scheme %s does not support object metadata", u.Scheme)
+	}
+
+	if o.wormRetention > 0 && u.Scheme != "gcs" {
+		return nil, fmt.Errorf("storage scheme %s does not support object lock retention", u.Scheme)
+	}
+
+	if o.atomicWrites && u.Scheme != "file" {
+		return nil, fmt.Errorf("storage scheme %s does not support atomic writes", u.Scheme)
+	}
+
+	if o.finalizeRetries > 0 && u.Scheme != "gcs" {
+		return nil, fmt.Errorf("storage scheme %s does not support finalize retries", u.Scheme)
+	}
+
+	if o.storeCompression && u.Scheme == "kafka" {
+		// Documents are produced as archives are decompressed, so they must be compressed by the archiver
+		return nil, fmt.Errorf("storage scheme %s does not support store compression", u.Scheme)
+	}
+
 	switch u.Scheme {
 	case "file":
-		return newDisk(u.Path), nil
+		return newDisk(u.Path, o.minFreeBytes, o.atomicWrites), nil
 	case "gcs":
-		return newGCS(ctx, u.Host, strings.TrimPrefix(u.Path, "/"))
+		opts, err := gcsEndpointOptions(ctx, u.Query(), gcsClientOptions(o))
+		if err != nil {
+			return nil, err
+		}
+		gcs, err := newGCS(
+			ctx,
+			u.Host,
+			strings.TrimPrefix(u.Path, "/"),
+			o.metadata,
+			o.storeCompression,
+			o.wormRetention,
+			opts...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		gcs.finalizeRetries = o.finalizeRetries
+		gcs.finalizeBackoff = o.finalizeBackoff
+		return gcs, nil
+	case "kafka":
+		return newKafka(u.Host, strings.TrimPrefix(u.Path, "/"))
 	case "noop":
 		return newNoop(), nil
+	case "counting":
+		return newCounting(), nil
 	default:
 		return nil, fmt.Errorf("unsupported storage scheme: %s", u.Scheme)
 	}
@@ -0,0 +1,143 @@
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/archive"
+)
+
+// IndexPath is the location of the index in the store at the base storage URL, beneath which each tenant is archived
+const IndexPath = "_archiver/index.json"
+
+type store interface {
+	Create(ctx context.Context, path string) (io.WriteCloser, error)
+}
+
+// Index summarises what a run archived for each tenant database, giving a single view over a run spanning many. Days
+// are observed as they're archived, and the index is rewritten as each database completes. It's safe for concurrent
+// use, as databases may be archived at once.
+type Index struct {
+	store      store
+	collection string
+	startedAt  time.Time
+
+	mu        sync.Mutex
+	summaries map[string]*CollectionSummary
+}
+
+// CollectionSummary summarises what a run archived for the collection of a single tenant database
+type CollectionSummary struct {
+	Database   string `json:"database"`
+	StorageURL string `json:"storageUrl"`
+	// From and To are the first and last days archived, omitted should no days have been archived
+	From              string `json:"from,omitempty"`
+	To                string `json:"to,omitempty"`
+	Days              int    `json:"days"`
+	Files             int    `json:"files"`
+	Documents         int    `json:"documents"`
+	UncompressedBytes int64  `json:"uncompressedBytes"`
+	CompressedBytes   int64  `json:"compressedBytes"`
+	// CompletedAt is omitted whilst the database is still being archived
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// indexDocument is the document written to IndexPath
+type indexDocument struct {
+	Collection  string              `json:"collection"`
+	StartedAt   time.Time           `json:"startedAt"`
+	UpdatedAt   time.Time           `json:"updatedAt"`
+	Collections []CollectionSummary `json:"collections"`
+}
+
+// NewIndex returns an index of a run archiving the collection, written to the supplied store
+func NewIndex(store store, collection string) *Index {
+	return &Index{
+		store:      store,
+		collection: collection,
+		startedAt:  time.Now(),
+		summaries:  make(map[string]*CollectionSummary),
+	}
+}
+
+// Observe records that the day has been archived for the database, whose archives are written to the storage URL
+func (i *Index) Observe(database, storageURL string, day archive.ArchivedDay) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	s := i.summary(database, storageURL)
+	date := day.Date.Format(time.DateOnly)
+	if s.From == "" || date < s.From {
+		s.From = date
+	}
+	if date > s.To {
+		s.To = date
+	}
+	s.Days++
+	s.Documents += day.Documents
+	for _, f := range day.Files {
+		s.Files++
+		s.UncompressedBytes += f.UncompressedBytes
+		s.CompressedBytes += f.CompressedBytes
+	}
+}
+
+// Complete records that the database has been archived, failing with err if non-nil, and rewrites the index
+func (i *Index) Complete(ctx context.Context, database, storageURL string, err error) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := time.Now()
+	s := i.summary(database, storageURL)
+	s.CompletedAt = &now
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	doc := indexDocument{
+		Collection:  i.collection,
+		StartedAt:   i.startedAt,
+		UpdatedAt:   now,
+		Collections: []CollectionSummary{},
+	}
+	for _, database := range slices.Sorted(maps.Keys(i.summaries)) {
+		doc.Collections = append(doc.Collections, *i.summaries[database])
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode index: %w", err)
+	}
+	return i.write(ctx, append(out, '\n'))
+}
+
+// summary returns the summary of the database, creating it should it not yet exist
+func (i *Index) summary(database, storageURL string) *CollectionSummary {
+	s, ok := i.summaries[database]
+	if !ok {
+		s = &CollectionSummary{Database: database, StorageURL: storageURL}
+		i.summaries[database] = s
+	}
+	return s
+}
+
+func (i *Index) write(ctx context.Context, out []byte) (err error) {
+	w, err := i.store.Create(ctx, IndexPath)
+	if err != nil {
+		return fmt.Errorf("failed to create index: %w", err)
+	}
+	defer func() {
+		if cErr := w.Close(); cErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close index: %w", cErr))
+		}
+	}()
+	_, err = w.Write(out)
+	return err
+}
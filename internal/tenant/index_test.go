@@ -0,0 +1,140 @@
+package tenant_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/archive"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/tenant"
+)
+
+func TestIndex(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := &indexStore{}
+	index := tenant.NewIndex(store, "events")
+
+	day := func(date time.Time, documents int, sizes ...int64) archive.ArchivedDay {
+		d := archive.ArchivedDay{Date: date, Documents: documents}
+		for _, size := range sizes {
+			d.Files = append(d.Files, archive.ArchivedFile{UncompressedBytes: size * 10, CompressedBytes: size})
+		}
+		return d
+	}
+	first := time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC)
+	days := map[string][]archive.ArchivedDay{
+		"tenant-a": {day(first, 10, 100), day(first.AddDate(0, 0, 1), 20, 150, 50), day(first.AddDate(0, 0, 2), 5, 30)},
+		"tenant-b": {day(first.AddDate(0, 0, 1), 7, 70)},
+		"tenant-c": {day(first, 3, 40)},
+		"tenant-d": nil, // nothing eligible
+	}
+
+	_, err := tenant.Run(ctx, []string{"tenant-a", "tenant-b", "tenant-c", "tenant-d"}, 2,
+		func(ctx context.Context, database string) error {
+			storageURL, err := tenant.StorageURL("file:///archives", database)
+			if err != nil {
+				return err
+			}
+
+			for _, d := range days[database] {
+				index.Observe(database, storageURL, d)
+			}
+			var archiveErr error
+			if database == "tenant-c" {
+				archiveErr = errors.New("archival failed")
+			}
+			assert.NoError(t, index.Complete(ctx, database, storageURL, archiveErr))
+			return archiveErr
+		},
+	)
+	require.Error(t, err)
+
+	// Written once per database, each time covering every database completed so far
+	assert.Len(t, store.writes, 4)
+
+	var written struct {
+		Collection  string                     `json:"collection"`
+		Collections []tenant.CollectionSummary `json:"collections"`
+	}
+	require.NoError(t, json.Unmarshal(store.writes[len(store.writes)-1], &written))
+	assert.Equal(t, "events", written.Collection)
+	for i := range written.Collections {
+		assert.NotNil(t, written.Collections[i].CompletedAt)
+		written.Collections[i].CompletedAt = nil
+	}
+	assert.Equal(t, []tenant.CollectionSummary{
+		{
+			Database:          "tenant-a",
+			StorageURL:        "file:///archives/tenant-a",
+			From:              "2024-11-01",
+			To:                "2024-11-03",
+			Days:              3,
+			Files:             4,
+			Documents:         35,
+			UncompressedBytes: 3300,
+			CompressedBytes:   330,
+		},
+		{
+			Database:          "tenant-b",
+			StorageURL:        "file:///archives/tenant-b",
+			From:              "2024-11-02",
+			To:                "2024-11-02",
+			Days:              1,
+			Files:             1,
+			Documents:         7,
+			UncompressedBytes: 700,
+			CompressedBytes:   70,
+		},
+		{
+			Database:          "tenant-c",
+			StorageURL:        "file:///archives/tenant-c",
+			From:              "2024-11-01",
+			To:                "2024-11-01",
+			Days:              1,
+			Files:             1,
+			Documents:         3,
+			UncompressedBytes: 400,
+			CompressedBytes:   40,
+			Error:             "archival failed",
+		},
+		{
+			Database:   "tenant-d",
+			StorageURL: "file:///archives/tenant-d",
+		},
+	}, written.Collections)
+}
+
+// indexStore records the contents of every index written
+type indexStore struct {
+	mu     sync.Mutex
+	writes [][]byte
+}
+
+func (s *indexStore) Create(_ context.Context, path string) (io.WriteCloser, error) {
+	if path != tenant.IndexPath {
+		return nil, errors.New("unexpected path " + path)
+	}
+	return &indexWriter{store: s}, nil
+}
+
+type indexWriter struct {
+	bytes.Buffer
+	store *indexStore
+}
+
+func (w *indexWriter) Close() error {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+	w.store.writes = append(w.store.writes, w.Bytes())
+	return nil
+}
@@ -0,0 +1,157 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/duration"
+)
+
+// Databases returns the names of all databases matching the supplied pattern which hold the named collection. Names
+// are matched here rather than by the server, whose PCRE syntax differs from that of the pattern.
+func Databases(ctx context.Context, client *mongo.Client, pattern *regexp.Regexp, collection string) ([]string, error) {
+	names, err := client.ListDatabaseNames(ctx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases: %w", err)
+	}
+
+	var databases []string
+	for _, name := range names {
+		if !pattern.MatchString(name) {
+			continue
+		}
+		collections, err := client.Database(name).ListCollectionNames(ctx, bson.M{"name": collection})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list collections in %s: %w", name, err)
+		}
+		if len(collections) == 0 {
+			slog.Info("skipping database without collection", slog.String("database", name))
+			continue
+		}
+		databases = append(databases, name)
+	}
+	return databases, nil
+}
+
+// Summary aggregates the outcome of processing every tenant database
+type Summary struct {
+	Processed int           // databases for which fn was invoked
+	Failed    []string      // databases for which fn failed, in the order supplied
+	Elapsed   time.Duration // wall time taken to process every database
+}
+
+// Run invokes fn for each of the supplied databases, processing up to concurrency databases at once. A failure for one
+// database does not prevent the remaining databases from being processed - all errors are collected and returned
+// together, in the order the databases were supplied.
+func Run(
+	ctx context.Context,
+	databases []string,
+	concurrency int,
+	fn func(ctx context.Context, database string) error,
+) (Summary, error) {
+	started := time.Now()
+	errs := make([]error, len(databases))
+	slots := make(chan struct{}, max(concurrency, 1))
+
+	var (
+		wg        sync.WaitGroup
+		cancelled error
+		processed int
+	)
+	for i, database := range databases {
+		select {
+		case <-ctx.Done():
+		case slots <- struct{}{}:
+		}
+		if err := ctx.Err(); err != nil {
+			cancelled = err
+			break
+		}
+
+		processed++
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			slog.Info("processing tenant", slog.String("database", database))
+			if err := fn(ctx, database); err != nil {
+				slog.Error("tenant failed", slog.String("database", database), slog.Any("error", err))
+				errs[i] = fmt.Errorf("%s: %w", database, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	summary := Summary{Processed: processed, Elapsed: time.Since(started)}
+	for i, err := range errs {
+		if err != nil {
+			summary.Failed = append(summary.Failed, databases[i])
+		}
+	}
+	slog.Info(
+		"tenants processed",
+		slog.Int("processed", summary.Processed),
+		slog.Int("failed", len(summary.Failed)),
+		slog.Any("failedDatabases", summary.Failed),
+		slog.Duration("elapsed", summary.Elapsed),
+	)
+
+	return summary, errors.Join(append(errs, cancelled)...)
+}
+
+// StorageURL returns the storage URL for a tenant, which is the base URL with the database name appended to its path
+func StorageURL(baseURL, database string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, database)
+	return u.String(), nil
+}
+
+// ParseRetentions parses per-tenant retention overrides supplied in the form database=duration, e.g. tenant1=90d
+func ParseRetentions(values []string) (map[string]time.Duration, error) {
+	retentions := make(map[string]time.Duration, len(values))
+	for _, value := range values {
+		database, rawRetention, ok := strings.Cut(value, "=")
+		if !ok || database == "" {
+			return nil, fmt.Errorf("invalid retention override %q, expected database=duration", value)
+		}
+		retention, err := duration.Parse(rawRetention)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention override %q: %w", value, err)
+		}
+		retentions[database] = retention
+	}
+	return retentions, nil
+}
+
+// CheckRetentions returns an error should any retention override name a database the pattern doesn't match, as such
+// an override could never apply
+func CheckRetentions(pattern *regexp.Regexp, retentions map[string]time.Duration) error {
+	var unmatched []string
+	for database := range retentions {
+		if !pattern.MatchString(database) {
+			unmatched = append(unmatched, database)
+		}
+	}
+	if len(unmatched) > 0 {
+		slices.Sort(unmatched)
+		return fmt.Errorf("retention overrides for databases not matching the pattern: %s", strings.Join(unmatched, ", "))
+	}
+	return nil
+}
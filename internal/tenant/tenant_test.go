@@ -0,0 +1,157 @@
+package tenant_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/tenant"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/testutil"
+)
+
+func TestDatabases_Integration(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := testutil.StartMongoDB(ctx, t)
+
+	prefix := "t" + uuid.NewString()[:8]
+	for _, database := range []string{prefix + "-a", prefix + "-b", prefix + "-c"} {
+		_, err := client.Database(database).Collection("events").InsertOne(ctx, bson.M{"createdAt": time.Now()})
+		require.NoError(t, err)
+	}
+	// Matches the pattern, but lacks the target collection
+	_, err := client.Database(prefix+"-d").Collection("other").InsertOne(ctx, bson.M{})
+	require.NoError(t, err)
+	// Holds the target collection, but doesn't match the pattern
+	_, err = client.Database("x"+prefix).Collection("events").InsertOne(ctx, bson.M{})
+	require.NoError(t, err)
+
+	databases, err := tenant.Databases(ctx, client, regexp.MustCompile("^"+prefix+"-"), "events")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{prefix + "-a", prefix + "-b", prefix + "-c"}, databases)
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	var visited []string
+	databases := []string{"a", "b", "c"}
+	summary, err := tenant.Run(context.Background(), databases, 1, func(_ context.Context, database string) error {
+		visited = append(visited, database)
+		if database == "a" || database == "c" {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	assert.Equal(t, []string{"a", "b", "c"}, visited) // failures don't stop later tenants
+	assert.ErrorContains(t, err, "a: failed")
+	assert.ErrorContains(t, err, "c: failed")
+	assert.Equal(t, 3, summary.Processed)
+	assert.Equal(t, []string{"a", "c"}, summary.Failed)
+}
+
+func TestRun_Concurrency(t *testing.T) {
+	t.Parallel()
+
+	databases := make([]string, 12)
+	for i := range databases {
+		databases[i] = fmt.Sprintf("tenant%d", i)
+	}
+
+	const concurrency = 3
+	var running, peak atomic.Int32
+	ctx := context.Background()
+	summary, err := tenant.Run(ctx, databases, concurrency, func(_ context.Context, database string) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond * 10)
+		if database == "tenant4" || database == "tenant9" {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	assert.LessOrEqual(t, peak.Load(), int32(concurrency))
+	assert.Greater(t, peak.Load(), int32(1)) // databases were processed in parallel
+	assert.Equal(t, len(databases), summary.Processed)
+	assert.Equal(t, []string{"tenant4", "tenant9"}, summary.Failed)
+	assert.ErrorContains(t, err, "tenant4: failed")
+	assert.ErrorContains(t, err, "tenant9: failed")
+}
+
+func TestRun_Cancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	summary, err := tenant.Run(ctx, []string{"a", "b", "c"}, 1, func(_ context.Context, database string) error {
+		if database == "b" {
+			cancel()
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 2, summary.Processed) // c was never started
+	assert.Empty(t, summary.Failed)
+}
+
+func TestStorageURL(t *testing.T) {
+	t.Parallel()
+
+	for base, expected := range map[string]string{
+		"file:///tmp/archive":   "file:///tmp/archive/tenant1",
+		"gcs://bucket":          "gcs://bucket/tenant1",
+		"gcs://bucket/archives": "gcs://bucket/archives/tenant1",
+	} {
+		actual, err := tenant.StorageURL(base, "tenant1")
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+}
+
+func TestParseRetentions(t *testing.T) {
+	t.Parallel()
+
+	retentions, err := tenant.ParseRetentions([]string{"tenant1=90d", "tenant2=720h"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{
+		"tenant1": time.Hour * 24 * 90,
+		"tenant2": time.Hour * 720,
+	}, retentions)
+
+	_, err = tenant.ParseRetentions([]string{"tenant1"})
+	assert.Error(t, err)
+
+	_, err = tenant.ParseRetentions([]string{"tenant1=bogus"})
+	assert.Error(t, err)
+}
+
+func TestCheckRetentions(t *testing.T) {
+	t.Parallel()
+
+	pattern := regexp.MustCompile("^tenant[0-9]+$")
+	assert.NoError(t, tenant.CheckRetentions(pattern, map[string]time.Duration{"tenant1": time.Hour}))
+	assert.NoError(t, tenant.CheckRetentions(pattern, nil))
+
+	err := tenant.CheckRetentions(pattern, map[string]time.Duration{
+		"tenant1": time.Hour,
+		"tenantb": time.Hour,
+		"admin":   time.Hour,
+	})
+	assert.EqualError(t, err, "retention overrides for databases not matching the pattern: admin, tenantb")
+}
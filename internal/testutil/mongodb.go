@@ -28,3 +28,24 @@ func StartMongoDB(ctx context.Context, t *testing.T) *mongo.Client {
 
 	return client
 }
+
+// StartMongoDBReplicaSet starts a single member replica set, as required by features such as change streams
+func StartMongoDBReplicaSet(ctx context.Context, t *testing.T) *mongo.Client {
+	t.Helper()
+
+	container, err := mongodb.Run(ctx, "mongo:6", mongodb.WithReplicaSet("rs0"))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = container.Terminate(context.Background())
+	})
+
+	url, err := container.ConnectionString(ctx)
+	require.NoError(t, err)
+
+	// The member is known by its address within the container network, so it is connected to directly
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(url).SetDirect(true))
+	require.NoError(t, err)
+
+	return client
+}
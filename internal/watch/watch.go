@@ -0,0 +1,78 @@
+package watch
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Clock provides the current time, and the means to wait for time to pass
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the Clock backed by the wall clock
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// NextDay returns when the next day becomes eligible for archiving with any of the retentions, being the earliest
+// time after now at which a further day lies wholly beyond one of them
+func NextDay(retentions ...time.Duration) func(now time.Time) time.Time {
+	return func(now time.Time) time.Time {
+		var next time.Time
+		for _, retention := range retentions {
+			eligible := now.Add(-retention).Truncate(time.Hour*24).AddDate(0, 0, 1).Add(retention)
+			if next.IsZero() || eligible.Before(next) {
+				next = eligible
+			}
+		}
+		return next
+	}
+}
+
+// Run invokes fn with the current time, then waits before invoking it again with the new time, so that days becoming
+// eligible for archiving are picked up as time passes. Each wait lasts until the time returned by next, so that runs
+// coincide with days becoming eligible, or for the interval should it be sooner. Either may be omitted, with a nil next
+// or an interval of zero, but not both. It continues until fn fails or the context is cancelled. Cancellation whilst
+// waiting is a clean shutdown, so nil is returned.
+func Run(
+	ctx context.Context,
+	clock Clock,
+	interval time.Duration,
+	next func(now time.Time) time.Time,
+	fn func(ctx context.Context, now time.Time) error,
+) error {
+	for ctx.Err() == nil {
+		if err := fn(ctx, clock.Now()); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wait := interval
+		if next != nil {
+			now := clock.Now()
+			if untilNext := next(now).Sub(now); wait <= 0 || untilNext < wait {
+				wait = untilNext
+			}
+		}
+		slog.Info("waiting for next run", slog.Duration("wait", wait))
+
+		select {
+		case <-ctx.Done():
+		case <-clock.After(wait):
+		}
+	}
+
+	slog.Info("watch stopped")
+	return nil
+}
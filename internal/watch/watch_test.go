@@ -0,0 +1,169 @@
+package watch_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/watch"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	t.Run("recomputes target as the clock crosses a day", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		retention := time.Hour * 24 * 30
+		clock := &fakeClock{now: time.Date(2024, time.December, 1, 22, 30, 0, 0, time.UTC)}
+
+		var eligible []time.Time
+		err := watch.Run(ctx, clock, time.Hour, nil, func(_ context.Context, now time.Time) error {
+			eligible = append(eligible, now.Add(-retention).Truncate(time.Hour*24))
+			if len(eligible) == 3 {
+				cancel()
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []time.Time{
+			time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC), // 22:30
+			time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC), // 23:30
+			time.Date(2024, time.November, 2, 0, 0, 0, 0, time.UTC), // 00:30, the next day is now eligible
+		}, eligible)
+	})
+
+	t.Run("wakes as the incomplete day becomes eligible", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		retention := time.Hour * 24 * 30
+		clock := &fakeClock{now: time.Date(2024, time.December, 1, 22, 30, 0, 0, time.UTC)}
+
+		// Only days wholly beyond the retention are eligible, so the day holding the boundary never is
+		var runs, eligible []time.Time
+		err := watch.Run(ctx, clock, 0, watch.NextDay(retention), func(_ context.Context, now time.Time) error {
+			runs = append(runs, now)
+			eligible = append(eligible, now.Add(-retention).Truncate(time.Hour*24).AddDate(0, 0, -1))
+			if len(runs) == 3 {
+				cancel()
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []time.Time{
+			time.Date(2024, time.December, 1, 22, 30, 0, 0, time.UTC),
+			time.Date(2024, time.December, 2, 0, 0, 0, 0, time.UTC), // woken as November 1st completes
+			time.Date(2024, time.December, 3, 0, 0, 0, 0, time.UTC),
+		}, runs)
+		assert.Equal(t, []time.Time{
+			time.Date(2024, time.October, 31, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, time.November, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, time.November, 2, 0, 0, 0, 0, time.UTC),
+		}, eligible)
+	})
+
+	t.Run("wakes no later than the interval", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		clock := &fakeClock{now: time.Date(2024, time.December, 1, 22, 30, 0, 0, time.UTC)}
+
+		var runs []time.Time
+		err := watch.Run(ctx, clock, time.Hour, watch.NextDay(time.Hour*24*30), func(_ context.Context, now time.Time) error {
+			runs = append(runs, now)
+			if len(runs) == 3 {
+				cancel()
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []time.Time{
+			time.Date(2024, time.December, 1, 22, 30, 0, 0, time.UTC),
+			time.Date(2024, time.December, 1, 23, 30, 0, 0, time.UTC),
+			time.Date(2024, time.December, 2, 0, 0, 0, 0, time.UTC),
+		}, runs)
+	})
+
+	t.Run("wakes for the soonest of several retentions", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Date(2024, time.December, 1, 22, 30, 0, 0, time.UTC)
+		next := watch.NextDay(time.Hour*24*30, time.Hour*24*30-time.Hour)
+		assert.Equal(t, time.Date(2024, time.December, 1, 23, 0, 0, 0, time.UTC), next(now))
+	})
+
+	t.Run("stops on failure", func(t *testing.T) {
+		t.Parallel()
+
+		clock := &fakeClock{now: time.Date(2024, time.December, 1, 0, 0, 0, 0, time.UTC)}
+		failure := errors.New("archival failed")
+
+		var calls int
+		err := watch.Run(context.Background(), clock, time.Hour, nil, func(_ context.Context, _ time.Time) error {
+			calls++
+			if calls == 2 {
+				return failure
+			}
+			return nil
+		})
+		assert.ErrorIs(t, err, failure)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("stops when cancelled whilst waiting", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var calls int
+		err := watch.Run(ctx, blockingClock{}, time.Hour, nil, func(_ context.Context, _ time.Time) error {
+			calls++
+			cancel()
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+// fakeClock advances immediately whenever it is waited on
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// blockingClock never finishes waiting
+type blockingClock struct{}
+
+func (blockingClock) Now() time.Time {
+	return time.Now()
+}
+
+func (blockingClock) After(_ time.Duration) <-chan time.Time {
+	return nil
+}
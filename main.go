@@ -1,22 +1,42 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
 	"os"
 	"os/signal"
+	"regexp"
+	"slices"
+	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/urfave/cli/v2"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/api/option"
 
 	"github.com/e-flux-platform/mongo-collection-archiver/internal/archive"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/doctor"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/duration"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/exitcode"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/freeze"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/hook"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/logsample"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/metrics"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/predicate"
 	"github.com/e-flux-platform/mongo-collection-archiver/internal/source"
 	"github.com/e-flux-platform/mongo-collection-archiver/internal/storage"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/tenant"
+	"github.com/e-flux-platform/mongo-collection-archiver/internal/watch"
 )
 
 type config struct {
@@ -24,14 +44,141 @@ type config struct {
 	mongoURL              string
 	mongoDatabase         string
 	mongoCollection       string
+	namespaceEnv          string
+	collectionAlias       string
+	deleteCollection      string
+	rollupCollection      string
+	rollupPipeline        string
+	rollupDayField        string
+	mergeCollections      cli.StringSlice
+	mergeTagField         string
+	mongoDatabasePattern  string
+	tenantRetentions      cli.StringSlice
+	tenantConcurrency     int
 	delete                bool
 	ignoreFileExistsError bool
+	onCollision           archive.CollisionPolicy
+	overwriteIncomplete   bool
+	maxDocBytes           int
+	maxBSONDocBytes       int
+	oversizePolicy        archive.OversizePolicy
+	requireFields         cli.StringSlice
+	missingFieldPolicy    archive.MissingFieldPolicy
+	specialTypes          string
+	postArchiveCommand    string
+	postArchiveTopic      string
+	postArchiveFailRun    bool
+	metricsPushgateway    string
 	retention             time.Duration
+	ttlCatchUp            time.Duration
+	completeDaysOnly      bool
+	keepDays              int
 	delay                 time.Duration
+	maxDocuments          int
+	startDate             string
+	skipEmptyDays         bool
+	sortWithinDay         string
+	fileHeader            bool
+	recordProvenance      bool
+	storeContentCRC       bool
+	successMarker         bool
+	emptyRunManifest      bool
+	writeOffsetIndex      bool
+	writeSchema           bool
+	writeBounds           bool
+	writeChecksums        bool
+	writeLayout           bool
+	progressFile          string
+	progressInterval      time.Duration
+	resumable             bool
+	checkpointInterval    int
+	commitInterval        time.Duration
+	compression           archive.Compression
+	validateCompression   bool
+	compressionThreads    int
+	adaptiveCompression   bool
+	compressionTarget     time.Duration
+	compressionSmallDay   int
+	compressionLargeDay   int
+	estimate              bool
+	estimateRatio         float64
+	compareRetention      time.Duration
+	compareJSON           bool
+	reconcile             bool
+	reconcileVerify       bool
+	verifySampleRate      float64
+	auditChecksums        bool
+	auditWorkers          int
+	peek                  bool
+	peekFile              string
+	peekCount             int
+	peekSample            bool
+	peekPretty            bool
+	doctor                bool
+	respectPauseFlag      bool
+	plainFields           cli.StringSlice
+	explodeField          string
+	explodeParentFields   cli.StringSlice
+	exactDelete           bool
+	deleteChunkSize       int
+	deleteRetries         int
+	ttlMarkField          string
+	ttlMarkWindow         time.Duration
+	deleteRetryBackoff    time.Duration
+	deleteCancelGrace     time.Duration
+	preserveDeletedCount  bool
+	deleteUnarchived      bool
+	requireHealthyReplSet bool
+	unhealthyArchive      bool
+	fileExtension         string
+	format                string
+	minFreeBytes          uint64
+	diskAtomicWrites      bool
+	maxConcurrentUploads  int
+	gcsCredentialsFile    string
+	gcsCredentialsJSON    string
+	objectMetadata        cli.StringSlice
+	wormRetention         time.Duration
+	spoolDir              string
+	finalizeRetries       int
+	finalizeRetryBackoff  time.Duration
+	partitionField        string
+	maxOpenPartitions     int
+	causalConsistency     bool
+	boundary              source.Boundary
+	indexHint             string
+	maxTimeMS             int
+	idMin                 string
+	idMax                 string
+	dateExpr              string
+	objectIDFallback      bool
+	idRangeFastPath       bool
+	intraDayParallelism   int
+	maxConcurrentMongoOps int
+	logSampleEvery        int
+	opLimiter             *source.OpLimiter // shared by every source, when bounding concurrent mongo operations
+	maxScanDocs           int64
+	minCollectionDocs     int64
+	maxCollectionDocs     int64
+	collectionFilterExpr  string
+	renameFields          cli.StringSlice
+	watch                 bool
+	changeStream          bool
+	watchInterval         time.Duration
+	useServerTime         bool
+	provenance            *source.Provenance // resolved once connected, when recording provenance
+	skipDates             cli.StringSlice
+	writeIndex            bool
 }
 
 func main() {
-	var cfg config
+	cfg := config{
+		delay:                time.Second * 30,
+		deleteRetryBackoff:   time.Second,
+		progressInterval:     time.Minute,
+		finalizeRetryBackoff: time.Second,
+	}
+	var ran bool
 
 	app := &cli.App{
 		Flags: []cli.Flag{
@@ -41,6 +188,66 @@ func main() {
 				Required:    true,
 				Destination: &cfg.storageURL,
 			},
+			&cli.StringFlag{
+				Name:        "gcs-credentials-file",
+				Usage:       "path to a service account key file for GCS storage, instead of application default credentials",
+				EnvVars:     []string{"GCS_CREDENTIALS_FILE"},
+				Destination: &cfg.gcsCredentialsFile,
+			},
+			&cli.StringFlag{
+				Name:        "gcs-credentials-json",
+				Usage:       "service account key JSON for GCS storage, instead of application default credentials",
+				EnvVars:     []string{"GCS_CREDENTIALS_JSON"},
+				Destination: &cfg.gcsCredentialsJSON,
+			},
+			&cli.StringSliceFlag{
+				Name:        "object-metadata",
+				Usage:       "custom metadata to attach to every object written to GCS storage, as key=value, e.g. tier=cold",
+				EnvVars:     []string{"OBJECT_METADATA"},
+				Destination: &cfg.objectMetadata,
+			},
+			&cli.GenericFlag{
+				Name:    "worm-retention",
+				Usage:   "lock every object written to GCS storage against deletion or overwrite for this long, e.g. 2555d",
+				EnvVars: []string{"WORM_RETENTION"},
+				Value:   (*duration.Value)(&cfg.wormRetention),
+			},
+			&cli.StringFlag{
+				Name:        "spool-dir",
+				Usage:       "write each file in full to this local directory, uploading it to storage once complete",
+				EnvVars:     []string{"SPOOL_DIR"},
+				Destination: &cfg.spoolDir,
+			},
+			&cli.IntFlag{
+				Name:        "gcs-finalize-retries",
+				Usage:       "upload a GCS object again up to this many times should it fail to finalize, 0 to not retry",
+				EnvVars:     []string{"GCS_FINALIZE_RETRIES"},
+				Destination: &cfg.finalizeRetries,
+			},
+			&cli.GenericFlag{
+				Name:    "gcs-finalize-retry-backoff",
+				Usage:   "how long to wait before the first finalize retry, growing by as much for each subsequent one, e.g. 1s",
+				EnvVars: []string{"GCS_FINALIZE_RETRY_BACKOFF"},
+				Value:   (*duration.Value)(&cfg.finalizeRetryBackoff),
+			},
+			&cli.Uint64Flag{
+				Name:        "min-free-bytes",
+				Usage:       "refuse to start writing a file to disk storage with less than this many bytes free",
+				EnvVars:     []string{"MIN_FREE_BYTES"},
+				Destination: &cfg.minFreeBytes,
+			},
+			&cli.BoolFlag{
+				Name:        "disk-atomic-writes",
+				Usage:       "write each file to disk storage as a partial file, renamed into place once written in full",
+				EnvVars:     []string{"DISK_ATOMIC_WRITES"},
+				Destination: &cfg.diskAtomicWrites,
+			},
+			&cli.IntFlag{
+				Name:        "max-concurrent-uploads",
+				Usage:       "bound the number of operations uploading to storage at once, 0 for unbounded",
+				EnvVars:     []string{"MAX_CONCURRENT_UPLOADS"},
+				Destination: &cfg.maxConcurrentUploads,
+			},
 			&cli.StringFlag{
 				Name:        "mongo-url",
 				EnvVars:     []string{"MONGO_URL"},
@@ -50,39 +257,707 @@ func main() {
 			&cli.StringFlag{
 				Name:        "mongo-database",
 				EnvVars:     []string{"MONGO_DATABASE"},
-				Required:    true,
 				Destination: &cfg.mongoDatabase,
 			},
+			&cli.StringFlag{
+				Name:        "mongo-database-pattern",
+				Usage:       "archive the collection from every database matching this regular expression, instead of a single database",
+				EnvVars:     []string{"MONGO_DATABASE_PATTERN"},
+				Destination: &cfg.mongoDatabasePattern,
+			},
+			&cli.StringSliceFlag{
+				Name:        "tenant-retention",
+				Usage:       "per database retention override when using a database pattern, e.g. tenant1=90d",
+				EnvVars:     []string{"TENANT_RETENTION"},
+				Destination: &cfg.tenantRetentions,
+			},
+			&cli.IntFlag{
+				Name:        "collection-concurrency",
+				Usage:       "number of databases matching the pattern to archive at once, sharing the delay between days",
+				EnvVars:     []string{"COLLECTION_CONCURRENCY"},
+				Value:       1,
+				Destination: &cfg.tenantConcurrency,
+			},
+			&cli.BoolFlag{
+				Name:        "write-index",
+				Usage:       "write _archiver/index.json to the storage URL, summarising what each matching database archived",
+				EnvVars:     []string{"WRITE_INDEX"},
+				Destination: &cfg.writeIndex,
+			},
 			&cli.StringFlag{
 				Name:        "mongo-collection",
 				EnvVars:     []string{"MONGO_COLLECTION"},
-				Required:    true,
 				Destination: &cfg.mongoCollection,
 			},
+			&cli.StringFlag{
+				Name:        "collection-namespace-from-env",
+				Usage:       "name of an environment variable holding the database.collection namespace to archive",
+				EnvVars:     []string{"COLLECTION_NAMESPACE_FROM_ENV"},
+				Destination: &cfg.namespaceEnv,
+			},
+			&cli.StringFlag{
+				Name:        "collection-name-alias",
+				Usage:       "logical name to archive the collection under, filling {collection} in the storage URL and headers",
+				EnvVars:     []string{"COLLECTION_NAME_ALIAS"},
+				Destination: &cfg.collectionAlias,
+			},
+			&cli.StringFlag{
+				Name:        "delete-collection",
+				Usage:       "collection to delete archived documents from, e.g. when mongo-collection is a view over it",
+				EnvVars:     []string{"DELETE_COLLECTION"},
+				Destination: &cfg.deleteCollection,
+			},
+			&cli.StringFlag{
+				Name:        "rollup-collection",
+				Usage:       "collection to merge each day's rollup into before the day is archived and deleted",
+				EnvVars:     []string{"ROLLUP_COLLECTION"},
+				Destination: &cfg.rollupCollection,
+			},
+			&cli.StringFlag{
+				Name:        "rollup-pipeline",
+				Usage:       "aggregation stages rolling up each day's documents, as an extended JSON array",
+				EnvVars:     []string{"ROLLUP_PIPELINE"},
+				Destination: &cfg.rollupPipeline,
+			},
+			&cli.StringFlag{
+				Name:        "rollup-day-field",
+				Usage:       "field each rollup document is stamped with the day it rolls up under",
+				EnvVars:     []string{"ROLLUP_DAY_FIELD"},
+				Destination: &cfg.rollupDayField,
+				Value:       "day",
+			},
+			&cli.StringSliceFlag{
+				Name:        "merge-collections",
+				Usage:       "further collections archived into the same file per day as mongo-collection, and deleted from",
+				EnvVars:     []string{"MERGE_COLLECTIONS"},
+				Destination: &cfg.mergeCollections,
+			},
+			&cli.StringFlag{
+				Name:        "merge-tag-field",
+				Usage:       "field prepended to each document of merged collections, holding the collection it came from",
+				EnvVars:     []string{"MERGE_TAG_FIELD"},
+				Destination: &cfg.mergeTagField,
+				Value:       "_collection",
+			},
 			&cli.BoolFlag{
 				Name:        "delete",
 				EnvVars:     []string{"DELETE"},
 				Destination: &cfg.delete,
 			},
+			&cli.BoolFlag{
+				Name:        "exact-delete",
+				Usage:       "verify each file, then delete exactly the documents written to it with majority write concern",
+				EnvVars:     []string{"EXACT_DELETE"},
+				Destination: &cfg.exactDelete,
+			},
+			&cli.IntFlag{
+				Name:        "delete-chunk-size",
+				Usage:       "when deleting exactly, read ids back from each file and delete this many at a time, 0 to hold all",
+				EnvVars:     []string{"DELETE_CHUNK_SIZE"},
+				Destination: &cfg.deleteChunkSize,
+			},
+			&cli.IntFlag{
+				Name:        "delete-retries",
+				Usage:       "retry deletes failing under lock contention or a stepdown up to this many times, 0 to not retry",
+				EnvVars:     []string{"DELETE_RETRIES"},
+				Destination: &cfg.deleteRetries,
+			},
+			&cli.GenericFlag{
+				Name:    "delete-retry-backoff",
+				Usage:   "how long to wait before the first delete retry, doubling for each subsequent one, e.g. 1s",
+				EnvVars: []string{"DELETE_RETRY_BACKOFF"},
+				Value:   (*duration.Value)(&cfg.deleteRetryBackoff),
+			},
+			&cli.StringFlag{
+				Name:        "ttl-mark-field",
+				Usage:       "instead of deleting archived documents, set this field so a TTL index on it removes them later",
+				EnvVars:     []string{"TTL_MARK_FIELD"},
+				Destination: &cfg.ttlMarkField,
+			},
+			&cli.GenericFlag{
+				Name:    "ttl-mark-window",
+				Usage:   "how long after being marked a TTL index should remove archived documents, e.g. 7d",
+				EnvVars: []string{"TTL_MARK_WINDOW"},
+				Value:   (*duration.Value)(&cfg.ttlMarkWindow),
+			},
+			&cli.GenericFlag{
+				Name:    "delete-cancel-grace",
+				Usage:   "on cancellation mid-delete, e.g. SIGTERM, keep deleting the day for up to this long before stopping",
+				EnvVars: []string{"DELETE_CANCEL_GRACE"},
+				Value:   (*duration.Value)(&cfg.deleteCancelGrace),
+			},
+			&cli.BoolFlag{
+				Name:        "preserve-deleted-count",
+				Usage:       "fail should the number of documents deleted for a day differ from the number archived",
+				EnvVars:     []string{"PRESERVE_DELETED_COUNT"},
+				Destination: &cfg.preserveDeletedCount,
+			},
+			&cli.BoolFlag{
+				Name:        "delete-unarchived",
+				Usage:       "delete a day for which no documents were archived even if it still holds documents",
+				EnvVars:     []string{"DELETE_UNARCHIVED"},
+				Destination: &cfg.deleteUnarchived,
+			},
+			&cli.BoolFlag{
+				Name:        "require-healthy-replset",
+				Usage:       "refuse to delete unless the replica set has a primary and a majority of healthy members",
+				EnvVars:     []string{"REQUIRE_HEALTHY_REPLSET"},
+				Destination: &cfg.requireHealthyReplSet,
+			},
+			&cli.BoolFlag{
+				Name:        "unhealthy-replset-archive",
+				Usage:       "whilst the replica set is unhealthy, keep archiving days without deleting them, for reconciling",
+				EnvVars:     []string{"UNHEALTHY_REPLSET_ARCHIVE"},
+				Destination: &cfg.unhealthyArchive,
+			},
+			&cli.BoolFlag{
+				Name:        "causal-consistency",
+				Usage:       "read and delete each day within a single causally consistent session",
+				EnvVars:     []string{"CAUSAL_CONSISTENCY"},
+				Destination: &cfg.causalConsistency,
+			},
 			&cli.BoolFlag{
 				Name:        "ignore-file-exists-error",
 				EnvVars:     []string{"IGNORE_FILE_EXISTS_ERROR"},
 				Destination: &cfg.ignoreFileExistsError,
 			},
-			&cli.DurationFlag{
-				Name:        "retention",
-				EnvVars:     []string{"RETENTION"},
-				Required:    true,
-				Destination: &cfg.retention,
+			&cli.GenericFlag{
+				Name:    "on-collision",
+				Usage:   "what to do when a day's file already exists in storage, fail, suffix or overwrite",
+				EnvVars: []string{"ON_COLLISION"},
+				Value:   &cfg.onCollision,
+			},
+			&cli.BoolFlag{
+				Name:        "overwrite-incomplete",
+				Usage:       "overwrite an existing file that is empty or truncated, as a failed run leaves, rather than collide",
+				EnvVars:     []string{"OVERWRITE_INCOMPLETE"},
+				Destination: &cfg.overwriteIncomplete,
+			},
+			&cli.IntFlag{
+				Name:        "max-doc-bytes",
+				Usage:       "handle documents whose extended JSON exceeds this many bytes per the oversize policy, 0 for no limit",
+				EnvVars:     []string{"MAX_DOC_BYTES"},
+				Destination: &cfg.maxDocBytes,
+			},
+			&cli.IntFlag{
+				Name:        "max-bson-doc-bytes",
+				Usage:       "handle transformed documents whose BSON exceeds this many bytes, e.g. 16777216, 0 for no limit",
+				EnvVars:     []string{"MAX_BSON_DOC_BYTES"},
+				Destination: &cfg.maxBSONDocBytes,
+			},
+			&cli.GenericFlag{
+				Name:    "oversize-policy",
+				Usage:   "what to do with documents exceeding max-doc-bytes or max-bson-doc-bytes, fail or dead-letter",
+				EnvVars: []string{"OVERSIZE_POLICY"},
+				Value:   &cfg.oversizePolicy,
+			},
+			&cli.StringSliceFlag{
+				Name:        "require-fields",
+				Usage:       "fields every document must hold, as dotted paths, handled per the missing field policy",
+				EnvVars:     []string{"REQUIRE_FIELDS"},
+				Destination: &cfg.requireFields,
+			},
+			&cli.GenericFlag{
+				Name:    "missing-field-policy",
+				Usage:   "what to do with documents lacking a required field, fail, skip or dead-letter",
+				EnvVars: []string{"MISSING_FIELD_POLICY"},
+				Value:   &cfg.missingFieldPolicy,
+			},
+			&cli.StringFlag{
+				Name:        "special-types",
+				Usage:       "detect documents holding types not round-tripping through extended JSON, canonical, bson or flag",
+				EnvVars:     []string{"SPECIAL_TYPES"},
+				Destination: &cfg.specialTypes,
+			},
+			&cli.StringFlag{
+				Name:        "post-archive-command",
+				Usage:       "shell command run after each day is archived, passed the file URLs as arguments",
+				EnvVars:     []string{"POST_ARCHIVE_COMMAND"},
+				Destination: &cfg.postArchiveCommand,
+			},
+			&cli.StringFlag{
+				Name:        "post-archive-pubsub-topic",
+				Usage:       "Pub/Sub topic published to after each day is archived, e.g. projects/my-project/topics/archived",
+				EnvVars:     []string{"POST_ARCHIVE_PUBSUB_TOPIC"},
+				Destination: &cfg.postArchiveTopic,
+			},
+			&cli.BoolFlag{
+				Name:        "post-archive-fail-on-error",
+				Usage:       "fail the run when a post archive hook fails, rather than only logging the failure",
+				EnvVars:     []string{"POST_ARCHIVE_FAIL_ON_ERROR"},
+				Destination: &cfg.postArchiveFailRun,
+			},
+			&cli.StringFlag{
+				Name:        "metrics-pushgateway",
+				Usage:       "Prometheus Pushgateway to push the metrics of each run to once it ends, e.g. http://pushgateway:9091",
+				EnvVars:     []string{"METRICS_PUSHGATEWAY"},
+				Destination: &cfg.metricsPushgateway,
+			},
+			&cli.GenericFlag{
+				Name:     "retention",
+				Usage:    "how long to retain documents for, e.g. 2160h, 90d, 12w",
+				EnvVars:  []string{"RETENTION"},
+				Required: true,
+				Value:    (*duration.Value)(&cfg.retention),
+			},
+			&cli.GenericFlag{
+				Name:    "ttl-catch-up",
+				Usage:   "instead of the retention, archive and delete whole days of documents older than this TTL, e.g. 30d",
+				EnvVars: []string{"TTL_CATCH_UP"},
+				Value:   (*duration.Value)(&cfg.ttlCatchUp),
+			},
+			&cli.BoolFlag{
+				Name:        "complete-days-only",
+				Usage:       "leave the day holding the target for a later run, rather than archiving it in full, as when watching",
+				EnvVars:     []string{"COMPLETE_DAYS_ONLY"},
+				Destination: &cfg.completeDaysOnly,
+			},
+			&cli.IntFlag{
+				Name:        "keep-days",
+				Usage:       "never archive the most recent this many full days, nor today, however far the retention reaches",
+				EnvVars:     []string{"KEEP_DAYS"},
+				Destination: &cfg.keepDays,
+			},
+			&cli.GenericFlag{
+				Name:    "delay",
+				Usage:   "delay between archiving each day, e.g. 30s, 1m",
+				EnvVars: []string{"DELAY"},
+				Value:   (*duration.Value)(&cfg.delay),
+			},
+			&cli.IntFlag{
+				Name:        "max-documents",
+				Usage:       "stop the run once this many documents have been archived, finishing the current day, 0 for no cap",
+				EnvVars:     []string{"MAX_DOCUMENTS"},
+				Destination: &cfg.maxDocuments,
+			},
+			&cli.StringFlag{
+				Name:        "start-date",
+				Usage:       "begin the run at this day (YYYY-MM-DD, UTC) rather than that of the earliest document",
+				EnvVars:     []string{"START_DATE"},
+				Destination: &cfg.startDate,
+			},
+			&cli.BoolFlag{
+				Name:        "skip-empty-days",
+				Usage:       "fast-forward over days without documents, rather than writing an empty archive for each",
+				EnvVars:     []string{"SKIP_EMPTY_DAYS"},
+				Destination: &cfg.skipEmptyDays,
+			},
+			&cli.StringFlag{
+				Name:        "sort-within-day",
+				Usage:       "field to sort documents by within each day, e.g. _id or createdAt",
+				EnvVars:     []string{"SORT_WITHIN_DAY"},
+				Destination: &cfg.sortWithinDay,
+			},
+			&cli.GenericFlag{
+				Name:    "boundary",
+				Usage:   "which day documents created exactly at midnight belong to, left-inclusive or right-inclusive",
+				EnvVars: []string{"BOUNDARY"},
+				Value:   &cfg.boundary,
+			},
+			&cli.StringFlag{
+				Name:        "date-expr",
+				Usage:       "aggregation expression, as extended JSON, computing the date to bucket by instead of createdAt",
+				EnvVars:     []string{"DATE_EXPR"},
+				Destination: &cfg.dateExpr,
+			},
+			&cli.BoolFlag{
+				Name:        "object-id-fallback",
+				Usage:       "bucket by ObjectID _id timestamps whenever no document in the collection holds createdAt",
+				EnvVars:     []string{"OBJECT_ID_FALLBACK"},
+				Destination: &cfg.objectIDFallback,
+			},
+			&cli.BoolFlag{
+				Name:        "id-range-fastpath",
+				Usage:       "select each day by its range of ObjectID _ids, only valid where createdAt is monotonic with _id",
+				EnvVars:     []string{"ID_RANGE_FASTPATH"},
+				Destination: &cfg.idRangeFastPath,
+			},
+			&cli.IntFlag{
+				Name:        "intra-day-parallelism",
+				Usage:       "read each day as this many sub-ranges at once, interleaving their documents in the order read",
+				EnvVars:     []string{"INTRA_DAY_PARALLELISM"},
+				Value:       1,
+				Destination: &cfg.intraDayParallelism,
+			},
+			&cli.IntFlag{
+				Name:        "max-concurrent-mongo-ops",
+				Usage:       "at most this many mongo operations in flight at once, across every collection, 0 for no limit",
+				EnvVars:     []string{"MAX_CONCURRENT_MONGO_OPS"},
+				Destination: &cfg.maxConcurrentMongoOps,
+			},
+			&cli.IntFlag{
+				Name:        "log-sample-every",
+				Usage:       "only log every Nth line of each repeated info message, e.g. per day, summarizing the rest at the end",
+				EnvVars:     []string{"LOG_SAMPLE_EVERY"},
+				Destination: &cfg.logSampleEvery,
+			},
+			&cli.StringFlag{
+				Name:        "index-hint",
+				Usage:       "name of the index to force queries by createdAt to use, e.g. createdAt_1",
+				EnvVars:     []string{"INDEX_HINT"},
+				Destination: &cfg.indexHint,
+			},
+			&cli.IntFlag{
+				Name:        "max-time-ms",
+				Usage:       "have the server kill any find, count or aggregate running longer than this, 0 for no limit",
+				EnvVars:     []string{"MAX_TIME_MS"},
+				Destination: &cfg.maxTimeMS,
+			},
+			&cli.StringFlag{
+				Name:        "id-min",
+				Usage:       "only archive and delete documents whose ObjectID _id is at least this hex id",
+				EnvVars:     []string{"ID_MIN"},
+				Destination: &cfg.idMin,
+			},
+			&cli.StringFlag{
+				Name:        "id-max",
+				Usage:       "only archive and delete documents whose ObjectID _id is at most this hex id",
+				EnvVars:     []string{"ID_MAX"},
+				Destination: &cfg.idMax,
+			},
+			&cli.Int64Flag{
+				Name:        "max-scan-docs",
+				Usage:       "refuse to run if queries by createdAt would scan a collection of more than this many documents",
+				EnvVars:     []string{"MAX_SCAN_DOCS"},
+				Destination: &cfg.maxScanDocs,
+			},
+			&cli.Int64Flag{
+				Name:        "min-collection-docs",
+				Usage:       "skip collections holding fewer than this many documents, as not worth archiving",
+				EnvVars:     []string{"MIN_COLLECTION_DOCS"},
+				Destination: &cfg.minCollectionDocs,
+			},
+			&cli.Int64Flag{
+				Name:        "max-collection-docs",
+				Usage:       "skip collections holding more than this many documents, leaving them for manual handling",
+				EnvVars:     []string{"MAX_COLLECTION_DOCS"},
+				Destination: &cfg.maxCollectionDocs,
+			},
+			&cli.StringFlag{
+				Name:        "collection-filter-expr",
+				Usage:       "skip collections whose stats don't satisfy this predicate, e.g. 'count > 1e6 AND avgObjSize < 1024'",
+				EnvVars:     []string{"COLLECTION_FILTER_EXPR"},
+				Destination: &cfg.collectionFilterExpr,
+			},
+			&cli.StringSliceFlag{
+				Name:        "plain-fields",
+				Usage:       "dotted field paths to write as plain JSON instead of extended JSON, losing BSON type information",
+				EnvVars:     []string{"PLAIN_FIELDS"},
+				Destination: &cfg.plainFields,
+			},
+			&cli.StringSliceFlag{
+				Name:        "rename-fields",
+				Usage:       "fields to archive under a different name, as old=new, e.g. meta.userId=user_id",
+				EnvVars:     []string{"RENAME_FIELDS"},
+				Destination: &cfg.renameFields,
+			},
+			&cli.StringFlag{
+				Name:        "explode-field",
+				Usage:       "top level array field to write a record per element of, rather than each document",
+				EnvVars:     []string{"EXPLODE_FIELD"},
+				Destination: &cfg.explodeField,
+			},
+			&cli.StringSliceFlag{
+				Name:        "explode-parent-fields",
+				Usage:       "top level fields of each document to copy into every record exploded from it, e.g. _id",
+				EnvVars:     []string{"EXPLODE_PARENT_FIELDS"},
+				Destination: &cfg.explodeParentFields,
+			},
+			&cli.StringFlag{
+				Name:        "file-extension",
+				Usage:       "data format extension of archived files, to which the compression extension is appended",
+				EnvVars:     []string{"FILE_EXTENSION"},
+				Destination: &cfg.fileExtension,
+				Value:       "json",
+			},
+			&cli.StringFlag{
+				Name:        "format",
+				Usage:       "format documents are archived in, extjson or bson, which also determines the file extension",
+				EnvVars:     []string{"FORMAT"},
+				Destination: &cfg.format,
+				Value:       archive.DefaultEncoder,
+			},
+			&cli.StringFlag{
+				Name:        "partition-field",
+				Usage:       "split each day into separate files by the value of this top level field, e.g. region",
+				EnvVars:     []string{"PARTITION_FIELD"},
+				Destination: &cfg.partitionField,
+			},
+			&cli.IntFlag{
+				Name:        "max-open-partitions",
+				Usage:       "cap the partition files open at once, closing and later appending to the least recently written",
+				EnvVars:     []string{"MAX_OPEN_PARTITIONS"},
+				Destination: &cfg.maxOpenPartitions,
+			},
+			&cli.BoolFlag{
+				Name:        "file-header",
+				Usage:       "write a <day>.header.json sidecar describing each archived file",
+				EnvVars:     []string{"FILE_HEADER"},
+				Destination: &cfg.fileHeader,
+			},
+			&cli.BoolFlag{
+				Name:        "record-provenance",
+				Usage:       "record the mongo server's host, replica set and version in file headers, and as GCS object metadata",
+				EnvVars:     []string{"RECORD_PROVENANCE"},
+				Destination: &cfg.recordProvenance,
+			},
+			&cli.BoolFlag{
+				Name:        "store-content-crc",
+				Usage:       "record the CRC32 of each archive's uncompressed contents in its file header, checked when verifying",
+				EnvVars:     []string{"STORE_CONTENT_CRC"},
+				Destination: &cfg.storeContentCRC,
 			},
-			&cli.DurationFlag{
-				Name:        "delay",
-				EnvVars:     []string{"DELAY"},
-				Destination: &cfg.delay,
-				Value:       time.Second * 30,
+			&cli.BoolFlag{
+				Name:        "write-success-marker",
+				Usage:       "write an empty <day>/_SUCCESS file once each day has been written, verified and deleted",
+				EnvVars:     []string{"WRITE_SUCCESS_MARKER"},
+				Destination: &cfg.successMarker,
+			},
+			&cli.BoolFlag{
+				Name:        "write-empty-run-manifest",
+				Usage:       "record runs archiving nothing, e.g. of empty collections, with a manifest in _archiver/empty-runs",
+				EnvVars:     []string{"WRITE_EMPTY_RUN_MANIFEST"},
+				Destination: &cfg.emptyRunManifest,
+			},
+			&cli.BoolFlag{
+				Name:        "write-offset-index",
+				Usage:       "write a <day>.index.json.gz sidecar holding the uncompressed byte offset of each document",
+				EnvVars:     []string{"WRITE_OFFSET_INDEX"},
+				Destination: &cfg.writeOffsetIndex,
+			},
+			&cli.BoolFlag{
+				Name:        "write-schema",
+				Usage:       "write a <day>.schema.json sidecar describing the fields and BSON types observed in the documents",
+				EnvVars:     []string{"WRITE_SCHEMA"},
+				Destination: &cfg.writeSchema,
+			},
+			&cli.BoolFlag{
+				Name:        "write-bounds",
+				Usage:       "write a <day>.bounds.json sidecar holding the lowest and highest _id and createdAt archived",
+				EnvVars:     []string{"WRITE_BOUNDS"},
+				Destination: &cfg.writeBounds,
+			},
+			&cli.BoolFlag{
+				Name:        "write-checksums",
+				Usage:       "write a <file>.sha256 sidecar holding the SHA-256 of each gzipped file, e.g. for audits",
+				EnvVars:     []string{"WRITE_CHECKSUMS"},
+				Destination: &cfg.writeChecksums,
+			},
+			&cli.BoolFlag{
+				Name:        "write-layout",
+				Usage:       "write _archiver/layout.json describing the path, codec, format and field transforms of archives",
+				EnvVars:     []string{"WRITE_LAYOUT"},
+				Destination: &cfg.writeLayout,
+			},
+			&cli.StringFlag{
+				Name:        "progress-file",
+				Usage:       "path in storage to write the progress of each run to, e.g. _archiver/progress.json",
+				EnvVars:     []string{"PROGRESS_FILE"},
+				Destination: &cfg.progressFile,
+			},
+			&cli.GenericFlag{
+				Name:    "progress-interval",
+				Usage:   "rewrite the progress file at most this often whilst documents are being written",
+				EnvVars: []string{"PROGRESS_INTERVAL"},
+				Value:   (*duration.Value)(&cfg.progressInterval),
+			},
+			&cli.BoolFlag{
+				Name:        "resumable",
+				Usage:       "checkpoint progress within each day, so an interrupted day can be continued (disk storage only)",
+				EnvVars:     []string{"RESUMABLE"},
+				Destination: &cfg.resumable,
+			},
+			&cli.IntFlag{
+				Name:        "checkpoint-interval",
+				Usage:       "number of documents to write between checkpoints when resumable",
+				EnvVars:     []string{"CHECKPOINT_INTERVAL"},
+				Destination: &cfg.checkpointInterval,
+				Value:       10000,
+			},
+			&cli.GenericFlag{
+				Name:    "commit-interval",
+				Usage:   "commit the progress of each file being written at least this often, e.g. 5m, for stores able to append",
+				EnvVars: []string{"COMMIT_INTERVAL"},
+				Value:   (*duration.Value)(&cfg.commitInterval),
+			},
+			&cli.GenericFlag{
+				Name:    "compression",
+				Usage:   "what compresses archives, gzip, or store to write plain JSON and leave compression to the store",
+				EnvVars: []string{"COMPRESSION"},
+				Value:   &cfg.compression,
+			},
+			&cli.BoolFlag{
+				Name:        "validate-compression",
+				Usage:       "decompress each file as it's written, aborting it rather than committing a malformed stream",
+				EnvVars:     []string{"VALIDATE_COMPRESSION"},
+				Destination: &cfg.validateCompression,
+			},
+			&cli.IntFlag{
+				Name:        "compression-threads",
+				Usage:       "compress each file on up to this many goroutines, for days whose compression is CPU-bound",
+				EnvVars:     []string{"COMPRESSION_THREADS"},
+				Destination: &cfg.compressionThreads,
+				Value:       1,
+			},
+			&cli.BoolFlag{
+				Name:        "adaptive-compression",
+				Usage:       "choose the gzip level for each day based on its document count",
+				EnvVars:     []string{"ADAPTIVE_COMPRESSION"},
+				Destination: &cfg.adaptiveCompression,
+			},
+			&cli.GenericFlag{
+				Name:    "adaptive-compression-target",
+				Usage:   "when adaptive, tune the gzip level toward writing each day within this duration instead, e.g. 20m",
+				EnvVars: []string{"ADAPTIVE_COMPRESSION_TARGET"},
+				Value:   (*duration.Value)(&cfg.compressionTarget),
+			},
+			&cli.IntFlag{
+				Name:        "compression-small-day",
+				Usage:       "days with fewer documents than this use the fastest gzip level when adaptive",
+				EnvVars:     []string{"COMPRESSION_SMALL_DAY"},
+				Destination: &cfg.compressionSmallDay,
+				Value:       10000,
+			},
+			&cli.IntFlag{
+				Name:        "compression-large-day",
+				Usage:       "days with at least this many documents use the best gzip level when adaptive",
+				EnvVars:     []string{"COMPRESSION_LARGE_DAY"},
+				Destination: &cfg.compressionLargeDay,
+				Value:       1000000,
+			},
+			&cli.BoolFlag{
+				Name:        "estimate",
+				Usage:       "print an approximate estimate of the days, documents and bytes to be archived, then exit",
+				EnvVars:     []string{"ESTIMATE"},
+				Destination: &cfg.estimate,
+			},
+			&cli.Float64Flag{
+				Name:        "estimate-compression-ratio",
+				Usage:       "expected compressed to uncompressed size ratio used when estimating",
+				EnvVars:     []string{"ESTIMATE_COMPRESSION_RATIO"},
+				Destination: &cfg.estimateRatio,
+				Value:       0.1,
+			},
+			&cli.GenericFlag{
+				Name:    "compare-retention",
+				Usage:   "with estimate, also estimate archiving with this retention, printing both side by side, e.g. 90d",
+				EnvVars: []string{"COMPARE_RETENTION"},
+				Value:   (*duration.Value)(&cfg.compareRetention),
+			},
+			&cli.BoolFlag{
+				Name:        "compare-json",
+				Usage:       "print the comparison of retentions as a JSON object per database, rather than as a table",
+				EnvVars:     []string{"COMPARE_JSON"},
+				Destination: &cfg.compareJSON,
+			},
+			&cli.BoolFlag{
+				Name:        "reconcile",
+				Usage:       "delete the documents of days which have already been archived but not deleted, then exit",
+				EnvVars:     []string{"RECONCILE"},
+				Destination: &cfg.reconcile,
+			},
+			&cli.BoolFlag{
+				Name:        "reconcile-verify",
+				Usage:       "read back each archive file in full before reconciling its day",
+				EnvVars:     []string{"RECONCILE_VERIFY"},
+				Destination: &cfg.reconcileVerify,
+			},
+			&cli.Float64Flag{
+				Name:        "verify-sample-rate",
+				Usage:       "fraction of lines parsed when verifying each file, from 0 to 1, with every file still read in full",
+				EnvVars:     []string{"VERIFY_SAMPLE_RATE"},
+				Destination: &cfg.verifySampleRate,
+				Value:       1,
+			},
+			&cli.BoolFlag{
+				Name:        "audit-checksums",
+				Usage:       "verify every gzipped file in storage against its checksum sidecar, reporting mismatches, then exit",
+				EnvVars:     []string{"AUDIT_CHECKSUMS"},
+				Destination: &cfg.auditChecksums,
+			},
+			&cli.IntFlag{
+				Name:        "audit-workers",
+				Usage:       "number of files to hash at once when auditing checksums",
+				EnvVars:     []string{"AUDIT_WORKERS"},
+				Value:       4,
+				Destination: &cfg.auditWorkers,
+			},
+			&cli.BoolFlag{
+				Name:        "peek",
+				Usage:       "print the first documents of an archived file in storage to stdout, then exit",
+				EnvVars:     []string{"PEEK"},
+				Destination: &cfg.peek,
+			},
+			&cli.StringFlag{
+				Name:        "peek-file",
+				Usage:       "the file to peek at, relative to the storage URL, rather than that of the latest day",
+				EnvVars:     []string{"PEEK_FILE"},
+				Destination: &cfg.peekFile,
+			},
+			&cli.IntFlag{
+				Name:        "peek-count",
+				Usage:       "number of documents to print when peeking",
+				EnvVars:     []string{"PEEK_COUNT"},
+				Value:       10,
+				Destination: &cfg.peekCount,
+			},
+			&cli.BoolFlag{
+				Name:        "peek-sample",
+				Usage:       "print a random sample of the file's documents when peeking, rather than the first",
+				EnvVars:     []string{"PEEK_SAMPLE"},
+				Destination: &cfg.peekSample,
+			},
+			&cli.BoolFlag{
+				Name:        "peek-pretty",
+				Usage:       "pretty-print documents when peeking",
+				EnvVars:     []string{"PEEK_PRETTY"},
+				Destination: &cfg.peekPretty,
+			},
+			&cli.BoolFlag{
+				Name:        "doctor",
+				Usage:       "check connectivity and permissions against mongo and storage, reporting each check, then exit",
+				EnvVars:     []string{"DOCTOR"},
+				Destination: &cfg.doctor,
+			},
+			&cli.BoolFlag{
+				Name:        "respect-pause-flag",
+				Usage:       "wait between days while a _archiver/PAUSE object exists in storage",
+				EnvVars:     []string{"RESPECT_PAUSE_FLAG"},
+				Destination: &cfg.respectPauseFlag,
+			},
+			&cli.BoolFlag{
+				Name:        "watch",
+				Usage:       "keep running once the target is reached, archiving further days as they become eligible",
+				EnvVars:     []string{"WATCH"},
+				Destination: &cfg.watch,
+			},
+			&cli.GenericFlag{
+				Name:    "watch-interval",
+				Usage:   "the longest to wait between runs when watching, rather than until the next day is eligible, e.g. 1h",
+				EnvVars: []string{"WATCH_INTERVAL"},
+				Value:   (*duration.Value)(&cfg.watchInterval),
+			},
+			&cli.BoolFlag{
+				Name:        "change-stream",
+				Usage:       "archive inserts from a change stream as they age beyond the retention, rather than by day",
+				EnvVars:     []string{"CHANGE_STREAM"},
+				Destination: &cfg.changeStream,
+			},
+			&cli.BoolFlag{
+				Name:        "use-server-time",
+				Usage:       "compute the target from the mongo server's clock rather than the local clock",
+				EnvVars:     []string{"USE_SERVER_TIME"},
+				Destination: &cfg.useServerTime,
+			},
+			&cli.StringSliceFlag{
+				Name:        "skip-dates",
+				Usage:       "UTC days on which runs do nothing, as weekdays, dates or ranges, e.g. sat,2024-12-20..2025-01-02",
+				EnvVars:     []string{"SKIP_DATES"},
+				Destination: &cfg.skipDates,
 			},
 		},
 		Action: func(cCtx *cli.Context) error {
+			ran = true
 			ctx, cancel := signal.NotifyContext(cCtx.Context, syscall.SIGTERM, syscall.SIGINT)
 			defer cancel()
 			return run(ctx, cfg)
@@ -90,40 +965,1605 @@ func main() {
 	}
 
 	if err := app.RunContext(context.Background(), os.Args); err != nil {
-		slog.Error("exiting", slog.Any("error", err))
-		os.Exit(1)
+		if !ran {
+			// Flag parsing failed before the action was invoked
+			err = exitcode.WithCode(exitcode.Config, err)
+		}
+		code := exitcode.For(err)
+		slog.Error("exiting", slog.Any("error", err), slog.Int("code", code))
+		os.Exit(code)
 	}
 }
 
-func run(ctx context.Context, cfg config) error {
-	slog.Info(
-		"received configuration",
-		slog.String("mongoURL", cfg.mongoURL),
-		slog.String("database", cfg.mongoDatabase),
-		slog.String("collection", cfg.mongoCollection),
-		slog.String("storageURL", cfg.storageURL),
-		slog.Bool("delete", cfg.delete),
-		slog.Bool("ignoreFileExistsError", cfg.ignoreFileExistsError),
-		slog.Duration("retention", cfg.retention),
-		slog.Duration("delay", cfg.delay),
-	)
-
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.mongoURL))
-	if err != nil {
-		return fmt.Errorf("unable to connect to mongo: %w", err)
+// resolveNamespace sets the database and collection from the database.collection namespace held by the environment
+// variable named by collection-namespace-from-env, e.g. as injected into each tenant's deployment. Database names
+// can't hold dots whereas collection names can, so the namespace is split at its first dot.
+func (cfg *config) resolveNamespace(lookupEnv func(key string) (string, bool)) error {
+	if cfg.namespaceEnv == "" {
+		return nil
 	}
-
-	collection := client.Database(cfg.mongoDatabase).Collection(cfg.mongoCollection)
-	docSource := source.NewMongoDB(collection)
-
-	store, err := storage.FromURL(ctx, cfg.storageURL)
-	if err != nil {
-		return fmt.Errorf("unable to connect to storage: %w", err)
+	if cfg.mongoDatabase != "" || cfg.mongoDatabasePattern != "" || cfg.mongoCollection != "" {
+		return errors.New(
+			"collection namespace from env cannot be combined with mongo-database, mongo-database-pattern or " +
+				"mongo-collection",
+		)
 	}
-	defer store.Close()
+	namespace, ok := lookupEnv(cfg.namespaceEnv)
+	if !ok {
+		return fmt.Errorf("collection namespace environment variable %s is not set", cfg.namespaceEnv)
+	}
+	database, collection, ok := strings.Cut(namespace, ".")
+	if !ok || database == "" || collection == "" {
+		return fmt.Errorf("invalid collection namespace %q in %s, expected database.collection", namespace, cfg.namespaceEnv)
+	}
+	cfg.mongoDatabase, cfg.mongoCollection = database, collection
+	return nil
+}
 
-	targetDate := time.Now().UTC().Add(cfg.retention * -1)
-	archiver := archive.NewArchiver(docSource, store, !cfg.delete, cfg.ignoreFileExistsError, cfg.delay)
+// collectionName returns the name the collection is archived under, which is its alias, if it has one. Documents are
+// always read from and deleted from the collection itself.
+func (cfg config) collectionName() string {
+	if cfg.collectionAlias != "" {
+		return cfg.collectionAlias
+	}
+	return cfg.mongoCollection
+}
 
-	return archiver.Run(ctx, targetDate)
+// validate checks for invalid combinations of configuration
+func (cfg config) validate() error {
+	if (cfg.mongoDatabase == "") == (cfg.mongoDatabasePattern == "") {
+		return errors.New("exactly one of mongo-database or mongo-database-pattern must be supplied")
+	}
+	if cfg.mongoCollection == "" {
+		return errors.New("mongo-collection must be supplied, unless read from collection-namespace-from-env")
+	}
+	if cfg.tenantConcurrency < 1 {
+		return errors.New("collection concurrency must be at least 1")
+	}
+	if cfg.tenantConcurrency > 1 && cfg.mongoDatabasePattern == "" {
+		return errors.New("collection concurrency requires mongo-database-pattern")
+	}
+	if cfg.writeIndex && cfg.mongoDatabasePattern == "" {
+		return errors.New("write index requires mongo-database-pattern")
+	}
+	if cfg.writeIndex && cfg.changeStream {
+		// Databases are only complete once their change stream ends, so the index would never be written
+		return errors.New("change stream cannot be combined with write-index")
+	}
+	if cfg.dateExpr != "" {
+		if _, err := source.ParseDateExpr(cfg.dateExpr); err != nil {
+			return err
+		}
+	}
+	if cfg.gcsCredentialsFile != "" && cfg.gcsCredentialsJSON != "" {
+		return errors.New("at most one of gcs-credentials-file or gcs-credentials-json may be supplied")
+	}
+	if _, err := storage.ParseMetadata(cfg.objectMetadata.Value()); err != nil {
+		return err
+	}
+	if cfg.maxDocBytes < 0 {
+		return errors.New("max doc bytes must not be negative")
+	}
+	if cfg.maxBSONDocBytes < 0 {
+		return errors.New("max bson doc bytes must not be negative")
+	}
+	if cfg.specialTypes != "" {
+		policy, err := archive.ParseSpecialTypesPolicy(cfg.specialTypes)
+		switch {
+		case err != nil:
+			return err
+		case cfg.changeStream:
+			return errors.New("special types cannot be combined with change stream")
+		case policy == archive.SpecialTypesBSON && (cfg.exactDelete || cfg.resumable):
+			return errors.New("setting aside special types as bson cannot be combined with exact-delete or resumable")
+		}
+	}
+	if cfg.missingFieldPolicy != archive.MissingFieldFail {
+		switch {
+		case len(cfg.requireFields.Value()) == 0:
+			return errors.New("missing field policy requires require-fields")
+		case cfg.delete && !cfg.exactDelete:
+			return errors.New("skipping or dead lettering invalid documents requires exact-delete, to leave them in place")
+		case cfg.resumable || cfg.changeStream:
+			return errors.New("skipping or dead lettering invalid documents cannot be combined with resumable or change stream")
+		}
+	}
+	if cfg.maxDocuments < 0 {
+		return errors.New("max documents must not be negative")
+	}
+	if cfg.maxDocuments > 0 && (cfg.estimate || cfg.reconcile || cfg.changeStream) {
+		return errors.New("max documents cannot be combined with estimate, reconcile or change stream")
+	}
+	if cfg.startDate != "" {
+		// Whether it's before the target is checked by the archiver, once the target is known
+		if _, err := time.Parse(time.DateOnly, cfg.startDate); err != nil {
+			return fmt.Errorf("invalid start date: %w", err)
+		}
+		if cfg.estimate || cfg.changeStream {
+			return errors.New("start date cannot be combined with estimate or change stream")
+		}
+	}
+	if cfg.deleteChunkSize < 0 {
+		return errors.New("delete chunk size must not be negative")
+	}
+	if cfg.deleteChunkSize > 0 && !cfg.exactDelete {
+		return errors.New("delete chunk size requires exact-delete")
+	}
+	if cfg.deleteCancelGrace < 0 {
+		return errors.New("delete cancel grace must not be negative")
+	}
+	if cfg.deleteCancelGrace > 0 && cfg.changeStream {
+		return errors.New("delete cancel grace cannot be combined with change stream")
+	}
+	if cfg.minCollectionDocs < 0 || cfg.maxCollectionDocs < 0 {
+		return errors.New("collection document thresholds must not be negative")
+	}
+	if cfg.maxCollectionDocs > 0 && cfg.minCollectionDocs > cfg.maxCollectionDocs {
+		return errors.New("min collection docs must not exceed max collection docs")
+	}
+	if _, err := freeze.Parse(cfg.skipDates.Value()); err != nil {
+		return err
+	}
+	if cfg.changeStream && len(cfg.skipDates.Value()) > 0 {
+		// Change streams run continuously, so there's no run to skip
+		return errors.New("change stream cannot be combined with skip-dates")
+	}
+	if cfg.collectionFilterExpr != "" {
+		if _, err := predicate.Parse(cfg.collectionFilterExpr, source.StatsVariables); err != nil {
+			return err
+		}
+	}
+	if cfg.deleteRetries < 0 {
+		return errors.New("delete retries must not be negative")
+	}
+	if cfg.deleteRetries > 0 && cfg.deleteRetryBackoff <= 0 {
+		return errors.New("delete retry backoff must be positive")
+	}
+	if cfg.ttlMarkField != "" {
+		switch {
+		case !cfg.delete:
+			return errors.New("ttl mark field marks documents in place of deleting them, so requires delete")
+		case cfg.ttlMarkWindow <= 0:
+			return errors.New("ttl mark window must be positive")
+		case cfg.ttlMarkField == "_id" || cfg.ttlMarkField == "createdAt":
+			return fmt.Errorf("ttl mark field cannot be %s", cfg.ttlMarkField)
+		}
+	} else if cfg.ttlMarkWindow != 0 {
+		return errors.New("ttl mark window requires ttl-mark-field")
+	}
+	if cfg.maxConcurrentUploads < 0 {
+		return errors.New("max concurrent uploads must not be negative")
+	}
+	if cfg.ignoreFileExistsError && cfg.onCollision != archive.CollisionFail {
+		return errors.New("ignore-file-exists-error cannot be combined with an on-collision policy")
+	}
+	if cfg.resumable && cfg.checkpointInterval <= 0 {
+		return errors.New("checkpoint interval must be positive")
+	}
+	if cfg.commitInterval < 0 {
+		return errors.New("commit interval must not be negative")
+	}
+	if (cfg.resumable || cfg.exactDelete) && slices.Contains(cfg.plainFields.Value(), "_id") {
+		return errors.New("_id cannot be a plain field when resumable or deleting exactly, as it must round trip")
+	}
+	if _, err := source.ParseRenames(cfg.renameFields.Value()); err != nil {
+		return err
+	}
+	if (cfg.resumable || cfg.exactDelete) && slices.ContainsFunc(cfg.renameFields.Value(), func(rename string) bool {
+		return strings.HasPrefix(rename, "_id=")
+	}) {
+		return errors.New("_id cannot be renamed when resumable or deleting exactly, as it must round trip")
+	}
+	if cfg.compression == archive.CompressionStore && (cfg.resumable || cfg.adaptiveCompression) {
+		return errors.New("store compression cannot be combined with resumable or adaptive-compression")
+	}
+	if cfg.validateCompression && (cfg.resumable || cfg.compression == archive.CompressionStore) {
+		return errors.New("validate compression cannot be combined with resumable or store compression")
+	}
+	if cfg.compressionThreads < 1 {
+		return errors.New("compression threads must be at least 1")
+	}
+	if cfg.compressionThreads > 1 && (cfg.resumable || cfg.compression == archive.CompressionStore) {
+		return errors.New("compression threads cannot be combined with resumable or store compression")
+	}
+	if cfg.compressionTarget < 0 {
+		return errors.New("adaptive compression target must not be negative")
+	}
+	if cfg.compressionTarget > 0 && !cfg.adaptiveCompression {
+		return errors.New("adaptive compression target requires adaptive-compression")
+	}
+	if cfg.adaptiveCompression && cfg.compressionSmallDay > cfg.compressionLargeDay {
+		return errors.New("compression small day threshold must not exceed the large day threshold")
+	}
+	if cfg.watch && cfg.estimate {
+		return errors.New("watch cannot be combined with estimate")
+	}
+	if cfg.compareRetention < 0 {
+		return errors.New("compare retention must not be negative")
+	}
+	if cfg.compareRetention > 0 && !cfg.estimate {
+		return errors.New("compare retention compares estimates, so requires estimate")
+	}
+	if cfg.compareJSON && cfg.compareRetention == 0 {
+		return errors.New("compare json requires compare-retention")
+	}
+	if cfg.reconcile && (cfg.estimate || cfg.watch) {
+		return errors.New("reconcile cannot be combined with estimate or watch")
+	}
+	if cfg.reconcile && !cfg.delete {
+		return errors.New("reconcile deletes documents, so requires delete")
+	}
+	if cfg.deleteUnarchived && !cfg.delete {
+		return errors.New("delete unarchived only applies when deleting, so requires delete")
+	}
+	if cfg.changeStream && (cfg.estimate || cfg.watch || cfg.reconcile) {
+		return errors.New("change stream cannot be combined with estimate, watch or reconcile")
+	}
+	if cfg.changeStream && cfg.mongoDatabasePattern != "" {
+		return errors.New("change stream cannot be combined with mongo-database-pattern")
+	}
+	if cfg.changeStream && cfg.deleteCollection != "" {
+		return errors.New("change stream cannot be combined with delete-collection")
+	}
+	if mergeCollections := cfg.mergeCollections.Value(); len(mergeCollections) > 0 {
+		switch {
+		case cfg.mergeTagField == "":
+			return errors.New("merge tag field must not be empty")
+		case slices.Contains(mergeCollections, cfg.mongoCollection):
+			return errors.New("merge-collections must not include mongo-collection")
+		case cfg.deleteCollection != "" || cfg.rollupPipeline != "" || cfg.indexHint != "":
+			return errors.New("merge-collections cannot be combined with delete-collection, rollup-pipeline or index-hint")
+		case cfg.exactDelete || cfg.resumable || cfg.causalConsistency:
+			return errors.New("merge-collections cannot be combined with exact-delete, resumable or causal-consistency")
+		case cfg.estimate || cfg.changeStream:
+			return errors.New("merge-collections cannot be combined with estimate or change-stream")
+		}
+	}
+	if (cfg.rollupCollection == "") != (cfg.rollupPipeline == "") {
+		return errors.New("rollup-collection and rollup-pipeline must be set together")
+	}
+	if cfg.rollupPipeline != "" {
+		if _, err := source.ParseRollupPipeline(cfg.rollupPipeline); err != nil {
+			return err
+		}
+		switch {
+		case cfg.rollupDayField == "" || strings.HasPrefix(cfg.rollupDayField, "$"):
+			return errors.New("rollup day field must be a field name")
+		case cfg.rollupCollection == cfg.mongoCollection || cfg.rollupCollection == cfg.deleteCollection:
+			return errors.New("rollup collection must differ from the collection archived")
+		case cfg.changeStream:
+			return errors.New("change stream cannot be combined with rollup-pipeline")
+		case cfg.resumable:
+			return errors.New("rollup-pipeline cannot be combined with resumable")
+		}
+	}
+	if cfg.objectIDFallback && (cfg.dateExpr != "" || cfg.changeStream) {
+		return errors.New("object id fallback cannot be combined with date-expr or change stream")
+	}
+	if cfg.idRangeFastPath && (cfg.dateExpr != "" || cfg.changeStream || cfg.objectIDFallback || cfg.indexHint != "") {
+		return errors.New(
+			"id range fast path cannot be combined with date-expr, change stream, object-id-fallback or index-hint",
+		)
+	}
+	if cfg.idRangeFastPath && cfg.boundary != source.LeftInclusive {
+		return errors.New("id range fast path requires a left-inclusive boundary, as ObjectID timestamps are whole seconds")
+	}
+	if cfg.intraDayParallelism < 1 {
+		return errors.New("intra day parallelism must be at least 1")
+	}
+	if cfg.intraDayParallelism > 1 && (cfg.dateExpr != "" || cfg.sortWithinDay != "" || cfg.resumable) {
+		return errors.New("intra day parallelism cannot be combined with date-expr, sort-within-day or resumable")
+	}
+	if cfg.logSampleEvery < 0 {
+		return errors.New("log sample every must not be negative")
+	}
+	if cfg.maxConcurrentMongoOps < 0 {
+		return errors.New("max concurrent mongo ops must not be negative")
+	}
+	if cfg.maxTimeMS < 0 {
+		return errors.New("max time ms must not be negative")
+	}
+	if _, err := source.ParseIDRange(cfg.idMin, cfg.idMax); err != nil {
+		return err
+	}
+	if cfg.changeStream && (cfg.idMin != "" || cfg.idMax != "") {
+		return errors.New("change stream cannot be combined with id-min or id-max")
+	}
+	if _, err := archive.LookupEncoder(cfg.format); err != nil {
+		return err
+	}
+	if cfg.format != archive.DefaultEncoder {
+		switch {
+		case cfg.fileExtension != "json":
+			return errors.New("file-extension cannot be combined with format, which determines the extension")
+		case cfg.exactDelete || cfg.reconcileVerify:
+			return errors.New("format cannot be combined with exact-delete or reconcile-verify, which read back extended JSON")
+		case strings.HasPrefix(cfg.storageURL, "kafka:"):
+			return errors.New("format cannot be combined with Kafka storage, which produces each line as a message")
+		}
+	}
+	if cfg.changeStream && cfg.skipEmptyDays {
+		return errors.New("change stream cannot be combined with skip-empty-days")
+	}
+	if cfg.changeStream && cfg.successMarker {
+		return errors.New("change stream cannot be combined with write-success-marker")
+	}
+	if cfg.changeStream && cfg.emptyRunManifest {
+		return errors.New("change stream cannot be combined with write-empty-run-manifest")
+	}
+	if cfg.changeStream && cfg.dateExpr != "" {
+		return errors.New("change stream cannot be combined with date-expr")
+	}
+	if cfg.preserveDeletedCount && (!cfg.delete || cfg.ignoreFileExistsError) {
+		return errors.New("preserve-deleted-count requires delete, and cannot be combined with ignore-file-exists-error")
+	}
+	if cfg.requireHealthyReplSet && (!cfg.delete || cfg.changeStream) {
+		return errors.New("require-healthy-replset requires delete, and cannot be combined with change stream")
+	}
+	if cfg.unhealthyArchive && !cfg.requireHealthyReplSet {
+		return errors.New("unhealthy-replset-archive requires require-healthy-replset")
+	}
+	postArchive := cfg.postArchiveCommand != "" || cfg.postArchiveTopic != ""
+	if postArchive && (cfg.estimate || cfg.reconcile || cfg.changeStream) {
+		return errors.New("post archive hooks cannot be combined with estimate, reconcile or change stream")
+	}
+	if cfg.metricsPushgateway != "" {
+		if cfg.estimate || cfg.reconcile || cfg.changeStream {
+			return errors.New("metrics pushgateway cannot be combined with estimate, reconcile or change stream")
+		}
+		if _, err := metrics.NewPushgateway(cfg.metricsPushgateway); err != nil {
+			return err
+		}
+	}
+	if cfg.postArchiveFailRun && !postArchive {
+		return errors.New("post-archive-fail-on-error requires a post archive command or Pub/Sub topic")
+	}
+	if cfg.writeChecksums && (cfg.resumable || cfg.compression == archive.CompressionStore) {
+		return errors.New("write checksums cannot be combined with resumable or store compression")
+	}
+	if cfg.auditChecksums && (cfg.estimate || cfg.reconcile || cfg.watch || cfg.changeStream) {
+		return errors.New("audit checksums cannot be combined with estimate, reconcile, watch or change stream")
+	}
+	if cfg.auditChecksums && cfg.mongoDatabasePattern != "" {
+		return errors.New("audit checksums cannot be combined with mongo-database-pattern")
+	}
+	if cfg.auditChecksums && cfg.auditWorkers <= 0 {
+		return errors.New("audit workers must be positive")
+	}
+	if cfg.peek && (cfg.estimate || cfg.reconcile || cfg.watch || cfg.changeStream || cfg.auditChecksums) {
+		return errors.New("peek cannot be combined with estimate, reconcile, watch, change stream or audit checksums")
+	}
+	if cfg.peek && cfg.mongoDatabasePattern != "" {
+		return errors.New("peek cannot be combined with mongo-database-pattern")
+	}
+	if cfg.peek && cfg.peekCount <= 0 {
+		return errors.New("peek count must be positive")
+	}
+	if !cfg.peek && (cfg.peekFile != "" || cfg.peekSample || cfg.peekPretty) {
+		return errors.New("peek-file, peek-sample and peek-pretty require peek")
+	}
+	if cfg.doctor && (cfg.estimate || cfg.reconcile || cfg.watch || cfg.changeStream || cfg.auditChecksums || cfg.peek) {
+		return errors.New("doctor cannot be combined with estimate, reconcile, watch, change stream, audit checksums or peek")
+	}
+	if cfg.doctor && cfg.mongoDatabasePattern != "" {
+		return errors.New("doctor cannot be combined with mongo-database-pattern")
+	}
+	if cfg.reconcileVerify && !cfg.reconcile {
+		return errors.New("reconcile verify requires reconcile")
+	}
+	if cfg.verifySampleRate <= 0 || cfg.verifySampleRate > 1 {
+		return errors.New("verify sample rate must be greater than 0 and at most 1")
+	}
+	if cfg.verifySampleRate < 1 {
+		switch {
+		case !cfg.reconcileVerify:
+			return errors.New("verify sample rate requires reconcile-verify")
+		case cfg.exactDelete:
+			// Every line is parsed for its _id regardless, to delete exactly those archived
+			return errors.New("verify sample rate cannot be combined with exact-delete")
+		}
+	}
+	if cfg.wormRetention < 0 {
+		return errors.New("worm retention must not be negative")
+	}
+	overwrites := cfg.resumable || cfg.onCollision == archive.CollisionOverwrite || cfg.overwriteIncomplete
+	if cfg.wormRetention > 0 && overwrites {
+		// Locked objects can be neither replaced nor removed, which resuming and overwriting depend on
+		return errors.New("worm retention cannot be combined with resumable, on-collision overwrite or overwrite-incomplete")
+	}
+	if cfg.finalizeRetries < 0 {
+		return errors.New("gcs finalize retries must not be negative")
+	}
+	if cfg.finalizeRetries > 0 && cfg.finalizeRetryBackoff <= 0 {
+		return errors.New("gcs finalize retry backoff must be positive")
+	}
+	if cfg.recordProvenance && !cfg.fileHeader && !storage.SupportsObjectMetadata(cfg.storageURL) {
+		return errors.New("record provenance requires file-header, or GCS storage to record it as object metadata")
+	}
+	if cfg.storeContentCRC && !cfg.fileHeader {
+		return errors.New("store-content-crc requires file-header")
+	}
+	if cfg.storeContentCRC && (cfg.resumable || cfg.partitionField != "") {
+		return errors.New("store-content-crc cannot be combined with resumable or partition-field")
+	}
+	if cfg.maxOpenPartitions < 0 {
+		return errors.New("max open partitions must not be negative")
+	}
+	if cfg.maxOpenPartitions > 0 && cfg.partitionField == "" {
+		return errors.New("max open partitions requires partition-field")
+	}
+	if cfg.diskAtomicWrites && cfg.resumable {
+		// Files are only in place once complete, so there is nothing for resuming to append to
+		return errors.New("disk atomic writes cannot be combined with resumable")
+	}
+	if cfg.spoolDir != "" && cfg.resumable {
+		// Spooled files are uploaded whole, so the store cannot append to them
+		return errors.New("spool dir cannot be combined with resumable")
+	}
+	if cfg.ttlCatchUp < 0 {
+		return errors.New("ttl catch-up must not be negative")
+	}
+	if cfg.ttlCatchUp > 0 && (cfg.estimate || cfg.reconcile || cfg.changeStream) {
+		return errors.New("ttl catch-up cannot be combined with estimate, reconcile or change stream")
+	}
+	if cfg.ttlCatchUp > 0 && !cfg.delete {
+		return errors.New("ttl catch-up deletes expired documents, so requires delete")
+	}
+	if cfg.changeStream && cfg.writeLayout {
+		return errors.New("change stream cannot be combined with write-layout")
+	}
+	if cfg.progressFile != "" && cfg.progressInterval <= 0 {
+		return errors.New("progress interval must be positive")
+	}
+	if cfg.changeStream && cfg.progressFile != "" {
+		return errors.New("change stream cannot be combined with progress-file")
+	}
+	if cfg.changeStream && cfg.completeDaysOnly {
+		return errors.New("change stream cannot be combined with complete-days-only")
+	}
+	if cfg.keepDays < 0 {
+		return errors.New("keep days must not be negative")
+	}
+	if cfg.changeStream && cfg.keepDays > 0 {
+		return errors.New("change stream cannot be combined with keep-days")
+	}
+	if cfg.watchInterval < 0 {
+		return errors.New("watch interval must not be negative")
+	}
+	if cfg.explodeField == "" && len(cfg.explodeParentFields.Value()) > 0 {
+		return errors.New("explode-parent-fields requires explode-field")
+	}
+	if cfg.explodeField != "" {
+		switch {
+		case strings.Contains(cfg.explodeField, "."):
+			return errors.New("explode field must be a top level field")
+		case cfg.resumable:
+			return errors.New("explode-field cannot be combined with resumable")
+		case cfg.exactDelete:
+			return errors.New("explode-field cannot be combined with exact-delete")
+		case cfg.reconcileVerify:
+			return errors.New("explode-field cannot be combined with reconcile-verify")
+		case cfg.changeStream:
+			return errors.New("change stream cannot be combined with explode-field")
+		}
+	}
+	return nil
+}
+
+func run(ctx context.Context, cfg config) error {
+	if cfg.logSampleEvery > 1 {
+		// Sampled lines are written by a text handler, as the default handler can't be wrapped
+		sampler := logsample.NewHandler(slog.NewTextHandler(os.Stderr, nil), cfg.logSampleEvery)
+		slog.SetDefault(slog.New(sampler))
+		defer sampler.Summarize(context.WithoutCancel(ctx))
+	}
+	if err := cfg.resolveNamespace(os.LookupEnv); err != nil {
+		return exitcode.WithCode(exitcode.Config, err)
+	}
+
+	slog.Info(
+		"received configuration",
+		slog.String("mongoURL", cfg.mongoURL),
+		slog.String("database", cfg.mongoDatabase),
+		slog.String("databasePattern", cfg.mongoDatabasePattern),
+		slog.Any("tenantRetentions", cfg.tenantRetentions.Value()),
+		slog.Int("collectionConcurrency", cfg.tenantConcurrency),
+		slog.Bool("writeIndex", cfg.writeIndex),
+		slog.String("collection", cfg.mongoCollection),
+		slog.String("namespaceEnv", cfg.namespaceEnv),
+		slog.String("collectionAlias", cfg.collectionAlias),
+		slog.String("deleteCollection", cfg.deleteCollection),
+		slog.String("rollupCollection", cfg.rollupCollection),
+		slog.String("rollupPipeline", cfg.rollupPipeline),
+		slog.String("rollupDayField", cfg.rollupDayField),
+		slog.Any("mergeCollections", cfg.mergeCollections.Value()),
+		slog.String("mergeTagField", cfg.mergeTagField),
+		slog.String("storageURL", cfg.storageURL),
+		slog.Uint64("minFreeBytes", cfg.minFreeBytes),
+		slog.Bool("diskAtomicWrites", cfg.diskAtomicWrites),
+		slog.Int("maxConcurrentUploads", cfg.maxConcurrentUploads),
+		slog.String("gcsCredentialsFile", cfg.gcsCredentialsFile),
+		slog.Bool("gcsCredentialsJSON", cfg.gcsCredentialsJSON != ""),
+		slog.Any("objectMetadata", cfg.objectMetadata.Value()),
+		slog.Duration("wormRetention", cfg.wormRetention),
+		slog.String("spoolDir", cfg.spoolDir),
+		slog.Int("gcsFinalizeRetries", cfg.finalizeRetries),
+		slog.Duration("gcsFinalizeRetryBackoff", cfg.finalizeRetryBackoff),
+		slog.Bool("delete", cfg.delete),
+		slog.Bool("exactDelete", cfg.exactDelete),
+		slog.Int("deleteChunkSize", cfg.deleteChunkSize),
+		slog.Int("deleteRetries", cfg.deleteRetries),
+		slog.Duration("deleteRetryBackoff", cfg.deleteRetryBackoff),
+		slog.String("ttlMarkField", cfg.ttlMarkField),
+		slog.Duration("ttlMarkWindow", cfg.ttlMarkWindow),
+		slog.Duration("deleteCancelGrace", cfg.deleteCancelGrace),
+		slog.Bool("preserveDeletedCount", cfg.preserveDeletedCount),
+		slog.Bool("deleteUnarchived", cfg.deleteUnarchived),
+		slog.Bool("requireHealthyReplSet", cfg.requireHealthyReplSet),
+		slog.Bool("unhealthyReplSetArchive", cfg.unhealthyArchive),
+		slog.Bool("causalConsistency", cfg.causalConsistency),
+		slog.Bool("ignoreFileExistsError", cfg.ignoreFileExistsError),
+		slog.String("onCollision", cfg.onCollision.String()),
+		slog.Bool("overwriteIncomplete", cfg.overwriteIncomplete),
+		slog.Int("maxDocBytes", cfg.maxDocBytes),
+		slog.Int("maxBSONDocBytes", cfg.maxBSONDocBytes),
+		slog.String("oversizePolicy", cfg.oversizePolicy.String()),
+		slog.Any("requireFields", cfg.requireFields.Value()),
+		slog.String("missingFieldPolicy", cfg.missingFieldPolicy.String()),
+		slog.String("specialTypes", cfg.specialTypes),
+		slog.String("postArchiveCommand", cfg.postArchiveCommand),
+		slog.String("postArchivePubSubTopic", cfg.postArchiveTopic),
+		slog.Bool("postArchiveFailOnError", cfg.postArchiveFailRun),
+		slog.String("metricsPushgateway", cfg.metricsPushgateway),
+		slog.Duration("retention", cfg.retention),
+		slog.Duration("ttlCatchUp", cfg.ttlCatchUp),
+		slog.Bool("completeDaysOnly", cfg.completeDaysOnly),
+		slog.Int("keepDays", cfg.keepDays),
+		slog.Duration("delay", cfg.delay),
+		slog.Int("maxDocuments", cfg.maxDocuments),
+		slog.String("startDate", cfg.startDate),
+		slog.Bool("skipEmptyDays", cfg.skipEmptyDays),
+		slog.String("sortWithinDay", cfg.sortWithinDay),
+		slog.String("boundary", cfg.boundary.String()),
+		slog.String("dateExpr", cfg.dateExpr),
+		slog.Bool("objectIDFallback", cfg.objectIDFallback),
+		slog.Bool("idRangeFastPath", cfg.idRangeFastPath),
+		slog.Int("intraDayParallelism", cfg.intraDayParallelism),
+		slog.Int("maxConcurrentMongoOps", cfg.maxConcurrentMongoOps),
+		slog.Int("logSampleEvery", cfg.logSampleEvery),
+		slog.String("indexHint", cfg.indexHint),
+		slog.Int("maxTimeMS", cfg.maxTimeMS),
+		slog.String("idMin", cfg.idMin),
+		slog.String("idMax", cfg.idMax),
+		slog.Int64("maxScanDocs", cfg.maxScanDocs),
+		slog.Int64("minCollectionDocs", cfg.minCollectionDocs),
+		slog.Int64("maxCollectionDocs", cfg.maxCollectionDocs),
+		slog.String("collectionFilterExpr", cfg.collectionFilterExpr),
+		slog.Any("plainFields", cfg.plainFields.Value()),
+		slog.Any("renameFields", cfg.renameFields.Value()),
+		slog.String("explodeField", cfg.explodeField),
+		slog.Any("explodeParentFields", cfg.explodeParentFields.Value()),
+		slog.String("fileExtension", cfg.fileExtension),
+		slog.String("format", cfg.format),
+		slog.String("partitionField", cfg.partitionField),
+		slog.Int("maxOpenPartitions", cfg.maxOpenPartitions),
+		slog.Bool("fileHeader", cfg.fileHeader),
+		slog.Bool("recordProvenance", cfg.recordProvenance),
+		slog.Bool("storeContentCRC", cfg.storeContentCRC),
+		slog.Bool("successMarker", cfg.successMarker),
+		slog.Bool("emptyRunManifest", cfg.emptyRunManifest),
+		slog.Bool("writeOffsetIndex", cfg.writeOffsetIndex),
+		slog.Bool("writeSchema", cfg.writeSchema),
+		slog.Bool("writeBounds", cfg.writeBounds),
+		slog.Bool("writeChecksums", cfg.writeChecksums),
+		slog.Bool("writeLayout", cfg.writeLayout),
+		slog.String("progressFile", cfg.progressFile),
+		slog.Duration("progressInterval", cfg.progressInterval),
+		slog.Bool("resumable", cfg.resumable),
+		slog.Int("checkpointInterval", cfg.checkpointInterval),
+		slog.Duration("commitInterval", cfg.commitInterval),
+		slog.String("compression", cfg.compression.String()),
+		slog.Bool("validateCompression", cfg.validateCompression),
+		slog.Int("compressionThreads", cfg.compressionThreads),
+		slog.Bool("adaptiveCompression", cfg.adaptiveCompression),
+		slog.Duration("adaptiveCompressionTarget", cfg.compressionTarget),
+		slog.Int("compressionSmallDay", cfg.compressionSmallDay),
+		slog.Int("compressionLargeDay", cfg.compressionLargeDay),
+		slog.Bool("estimate", cfg.estimate),
+		slog.Float64("estimateCompressionRatio", cfg.estimateRatio),
+		slog.Duration("compareRetention", cfg.compareRetention),
+		slog.Bool("compareJSON", cfg.compareJSON),
+		slog.Bool("reconcile", cfg.reconcile),
+		slog.Bool("reconcileVerify", cfg.reconcileVerify),
+		slog.Float64("verifySampleRate", cfg.verifySampleRate),
+		slog.Bool("auditChecksums", cfg.auditChecksums),
+		slog.Int("auditWorkers", cfg.auditWorkers),
+		slog.Bool("peek", cfg.peek),
+		slog.String("peekFile", cfg.peekFile),
+		slog.Int("peekCount", cfg.peekCount),
+		slog.Bool("peekSample", cfg.peekSample),
+		slog.Bool("peekPretty", cfg.peekPretty),
+		slog.Bool("doctor", cfg.doctor),
+		slog.Bool("respectPauseFlag", cfg.respectPauseFlag),
+		slog.Bool("watch", cfg.watch),
+		slog.Duration("watchInterval", cfg.watchInterval),
+		slog.Bool("changeStream", cfg.changeStream),
+		slog.Bool("useServerTime", cfg.useServerTime),
+		slog.Any("skipDates", cfg.skipDates.Value()),
+	)
+
+	if err := cfg.validate(); err != nil {
+		return exitcode.WithCode(exitcode.Config, err)
+	}
+	// Expanded up front, so that every mode resolves the same storage
+	storageURL, err := storage.ExpandCollection(cfg.storageURL, cfg.collectionName())
+	if err != nil {
+		return exitcode.WithCode(exitcode.Config, err)
+	}
+	cfg.storageURL = storageURL
+	if cfg.auditChecksums {
+		// Auditing only reads from storage, so mongo is never connected to
+		return auditChecksums(ctx, cfg)
+	}
+	if cfg.peek {
+		// As for auditing, peeking only reads from storage
+		return peek(ctx, cfg, os.Stdout)
+	}
+	if cfg.doctor {
+		return runDoctor(ctx, cfg, os.Stdout)
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.mongoURL))
+	if err != nil {
+		return exitcode.WithCode(exitcode.MongoConnection, fmt.Errorf("unable to connect to mongo: %w", err))
+	}
+
+	if cfg.recordProvenance {
+		// Resolved once, as every database archived is on the same deployment
+		provenance, err := source.ServerProvenance(ctx, client)
+		if err != nil {
+			return exitcode.WithCode(exitcode.MongoConnection, fmt.Errorf("unable to resolve provenance: %w", err))
+		}
+		slog.Info(
+			"recording provenance",
+			slog.String("host", provenance.Host),
+			slog.String("replicaSet", provenance.ReplicaSet),
+			slog.String("serverVersion", provenance.ServerVersion),
+		)
+		cfg.provenance = &provenance
+	}
+	if cfg.maxConcurrentMongoOps > 0 {
+		// Shared, so the bound holds across every database and collection archived at once
+		cfg.opLimiter = source.NewOpLimiter(cfg.maxConcurrentMongoOps)
+	}
+
+	hooks, err := postArchiveHooks(ctx, cfg)
+	if err != nil {
+		return exitcode.WithCode(exitcode.Config, err)
+	}
+	defer func() {
+		if cErr := hooks.Close(); cErr != nil {
+			slog.Error("failed to close post archive hooks", slog.Any("error", cErr))
+		}
+	}()
+
+	var runMetrics *metrics.Run
+	if cfg.metricsPushgateway != "" {
+		// Days are observed as they're archived, as hooks are notified of them
+		runMetrics = &metrics.Run{}
+		hooks = append(hooks, runMetrics)
+	}
+
+	archiveAll, err := archiveFunc(ctx, cfg, client, hooks)
+	if err != nil {
+		return err
+	}
+	if skipDates := cfg.skipDates.Value(); len(skipDates) > 0 {
+		calendar, err := freeze.Parse(skipDates)
+		if err != nil {
+			return exitcode.WithCode(exitcode.Config, err)
+		}
+		archiveAll = calendar.Skip(archiveAll)
+	}
+	if cfg.useServerTime {
+		// Wraps skipping dates, so that skip dates are also judged by the server's clock
+		archiveAll = withServerTime(client, archiveAll)
+	}
+	if runMetrics != nil {
+		archiveAll = withMetricsPush(cfg, runMetrics, archiveAll)
+	}
+	if !cfg.watch {
+		return archiveAll(ctx, time.Now())
+	}
+	retentions, err := watchRetentions(cfg)
+	if err != nil {
+		return exitcode.WithCode(exitcode.Config, err)
+	}
+	return watch.Run(ctx, watch.SystemClock{}, cfg.watchInterval, watch.NextDay(retentions...), archiveAll)
+}
+
+// watchRetentions returns every retention days are archived with, so that watching wakes as soon as a day becomes
+// eligible with any of them
+func watchRetentions(cfg config) ([]time.Duration, error) {
+	if cfg.ttlCatchUp > 0 {
+		return []time.Duration{cfg.ttlCatchUp}, nil
+	}
+	retentions := []time.Duration{cfg.retention}
+	if cfg.mongoDatabasePattern == "" {
+		return retentions, nil
+	}
+	overrides, err := tenant.ParseRetentions(cfg.tenantRetentions.Value())
+	if err != nil {
+		return nil, err
+	}
+	return append(retentions, slices.Collect(maps.Values(overrides))...), nil
+}
+
+// archiveFunc returns the function archiving everything that is eligible at the supplied time, which is either the
+// single configured database, or each tenant database matching the pattern
+func archiveFunc(
+	ctx context.Context,
+	cfg config,
+	client *mongo.Client,
+	hooks hook.Multi,
+) (func(ctx context.Context, now time.Time) error, error) {
+	if cfg.mongoDatabasePattern == "" {
+		return func(ctx context.Context, now time.Time) error {
+			return archiveCollection(
+				ctx, cfg, client, cfg.mongoDatabase, cfg.storageURL, cfg.retention, now,
+				dayArchivedHook(cfg, hooks, nil, cfg.mongoDatabase, cfg.storageURL),
+			)
+		}, nil
+	}
+
+	// Multi-tenant mode, where each matching database holds its own copy of the collection
+	pattern, err := regexp.Compile(cfg.mongoDatabasePattern)
+	if err != nil {
+		return nil, exitcode.WithCode(exitcode.Config, fmt.Errorf("invalid database pattern: %w", err))
+	}
+	retentions, err := tenant.ParseRetentions(cfg.tenantRetentions.Value())
+	if err != nil {
+		return nil, exitcode.WithCode(exitcode.Config, err)
+	}
+	if err = tenant.CheckRetentions(pattern, retentions); err != nil {
+		return nil, exitcode.WithCode(exitcode.Config, err)
+	}
+
+	return func(ctx context.Context, now time.Time) error {
+		// Databases are resolved on every run, so tenants added whilst watching are picked up
+		databases, err := tenant.Databases(ctx, client, pattern, cfg.mongoCollection)
+		if err != nil {
+			return err
+		}
+		slog.Info("resolved tenant databases", slog.Any("databases", databases))
+		for database := range retentions {
+			if !slices.Contains(databases, database) {
+				slog.Warn("retention override names no database holding the collection", slog.String("database", database))
+			}
+		}
+
+		// Databases archived at once share the delay between days, so they start days no faster than one would alone
+		var archiverOpts []archive.Option
+		if cfg.tenantConcurrency > 1 {
+			archiverOpts = append(archiverOpts, archive.WithSharedDelay(archive.NewSharedDelay(cfg.delay)))
+		}
+
+		var index *tenant.Index
+		if cfg.writeIndex {
+			store, err := storage.FromURL(ctx, cfg.storageURL, credentialOptions(cfg)...)
+			if err != nil {
+				return exitcode.WithCode(exitcode.Storage, fmt.Errorf("unable to connect to storage: %w", err))
+			}
+			defer store.Close()
+			index = tenant.NewIndex(store, cfg.collectionName())
+		}
+
+		_, err = tenant.Run(ctx, databases, cfg.tenantConcurrency, func(ctx context.Context, database string) error {
+			storageURL, err := tenant.StorageURL(cfg.storageURL, database)
+			if err != nil {
+				return err
+			}
+			retention, ok := retentions[database]
+			if !ok {
+				retention = cfg.retention
+			}
+			opts := append(slices.Clone(archiverOpts), dayArchivedHook(cfg, hooks, index, database, storageURL))
+			err = archiveCollection(ctx, cfg, client, database, storageURL, retention, now, opts...)
+			if index != nil {
+				// The index is informational, so failing to write it doesn't fail the database
+				if iErr := index.Complete(ctx, database, storageURL, err); iErr != nil {
+					slog.Error("failed to write index", slog.String("database", database), slog.Any("error", iErr))
+				}
+			}
+			return err
+		})
+		return err
+	}, nil
+}
+
+// fieldTransforms describes the renamed and plain fields of archived documents, for the layout document
+func fieldTransforms(cfg config) []archive.FieldTransform {
+	var transforms []archive.FieldTransform
+	for _, rename := range cfg.renameFields.Value() {
+		from, to, _ := strings.Cut(rename, "=")
+		transforms = append(transforms, archive.FieldTransform{Kind: "rename", Field: from, To: to})
+	}
+	for _, field := range cfg.plainFields.Value() {
+		transforms = append(transforms, archive.FieldTransform{Kind: "plain", Field: field})
+	}
+	return transforms
+}
+
+// logSkippedCollection logs that the collection of the database is skipped, rather than archived, for the reason
+func logSkippedCollection(cfg config, database string, reason error) {
+	slog.Warn(
+		"skipping collection",
+		slog.String("database", database),
+		slog.String("collection", cfg.mongoCollection),
+		slog.String("reason", reason.Error()),
+	)
+}
+
+// postArchiveHooks returns the hooks notified of each archived day, which is empty when none are configured
+func postArchiveHooks(ctx context.Context, cfg config) (hook.Multi, error) {
+	var hooks hook.Multi
+	if cfg.postArchiveCommand != "" {
+		hooks = append(hooks, hook.NewCommand(cfg.postArchiveCommand))
+	}
+	if cfg.postArchiveTopic != "" {
+		var opts []option.ClientOption
+		if cfg.gcsCredentialsFile != "" {
+			opts = append(opts, option.WithCredentialsFile(cfg.gcsCredentialsFile))
+		}
+		if cfg.gcsCredentialsJSON != "" {
+			opts = append(opts, option.WithCredentialsJSON([]byte(cfg.gcsCredentialsJSON)))
+		}
+		p, err := hook.NewPubSub(ctx, cfg.postArchiveTopic, opts...)
+		if err != nil {
+			return nil, errors.Join(err, hooks.Close())
+		}
+		hooks = append(hooks, p)
+	}
+	return hooks, nil
+}
+
+// dayArchivedHook returns the option notifying the hooks of each day archived from the database. Failures are only
+// logged, unless configured to fail the run.
+func dayArchivedHook(cfg config, hooks hook.Multi, index *tenant.Index, database, storageURL string) archive.Option {
+	return archive.WithDayArchivedHook(func(ctx context.Context, day archive.ArchivedDay) error {
+		if index != nil {
+			index.Observe(database, storageURL, day)
+		}
+		if len(hooks) == 0 {
+			return nil
+		}
+		ev, err := hook.NewEvent(database, cfg.collectionName(), storageURL, day)
+		if err == nil {
+			err = hooks.Notify(ctx, ev)
+		}
+		if err != nil && !cfg.postArchiveFailRun {
+			slog.Error(
+				"post archive hook failed",
+				slog.String("database", database),
+				slog.String("date", day.Date.Format(time.DateOnly)),
+				slog.Any("error", err),
+			)
+			return nil
+		}
+		return err
+	})
+}
+
+// withMetricsPush wraps fn so that the metrics of each run are pushed to the Pushgateway once it ends, whether or not
+// it succeeded. Failing to push is only logged, as the run itself is unaffected.
+func withMetricsPush(
+	cfg config,
+	runMetrics *metrics.Run,
+	fn func(ctx context.Context, now time.Time) error,
+) func(ctx context.Context, now time.Time) error {
+	// Validated up front, so can't fail
+	gateway, _ := metrics.NewPushgateway(cfg.metricsPushgateway)
+	return func(ctx context.Context, now time.Time) error {
+		runMetrics.Start(time.Now())
+		err := fn(ctx, now)
+		// Pushed even once cancelled, e.g. on SIGTERM, so that the failed run is recorded
+		pErr := gateway.Push(context.WithoutCancel(ctx), cfg.collectionName(), runMetrics, time.Now(), err == nil)
+		if pErr != nil {
+			slog.Error("failed to push metrics", slog.Any("error", pErr))
+		}
+		return err
+	}
+}
+
+// withServerTime wraps fn so that it's invoked with the time according to the mongo server, rather than the local
+// time it would otherwise receive. The local time is used should the server time be unavailable.
+func withServerTime(
+	client *mongo.Client,
+	fn func(ctx context.Context, now time.Time) error,
+) func(ctx context.Context, now time.Time) error {
+	return func(ctx context.Context, now time.Time) error {
+		serverNow, err := source.ServerTime(ctx, client)
+		if err != nil {
+			slog.Warn("unable to get server time, using local time", slog.Any("error", err))
+			return fn(ctx, now)
+		}
+		slog.Info(
+			"using server time",
+			slog.Time("serverTime", serverNow),
+			slog.Duration("skew", now.Sub(serverNow)),
+		)
+		return fn(ctx, serverNow)
+	}
+}
+
+// credentialOptions returns the options authenticating with storage, for stores only read from or written to directly
+// rather than archived to
+func credentialOptions(cfg config) []storage.Option {
+	var storageOpts []storage.Option
+	if cfg.gcsCredentialsFile != "" {
+		storageOpts = append(storageOpts, storage.WithGCSCredentialsFile(cfg.gcsCredentialsFile))
+	}
+	if cfg.gcsCredentialsJSON != "" {
+		storageOpts = append(storageOpts, storage.WithGCSCredentialsJSON([]byte(cfg.gcsCredentialsJSON)))
+	}
+	return storageOpts
+}
+
+// auditChecksums verifies every gzipped file in storage against its checksum sidecar, failing with an integrity error
+// should any not match
+func auditChecksums(ctx context.Context, cfg config) error {
+	store, err := storage.FromURL(ctx, cfg.storageURL, credentialOptions(cfg)...)
+	if err != nil {
+		return exitcode.WithCode(exitcode.Storage, fmt.Errorf("unable to connect to storage: %w", err))
+	}
+	defer store.Close()
+
+	report, err := archive.Audit(ctx, store, cfg.auditWorkers)
+	if err != nil {
+		return fmt.Errorf("failed to audit checksums: %w", err)
+	}
+	slog.Info(
+		"checksums audited",
+		slog.Int("matched", len(report.Matched)),
+		slog.Int("mismatched", len(report.Mismatched)),
+		slog.Int("missingChecksum", len(report.MissingChecksum)),
+		slog.Any("mismatchedFiles", report.Mismatched),
+		slog.Any("missingChecksumFiles", report.MissingChecksum),
+	)
+	if len(report.Mismatched) > 0 {
+		return fmt.Errorf("%w: %d files do not match their checksum", archive.ErrIntegrity, len(report.Mismatched))
+	}
+	return nil
+}
+
+// peek prints documents from an archived file in storage to w, one per line unless pretty-printing
+func peek(ctx context.Context, cfg config, w io.Writer) error {
+	store, err := storage.FromURL(ctx, cfg.storageURL, credentialOptions(cfg)...)
+	if err != nil {
+		return exitcode.WithCode(exitcode.Storage, fmt.Errorf("unable to connect to storage: %w", err))
+	}
+	defer store.Close()
+
+	path, docs, err := archive.Peek(ctx, store, cfg.peekFile, cfg.peekCount, cfg.peekSample)
+	if err != nil {
+		return fmt.Errorf("failed to peek: %w", err)
+	}
+	slog.Info("peeked at file", slog.String("fileName", path), slog.Int("documents", len(docs)))
+
+	for _, doc := range docs {
+		if cfg.peekPretty {
+			var out bytes.Buffer
+			if err = json.Indent(&out, doc, "", "  "); err != nil {
+				return fmt.Errorf("failed to pretty-print document: %w", err)
+			}
+			doc = out.Bytes()
+		}
+		if _, err = fmt.Fprintf(w, "%s\n", doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runDoctor checks that mongo and storage can be reached, and that the archiver has the permissions it needs, writing
+// the result of each check to w. Nothing is archived or deleted, though a probe file is written to and removed from
+// storage.
+func runDoctor(ctx context.Context, cfg config, w io.Writer) error {
+	var client *mongo.Client
+	var docSource *source.MongoDB
+	var store storage.Store
+	defer func() {
+		if client != nil {
+			_ = client.Disconnect(context.WithoutCancel(ctx))
+		}
+		if store != nil {
+			_ = store.Close()
+		}
+	}()
+
+	collection := cfg.mongoDatabase + "." + cfg.mongoCollection
+	checks := []doctor.Check{
+		{
+			Name:     "mongo connect",
+			Hint:     "check --mongo-url is a valid connection string",
+			Required: true,
+			Run: func(ctx context.Context) (err error) {
+				client, err = mongo.Connect(ctx, options.Client().ApplyURI(cfg.mongoURL))
+				if err != nil {
+					return err
+				}
+				var sourceOpts []source.MongoDBOption
+				if cfg.deleteCollection != "" {
+					deletes := client.Database(cfg.mongoDatabase).Collection(cfg.deleteCollection)
+					sourceOpts = append(sourceOpts, source.WithDeleteCollection(deletes))
+				}
+				docSource = source.NewMongoDB(client.Database(cfg.mongoDatabase).Collection(cfg.mongoCollection), sourceOpts...)
+				return nil
+			},
+		},
+		{
+			Name:     "mongo ping",
+			Hint:     "check the primary is reachable from here, e.g. network access lists, TLS and credentials",
+			Required: true,
+			Run: func(ctx context.Context) error {
+				return docSource.Ping(ctx)
+			},
+		},
+		{
+			Name: "date index",
+			Hint: fmt.Sprintf(
+				"create an index leading with createdAt, e.g. db.%s.createIndex({createdAt: 1})",
+				cfg.mongoCollection,
+			),
+			Run: func(ctx context.Context) error {
+				if cfg.dateExpr != "" {
+					return fmt.Errorf("%w: days are bucketed by a date expression, which can't use indexes", doctor.ErrSkip)
+				}
+				if cfg.idRangeFastPath {
+					return fmt.Errorf("%w: days are selected by _id", doctor.ErrSkip)
+				}
+				return docSource.CheckDateIndex(ctx)
+			},
+		},
+		{
+			Name: "index hint",
+			Hint: "set --index-hint to the name of an existing index, or create it",
+			Run: func(ctx context.Context) error {
+				if cfg.indexHint == "" {
+					return fmt.Errorf("%w: no index hint", doctor.ErrSkip)
+				}
+				exists, err := docSource.HasIndex(ctx, cfg.indexHint)
+				if err != nil {
+					return fmt.Errorf("failed to list indexes: %w", err)
+				}
+				if !exists {
+					return fmt.Errorf("index hint %q is not an index of the collection", cfg.indexHint)
+				}
+				return nil
+			},
+		},
+		{
+			Name: "read permission",
+			Hint: "grant the find action on " + collection + ", e.g. with the read role",
+			Run: func(ctx context.Context) error {
+				return docSource.ProbeRead(ctx)
+			},
+		},
+		{
+			Name: "delete permission",
+			Hint: "grant the remove action on the collection deleted from, e.g. with the readWrite role, or point " +
+				"--delete-collection at a collection rather than a view",
+			Run: func(ctx context.Context) error {
+				if !cfg.delete {
+					return fmt.Errorf("%w: deleting is disabled", doctor.ErrSkip)
+				}
+				return docSource.ProbeDelete(ctx)
+			},
+		},
+	}
+	storageChecks := []doctor.Check{
+		{
+			Name:     "storage connect",
+			Hint:     "check --storage-url, and the credentials supplied for it",
+			Required: true,
+			Run: func(ctx context.Context) (err error) {
+				store, err = storage.FromURL(ctx, cfg.storageURL, credentialOptions(cfg)...)
+				return err
+			},
+		},
+		{
+			Name: "storage write",
+			Hint: "grant permission to create files beneath the storage URL, e.g. roles/storage.objectCreator",
+			Run: func(ctx context.Context) error {
+				return doctor.ProbeWrite(ctx, store)
+			},
+		},
+		{
+			Name: "storage delete",
+			Hint: "grant permission to delete files beneath the storage URL, e.g. roles/storage.objectUser, " +
+				"which resuming relies upon; " + doctor.ProbePath + " may need removing by hand",
+			Run: func(ctx context.Context) error {
+				return doctor.ProbeDelete(ctx, store)
+			},
+		},
+	}
+
+	// Storage is checked regardless of mongo failing
+	results := append(doctor.Run(ctx, checks), doctor.Run(ctx, storageChecks)...)
+	failures, err := doctor.Write(w, results)
+	if err != nil {
+		return err
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d of %d checks failed", failures, len(results))
+	}
+	return nil
+}
+
+func archiveCollection(
+	ctx context.Context,
+	cfg config,
+	client *mongo.Client,
+	database, storageURL string,
+	retention time.Duration,
+	now time.Time,
+	archiverOpts ...archive.Option,
+) error {
+	collection := client.Database(database).Collection(cfg.mongoCollection)
+	sourceOpts := []source.MongoDBOption{source.WithBoundary(cfg.boundary)}
+	if cfg.sortWithinDay != "" {
+		slog.Warn(
+			"sorting within day enabled, large days may be slow or memory intensive to sort without a supporting index",
+			slog.String("field", cfg.sortWithinDay),
+		)
+		sourceOpts = append(sourceOpts, source.WithSortField(cfg.sortWithinDay))
+	}
+	if cfg.causalConsistency {
+		sourceOpts = append(sourceOpts, source.WithCausalConsistency())
+	}
+	if renameFields := cfg.renameFields.Value(); len(renameFields) > 0 {
+		renames, err := source.ParseRenames(renameFields)
+		if err != nil {
+			return exitcode.WithCode(exitcode.Config, err)
+		}
+		sourceOpts = append(sourceOpts, source.WithRenameFields(renames))
+	}
+	if plainFields := cfg.plainFields.Value(); len(plainFields) > 0 {
+		sourceOpts = append(sourceOpts, source.WithPlainFields(plainFields))
+	}
+	if cfg.dateExpr != "" {
+		expr, err := source.ParseDateExpr(cfg.dateExpr)
+		if err != nil {
+			return exitcode.WithCode(exitcode.Config, err)
+		}
+		slog.Warn("bucketing by a date expression, queries cannot make use of indexes")
+		sourceOpts = append(sourceOpts, source.WithDateExpr(expr))
+	}
+	if cfg.indexHint != "" {
+		sourceOpts = append(sourceOpts, source.WithIndexHint(cfg.indexHint))
+	}
+	if cfg.objectIDFallback {
+		sourceOpts = append(sourceOpts, source.WithObjectIDFallback())
+	}
+	if cfg.idRangeFastPath {
+		slog.Warn("selecting days by _id, which is only correct when createdAt is monotonic with _id")
+		sourceOpts = append(sourceOpts, source.WithIDRangeFastPath())
+	}
+	if cfg.intraDayParallelism > 1 {
+		sourceOpts = append(sourceOpts, source.WithIntraDayParallelism(cfg.intraDayParallelism))
+	}
+	if cfg.opLimiter != nil {
+		sourceOpts = append(sourceOpts, source.WithOpLimiter(cfg.opLimiter))
+	}
+	if cfg.maxTimeMS > 0 {
+		sourceOpts = append(sourceOpts, source.WithMaxTime(time.Duration(cfg.maxTimeMS)*time.Millisecond))
+	}
+	if idRange, err := source.ParseIDRange(cfg.idMin, cfg.idMax); err != nil {
+		return exitcode.WithCode(exitcode.Config, err)
+	} else if !idRange.IsZero() {
+		sourceOpts = append(sourceOpts, source.WithIDRange(idRange))
+	}
+	if cfg.deleteRetries > 0 {
+		sourceOpts = append(sourceOpts, source.WithDeleteRetries(cfg.deleteRetries, cfg.deleteRetryBackoff))
+	}
+	if cfg.ttlMarkField != "" {
+		sourceOpts = append(sourceOpts, source.WithTTLMark(cfg.ttlMarkField, cfg.ttlMarkWindow))
+	}
+	if cfg.deleteCollection != "" {
+		deletes := client.Database(database).Collection(cfg.deleteCollection)
+		sourceOpts = append(sourceOpts, source.WithDeleteCollection(deletes))
+	}
+	if cfg.rollupPipeline != "" {
+		pipeline, err := source.ParseRollupPipeline(cfg.rollupPipeline)
+		if err != nil {
+			return exitcode.WithCode(exitcode.Config, err)
+		}
+		rollups := client.Database(database).Collection(cfg.rollupCollection)
+		sourceOpts = append(sourceOpts, source.WithRollup(rollups, pipeline, cfg.rollupDayField))
+	}
+	if cfg.specialTypes != "" {
+		// Special types are only detected, and preserved, in documents rendered as canonical extended JSON
+		sourceOpts = append(sourceOpts, source.WithCanonicalSpecialTypes())
+	}
+	docSource := source.NewMongoDB(collection, sourceOpts...)
+
+	if cfg.minCollectionDocs > 0 || cfg.maxCollectionDocs > 0 {
+		if err := docSource.CheckSize(ctx, cfg.minCollectionDocs, cfg.maxCollectionDocs); err != nil {
+			if errors.Is(err, source.ErrCollectionSize) {
+				logSkippedCollection(cfg, database, err)
+				return nil
+			}
+			return err
+		}
+	}
+	if cfg.collectionFilterExpr != "" {
+		filter, err := predicate.Parse(cfg.collectionFilterExpr, source.StatsVariables)
+		if err != nil {
+			return exitcode.WithCode(exitcode.Config, err)
+		}
+		if err = docSource.CheckStats(ctx, filter); err != nil {
+			if errors.Is(err, source.ErrCollectionStats) {
+				logSkippedCollection(cfg, database, err)
+				return nil
+			}
+			return err
+		}
+	}
+
+	if cfg.delete && !cfg.estimate {
+		// Views can be read from but not deleted from, which would otherwise only fail after archiving the first day, as
+		// would marking documents for expiry without a TTL index to remove them
+		if err := docSource.CheckDeletable(ctx); err != nil {
+			if errors.Is(err, source.ErrView) || errors.Is(err, source.ErrNoTTLIndex) {
+				return exitcode.WithCode(exitcode.Config, err)
+			}
+			return err
+		}
+	}
+
+	if cfg.indexHint != "" {
+		// An unknown hint would otherwise only surface as a query failure part way through archiving
+		exists, err := docSource.HasIndex(ctx, cfg.indexHint)
+		if err != nil {
+			return fmt.Errorf("failed to list indexes: %w", err)
+		}
+		if !exists {
+			err = fmt.Errorf("index hint %q is not an index of the collection", cfg.indexHint)
+			return exitcode.WithCode(exitcode.Config, err)
+		}
+	}
+
+	if cfg.maxScanDocs > 0 {
+		if err := docSource.CheckScan(ctx, cfg.maxScanDocs); err != nil {
+			if errors.Is(err, source.ErrCollectionScan) {
+				return exitcode.WithCode(exitcode.Config, err)
+			}
+			return err
+		}
+	}
+
+	// Further collections are read and deleted alongside the collection, with each day merged into a single file
+	var archiveSource source.DaySource = docSource
+	if mergeCollections := cfg.mergeCollections.Value(); len(mergeCollections) > 0 {
+		sources := []source.NamedSource{{Name: cfg.mongoCollection, DaySource: docSource}}
+		for _, name := range mergeCollections {
+			merged := source.NewMongoDB(client.Database(database).Collection(name), sourceOpts...)
+			if cfg.delete {
+				if err := merged.CheckDeletable(ctx); err != nil {
+					if errors.Is(err, source.ErrView) || errors.Is(err, source.ErrNoTTLIndex) {
+						return exitcode.WithCode(exitcode.Config, err)
+					}
+					return err
+				}
+			}
+			sources = append(sources, source.NamedSource{Name: name, DaySource: merged})
+		}
+		archiveSource = source.NewMerged(cfg.mergeTagField, sources...)
+	}
+
+	storageOpts := []storage.Option{
+		storage.WithMinFreeBytes(cfg.minFreeBytes),
+		storage.WithMaxConcurrentUploads(cfg.maxConcurrentUploads),
+	}
+	if cfg.gcsCredentialsFile != "" {
+		storageOpts = append(storageOpts, storage.WithGCSCredentialsFile(cfg.gcsCredentialsFile))
+	}
+	if cfg.gcsCredentialsJSON != "" {
+		storageOpts = append(storageOpts, storage.WithGCSCredentialsJSON([]byte(cfg.gcsCredentialsJSON)))
+	}
+	metadata := make(map[string]string)
+	if cfg.provenance != nil && storage.SupportsObjectMetadata(storageURL) {
+		maps.Copy(metadata, cfg.provenance.Metadata())
+	}
+	if objectMetadata := cfg.objectMetadata.Value(); len(objectMetadata) > 0 {
+		parsed, err := storage.ParseMetadata(objectMetadata)
+		if err != nil {
+			return exitcode.WithCode(exitcode.Config, err)
+		}
+		maps.Copy(metadata, parsed) // taking precedence over provenance
+	}
+	if len(metadata) > 0 {
+		storageOpts = append(storageOpts, storage.WithObjectMetadata(metadata))
+	}
+	if cfg.compression == archive.CompressionStore {
+		storageOpts = append(storageOpts, storage.WithStoreCompression())
+	}
+	if cfg.wormRetention > 0 {
+		storageOpts = append(storageOpts, storage.WithWORMRetention(cfg.wormRetention))
+	}
+	if cfg.diskAtomicWrites {
+		storageOpts = append(storageOpts, storage.WithAtomicWrites())
+	}
+	if cfg.spoolDir != "" {
+		storageOpts = append(storageOpts, storage.WithSpoolDir(cfg.spoolDir))
+	}
+	if cfg.finalizeRetries > 0 {
+		storageOpts = append(storageOpts, storage.WithGCSFinalizeRetries(cfg.finalizeRetries, cfg.finalizeRetryBackoff))
+	}
+	store, err := storage.FromURL(ctx, storageURL, storageOpts...)
+	if err != nil {
+		return exitcode.WithCode(exitcode.Storage, fmt.Errorf("unable to connect to storage: %w", err))
+	}
+	defer store.Close()
+
+	archiverOpts = append([]archive.Option{archive.WithFileExtension(cfg.fileExtension)}, archiverOpts...)
+	if cfg.format != archive.DefaultEncoder {
+		encoder, err := archive.LookupEncoder(cfg.format)
+		if err != nil {
+			return exitcode.WithCode(exitcode.Config, err)
+		}
+		archiverOpts = append(archiverOpts, archive.WithEncoder(encoder))
+	}
+	if cfg.fileHeader {
+		archiverOpts = append(archiverOpts, archive.WithFileHeader(cfg.collectionName()))
+		if cfg.provenance != nil {
+			archiverOpts = append(archiverOpts, archive.WithProvenance(*cfg.provenance))
+		}
+		if cfg.storeContentCRC {
+			archiverOpts = append(archiverOpts, archive.WithContentCRC())
+		}
+	}
+	if cfg.successMarker {
+		archiverOpts = append(archiverOpts, archive.WithSuccessMarker())
+	}
+	if cfg.emptyRunManifest {
+		archiverOpts = append(archiverOpts, archive.WithEmptyRunManifest())
+	}
+	if cfg.onCollision != archive.CollisionFail {
+		archiverOpts = append(archiverOpts, archive.WithCollisionPolicy(cfg.onCollision))
+	}
+	if cfg.overwriteIncomplete {
+		archiverOpts = append(archiverOpts, archive.WithOverwriteIncomplete())
+	}
+	if cfg.maxDocBytes > 0 {
+		archiverOpts = append(archiverOpts, archive.WithMaxDocumentSize(cfg.maxDocBytes, cfg.oversizePolicy))
+	}
+	if cfg.maxBSONDocBytes > 0 {
+		archiverOpts = append(archiverOpts, archive.WithMaxBSONDocumentSize(cfg.maxBSONDocBytes, cfg.oversizePolicy))
+	}
+	if fields := cfg.requireFields.Value(); len(fields) > 0 {
+		archiverOpts = append(archiverOpts, archive.WithRequiredFields(fields, cfg.missingFieldPolicy))
+	}
+	if cfg.specialTypes != "" {
+		policy, err := archive.ParseSpecialTypesPolicy(cfg.specialTypes)
+		if err != nil {
+			return exitcode.WithCode(exitcode.Config, err)
+		}
+		archiverOpts = append(archiverOpts, archive.WithSpecialTypes(policy))
+	}
+	if cfg.writeOffsetIndex {
+		archiverOpts = append(archiverOpts, archive.WithOffsetIndex())
+	}
+	if cfg.writeSchema {
+		archiverOpts = append(archiverOpts, archive.WithSchema())
+	}
+	if cfg.writeBounds {
+		archiverOpts = append(archiverOpts, archive.WithBounds())
+	}
+	if cfg.writeChecksums {
+		archiverOpts = append(archiverOpts, archive.WithChecksums())
+	}
+	if cfg.writeLayout {
+		archiverOpts = append(archiverOpts, archive.WithLayout(fieldTransforms(cfg)))
+	}
+	if cfg.progressFile != "" {
+		archiverOpts = append(archiverOpts, archive.WithProgress(cfg.progressFile, cfg.progressInterval))
+	}
+	if cfg.resumable {
+		archiverOpts = append(archiverOpts, archive.WithResume(cfg.checkpointInterval))
+	}
+	if cfg.commitInterval > 0 {
+		archiverOpts = append(archiverOpts, archive.WithCommitInterval(cfg.commitInterval))
+	}
+	if cfg.exactDelete {
+		archiverOpts = append(archiverOpts, archive.WithExactDelete())
+	}
+	if cfg.deleteChunkSize > 0 {
+		archiverOpts = append(archiverOpts, archive.WithDeleteChunkSize(cfg.deleteChunkSize))
+	}
+	if cfg.deleteCancelGrace > 0 {
+		archiverOpts = append(archiverOpts, archive.WithDeleteCancelGrace(cfg.deleteCancelGrace))
+	}
+	if cfg.verifySampleRate < 1 {
+		archiverOpts = append(archiverOpts, archive.WithVerifySampleRate(cfg.verifySampleRate))
+	}
+	if cfg.preserveDeletedCount {
+		archiverOpts = append(archiverOpts, archive.WithStrictDeleteCount())
+	}
+	if cfg.deleteUnarchived {
+		archiverOpts = append(archiverOpts, archive.WithDeleteUnarchived())
+	}
+	if cfg.requireHealthyReplSet {
+		archiverOpts = append(archiverOpts, archive.WithDeleteGuard(docSource.CheckReplicaSetHealth, cfg.unhealthyArchive))
+	}
+	if cfg.partitionField != "" {
+		archiverOpts = append(archiverOpts, archive.WithPartitionField(cfg.partitionField))
+	}
+	if cfg.maxOpenPartitions > 0 {
+		archiverOpts = append(archiverOpts, archive.WithMaxOpenPartitions(cfg.maxOpenPartitions))
+	}
+	if cfg.respectPauseFlag {
+		archiverOpts = append(archiverOpts, archive.WithPauseFlag(time.Second*5, time.Minute*5))
+	}
+	if cfg.maxDocuments > 0 {
+		archiverOpts = append(archiverOpts, archive.WithMaxDocuments(cfg.maxDocuments))
+	}
+	if cfg.startDate != "" {
+		// Validated up front
+		start, _ := time.Parse(time.DateOnly, cfg.startDate)
+		archiverOpts = append(archiverOpts, archive.WithStartDate(start))
+	}
+	if cfg.skipEmptyDays {
+		archiverOpts = append(archiverOpts, archive.WithSkipEmptyDays())
+	}
+	if cfg.keepDays > 0 {
+		archiverOpts = append(archiverOpts, archive.WithKeepDays(cfg.keepDays, now))
+	}
+	if cfg.rollupPipeline != "" {
+		archiverOpts = append(archiverOpts, archive.WithRollup())
+	}
+	if cfg.explodeField != "" {
+		archiverOpts = append(archiverOpts, archive.WithExplodeField(cfg.explodeField, cfg.explodeParentFields.Value()))
+	}
+	if cfg.completeDaysOnly || cfg.watch {
+		// The day holding the target is still being filled whilst watching, so is left until it has been completed
+		archiverOpts = append(archiverOpts, archive.WithCompleteDaysOnly())
+	}
+	if cfg.compression != archive.CompressionGzip {
+		archiverOpts = append(archiverOpts, archive.WithCompression(cfg.compression))
+	}
+	if cfg.validateCompression {
+		archiverOpts = append(archiverOpts, archive.WithCompressionValidation())
+	}
+	if cfg.compressionThreads > 1 {
+		archiverOpts = append(archiverOpts, archive.WithCompressionThreads(cfg.compressionThreads))
+	}
+	if cfg.adaptiveCompression && cfg.compressionTarget > 0 {
+		archiverOpts = append(archiverOpts, archive.WithAdaptiveCompressionTarget(cfg.compressionTarget))
+	} else if cfg.adaptiveCompression {
+		archiverOpts = append(
+			archiverOpts,
+			archive.WithAdaptiveCompression(cfg.compressionSmallDay, cfg.compressionLargeDay),
+		)
+	}
+
+	targetDate := now.UTC().Add(retention * -1)
+	archiver := archive.NewArchiver(
+		archiveSource,
+		store,
+		!cfg.delete,
+		cfg.ignoreFileExistsError,
+		cfg.delay,
+		archiverOpts...,
+	)
+
+	if cfg.estimate && cfg.compareRetention > 0 {
+		retentions := []time.Duration{retention, cfg.compareRetention}
+		targets := []time.Time{targetDate, now.UTC().Add(cfg.compareRetention * -1)}
+		ests, err := archiver.CompareEstimates(ctx, targets, cfg.estimateRatio)
+		if err != nil {
+			return fmt.Errorf("failed to estimate: %w", err)
+		}
+		return writeComparison(os.Stdout, database, retentions, ests, cfg.compareJSON)
+	}
+	if cfg.estimate {
+		est, err := archiver.Estimate(ctx, targetDate, cfg.estimateRatio)
+		if err != nil {
+			return fmt.Errorf("failed to estimate: %w", err)
+		}
+		slog.Info(
+			"approximate estimate",
+			slog.String("database", database),
+			slog.Int("days", est.Days),
+			slog.Int("documents", est.Documents),
+			slog.Int("averageDocumentSize", est.AverageDocumentSize),
+			slog.Int64("uncompressedBytes", est.UncompressedBytes),
+			slog.Int64("compressedBytes", est.CompressedBytes),
+			slog.Duration("minimumRuntime", est.MinimumRuntime),
+		)
+		return nil
+	}
+	if cfg.reconcile {
+		return archiver.Reconcile(ctx, targetDate, cfg.reconcileVerify)
+	}
+	if cfg.changeStream {
+		return archiver.Stream(ctx, watch.SystemClock{}, retention)
+	}
+	if cfg.ttlCatchUp > 0 {
+		return archiver.CatchUpTTL(ctx, now, cfg.ttlCatchUp)
+	}
+
+	return archiver.Run(ctx, targetDate)
+}
+
+// estimateComparison is an estimate as printed when comparing retentions
+type estimateComparison struct {
+	Retention           string `json:"retention"`
+	Days                int    `json:"days"`
+	Documents           int    `json:"documents"`
+	AverageDocumentSize int    `json:"averageDocumentSize"`
+	UncompressedBytes   int64  `json:"uncompressedBytes"`
+	CompressedBytes     int64  `json:"compressedBytes"`
+	MinimumRuntime      string `json:"minimumRuntime"`
+}
+
+// writeComparison writes the estimates made with each retention side by side, as a table with a column per retention,
+// or as a single line of JSON
+func writeComparison(
+	w io.Writer,
+	database string,
+	retentions []time.Duration,
+	ests []archive.Estimate,
+	asJSON bool,
+) error {
+	comparisons := make([]estimateComparison, len(ests))
+	for i, est := range ests {
+		comparisons[i] = estimateComparison{
+			Retention:           formatRetention(retentions[i]),
+			Days:                est.Days,
+			Documents:           est.Documents,
+			AverageDocumentSize: est.AverageDocumentSize,
+			UncompressedBytes:   est.UncompressedBytes,
+			CompressedBytes:     est.CompressedBytes,
+			MinimumRuntime:      est.MinimumRuntime.String(),
+		}
+	}
+	if asJSON {
+		return json.NewEncoder(w).Encode(struct {
+			Database   string               `json:"database"`
+			Retentions []estimateComparison `json:"retentions"`
+		}{database, comparisons})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	rows := []struct {
+		name  string
+		value func(c estimateComparison) any
+	}{
+		{database, func(c estimateComparison) any { return c.Retention }},
+		{"days", func(c estimateComparison) any { return c.Days }},
+		{"documents", func(c estimateComparison) any { return c.Documents }},
+		{"average document size", func(c estimateComparison) any { return c.AverageDocumentSize }},
+		{"uncompressed bytes", func(c estimateComparison) any { return c.UncompressedBytes }},
+		{"compressed bytes", func(c estimateComparison) any { return c.CompressedBytes }},
+		{"minimum runtime", func(c estimateComparison) any { return c.MinimumRuntime }},
+	}
+	for _, row := range rows {
+		line := row.name
+		for _, c := range comparisons {
+			line += fmt.Sprintf("\t%v", row.value(c))
+		}
+		if _, err := fmt.Fprintln(tw, line+"\t"); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// formatRetention renders a retention in days where it's a whole number of them, as retentions are usually given
+func formatRetention(d time.Duration) string {
+	if d > 0 && d%(time.Hour*24) == 0 {
+		return fmt.Sprintf("%dd", d/(time.Hour*24))
+	}
+	return d.String()
 }